@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Opening a fax, its document or its confirmation is recorded in the audit log, so admins can account for
+// who accessed a document and when (e.g. for a HIPAA accounting of disclosures). The access report at
+// /admin/access lists those entries for a fax, a phone number or a user, as a page, CSV or PDF.
+const (
+	accessViewed       = "fax.viewed"                  // details page
+	accessDocument     = "fax.document_opened"         // document previewed or downloaded
+	accessConfirmation = "fax.confirmation_downloaded" // delivery confirmation, which shows the first page
+	accessExported     = "fax.document_exported"       // document included in a ZIP export
+)
+
+// accessActions are the audit actions an access report covers
+var accessActions = []string{accessViewed, accessDocument, accessConfirmation, accessExported}
+
+// accessReportLimit caps the entries of one report
+const accessReportLimit = 5000
+
+// recordAccess writes an access to a fax to the audit log
+func (a *App) recordAccess(r *http.Request, action, profile, faxID string) {
+	user, _ := a.sessionUser(r)
+	a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: action, Profile: profile, FaxID: faxID})
+}
+
+// accessQuery selects the entries of an access report; empty fields match everything
+type accessQuery struct {
+	FaxIDs []string // the fax, or the faxes sent to or from a number
+	User   string
+	From   time.Time
+	To     time.Time // exclusive
+}
+
+// handleAdminAccess shows the access report form and its results, or downloads them with format=csv or format=pdf
+func (a *App) handleAdminAccess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+	loc := a.location(r)
+	now := time.Now().In(loc)
+	data := map[string]any{
+		"Fax":    strings.TrimSpace(params.Get("fax")),
+		"Number": strings.TrimSpace(params.Get("number")),
+		"User":   strings.TrimSpace(params.Get("user")),
+		"From":   firstNonEmpty(params.Get("from"), now.AddDate(-6, 0, 0).Format("2006-01-02")),
+		"To":     firstNonEmpty(params.Get("to"), now.Format("2006-01-02")),
+		"Limit":  accessReportLimit,
+	}
+	if params.Get("run") == "" {
+		a.render(w, r, "admin_access.html", data)
+		return
+	}
+	fail := func(msg string, args ...any) {
+		data["Error"] = a.T(r, msg, args...)
+		a.render(w, r, "admin_access.html", data)
+	}
+
+	q := accessQuery{User: data["User"].(string)}
+	var err error
+	if q.From, err = time.ParseInLocation("2006-01-02", data["From"].(string), loc); err != nil {
+		fail("Enter a start date")
+		return
+	}
+	if q.To, err = time.ParseInLocation("2006-01-02", data["To"].(string), loc); err != nil || q.To.Before(q.From) {
+		fail("Enter an end date on or after the start date")
+		return
+	}
+	q.To = q.To.AddDate(0, 0, 1)
+	subject := ""
+	switch {
+	case data["Fax"] != "":
+		q.FaxIDs = []string{data["Fax"].(string)}
+		subject = "fax " + data["Fax"].(string)
+	case data["Number"] != "":
+		d, err := a.parseDataSubject(data["Number"].(string))
+		if err != nil || d.Number == "" {
+			fail("Enter a valid phone number")
+			return
+		}
+		faxes, err := a.Store.subjectFaxes(r.Context(), d)
+		if err != nil {
+			fail("Failed to load the access report: " + err.Error())
+			return
+		}
+		for _, f := range faxes {
+			q.FaxIDs = append(q.FaxIDs, f.FaxID)
+		}
+		subject = "faxes sent to or from " + d.Number
+		if len(q.FaxIDs) == 0 {
+			fail("No faxes were sent to or from %s", d.Number)
+			return
+		}
+	case q.User == "":
+		fail("Enter a fax ID, a phone number or a user")
+		return
+	}
+	if q.User != "" {
+		subject = strings.TrimPrefix(subject+", ", ", ") + "accessed by " + q.User
+	}
+	entries, err := a.Store.accessLog(r.Context(), q, accessReportLimit)
+	if err != nil {
+		fail("Failed to load the access report: " + err.Error())
+		return
+	}
+
+	user, _ := a.sessionUser(r)
+	format := params.Get("format")
+	a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "access_report.generated",
+		FaxID: data["Fax"].(string), Detail: fmt.Sprintf("%s, %s to %s, %d entries%s", subject, data["From"], data["To"], len(entries),
+			map[string]string{"csv": ", as CSV", "pdf": ", as PDF"}[format])})
+	name := fmt.Sprintf("access-report-%s", now.Format("20060102-150405"))
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		w.Header().Set("Cache-Control", "private, no-store")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"at", "user", "action", "profile", "fax_id", "detail"})
+		for _, e := range entries {
+			cw.Write([]string{e.At.UTC().Format(time.RFC3339), e.Actor, e.Action, e.Profile, e.FaxID, e.Detail})
+		}
+		cw.Flush()
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.pdf"`)
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Write(accessReportPDF(subject, data["From"].(string), data["To"].(string), entries, now))
+	default:
+		data["Entries"] = entries
+		data["Subject"] = subject
+		params.Del("format")
+		data["Link"] = "/admin/access?" + params.Encode()
+		a.render(w, r, "admin_access.html", data)
+	}
+}
+
+// Access report PDF layout: entries per page, after the heading on the first
+const (
+	accessPDFRowsPerPage  = 44
+	accessPDFHeadingLines = 6
+)
+
+// accessReportPDF renders the entries as a table on US Letter pages, with times in now's time zone
+func accessReportPDF(subject, from, to string, entries []auditEntry, now time.Time) []byte {
+	const timeLayout = "2006-01-02 15:04:05 MST"
+	rows := entries
+	var pages []string
+	for first := true; first || len(rows) > 0; first = false {
+		var content bytes.Buffer
+		content.WriteString("BT\n")
+		n := accessPDFRowsPerPage
+		if first {
+			content.WriteString("/F2 18 Tf\n54 730 Td\n(Fax Access Report) Tj\n/F1 10 Tf\n14 TL\n0 -28 Td\n")
+			for _, line := range []string{
+				"Covers: " + subject,
+				"Period: " + from + " through " + to,
+				fmt.Sprintf("Entries: %d", len(entries)),
+				"Report created: " + now.Format(timeLayout),
+			} {
+				fmt.Fprintf(&content, "(%s) Tj T*\n", pdfString(line))
+			}
+			content.WriteString("T*\n")
+			n -= accessPDFHeadingLines
+		} else {
+			content.WriteString("/F1 10 Tf\n14 TL\n54 740 Td\n")
+		}
+		content.WriteString("/F2 9 Tf (When) Tj 110 0 Td (User) Tj 140 0 Td (Action) Tj 110 0 Td (Fax) Tj -360 0 Td T*\n/F1 8 Tf\n")
+		n = min(n, len(rows))
+		for _, e := range rows[:n] {
+			fmt.Fprintf(&content, "(%s) Tj 110 0 Td (%s) Tj 140 0 Td (%s) Tj 110 0 Td (%s) Tj -360 0 Td T*\n",
+				pdfString(e.At.In(now.Location()).Format(timeLayout)), pdfString(truncate(e.Actor, 32)),
+				pdfString(strings.TrimPrefix(e.Action, "fax.")), pdfString(truncate(e.FaxID, 36)))
+		}
+		rows = rows[n:]
+		content.WriteString("ET\n")
+		pages = append(pages, content.String())
+	}
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	for i, content := range pages {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+		)
+	}
+	return writePDF(objects)
+}
+
+// truncate shortens s to at most n characters, marking the cut
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
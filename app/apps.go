@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// faxAppCookieName stores the fax application selected in the UI switcher
+const faxAppCookieName = "fax_ui_app"
+
+// FaxApp is a configured Telnyx fax application (e.g. one per clinic location)
+type FaxApp struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+	From string `yaml:"from"` // default from number for faxes sent through this application
+}
+
+// faxApp returns the configured fax application with the given ID, or nil
+func (a *App) faxApp(id string) *FaxApp {
+	for i := range a.FaxApps {
+		if a.FaxApps[i].ID == id {
+			return &a.FaxApps[i]
+		}
+	}
+	return nil
+}
+
+// currentFaxApp returns the fax application selected in the switcher, defaulting to the first configured one.
+// Returns nil when no fax applications are configured.
+func (a *App) currentFaxApp(r *http.Request) *FaxApp {
+	if cookie, err := r.Cookie(faxAppCookieName); err == nil {
+		if app := a.faxApp(cookie.Value); app != nil {
+			return app
+		}
+	}
+	if len(a.FaxApps) > 0 {
+		return &a.FaxApps[0]
+	}
+	return nil
+}
+
+// defaultConnection returns the connection ID to use when the form provides none.
+// With several fax applications configured the selected application is used.
+func (a *App) defaultConnection(r *http.Request) string {
+	if len(a.FaxApps) > 1 {
+		return a.currentFaxApp(r).ID
+	}
+	return a.runtime().DefaultConnectionID
+}
+
+// defaultFrom returns the from number to use when the form provides none,
+// preferring the selected fax application's own default.
+func (a *App) defaultFrom(r *http.Request) string {
+	if app := a.currentFaxApp(r); app != nil && app.From != "" {
+		return app.From
+	}
+	return a.runtime().DefaultFrom
+}
+
+// handleSwitchApp selects the fax application used by the send form and settings pages
+func (a *App) handleSwitchApp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	app := a.faxApp(r.FormValue("app"))
+	if app == nil {
+		http.Error(w, "unknown fax application", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     faxAppCookieName,
+		Value:    app.ID,
+		Path:     "/",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Validate redirect to prevent open redirect attacks
+	redirect := r.FormValue("redirect")
+	if redirect == "" || !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/"
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// navData returns the data shared by the navigation bar on every page
+func (a *App) navData(r *http.Request) map[string]any {
+	_, viewingAs, _ := a.impersonation(r)
+	return map[string]any{
+		"FaxApps":      a.FaxApps,
+		"CurrentApp":   a.currentFaxApp(r),
+		"ShowSettings": len(a.FaxApps) > 0,
+		"IsAdmin":      a.isAdmin(r),
+		"Kiosk":        a.kiosk(r) != nil,
+		"ViewingAs":    viewingAs,
+		"Sandbox":      a.Sandbox,
+		"Scans":        a.Scans != nil,
+		"Intake":       len(a.Intake) > 0,
+		"Cloud":        a.Cloud != nil,
+		"TelnyxDown":   a.defaultProfile().breaker.isOpen(),
+		"Path":         r.URL.RequestURI(),
+		"Languages":    a.languageList(),
+		"Language":     a.language(r).Tag,
+	}
+}
+
+// render executes a page template, adding the navigation data shared by every page
+func (a *App) render(w http.ResponseWriter, r *http.Request, name string, data map[string]any) {
+	data["Nav"] = a.navData(r)
+	if err := a.tmpl(r).ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Received faxes are archived by a few workers, so an inbox page of unarchived faxes does not download
+// all of them at once. A failed fax waits archiveRetryAfter before inbox loads queue it again, twice as
+// long after each further failure, up to archiveMaxBackoff.
+const (
+	archiveTimeout    = 2 * time.Minute // bounds looking up and downloading one received fax
+	archiveWorkers    = 4
+	archiveQueueSize  = 200
+	archiveRetryAfter = 15 * time.Minute
+	archiveMaxBackoff = 24 * time.Hour
+)
+
+// archiveTask is a received fax waiting to be archived
+type archiveTask struct {
+	profile  *Profile
+	faxID    string
+	mediaURL string
+}
+
+// archiveReceived queues a received fax's document to be copied into the media store, so it outlives
+// the provider's retention window. mediaURL is the link from the webhook, if any; without one the fax
+// is looked up at the provider. When the queue is full the fax is left for a later inbox load.
+func (a *App) archiveReceived(p *Profile, faxID, mediaURL string) {
+	if !a.ArchiveReceived {
+		return
+	}
+	key := p.Name + "/" + faxID
+	if _, busy := a.archiving.LoadOrStore(key, true); busy {
+		return
+	}
+	a.archiveOnce.Do(a.startArchiveWorkers)
+	select {
+	case a.archiveQueue <- archiveTask{profile: p, faxID: faxID, mediaURL: mediaURL}:
+	default:
+		a.archiving.Delete(key)
+	}
+}
+
+// startArchiveWorkers starts the workers archiving the queued received faxes
+func (a *App) startArchiveWorkers() {
+	a.archiveQueue = make(chan archiveTask, archiveQueueSize)
+	for range archiveWorkers {
+		go func() {
+			for task := range a.archiveQueue {
+				a.runArchiveTask(task)
+			}
+		}()
+	}
+}
+
+// runArchiveTask archives a queued fax, recording a failed attempt so it is not tried again right away
+func (a *App) runArchiveTask(task archiveTask) {
+	defer a.archiving.Delete(task.profile.Name + "/" + task.faxID)
+	ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+	defer cancel()
+	err := a.archiveReceivedFax(ctx, task.profile, task.faxID, task.mediaURL)
+	if err == nil {
+		if err := a.Store.clearArchiveFailures(ctx, task.profile.Name, task.faxID); err != nil {
+			log.Printf("Warning: could not clear the archive attempts of fax %s: %v", task.faxID, err)
+		}
+		return
+	}
+	attempts, _, lookupErr := a.Store.archiveAttempts(ctx, task.profile.Name, task.faxID)
+	if lookupErr != nil {
+		log.Printf("Warning: could not look up the archive attempts of fax %s: %v", task.faxID, lookupErr)
+	}
+	attempts++
+	next := time.Now().Add(min(archiveRetryAfter<<(min(attempts, 10)-1), archiveMaxBackoff))
+	log.Printf("Warning: could not archive received fax %s (attempt %d, next after %s): %v", task.faxID, attempts, next.Format(time.RFC3339), err)
+	if err := a.Store.recordArchiveFailure(ctx, task.profile.Name, task.faxID, attempts, err.Error(), next); err != nil {
+		log.Printf("Warning: could not record the failed archive attempt of fax %s: %v", task.faxID, err)
+	}
+}
+
+// archiveInbox queues the received faxes among faxes that have not been archived yet, leaving out those
+// whose last attempt failed too recently
+func (a *App) archiveInbox(ctx context.Context, p *Profile, faxes []Fax) {
+	if !a.ArchiveReceived {
+		return
+	}
+	for _, f := range faxes {
+		if f.Direction != "inbound" || f.Status != "received" {
+			continue
+		}
+		if _, archived, err := a.Store.receivedMedia(ctx, p.Name, f.ID); err != nil || archived {
+			continue
+		}
+		if _, next, err := a.Store.archiveAttempts(ctx, p.Name, f.ID); err != nil || time.Now().Before(next) {
+			continue
+		}
+		a.archiveReceived(p, f.ID, f.MediaURL)
+	}
+}
+
+func (a *App) archiveReceivedFax(ctx context.Context, p *Profile, faxID, mediaURL string) error {
+	if _, archived, err := a.Store.receivedMedia(ctx, p.Name, faxID); err != nil || archived {
+		return err
+	}
+	doc, err := downloadReceived(ctx, p, faxID, mediaURL)
+	if err != nil {
+		return err
+	}
+	name, err := a.Media.Put(ctx, doc)
+	if err != nil {
+		return err
+	}
+	if err := a.Store.saveReceivedMedia(ctx, p.Name, faxID, name); err != nil {
+		a.Media.Delete(ctx, name)
+		return err
+	}
+	return nil
+}
+
+// receivedDocument returns a received fax's document: the archived copy, or else the provider's
+func (a *App) receivedDocument(ctx context.Context, p *Profile, faxID, mediaURL string) (*document, error) {
+	if name, _, err := a.Store.receivedMedia(ctx, p.Name, faxID); err == nil && name != "" {
+		if doc, err := a.Media.Get(ctx, name); err == nil {
+			return doc, nil
+		}
+	}
+	return downloadReceived(ctx, p, faxID, mediaURL)
+}
+
+// receivedMediaClient downloads documents at links taken from webhooks, which may only point at public
+// addresses: the link is only as trustworthy as the event that carried it
+var receivedMediaClient = newPublicClient(60 * time.Second)
+
+// downloadReceived downloads a received fax's document from the provider. mediaURL is the link from the
+// webhook, if any; without one the fax is looked up at the provider.
+func downloadReceived(ctx context.Context, p *Profile, faxID, mediaURL string) (*document, error) {
+	client := providerHTTPClient
+	if mediaURL != "" {
+		if _, err := checkUserURL(mediaURL, nil); err != nil {
+			return nil, fmt.Errorf("media URL: %w", err)
+		}
+		client = receivedMediaClient
+	} else {
+		// Cached lookups carry no links, so ask the provider itself
+		provider := p.provider
+		if c, ok := provider.(*cachingProvider); ok {
+			provider = c.FaxProvider
+		}
+		fax, err := provider.Get(ctx, faxID)
+		if err != nil {
+			return nil, err
+		}
+		mediaURL = firstNonEmpty(fax.MediaURL, fax.StoredMediaURL)
+		if mediaURL == "" {
+			return nil, fmt.Errorf("%s reports no document for it", provider.Name())
+		}
+	}
+
+	req, err := p.documentRequest(ctx, mediaURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download: %s", resp.Status)
+	}
+	doc, err := readMediaResponse(req, resp)
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestArchiveAttempts(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	app.ArchiveReceived = true
+	ctx := context.Background()
+	p := app.Profiles[0]
+
+	// A fax whose last attempt failed moments ago is not queued again
+	next := time.Now().Add(archiveRetryAfter)
+	if err := app.Store.recordArchiveFailure(ctx, p.Name, "f-1", 1, "download failed", next); err != nil {
+		t.Fatal(err)
+	}
+	app.archiveInbox(ctx, p, []Fax{{ID: "f-1", Direction: "inbound", Status: "received"}})
+	if _, queued := app.archiving.Load(p.Name + "/f-1"); queued {
+		t.Fatal("queued a fax before its retry-after")
+	}
+
+	// A failure counts the attempt and backs off further
+	app.runArchiveTask(archiveTask{profile: p, faxID: "f-1"})
+	attempts, after, err := app.Store.archiveAttempts(ctx, p.Name, "f-1")
+	if err != nil || attempts != 2 {
+		t.Fatalf("after a second failure: %d attempts, %v", attempts, err)
+	}
+	if wait := time.Until(after); wait < 2*archiveRetryAfter-time.Minute || wait > 2*archiveRetryAfter {
+		t.Fatalf("next attempt in %s, want %s", wait, 2*archiveRetryAfter)
+	}
+	if _, queued := app.archiving.Load(p.Name + "/f-1"); queued {
+		t.Fatal("the fax is still marked as being archived")
+	}
+
+	if err := app.Store.clearArchiveFailures(ctx, p.Name, "f-1"); err != nil {
+		t.Fatal(err)
+	}
+	if attempts, _, err := app.Store.archiveAttempts(ctx, p.Name, "f-1"); err != nil || attempts != 0 {
+		t.Fatalf("after clearing: %d attempts, %v", attempts, err)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+)
+
+// auditPageSize is how many of the latest audit entries the audit page shows
+const auditPageSize = 200
+
+// handleAdminAudit shows the latest audit log entries
+func (a *App) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	entries, err := a.Store.auditLog(r.Context(), auditPageSize)
+	if err != nil {
+		http.Error(w, "failed to load audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Entries":   entries,
+		"Limit":     auditPageSize,
+		"Retention": a.runtime().Retention,
+	}
+	a.render(w, r, "admin_audit.html", data)
+}
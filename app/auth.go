@@ -1,29 +1,38 @@
 package main
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/microsoft"
 )
 
+// OAuth sign-ins in progress are kept in a cookie per sign-in, named oauthCookiePrefix plus its state
 const (
-	sessionCookieName = "fax_ui_session"
-	sessionMaxAge     = 24 * time.Hour
+	oauthCookiePrefix = "oauth_login_"
+	oauthLoginMaxAge  = 10 * time.Minute
 )
 
+// defaultSessionCookieName is the session cookie's name unless --session_cookie_name sets another
+const defaultSessionCookieName = "fax_ui_session"
+
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
 	Password           string
+	PasswordHash       string // bcrypt hash of the password set at /setup, used when Password is empty
 	SessionSecret      string
 	GoogleClientID     string
 	GoogleClientSecret string
@@ -32,6 +41,17 @@ type AuthConfig struct {
 	GitHubClientID     string
 	GitHubSecret       string
 	BaseURL            string
+	AdminUsers         []string        // identities (emails, or "password") allowed to use admin pages; empty means everyone
+	Visibility         string          // which faxes users who are not admins see: all, or own
+	Departments        []Department    // groups of users who see the faxes tagged with the group's name
+	SessionMaxAge      time.Duration   // how long a session lasts after sign-in, or after its last request when sliding
+	SessionIdleTimeout time.Duration   // how long a session lasts without requests; 0 for no limit
+	SessionSliding     bool            // extend sessions by SessionMaxAge on every request
+	CookieName         string          // name of the session cookie
+	CookieDomain       string          // domain of the session cookies, e.g. .example.com to share a parent domain; empty for this host only
+	CookieSameSite     http.SameSite   // SameSite attribute of the session cookies
+	Exemptions         []AuthExemption // networks that may use some pages without signing in
+	Kiosks             []Kiosk         // accounts of shared terminals that only get the send form
 }
 
 // generateSessionToken creates a secure random session token
@@ -56,45 +76,89 @@ func verifySessionToken(token, signature, secret string) bool {
 	return hmac.Equal([]byte(signature), []byte(expected))
 }
 
-// setSessionCookie sets an authenticated session cookie
-func (a *App) setSessionCookie(w http.ResponseWriter, userInfo string) error {
-	token, err := generateSessionToken()
-	if err != nil {
-		return err
+// sessionUser returns the identity the request acts as: the signed-in user, or the user an admin is viewing as
+func (a *App) sessionUser(r *http.Request) (string, bool) {
+	if _, user, ok := a.impersonation(r); ok {
+		return user, true
 	}
+	return a.signedInUser(r)
+}
 
-	signature := signSessionToken(token, a.AuthConfig.SessionSecret)
-	value := fmt.Sprintf("%s.%s.%s", token, signature, userInfo)
+// signedInUser returns the user identity of the request's session, API token or client certificate
+func (a *App) signedInUser(r *http.Request) (string, bool) {
+	if s, ok := a.currentSession(r); ok {
+		return s.User, true
+	}
+	if user, ok := apiTokenUser(r); ok {
+		return user, true
+	}
+	if a.ClientCerts != nil {
+		if user, ok := a.ClientCerts.user(r); ok {
+			return user, true
+		}
+	}
+	return exemptUser(r)
+}
 
+// writeAuthCookie sets a cookie of the signed-in user (the session, remember-me or view-as cookie) with the
+// configured domain and SameSite mode, expiring after maxAge. A negative maxAge deletes the cookie.
+func (a *App) writeAuthCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	seconds := int(maxAge.Seconds())
+	if maxAge < 0 {
+		seconds = -1
+	}
 	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
+		Name:     name,
 		Value:    value,
 		Path:     "/",
-		MaxAge:   int(sessionMaxAge.Seconds()),
+		Domain:   a.AuthConfig.CookieDomain,
+		MaxAge:   seconds,
 		HttpOnly: true,
-		Secure:   strings.HasPrefix(a.PublicBaseURL, "https://"),
-		SameSite: http.SameSiteLaxMode,
+		Secure:   strings.HasPrefix(a.PublicBaseURL(), "https://"),
+		SameSite: a.AuthConfig.CookieSameSite,
 	})
-	return nil
 }
 
-// clearSessionCookie removes the session cookie
-func clearSessionCookie(w http.ResponseWriter) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: true,
-	})
+// parseSameSite parses the --session_cookie_samesite mode. None is refused: forms carry no CSRF token, so
+// the SameSite mode is what keeps other sites from posting them with the user's session.
+func parseSameSite(mode string) (http.SameSite, error) {
+	switch m := strings.ToLower(strings.TrimSpace(mode)); m {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return 0, fmt.Errorf("session cookie SameSite mode %q would let other sites post forms as the signed-in user (expected lax or strict)", m)
+	}
+	return 0, fmt.Errorf("invalid session cookie SameSite mode %q (expected lax or strict)", mode)
 }
 
 // hasAuthConfigured returns true if any authentication method is configured
 func (a *App) hasAuthConfigured() bool {
-	return a.AuthConfig.Password != "" ||
+	return a.hasLoginPage() || a.ClientCerts != nil
+}
+
+// hasLoginPage returns true if users can sign in on the login page, rather than only with client certificates
+func (a *App) hasLoginPage() bool {
+	return a.hasPassword() ||
 		a.AuthConfig.GoogleClientID != "" ||
 		a.AuthConfig.MicrosoftClientID != "" ||
-		a.AuthConfig.GitHubClientID != ""
+		a.AuthConfig.GitHubClientID != "" ||
+		a.SAML != nil
+}
+
+// hasPassword returns true if users can sign in with the shared password
+func (a *App) hasPassword() bool {
+	return a.AuthConfig.Password != "" || a.AuthConfig.PasswordHash != ""
+}
+
+// passwordMatches checks a password typed on the login page against AUTH_PASSWORD, or the one set at /setup
+func (a *App) passwordMatches(password string) bool {
+	if a.AuthConfig.Password != "" {
+		return password == a.AuthConfig.Password
+	}
+	return a.AuthConfig.PasswordHash != "" &&
+		bcrypt.CompareHashAndPassword([]byte(a.AuthConfig.PasswordHash), []byte(password)) == nil
 }
 
 // isAuthenticated checks if the request has a valid session
@@ -104,31 +168,94 @@ func (a *App) isAuthenticated(r *http.Request) bool {
 		return true
 	}
 
-	cookie, err := r.Cookie(sessionCookieName)
-	if err != nil {
+	_, ok := a.sessionUser(r)
+	return ok
+}
+
+// isAdmin checks if the request belongs to a user allowed to use admin pages.
+// Without ADMIN_USERS every authenticated user is an admin, matching the single-tenant default.
+func (a *App) isAdmin(r *http.Request) bool {
+	if !a.hasAuthConfigured() {
+		return true
+	}
+	if _, exempted := exemptUser(r); exempted {
 		return false
 	}
-
-	parts := strings.SplitN(cookie.Value, ".", 3)
-	if len(parts) != 3 {
+	if a.kiosk(r) != nil {
 		return false
 	}
+	user, ok := a.sessionUser(r)
+	return ok && a.isAdminUser(user)
+}
 
-	token, signature := parts[0], parts[1]
-	return verifySessionToken(token, signature, a.AuthConfig.SessionSecret)
+// isAdminUser checks whether a signed-in user identity may use admin pages
+func (a *App) isAdminUser(user string) bool {
+	return len(a.AuthConfig.AdminUsers) == 0 || containsFold(a.AuthConfig.AdminUsers, user)
 }
 
 // requireAuth is middleware that requires authentication
 func (a *App) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if a.setupPending() {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "fax-ui is not set up yet; finish the setup at /setup"})
+				return
+			}
+			http.Redirect(w, r, "/setup", http.StatusSeeOther)
+			return
+		}
+		if a.hasAuthConfigured() {
+			r = a.withSession(w, r)
+			r = a.withAPIToken(r)
+		}
+		exempted := false
 		if !a.isAuthenticated(r) {
+			r, exempted = a.exempt(r)
+		}
+		if !exempted && !a.isAuthenticated(r) {
+			// API clients get an error they can act on instead of the sign-in page
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "a valid API token is required"})
+				return
+			}
+			if !a.hasLoginPage() {
+				http.Error(w, "a valid client certificate is required", http.StatusForbidden)
+				return
+			}
 			http.Redirect(w, r, "/login?redirect="+r.URL.Path, http.StatusSeeOther)
 			return
 		}
+		if a.kiosk(r) != nil && !kioskAllows(r) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if admin, user, ok := a.impersonation(r); ok {
+			r = a.impersonating(r, admin, user)
+		}
 		next(w, r)
 	}
 }
 
+// requireAdmin is middleware that requires an authenticated admin user
+func (a *App) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return a.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		// Exemptions never open admin pages, even when every user is an admin
+		if _, ok := exemptUser(r); ok {
+			if a.hasLoginPage() {
+				http.Redirect(w, r, "/login?redirect="+r.URL.Path, http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "admin access required", http.StatusForbidden)
+			return
+		}
+		if !a.isAdmin(r) {
+			http.Error(w, "admin access required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
 // handleLogin shows the login page or processes login
 func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 	// If no auth configured, redirect to home
@@ -159,10 +286,14 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 		"HasGoogle":    a.AuthConfig.GoogleClientID != "",
 		"HasMicrosoft": a.AuthConfig.MicrosoftClientID != "",
 		"HasGitHub":    a.AuthConfig.GitHubClientID != "",
-		"HasPassword":  a.AuthConfig.Password != "",
+		"HasSAML":      a.SAML != nil,
+		"HasPassword":  a.hasPassword(),
+		"Languages":    a.languageList(),
+		"Language":     a.language(r).Tag,
+		"Path":         r.URL.RequestURI(),
 	}
 
-	if err := a.Tmpl.ExecuteTemplate(w, "login.html", data); err != nil {
+	if err := a.tmpl(r).ExecuteTemplate(w, "login.html", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
@@ -181,8 +312,8 @@ func (a *App) handlePasswordLogin(w http.ResponseWriter, r *http.Request) {
 		redirect = "/"
 	}
 
-	if password == a.AuthConfig.Password {
-		if err := a.setSessionCookie(w, "password"); err != nil {
+	if a.passwordMatches(password) {
+		if err := a.startSession(w, r, "password", r.FormValue("remember") == "1"); err != nil {
 			http.Error(w, "failed to create session", http.StatusInternalServerError)
 			return
 		}
@@ -195,13 +326,14 @@ func (a *App) handlePasswordLogin(w http.ResponseWriter, r *http.Request) {
 
 // handleLogout clears the session and redirects to login
 func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
-	clearSessionCookie(w)
+	a.endSession(w, r)
+	a.clearImpersonationCookie(w)
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
 // getOAuthConfig returns OAuth2 config for the specified provider
 func (a *App) getOAuthConfig(provider string) *oauth2.Config {
-	redirectURL := a.PublicBaseURL + "/auth/callback/" + provider
+	redirectURL := a.PublicBaseURL() + "/auth/callback/" + provider
 
 	switch provider {
 	case "google":
@@ -251,29 +383,36 @@ func (a *App) handleOAuthLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Store redirect in cookie
 	redirect := r.URL.Query().Get("redirect")
 	if redirect == "" {
 		redirect = "/"
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:     "oauth_redirect",
-		Value:    redirect,
-		Path:     "/",
-		MaxAge:   300, // 5 minutes
-		HttpOnly: true,
-	})
+	remember := "0"
+	if r.URL.Query().Get("remember") == "1" {
+		remember = "1"
+	}
 
-	state, _ := generateSessionToken()
+	// Each sign-in gets its own cookie, named after its state, so sign-ins started in several tabs do
+	// not overwrite each other. It holds the PKCE code verifier, "Keep me signed in" and the redirect,
+	// and is only sent back to the callback.
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+	verifier := oauth2.GenerateVerifier()
 	http.SetCookie(w, &http.Cookie{
-		Name:     "oauth_state",
-		Value:    state,
-		Path:     "/",
-		MaxAge:   300,
+		Name:     oauthCookiePrefix + state,
+		Value:    verifier + "." + remember + "." + base64.RawURLEncoding.EncodeToString([]byte(redirect)),
+		Path:     "/auth/callback/",
+		MaxAge:   int(oauthLoginMaxAge.Seconds()),
 		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.PublicBaseURL(), "https://"),
+		SameSite: http.SameSiteLaxMode,
 	})
 
-	url := config.AuthCodeURL(state)
+	url := config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
@@ -281,12 +420,23 @@ func (a *App) handleOAuthLogin(w http.ResponseWriter, r *http.Request) {
 func (a *App) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 	provider := strings.TrimPrefix(r.URL.Path, "/auth/callback/")
 
-	// Verify state
-	stateCookie, err := r.Cookie("oauth_state")
-	if err != nil || stateCookie.Value != r.URL.Query().Get("state") {
+	// Verify state: it names the cookie of the sign-in it belongs to
+	state := r.URL.Query().Get("state")
+	var login *http.Cookie
+	if state != "" && !strings.ContainsAny(state, ".=; ") {
+		login, _ = r.Cookie(oauthCookiePrefix + state)
+	}
+	if login == nil {
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+	parts := strings.SplitN(login.Value, ".", 3)
+	if len(parts) != 3 {
 		http.Error(w, "invalid state", http.StatusBadRequest)
 		return
 	}
+	verifier, remember := parts[0], parts[1] == "1"
+	http.SetCookie(w, &http.Cookie{Name: oauthCookiePrefix + state, MaxAge: -1, Path: "/auth/callback/"})
 
 	config := a.getOAuthConfig(provider)
 	if config == nil {
@@ -295,7 +445,7 @@ func (a *App) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 	}
 
 	code := r.URL.Query().Get("code")
-	token, err := config.Exchange(r.Context(), code)
+	token, err := config.Exchange(r.Context(), code, oauth2.VerifierOption(verifier))
 	if err != nil {
 		http.Error(w, "failed to exchange token", http.StatusInternalServerError)
 		return
@@ -306,23 +456,89 @@ func (a *App) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set session
-	if err := a.setSessionCookie(w, provider); err != nil {
+	// Identify the user by their email address at the provider
+	email, err := oauthUserEmail(r.Context(), provider, config.Client(r.Context(), token))
+	if err != nil {
+		log.Printf("Warning: could not fetch %s user email: %v", provider, err)
+		http.Error(w, "failed to fetch user details", http.StatusInternalServerError)
+		return
+	}
+
+	// Set session, remembered if "Keep me signed in" was ticked on the login page
+	if err := a.startSession(w, r, email, remember); err != nil {
 		http.Error(w, "failed to create session", http.StatusInternalServerError)
 		return
 	}
 
-	// Get redirect and validate to prevent open redirect attacks
+	// Validate redirect to prevent open redirect attacks
 	redirect := "/"
-	if cookie, err := r.Cookie("oauth_redirect"); err == nil {
-		if strings.HasPrefix(cookie.Value, "/") && !strings.HasPrefix(cookie.Value, "//") {
-			redirect = cookie.Value
+	if decoded, err := base64.RawURLEncoding.DecodeString(parts[2]); err == nil {
+		if s := string(decoded); strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") {
+			redirect = s
 		}
 	}
 
-	// Clear OAuth state cookies (but NOT the session cookie!)
-	http.SetCookie(w, &http.Cookie{Name: "oauth_state", MaxAge: -1, Path: "/"})
-	http.SetCookie(w, &http.Cookie{Name: "oauth_redirect", MaxAge: -1, Path: "/"})
-
 	http.Redirect(w, r, redirect, http.StatusSeeOther)
 }
+
+// oauthUserEmail fetches the signed-in user's email address from the provider's user info API
+func oauthUserEmail(ctx context.Context, provider string, client *http.Client) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	getJSON := func(url string, v any) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %s", url, res.Status)
+		}
+		return json.NewDecoder(res.Body).Decode(v)
+	}
+
+	switch provider {
+	case "google":
+		var info struct {
+			Email string `json:"email"`
+		}
+		if err := getJSON("https://www.googleapis.com/oauth2/v3/userinfo", &info); err != nil {
+			return "", err
+		}
+		if info.Email != "" {
+			return info.Email, nil
+		}
+	case "microsoft":
+		var info struct {
+			Mail              string `json:"mail"`
+			UserPrincipalName string `json:"userPrincipalName"`
+		}
+		if err := getJSON("https://graph.microsoft.com/v1.0/me", &info); err != nil {
+			return "", err
+		}
+		if email := firstNonEmpty(info.Mail, info.UserPrincipalName); email != "" {
+			return email, nil
+		}
+	case "github":
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := getJSON("https://api.github.com/user/emails", &emails); err != nil {
+			return "", err
+		}
+		for _, e := range emails {
+			if e.Primary && e.Verified {
+				return e.Email, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no email address returned by %s", provider)
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A backup is a gzipped tarball of the database, a snapshot of the configuration and optionally the
+// stored documents, encrypted with BACKUP_KEY. /admin/backup downloads one and `fax-ui restore` puts it
+// back. The tarball is sealed in chunks with AES-256-GCM, so backups of any size are written as they
+// are made, and a truncated or altered file is noticed before the database is replaced.
+
+// backupMagic starts every backup file
+const backupMagic = "FAXUIBAK1\n"
+
+// backupChunkSize is how much of the tarball each sealed chunk holds
+const backupChunkSize = 64 << 10
+
+// backupNoncePrefixSize is the random part of each chunk's nonce; a counter and a last-chunk flag follow
+const backupNoncePrefixSize = 7
+
+// Entries of the tarball
+const (
+	backupDatabaseEntry = "fax-ui.db"
+	backupConfigEntry   = "config.json"
+	backupConfigFile    = "config.yaml" // the configuration file as it was, when one is used
+	backupManifestEntry = "manifest.json"
+	backupMediaDir      = "media/"
+)
+
+// backupEnvironment are the environment variables fax-ui reads that are not named after a flag, recorded
+// in the configuration snapshot along with those that are
+var backupEnvironment = []string{
+	"ADMIN_USERS", "AUTH_PASSWORD", "AWS_ACCESS_KEY_ID", "AWS_REGION", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+	"BACKUP_KEY", "CLOUD_ARCHIVE_CLIENT_ID", "CLOUD_ARCHIVE_CLIENT_SECRET", "CONFIG_FILE", "DATABASE_PATH",
+	"FAX_APPLICATION_ID", "FAX_CONNECTION_ID", "FAX_FROM_DEFAULT", "FAX_PROFILE", "FHIR_TOKEN", "FROM_NUMBER",
+	"GITHUB_CLIENT_ID", "GITHUB_CLIENT_SECRET", "GOOGLE_CLIENT_ID", "GOOGLE_CLIENT_SECRET", "HIPAA_MODE",
+	"IPP_PASSWORD", "IPP_PRINTER_NAME", "MEDIA_ENCRYPTION_KEY", "MICROSOFT_CLIENT_ID", "MICROSOFT_CLIENT_SECRET",
+	"PORT", "SANDBOX_MODE", "SESSION_SECRET", "SFTP_PASSWORD", "TELNYX_API_BASE_URL", "TELNYX_API_KEY",
+	"TELNYX_CONNECTION_ID", "TELNYX_HTTP_PROXY", "VAPID_PRIVATE_KEY",
+}
+
+// backupManifest describes a backup
+type backupManifest struct {
+	Version   string    `json:"version"` // of fax-ui that made it
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+	Documents int       `json:"documents"` // stored documents included; 0 when they were left out
+}
+
+// backupConfig is the configuration snapshot. Secrets are left out: they are recorded as set, and have
+// to be kept elsewhere, e.g. in a password manager.
+type backupConfig struct {
+	Flags       map[string]string `json:"flags"`       // set on the command line
+	Environment map[string]string `json:"environment"` // variables fax-ui reads
+}
+
+// parseBackupKey decodes BACKUP_KEY; empty turns backups off
+func parseBackupKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("BACKUP_KEY must be a base64-encoded 32-byte key, e.g. from `openssl rand -base64 32`")
+	}
+	return key, nil
+}
+
+// newBackupAEAD returns the cipher backups are sealed with
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupNonce is the nonce of chunk n; the last chunk is marked so a truncated backup is noticed
+func backupNonce(prefix []byte, n uint32, last bool) []byte {
+	nonce := make([]byte, 0, backupNoncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, n)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// backupWriter seals what is written to it chunk by chunk
+type backupWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+}
+
+// newBackupWriter starts an encrypted backup on w
+func newBackupWriter(w io.Writer, key []byte) (*backupWriter, error) {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, backupNoncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, backupMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &backupWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, backupChunkSize)}, nil
+}
+
+func (b *backupWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), backupChunkSize-len(b.buf))
+		b.buf = append(b.buf, p[:n]...)
+		p, written = p[n:], written+n
+		// A full chunk is only sealed once more follows, since the last one has to be marked
+		if len(b.buf) == backupChunkSize && len(p) > 0 {
+			if err := b.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// seal encrypts and writes the buffered chunk
+func (b *backupWriter) seal(last bool) error {
+	_, err := b.w.Write(b.aead.Seal(nil, backupNonce(b.prefix, b.n, last), b.buf, nil))
+	b.n++
+	b.buf = b.buf[:0]
+	return err
+}
+
+// Close seals the last chunk
+func (b *backupWriter) Close() error {
+	return b.seal(true)
+}
+
+// backupReader opens an encrypted backup chunk by chunk
+type backupReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+	done   bool
+}
+
+// newBackupReader checks r starts a backup and returns a reader of the tarball in it
+func newBackupReader(r io.Reader, key []byte) (*backupReader, error) {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(r, backupChunkSize+aead.Overhead())
+	header := make([]byte, len(backupMagic)+backupNoncePrefixSize)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(backupMagic)]) != backupMagic {
+		return nil, errors.New("not a fax-ui backup")
+	}
+	return &backupReader{r: br, aead: aead, prefix: header[len(backupMagic):]}, nil
+}
+
+func (b *backupReader) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		if err := b.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk
+func (b *backupReader) open() error {
+	sealed := make([]byte, backupChunkSize+b.aead.Overhead())
+	n, err := io.ReadFull(b.r, sealed)
+	switch {
+	case err == io.EOF:
+		return errors.New("the backup is truncated")
+	case err == io.ErrUnexpectedEOF:
+		b.done = true
+	case err != nil:
+		return err
+	default:
+		_, err := b.r.Peek(1)
+		b.done = err == io.EOF
+	}
+	plain, err := b.aead.Open(nil, backupNonce(b.prefix, b.n, b.done), sealed[:n], nil)
+	if err != nil {
+		if b.n == 0 {
+			return errors.New("the backup cannot be decrypted: BACKUP_KEY is not the key it was made with, or the file is damaged")
+		}
+		return errors.New("the backup is damaged or truncated")
+	}
+	b.n++
+	b.buf = plain
+	return nil
+}
+
+// configSnapshot records the flags set on the command line and the environment fax-ui reads, with
+// secrets left out
+func configSnapshot() backupConfig {
+	snap := backupConfig{Flags: map[string]string{}, Environment: map[string]string{}}
+	flag.Visit(func(f *flag.Flag) {
+		snap.Flags[f.Name] = redactSecret(strings.ToUpper(f.Name), f.Value.String())
+	})
+	names := slices.Clone(backupEnvironment)
+	flag.VisitAll(func(f *flag.Flag) { names = append(names, strings.ToUpper(f.Name)) })
+	for _, name := range names {
+		if v, ok := os.LookupEnv(name); ok {
+			snap.Environment[name] = redactSecret(name, v)
+		}
+	}
+	return snap
+}
+
+// redactSecret replaces the value of a secret setting, named after its environment variable, with a
+// note that it was set. Other settings keep their value without the credentials of a URL such as
+// DATABASE_URL or a proxy, or the password of a key=value database connection string.
+func redactSecret(name, value string) string {
+	if value == "" || strings.HasSuffix(name, "PUBLIC_KEY") {
+		return value
+	}
+	for _, s := range []string{"SECRET", "PASSWORD", "TOKEN", "KEY"} {
+		if strings.Contains(name, s) {
+			return "(set, not included)"
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+		// A user name alone is often a token, e.g. https://TOKEN@proxy
+		u.User = url.User("xxxxx")
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(value, "${1}xxxxx")
+}
+
+// dsnPassword matches the password of a key=value connection string, e.g. "host=db password=secret"
+var dsnPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// writeBackup writes an encrypted backup of the database snapshot at dbPath, the configuration and, when
+// withMedia is set, the stored documents to w
+func (a *App) writeBackup(ctx context.Context, w io.Writer, dbPath string, withMedia bool, user string) (backupManifest, error) {
+	manifest := backupManifest{Version: Version, CreatedAt: time.Now().UTC(), CreatedBy: user}
+	bw, err := newBackupWriter(w, a.BackupKey)
+	if err != nil {
+		return manifest, err
+	}
+	gz := gzip.NewWriter(bw)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte, modTime time.Time) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: modTime}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	db, err := os.Open(dbPath)
+	if err != nil {
+		return manifest, err
+	}
+	defer db.Close()
+	info, err := db.Stat()
+	if err != nil {
+		return manifest, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: backupDatabaseEntry, Mode: 0o600, Size: info.Size(), ModTime: manifest.CreatedAt}); err != nil {
+		return manifest, err
+	}
+	if _, err := io.Copy(tw, db); err != nil {
+		return manifest, err
+	}
+
+	config, err := json.MarshalIndent(configSnapshot(), "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := add(backupConfigEntry, config, manifest.CreatedAt); err != nil {
+		return manifest, err
+	}
+	if f := flag.Lookup("config"); f != nil {
+		if p := firstNonEmpty(f.Value.String(), os.Getenv("CONFIG_FILE")); p != "" {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return manifest, err
+			}
+			if err := add(backupConfigFile, data, manifest.CreatedAt); err != nil {
+				return manifest, err
+			}
+		}
+	}
+
+	if withMedia {
+		names, err := a.Store.mediaFiles(ctx)
+		if err != nil {
+			return manifest, err
+		}
+		slices.Sort(names)
+		for _, name := range slices.Compact(names) {
+			doc, err := a.Media.Get(ctx, name)
+			if errors.Is(err, errMediaNotFound) {
+				continue
+			} else if err != nil {
+				return manifest, fmt.Errorf("document %s: %w", name, err)
+			}
+			if err := add(backupMediaDir+name, doc.Data, firstTime(doc.Stored, manifest.CreatedAt)); err != nil {
+				return manifest, err
+			}
+			manifest.Documents++
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := add(backupManifestEntry, data, manifest.CreatedAt); err != nil {
+		return manifest, err
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	if err := gz.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, bw.Close()
+}
+
+// handleAdminBackup shows the backup page and downloads a backup
+func (a *App) handleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.render(w, r, "admin_backup.html", map[string]any{
+			"Enabled":  a.BackupKey != nil,
+			"Archived": a.ArchiveReceived,
+			"Postgres": a.Store.postgres(),
+		})
+	case http.MethodPost:
+		if a.BackupKey == nil {
+			http.Error(w, "backups need BACKUP_KEY to be set", http.StatusBadRequest)
+			return
+		}
+		if a.Store.postgres() {
+			http.Error(w, "back up the Postgres database with pg_dump", http.StatusBadRequest)
+			return
+		}
+		// The snapshot is taken before anything is sent, so a failure can still be reported
+		dir, err := os.MkdirTemp("", "fax-ui-backup-")
+		if err != nil {
+			http.Error(w, "failed to create backup: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.RemoveAll(dir)
+		dbPath := filepath.Join(dir, backupDatabaseEntry)
+		if err := a.Store.snapshot(r.Context(), dbPath); err != nil {
+			http.Error(w, "failed to create backup: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		user, _ := a.sessionUser(r)
+		withMedia := r.FormValue("media") == "on"
+		name := "fax-ui-backup-" + time.Now().UTC().Format("20060102-150405") + ".tar.enc"
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		manifest, err := a.writeBackup(r.Context(), w, dbPath, withMedia, user)
+		if err != nil {
+			// The download ends without its last chunk, which restore refuses
+			log.Printf("Warning: backup %s failed: %v", name, err)
+			return
+		}
+		detail := "without documents"
+		if withMedia {
+			detail = fmt.Sprintf("with %d documents", manifest.Documents)
+		}
+		a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "backup.created", Detail: detail})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// runRestoreCommand restores a backup: the database replaces DATABASE_PATH, documents go back into the
+// upload directory or S3 bucket, and the configuration snapshot is written next to the database for
+// comparison with the current one. fax-ui must not be running meanwhile.
+func runRestoreCommand() error {
+	force := flag.Bool("force", false, "Replace an existing database (restore).")
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if flag.NArg() != 1 {
+		return errors.New("usage: fax-ui restore [--force] <backup file>")
+	}
+	if cfg.BackupKey == nil {
+		return errors.New("set BACKUP_KEY to the key the backup was made with")
+	}
+	if cfg.DatabaseURL != "" {
+		return errors.New("backups hold a SQLite database; unset DATABASE_URL to restore to DATABASE_PATH")
+	}
+	if _, err := os.Stat(cfg.DatabasePath); err == nil && !*force {
+		return fmt.Errorf("%s exists; stop fax-ui and pass --force to replace it", cfg.DatabasePath)
+	}
+	media, err := newMediaStore(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.MediaKey != nil {
+		if media, err = newEncryptedMediaStore(media, cfg.MediaKey); err != nil {
+			return err
+		}
+	}
+	restorer, _ := media.(mediaRestorer)
+	if _, inMemory := media.(*memoryMediaStore); inMemory {
+		restorer = nil
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br, err := newBackupReader(f, cfg.BackupKey)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	// The database is written beside the current one and only replaces it once the whole backup checked out
+	dir := filepath.Dir(cfg.DatabasePath)
+	restoredDB := cfg.DatabasePath + ".restoring"
+	defer os.Remove(restoredDB)
+	var manifest backupManifest
+	var documents, skipped int
+	ctx := context.Background()
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch name := path.Clean(h.Name); {
+		case name == backupDatabaseEntry:
+			if err := writeFileFrom(restoredDB, tr); err != nil {
+				return err
+			}
+		case name == backupConfigEntry || name == backupConfigFile:
+			if err := writeFileFrom(filepath.Join(dir, "restored-"+name), tr); err != nil {
+				return err
+			}
+		case name == backupManifestEntry:
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return fmt.Errorf("invalid manifest: %w", err)
+			}
+		case strings.HasPrefix(name, backupMediaDir):
+			if restorer == nil {
+				skipped++
+				continue
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			doc := &document{Name: path.Base(name), Type: mediaType(name, data), Data: data}
+			if err := restorer.putAs(ctx, path.Base(name), doc); err != nil {
+				return fmt.Errorf("document %s: %w", path.Base(name), err)
+			}
+			documents++
+		}
+	}
+	// Reading to the end checks the last chunk, so a truncated backup stops here
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		return err
+	}
+	if manifest.CreatedAt.IsZero() {
+		return errors.New("the backup is incomplete: it has no manifest")
+	}
+	if _, err := os.Stat(restoredDB); err != nil {
+		return errors.New("the backup has no database")
+	}
+	if _, err := os.Stat(cfg.DatabasePath); err == nil {
+		if err := os.Rename(cfg.DatabasePath, cfg.DatabasePath+".before-restore"); err != nil {
+			return err
+		}
+		fmt.Printf("Moved the previous database to %s\n", cfg.DatabasePath+".before-restore")
+	}
+	// A write-ahead log left by the previous database would be applied to the restored one
+	os.Remove(cfg.DatabasePath + "-wal")
+	os.Remove(cfg.DatabasePath + "-shm")
+	if err := os.Rename(restoredDB, cfg.DatabasePath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored the database from the backup made %s by fax-ui %s to %s\n",
+		manifest.CreatedAt.Format(time.RFC3339), manifest.Version, cfg.DatabasePath)
+	fmt.Printf("Restored %d of %d documents\n", documents, manifest.Documents)
+	if skipped > 0 {
+		fmt.Printf("Skipped %d documents: set UPLOAD_DIR or S3_BUCKET to restore them\n", skipped)
+	}
+	fmt.Printf("The configuration at the time is in %s; secrets were not included\n", filepath.Join(dir, "restored-"+backupConfigEntry))
+	return nil
+}
+
+// mediaRestorer is a media store documents can be put back into under their original names
+type mediaRestorer interface {
+	putAs(ctx context.Context, name string, doc *document) error
+}
+
+// writeFileFrom writes what r holds to a new file at path, readable only by its owner
+func writeFileFrom(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Faxes are never sent to a number on the blocklist, from the form, the CLI, the gRPC API or the scan
+// folder. Admins add numbers one at a time or import them from a CSV file at /admin/blocklist, and
+// --blocklist_url syncs an operator's list, e.g. a national do-not-call list, periodically. Every number
+// added or removed is recorded in the audit log.
+
+// Sources of blocked numbers
+const (
+	blockSourceAdmin = "admin" // added or imported by an admin
+	blockSourceSync  = "sync"  // from --blocklist_url; the sync removes it when the list drops it
+)
+
+// blocklistSyncActor records the sync's changes in the audit log
+const blocklistSyncActor = "blocklist sync"
+
+// maxBlocklistSize caps an imported file or a synced list
+const maxBlocklistSize = 20 << 20
+
+// blockedNumber is a destination faxes may not be sent to
+type blockedNumber struct {
+	Number    string // E.164
+	Reason    string
+	Source    string
+	AddedBy   string
+	CreatedAt time.Time
+}
+
+// blocklistSync is the state of the periodic sync from --blocklist_url on this instance
+type blocklistSync struct {
+	URL      string
+	Interval time.Duration
+	mu       sync.Mutex
+	at       time.Time // last run
+	added    int
+	removed  int
+	err      string
+}
+
+// blocklistStatus is the last sync's outcome, for the admin page
+type blocklistStatus struct {
+	URL      string
+	At       time.Time
+	Added    int
+	Removed  int
+	Error    string
+	Interval time.Duration
+}
+
+func (s *blocklistSync) status() blocklistStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return blocklistStatus{URL: listURLForDisplay(s.URL), At: s.at, Added: s.added, Removed: s.removed, Error: s.err, Interval: s.Interval}
+}
+
+// listURLForDisplay hides the credentials and query, e.g. an access token, of a list URL for pages and errors
+func listURLForDisplay(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// blocklistImport is what a CSV file or synced list holds
+type blocklistImport struct {
+	Entries    []blockedNumber // valid numbers, each once
+	Invalid    []string        // rows that are not a phone number
+	Duplicates int             // rows repeating a number earlier in the list
+}
+
+// parseBlocklist reads a list of numbers: CSV with the number in the first column and an optional reason
+// in the second, or one number per line. A first row that is not a number is taken as the header, and
+// lines starting with # are comments. Do-not-call registries that put the area code and the number in
+// separate columns ("312,5550100") are read too.
+func parseBlocklist(r io.Reader, country string) (blocklistImport, error) {
+	var list blocklistImport
+	seen := make(map[string]bool)
+	cr := csv.NewReader(io.LimitReader(r, maxBlocklistSize))
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	for row := 0; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return list, err
+		}
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		number, reason := strings.TrimSpace(record[0]), ""
+		if len(record) > 1 {
+			reason = strings.TrimSpace(record[1])
+			if isDigits(number, 3) && isDigits(reason, 7) {
+				number, reason = number+reason, ""
+			}
+		}
+		e164, err := normalizePhoneNumber(number, country)
+		if err != nil || isSIPURI(e164) {
+			if row > 0 {
+				list.Invalid = append(list.Invalid, number)
+			}
+			continue
+		}
+		if seen[e164] {
+			list.Duplicates++
+			continue
+		}
+		seen[e164] = true
+		if len(reason) > 200 {
+			reason = reason[:200]
+		}
+		list.Entries = append(list.Entries, blockedNumber{Number: e164, Reason: reason})
+	}
+	return list, nil
+}
+
+// isDigits reports whether s is n decimal digits
+func isDigits(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// blockedNumbers returns the blocklist, newest first
+func (s *Store) blockedNumbers(ctx context.Context) ([]blockedNumber, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT number, reason, source, added_by, created_at FROM blocked_numbers
+		ORDER BY created_at DESC, number`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var numbers []blockedNumber
+	for rows.Next() {
+		var b blockedNumber
+		if err := rows.Scan(&b.Number, &b.Reason, &b.Source, &b.AddedBy, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, b)
+	}
+	return numbers, rows.Err()
+}
+
+// blockedNumber returns a number's blocklist entry, or nil if it is not blocked
+func (s *Store) blockedNumber(ctx context.Context, number string) (*blockedNumber, error) {
+	var b blockedNumber
+	err := s.db.QueryRowContext(ctx, `SELECT number, reason, source, added_by, created_at FROM blocked_numbers WHERE number = ?`, number).
+		Scan(&b.Number, &b.Reason, &b.Source, &b.AddedBy, &b.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// blockedSources returns the source of every blocked number
+func (s *Store) blockedSources(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT number, source FROM blocked_numbers`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sources := make(map[string]string)
+	for rows.Next() {
+		var number, source string
+		if err := rows.Scan(&number, &source); err != nil {
+			return nil, err
+		}
+		sources[number] = source
+	}
+	return sources, rows.Err()
+}
+
+// addBlockedNumber blocks a number, reporting whether it was not blocked already
+func (s *Store) addBlockedNumber(ctx context.Context, b blockedNumber) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO blocked_numbers (number, reason, source, added_by, created_at)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT (number) DO NOTHING`, b.Number, b.Reason, b.Source, b.AddedBy, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// removeBlockedNumber unblocks a number, reporting whether it was blocked
+func (s *Store) removeBlockedNumber(ctx context.Context, number string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM blocked_numbers WHERE number = ?`, number)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// checkBlocked returns a non-empty message when the destination is on the blocklist. A blocklist that
+// cannot be read refuses every destination, rather than let a fax through to a number that may be on it.
+func (a *App) checkBlocked(ctx context.Context, to string) string {
+	b, err := a.Store.blockedNumber(ctx, to)
+	if err != nil {
+		log.Printf("Warning: could not check the blocklist: %v", err)
+		return "The blocklist could not be checked. Try again in a moment."
+	}
+	if b != nil {
+		return "This number is on the blocklist; faxes may not be sent to it."
+	}
+	return ""
+}
+
+// blockNumbers adds imported numbers to the blocklist with an audit entry for each, returning how many were new
+func (a *App) blockNumbers(ctx context.Context, actor string, entries []blockedNumber, source string) (int, error) {
+	added := 0
+	for _, b := range entries {
+		b.Source, b.AddedBy = source, actor
+		ok, err := a.Store.addBlockedNumber(ctx, b)
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			added++
+			a.auditBlocklist(ctx, actor, "blocklist.added", b.Number, b.Reason)
+		}
+	}
+	return added, nil
+}
+
+// auditBlocklist records a change to the blocklist
+func (a *App) auditBlocklist(ctx context.Context, actor, action, number, reason string) {
+	detail := number
+	if reason != "" {
+		detail += ": " + reason
+	}
+	a.writeAudit(ctx, auditEntry{Actor: actor, Action: action, Detail: detail})
+}
+
+// startBlocklistSync syncs the blocklist from --blocklist_url now and then periodically
+func (a *App) startBlocklistSync() {
+	interval := a.Blocklist.Interval
+	backgroundTasks.register("blocklist sync", interval)
+	run := func() {
+		backgroundTasks.beat("blocklist sync")
+		if a.leads("blocklist sync", interval) {
+			a.syncBlocklist(context.Background())
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run()
+		}
+	}()
+}
+
+// syncBlocklist makes the synced part of the blocklist match the list at --blocklist_url: its new numbers
+// are blocked and the ones it dropped are unblocked. Numbers an admin blocked are left alone.
+func (a *App) syncBlocklist(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	added, removed, err := a.syncBlocklistFrom(ctx, a.Blocklist.URL)
+	s := a.Blocklist
+	s.mu.Lock()
+	s.at, s.added, s.removed, s.err = time.Now(), added, removed, ""
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("Warning: could not sync the blocklist: %v", err)
+	}
+	return err
+}
+
+func (a *App) syncBlocklistFrom(ctx context.Context, listURL string) (added, removed int, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("User-Agent", "fax-ui/"+Version)
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return 0, 0, fmt.Errorf("%s: %w", listURLForDisplay(listURL), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("%s: %s", listURLForDisplay(listURL), resp.Status)
+	}
+	list, err := parseBlocklist(resp.Body, a.DefaultCountry)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(list.Entries) == 0 {
+		// An empty list is more likely a broken export than an operator unblocking everyone
+		return 0, 0, errors.New("the list has no numbers")
+	}
+	sources, err := a.Store.blockedSources(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	listed := make(map[string]bool, len(list.Entries))
+	var fresh []blockedNumber
+	for _, b := range list.Entries {
+		listed[b.Number] = true
+		if _, ok := sources[b.Number]; !ok {
+			fresh = append(fresh, b)
+		}
+	}
+	if added, err = a.blockNumbers(ctx, blocklistSyncActor, fresh, blockSourceSync); err != nil {
+		return added, 0, err
+	}
+	for number, source := range sources {
+		if source != blockSourceSync || listed[number] {
+			continue
+		}
+		ok, err := a.Store.removeBlockedNumber(ctx, number)
+		if err != nil {
+			return added, removed, err
+		}
+		if ok {
+			removed++
+			a.auditBlocklist(ctx, blocklistSyncActor, "blocklist.removed", number, "")
+		}
+	}
+	return added, removed, nil
+}
+
+// handleAdminBlocklist shows the blocklist (GET) and adds, removes, previews or imports numbers, or syncs
+// the list now (POST)
+func (a *App) handleAdminBlocklist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		a.renderBlocklist(w, r, map[string]any{"Success": q.Get("success"), "Error": q.Get("error")})
+	case http.MethodPost:
+		a.handleUpdateAdminBlocklist(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// renderBlocklist renders the blocklist page with data
+func (a *App) renderBlocklist(w http.ResponseWriter, r *http.Request, data map[string]any) {
+	numbers, err := a.Store.blockedNumbers(r.Context())
+	if err != nil {
+		http.Error(w, "failed to load the blocklist: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data["Numbers"] = numbers
+	data["DefaultCountry"] = a.DefaultCountry
+	if a.Blocklist != nil {
+		data["Sync"] = a.Blocklist.status()
+	}
+	a.render(w, r, "admin_blocklist.html", data)
+}
+
+// handleUpdateAdminBlocklist applies the blocklist page's forms
+func (a *App) handleUpdateAdminBlocklist(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxBlocklistSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	redirect := func(status url.Values) {
+		http.Redirect(w, r, "/admin/blocklist?"+status.Encode(), http.StatusSeeOther)
+	}
+	user, _ := a.sessionUser(r)
+	actor := firstNonEmpty(user, "anonymous")
+
+	switch r.FormValue("action") {
+	case "add":
+		number, err := normalizePhoneNumber(r.FormValue("number"), a.DefaultCountry)
+		if err != nil || number == "" || isSIPURI(number) {
+			redirect(url.Values{"error": {a.T(r, "Enter a valid fax number")}})
+			return
+		}
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		added, err := a.blockNumbers(r.Context(), actor, []blockedNumber{{Number: number, Reason: reason}}, blockSourceAdmin)
+		switch {
+		case err != nil:
+			redirect(url.Values{"error": {a.failureMessage(r, err, "Failed to update the blocklist")}})
+		case added == 0:
+			redirect(url.Values{"error": {a.T(r, "%s is already on the blocklist", formatNumber(number, a.DefaultCountry))}})
+		default:
+			redirect(url.Values{"success": {a.T(r, "Blocked %s", formatNumber(number, a.DefaultCountry))}})
+		}
+	case "remove":
+		number := r.FormValue("number")
+		ok, err := a.Store.removeBlockedNumber(r.Context(), number)
+		if err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "Failed to update the blocklist")}})
+			return
+		}
+		if !ok {
+			redirect(url.Values{"error": {a.T(r, "%s is not on the blocklist", formatNumber(number, a.DefaultCountry))}})
+			return
+		}
+		a.auditBlocklist(r.Context(), actor, "blocklist.removed", number, "")
+		redirect(url.Values{"success": {a.T(r, "Unblocked %s", formatNumber(number, a.DefaultCountry))}})
+	case "preview":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			redirect(url.Values{"error": {a.T(r, "Choose a CSV file to import")}})
+			return
+		}
+		defer file.Close()
+		list, err := parseBlocklist(file, a.DefaultCountry)
+		if err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "The file could not be read as CSV")}})
+			return
+		}
+		sources, err := a.Store.blockedSources(r.Context())
+		if err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "Failed to load the blocklist")}})
+			return
+		}
+		var fresh []blockedNumber
+		for _, b := range list.Entries {
+			if _, ok := sources[b.Number]; !ok {
+				fresh = append(fresh, b)
+			}
+		}
+		// The numbers to import go back with the confirmation, as a list parseBlocklist reads again
+		var entries strings.Builder
+		cw := csv.NewWriter(&entries)
+		for _, b := range fresh {
+			cw.Write([]string{b.Number, b.Reason})
+		}
+		cw.Flush()
+		a.renderBlocklist(w, r, map[string]any{"Preview": map[string]any{
+			"New":        fresh,
+			"Existing":   len(list.Entries) - len(fresh),
+			"Duplicates": list.Duplicates,
+			"Invalid":    list.Invalid,
+			"Entries":    entries.String(),
+		}})
+	case "import":
+		list, err := parseBlocklist(strings.NewReader(r.FormValue("entries")), a.DefaultCountry)
+		if err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "The file could not be read as CSV")}})
+			return
+		}
+		added, err := a.blockNumbers(r.Context(), actor, list.Entries, blockSourceAdmin)
+		if err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "Imported %d numbers, then failed", added)}})
+			return
+		}
+		redirect(url.Values{"success": {a.T(r, "Imported %d numbers", added)}})
+	case "sync":
+		if a.Blocklist == nil {
+			redirect(url.Values{"error": {a.T(r, "No blocklist URL is configured")}})
+			return
+		}
+		if err := a.syncBlocklist(r.Context()); err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "The blocklist could not be synced")}})
+			return
+		}
+		st := a.Blocklist.status()
+		redirect(url.Values{"success": {a.T(r, "Synced the blocklist: %d added, %d removed", st.Added, st.Removed)}})
+	default:
+		redirect(url.Values{"error": {a.T(r, "unknown action")}})
+	}
+}
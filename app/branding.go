@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultAccent is the color of buttons unless the deployment brands them
+const defaultAccent = "#1f7a8c"
+
+// brandLogoPath serves a logo configured as a local file
+const brandLogoPath = "/branding/logo"
+
+// accentPattern accepts the CSS colors an accent may be: hex, a color name, or rgb()/hsl()
+var accentPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|(rgb|hsl)a?\([0-9.,% ]+\))$`)
+
+// Branding is how the UI presents itself, so each customer's deployment can carry that customer's brand.
+// Templates read it with {{ brand }}.
+type Branding struct {
+	Name         string // product name in page titles and headers
+	Logo         string // logo URL, shown in the navigation bar and on the login page
+	Accent       string // CSS color of buttons and highlights
+	Footer       string // text at the bottom of every page
+	LoginMessage string // shown on the login page above the sign-in options
+
+	logoFile string // a logo configured as a local file, served at brandLogoPath
+}
+
+// parseBranding checks the branding settings. A logo that is not an http(s) URL names a local file.
+func parseBranding(b Branding) (Branding, error) {
+	b.Name = firstNonEmpty(strings.TrimSpace(b.Name), "fax-ui")
+	b.Accent = firstNonEmpty(strings.TrimSpace(b.Accent), defaultAccent)
+	if !accentPattern.MatchString(b.Accent) {
+		return b, fmt.Errorf("invalid accent color %q (expected e.g. #1f7a8c, teal or rgb(31, 122, 140))", b.Accent)
+	}
+	if b.Logo != "" && !strings.HasPrefix(b.Logo, "https://") && !strings.HasPrefix(b.Logo, "http://") {
+		if _, err := os.Stat(b.Logo); err != nil {
+			return b, fmt.Errorf("logo: %w", err)
+		}
+		b.logoFile, b.Logo = b.Logo, brandLogoPath
+	}
+	return b, nil
+}
+
+// AccentCSS is the accent for the pages' stylesheet. parseBranding validated it, and html/template
+// would otherwise reject colors such as rgb(...).
+func (b Branding) AccentCSS() template.CSS {
+	return template.CSS(b.Accent)
+}
+
+// handleBrandLogo serves the logo file; it is public, since the login page shows it
+func (a *App) handleBrandLogo(w http.ResponseWriter, r *http.Request) {
+	if a.Branding.logoFile == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeFile(w, r, a.Branding.logoFile)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// listCache holds a list fetched from Telnyx and reuses it until it is older than the given TTL
+type listCache[T any] struct {
+	mu        sync.Mutex
+	items     []T
+	fetched   bool
+	fetchedAt time.Time
+}
+
+// get returns the cached items, calling fetch when the cache is empty, expired or refresh is set.
+// A failed fetch leaves the previous contents in place.
+func (c *listCache[T]) get(ttl time.Duration, refresh bool, fetch func() ([]T, error)) ([]T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !refresh && c.fetched && time.Since(c.fetchedAt) < ttl {
+		return c.items, nil
+	}
+	items, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	c.items = items
+	c.fetched = true
+	c.fetchedAt = time.Now()
+	return items, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits for chunked uploads. Browsers send large documents in chunks so a dropped connection on
+// flaky Wi-Fi only repeats the current chunk instead of the whole file.
+const (
+	uploadChunkSize  = 1 << 20  // chunk size the send form uses
+	maxUploadChunk   = 5 << 20  // largest chunk accepted
+	maxChunkedUpload = 50 << 20 // largest document accepted
+	uploadSessionTTL = time.Hour
+
+	// Uploads are held in memory, so their declared sizes are reserved when they start
+	maxUploadsPerUser = 5
+	maxUploadBytes    = 500 << 20 // of all users' uploads together
+)
+
+// Reasons an upload cannot start until others finish or expire
+var (
+	errTooManyUploads = errors.New("too many uploads in progress; send or discard one first")
+	errUploadsFull    = errors.New("the server is receiving too many uploads; try again in a few minutes")
+)
+
+// uploadSession is a document being uploaded in chunks. Chunks are kept in memory until the send
+// (or draft) that uses the document, and dropped when the session has been idle for uploadSessionTTL.
+type uploadSession struct {
+	ID        string
+	Owner     string
+	Name      string
+	Type      string
+	Size      int64 // declared total size
+	data      bytes.Buffer
+	expiresAt time.Time
+}
+
+// uploadSessions holds the chunked uploads in progress
+type uploadSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*uploadSession
+}
+
+// uploadStatus is the JSON the upload endpoints answer with
+type uploadStatus struct {
+	ID        string    `json:"id"`
+	Offset    int64     `json:"offset"`
+	Size      int64     `json:"size"`
+	ChunkSize int       `json:"chunk_size"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (s *uploadSession) status() uploadStatus {
+	return uploadStatus{ID: s.ID, Offset: int64(s.data.Len()), Size: s.Size, ChunkSize: uploadChunkSize, ExpiresAt: s.expiresAt}
+}
+
+// get returns the owner's session with the given ID, or nil when it does not exist or has expired.
+// u.mu must be held.
+func (u *uploadSessions) get(id, owner string) *uploadSession {
+	s := u.sessions[id]
+	if s == nil || s.Owner != owner || time.Now().After(s.expiresAt) {
+		return nil
+	}
+	return s
+}
+
+// finished returns the file name of the owner's upload if all of it has arrived
+func (u *uploadSessions) finished(id, owner string) (string, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s := u.get(id, owner)
+	if s == nil || int64(s.data.Len()) != s.Size {
+		return "", false
+	}
+	return s.Name, true
+}
+
+// start adds a session unless its owner has maxUploadsPerUser in progress, or its declared size would
+// take the uploads in progress past maxUploadBytes
+func (u *uploadSessions) start(s *uploadSession) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	mine, reserved := 0, s.Size
+	for _, other := range u.sessions {
+		if now.After(other.expiresAt) {
+			continue
+		}
+		if other.Owner == s.Owner {
+			mine++
+		}
+		reserved += other.Size
+	}
+	switch {
+	case mine >= maxUploadsPerUser:
+		return errTooManyUploads
+	case reserved > maxUploadBytes:
+		return errUploadsFull
+	}
+	u.sessions[s.ID] = s
+	return nil
+}
+
+// remove forgets a session, e.g. once its document has been sent
+func (u *uploadSessions) remove(id string) {
+	u.mu.Lock()
+	delete(u.sessions, id)
+	u.mu.Unlock()
+}
+
+// usage returns how many uploads are in progress and how many bytes they have received
+func (u *uploadSessions) usage() (int, int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var size int64
+	for _, s := range u.sessions {
+		size += int64(s.data.Len())
+	}
+	return len(u.sessions), size
+}
+
+// startCleanup periodically drops expired sessions
+func (u *uploadSessions) startCleanup(interval time.Duration) {
+	backgroundTasks.register("upload cleanup", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			backgroundTasks.beat("upload cleanup")
+			now := time.Now()
+			u.mu.Lock()
+			for id, s := range u.sessions {
+				if now.After(s.expiresAt) {
+					delete(u.sessions, id)
+					log.Printf("Cleaned up expired upload: %s", id[:8]+"...")
+				}
+			}
+			u.mu.Unlock()
+		}
+	}()
+}
+
+// handleUploads starts a chunked upload (POST) from the file's name, type and size
+func (a *App) handleUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	size, err := strconv.ParseInt(r.FormValue("size"), 10, 64)
+	if err != nil || size <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "size is required"})
+		return
+	}
+	if size > maxChunkedUpload {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "the document is larger than 50 MB"})
+		return
+	}
+	id, err := generateSecureToken(16)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start upload"})
+		return
+	}
+	owner, _ := a.sessionUser(r)
+	s := &uploadSession{
+		ID:        id,
+		Owner:     owner,
+		Name:      sanitizeFilename(r.FormValue("name")),
+		Type:      uploadType(r.FormValue("type")),
+		Size:      size,
+		expiresAt: time.Now().Add(uploadSessionTTL),
+	}
+	if err := a.uploads.start(s); err != nil {
+		w.Header().Set("Retry-After", "60")
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.status())
+}
+
+// handleUpload reports how much of an upload arrived (GET), appends a chunk at the Upload-Offset
+// header (PATCH) or abandons the upload (DELETE). A chunk at the wrong offset gets 409 with the
+// current offset, so clients resume from there after a dropped connection.
+func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/uploads/")
+	owner, _ := a.sessionUser(r)
+	switch r.Method {
+	case http.MethodGet, http.MethodPatch, http.MethodDelete:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The chunk is read without holding the lock, so slow clients do not hold up each other
+	var chunk []byte
+	if r.Method == http.MethodPatch {
+		var err error
+		chunk, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadChunk))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "chunks may be at most 5 MB"})
+			return
+		} else if err != nil {
+			// The connection dropped mid-chunk; nothing is appended
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "incomplete chunk"})
+			return
+		}
+	}
+
+	a.uploads.mu.Lock()
+	defer a.uploads.mu.Unlock()
+	s := a.uploads.get(id, owner)
+	if s == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "upload not found or expired"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.status())
+	case http.MethodPatch:
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Upload-Offset header is required"})
+			return
+		}
+		if offset != int64(s.data.Len()) {
+			writeJSON(w, http.StatusConflict, s.status())
+			return
+		}
+		if offset+int64(len(chunk)) > s.Size {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk goes past the declared size"})
+			return
+		}
+		s.data.Write(chunk)
+		s.expiresAt = time.Now().Add(uploadSessionTTL)
+		writeJSON(w, http.StatusOK, s.status())
+	case http.MethodDelete:
+		delete(a.uploads.sessions, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// uploadType keeps the declared type of a chunked upload when it is one that can be faxed
+func uploadType(t string) string {
+	if t == "application/pdf" || t == "image/tiff" {
+		return t
+	}
+	return ""
+}
+
+// requestDocument returns the document attached to a form: a completed chunked upload named by
+// upload_id, else the media_file upload, else nil
+func (a *App) requestDocument(r *http.Request) (*document, error) {
+	id := r.FormValue("upload_id")
+	if id == "" {
+		return readUploadedFile(r)
+	}
+	owner, _ := a.sessionUser(r)
+	a.uploads.mu.Lock()
+	defer a.uploads.mu.Unlock()
+	s := a.uploads.get(id, owner)
+	if s == nil {
+		return nil, errors.New("the uploaded file expired; choose it again")
+	}
+	if int64(s.data.Len()) != s.Size {
+		return nil, errors.New("the file upload did not finish; choose it again")
+	}
+	return &document{Name: s.Name, Type: s.Type, Data: bytes.Clone(s.data.Bytes())}, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUploadLimits(t *testing.T) {
+	u := &uploadSessions{sessions: map[string]*uploadSession{}}
+	start := func(owner string, size int64) error {
+		return u.start(&uploadSession{ID: fmt.Sprint(len(u.sessions)), Owner: owner, Size: size, expiresAt: time.Now().Add(uploadSessionTTL)})
+	}
+
+	for i := range maxUploadsPerUser {
+		if err := start("alice", 1<<20); err != nil {
+			t.Fatalf("alice's upload %d: %v", i+1, err)
+		}
+	}
+	if err := start("alice", 1<<20); !errors.Is(err, errTooManyUploads) {
+		t.Fatalf("alice's upload past the limit: %v", err)
+	}
+	if err := start("bob", 1<<20); err != nil {
+		t.Fatalf("bob's upload: %v", err)
+	}
+
+	// Declared sizes count against the total before any chunk arrives
+	for owner := 0; ; owner++ {
+		err := start(fmt.Sprint("user", owner), maxChunkedUpload)
+		if errors.Is(err, errUploadsFull) {
+			break
+		}
+		if err != nil || owner > maxUploadBytes/maxChunkedUpload {
+			t.Fatalf("upload %d of %d MB: %v", owner+1, maxChunkedUpload>>20, err)
+		}
+	}
+
+	// Expired uploads make room
+	for _, s := range u.sessions {
+		s.expiresAt = time.Now().Add(-time.Minute)
+	}
+	if err := start("alice", maxChunkedUpload); err != nil {
+		t.Fatalf("upload once the others expired: %v", err)
+	}
+}
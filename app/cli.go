@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Scripts on the same host can send faxes and check on them without the web UI:
+//
+//	fax-ui send --to +12025550143 --file letter.pdf [--cover "Please call back"]
+//	fax-ui list [--direction inbound] [--limit 20]
+//	fax-ui status <fax ID>
+//	fax-ui restore [--force] backup.tar.enc
+//	fax-ui migrate
+//
+// The subcommands take the same flags, environment and config file as the server (--from and --profile
+// pick the number and profile to send with) and use the same profiles, database and failover, without
+// starting the HTTP server. Telnyx fetches a sent file from the server's /media/ route, so --file needs
+// the upload directory or S3 bucket of a running fax-ui, unless --telnyx_media_upload uploads it to
+// Telnyx; --media_url sends a document Telnyx can already reach. list and status print a table, or JSON with --json.
+// restore puts back a backup downloaded from /admin/backup, while the server is stopped. migrate applies
+// pending database migrations, which the server otherwise applies when it starts.
+
+// cliTimeout bounds each subcommand's calls to the fax provider
+const cliTimeout = time.Minute
+
+// errFaxFailed makes status exit with an error for a failed fax
+var errFaxFailed = errors.New("the fax failed")
+
+// commands are the subcommands run instead of the server
+var commands = map[string]func() error{
+	"send":    runSendCommand,
+	"list":    runListCommand,
+	"status":  runStatusCommand,
+	"restore": runRestoreCommand,
+	"migrate": runMigrateCommand,
+}
+
+// runCommand runs the subcommand named by the first argument, if there is one, and reports whether it did.
+// Its flags are parsed with the server's, so they may come before or after them.
+func runCommand() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		return false
+	}
+	name := os.Args[1]
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "fax-ui %s: %v\n", name, err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// newCommandApp loads the configuration and builds the application for a subcommand. The background
+// jobs, provisioning webhooks, the relay and the scan folder are left to the server.
+func newCommandApp() (*App, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.ProvisionHooks = false
+	cfg.ScanDir = ""
+	cfg.NoBackground = true
+	return NewApp(cfg)
+}
+
+// runSendCommand sends a file, a cover page or a media URL and prints the new fax's ID
+func runSendCommand() error {
+	to := flag.String("to", "", "Number to send the fax to (send).")
+	file := flag.String("file", "", "PDF or TIFF to send (send).")
+	mediaURL := flag.String("media_url", "", "URL of a document Telnyx can fetch, instead of --file (send).")
+	cover := flag.String("cover", "", "Note for a cover page in front of the PDF, or sent alone without a document (send).")
+	quality := flag.String("quality", "", "Fax quality: normal, high, very_high, ultra_light or ultra_dark (send).")
+	a, err := newCommandApp()
+	if err != nil {
+		return err
+	}
+	defer a.Store.Close()
+	if *file != "" && *mediaURL != "" {
+		return errors.New("use either --file or --media_url")
+	}
+
+	// Documents kept in memory would be gone before Telnyx fetches them from the server
+	if _, inMemory := a.Media.(*memoryMediaStore); inMemory && !a.Sandbox && !a.MediaUpload && (*mediaURL == "" || a.FetchMedia) {
+		return errors.New("--file, --cover and --fetch_media_url need the --upload_dir or S3 bucket of the running fax-ui, which serves them to Telnyx")
+	}
+	var doc *document
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		doc = &document{Name: filepath.Base(*file), Type: mediaType(*file, data), Data: data}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+	defer cancel()
+	profile := a.profileByName(a.SendProfile)
+	fax, err := a.sendDocument(ctx, profile, headlessSend{
+		To:        *to,
+		Document:  doc,
+		MediaURL:  *mediaURL,
+		CoverText: *cover,
+		Quality:   *quality,
+		SentBy:    "cli",
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(fax.ID)
+	return nil
+}
+
+// headlessSend is a fax sent without the send form, by a subcommand or through the gRPC API
+type headlessSend struct {
+	From         string // empty for the profile's default
+	To           string
+	ConnectionID string // empty for the profile's default
+	Document     *document
+	MediaURL     string
+	CoverText    string
+	Quality      string
+	Options      faxOptions
+	SentBy       string
+}
+
+// invalidSendError is a fax sendDocument refused to send, as opposed to one the provider failed to send
+type invalidSendError struct{ error }
+
+// sendDocument sends a fax without the send form: it checks the numbers like the form does, puts the
+// cover page in front of the document and stamps it, stores it for the provider to fetch, and records the
+// fax as sent by s.SentBy
+func (a *App) sendDocument(ctx context.Context, profile *Profile, s headlessSend) (*Fax, error) {
+	defaultFrom, defaultConn := profile.From, profile.ConnectionID
+	if profile == a.defaultProfile() {
+		settings := a.runtime()
+		defaultFrom, defaultConn = settings.DefaultFrom, settings.DefaultConnectionID
+	}
+	from, err := normalizePhoneNumber(firstNonEmpty(s.From, defaultFrom), a.DefaultCountry)
+	if err != nil {
+		return nil, invalidSendError{fmt.Errorf("from number: %w", err)}
+	}
+	if err := profile.validateFromNumber(ctx, from); err != nil {
+		return nil, invalidSendError{fmt.Errorf("from number: %w", err)}
+	}
+	to, err := normalizePhoneNumber(s.To, a.DefaultCountry)
+	if err != nil {
+		return nil, invalidSendError{fmt.Errorf("to number: %w", err)}
+	}
+	connectionID := firstNonEmpty(s.ConnectionID, defaultConn)
+	if (connectionID == "" && profile.client != nil) || from == "" || to == "" {
+		return nil, invalidSendError{errors.New("a connection ID, from and to number are required")}
+	}
+	if msg := a.checkBlocked(ctx, to); msg != "" {
+		return nil, invalidSendError{errors.New(msg)}
+	}
+	if s.Document != nil && s.MediaURL != "" {
+		return nil, invalidSendError{errors.New("send either a document or a media URL")}
+	}
+	if s.MediaURL != "" {
+		if err := a.checkMediaURL(ctx, s.MediaURL); err != nil {
+			return nil, invalidSendError{fmt.Errorf("media URL: %w", err)}
+		}
+	}
+
+	doc := s.Document
+	if doc == nil && s.MediaURL != "" && a.FetchMedia {
+		if doc, err = downloadMedia(ctx, s.MediaURL); err != nil {
+			return nil, invalidSendError{fmt.Errorf("media URL: %w", err)}
+		}
+		s.MediaURL = ""
+	}
+	now := time.Now().In(a.Location)
+	if text := strings.TrimSpace(s.CoverText); text != "" {
+		cover := coverPagePDF(from, to, text, now)
+		switch {
+		case doc != nil && doc.isPDF():
+			merged, err := prependCoverPage(cover, doc.Data)
+			if err != nil {
+				return nil, invalidSendError{err}
+			}
+			doc = &document{Name: doc.Name, Type: "application/pdf", Data: merged}
+		case doc != nil || s.MediaURL != "":
+			return nil, invalidSendError{errors.New("a cover page can only be added to a PDF document")}
+		default:
+			doc = &document{Name: "cover.pdf", Type: "application/pdf", Data: cover}
+		}
+	}
+	if doc == nil && s.MediaURL == "" {
+		return nil, invalidSendError{errors.New("a document, media URL or cover page is required")}
+	}
+	if doc != nil && doc.isPDF() && a.Stamp.enabled() {
+		stamped, err := stampPDF(doc.Data, a.Stamp, from, to, now)
+		if err != nil {
+			return nil, invalidSendError{err}
+		}
+		doc = &document{Name: doc.Name, Type: "application/pdf", Data: stamped}
+	}
+
+	carrierHook, forwardHook := a.faxWebhook("", s.SentBy, profile.Name, from, to)
+	req := SendRequest{ConnectionID: connectionID, From: from, To: to, MediaURL: s.MediaURL, WebhookURL: carrierHook}
+	switch s.Quality {
+	case "":
+		if advice, ok := adviseQuality(doc); ok {
+			req.Quality = advice.Quality
+		}
+	case "normal", "high", "very_high", "ultra_light", "ultra_dark":
+		req.Quality = s.Quality
+	default:
+		return nil, invalidSendError{fmt.Errorf("unknown quality %q", s.Quality)}
+	}
+	if err := s.Options.validate(); err != nil {
+		return nil, invalidSendError{err}
+	}
+	req.Options = s.Options
+	if doc != nil {
+		if req.MediaURL, err = a.storeDocument(ctx, doc); err != nil {
+			return nil, err
+		}
+		req.Document = doc
+	}
+
+	fax, record, err := a.sendFax(ctx, profile, req)
+	if err != nil {
+		return nil, err
+	}
+	record.SentBy = s.SentBy
+	a.recordFaxWebhook(ctx, record.Profile, fax.ID, forwardHook)
+	if doc != nil {
+		record.MediaFile = path.Base(req.MediaURL)
+	}
+	if err := a.Store.recordSentFax(ctx, record); err != nil {
+		log.Printf("Warning: could not record sent fax %s: %v", fax.ID, err)
+	}
+	if err := a.Store.saveFaxes(ctx, record.Profile, []Fax{*fax}); err != nil {
+		log.Printf("Warning: could not save sent fax %s: %v", fax.ID, err)
+	}
+	return fax, nil
+}
+
+// runListCommand prints the most recent faxes of the send profile
+func runListCommand() error {
+	direction := flag.String("direction", "", "Only list inbound or outbound faxes (list).")
+	limit := flag.Int64("limit", 20, "Number of faxes to list (list).")
+	asJSON := flag.Bool("json", false, "Print JSON instead of a table (list, status).")
+	a, err := newCommandApp()
+	if err != nil {
+		return err
+	}
+	defer a.Store.Close()
+	switch *direction {
+	case "", "inbound", "outbound":
+	default:
+		return fmt.Errorf("unknown direction %q", *direction)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+	defer cancel()
+	profile := a.profileByName(a.SendProfile)
+	faxes, err := profile.provider.List(ctx, *direction, 1, *limit)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		out := make([]faxPartialJSON, len(faxes))
+		for i, f := range faxes {
+			out[i] = a.commandFaxJSON(ctx, profile, f)
+		}
+		return printJSON(out)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tDIRECTION\tFROM\tTO\tCREATED")
+	for _, f := range faxes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", f.ID, f.Status, f.Direction, f.From, f.To,
+			f.CreatedAt.In(a.Location).Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+// runStatusCommand prints the status of a fax of the send profile. It exits with an error when the fax
+// failed, so scripts can check the result.
+func runStatusCommand() error {
+	asJSON := flag.Bool("json", false, "Print JSON instead of a table (list, status).")
+	a, err := newCommandApp()
+	if err != nil {
+		return err
+	}
+	defer a.Store.Close()
+	if flag.NArg() != 1 {
+		return errors.New("usage: fax-ui status <fax ID>")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+	defer cancel()
+	profile := a.profileByName(a.SendProfile)
+	fax, err := profile.provider.Get(ctx, flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if err := printJSON(a.commandFaxJSON(ctx, profile, *fax)); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "ID:\t%s\n", fax.ID)
+		fmt.Fprintf(tw, "Status:\t%s\n", fax.Status)
+		fmt.Fprintf(tw, "Direction:\t%s\n", fax.Direction)
+		fmt.Fprintf(tw, "From:\t%s\n", fax.From)
+		fmt.Fprintf(tw, "To:\t%s\n", fax.To)
+		fmt.Fprintf(tw, "Created:\t%s\n", fax.CreatedAt.In(a.Location).Format("2006-01-02 15:04:05 MST"))
+		if fax.Pages > 0 {
+			fmt.Fprintf(tw, "Pages:\t%d\n", fax.Pages)
+		}
+		if fax.FailureReason != "" {
+			reason := fax.FailureReason
+			if e, ok := explainFailure(reason); ok {
+				reason += " (" + e.Summary + ")"
+			}
+			fmt.Fprintf(tw, "Failure:\t%s\n", reason)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if fax.Status == "failed" {
+		return errFaxFailed
+	}
+	return nil
+}
+
+// commandFaxJSON returns the JSON form of a fax the subcommands print, the same as the status endpoint's
+func (a *App) commandFaxJSON(ctx context.Context, profile *Profile, f Fax) faxPartialJSON {
+	tags, err := a.Store.faxTags(ctx, profile.Name, []string{f.ID})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load tags for fax %s: %v\n", f.ID, err)
+	}
+	explanation := ""
+	if e, ok := explainFailure(f.FailureReason); ok {
+		explanation = e.Summary
+	}
+	return faxPartialJSON{
+		ID:                 f.ID,
+		Profile:            profile.Name,
+		Status:             f.Status,
+		Final:              f.Final(),
+		Direction:          f.Direction,
+		From:               f.From,
+		To:                 f.To,
+		CreatedAt:          f.CreatedAt,
+		UpdatedAt:          f.UpdatedAt,
+		Pages:              f.Pages,
+		DurationSeconds:    int(f.Duration.Seconds()),
+		FailureReason:      f.FailureReason,
+		FailureExplanation: explanation,
+		Tags:               append([]string{}, tags[f.ID]...),
+	}
+}
+
+// printJSON writes v to standard output as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// runMigrateCommand applies the pending database migrations, e.g. before a new version is rolled out to
+// instances sharing a Postgres database, and prints the version the database is at
+func runMigrateCommand() error {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	store, err := openStore(firstNonEmpty(cfg.DatabaseURL, cfg.DatabasePath))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// Another instance may hold the migration lock for a while, so there is no timeout
+	ctx := context.Background()
+	applied, err := store.migrate(ctx)
+	for _, m := range applied {
+		fmt.Printf("Applied migration %d (%s)\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	version, err := store.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Database %s is at version %d\n", redactDatabaseURL(firstNonEmpty(cfg.DatabaseURL, cfg.DatabasePath)), version)
+	return nil
+}
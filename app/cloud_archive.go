@@ -0,0 +1,731 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// Delivered and received faxes can be archived to a Google Drive or OneDrive folder, as the document plus a
+// JSON file of its details. An admin connects the account on the admin Cloud Archive page; fax-ui keeps its
+// refresh token and uploads in the background. Faxes are queued as their webhooks arrive, and uploads are
+// retried with backoff through transient failures. Uploads that are refused, or keep failing, are listed
+// as failed on the same page, where they can be retried.
+
+// CloudArchiveConfig configures archiving to Google Drive or OneDrive
+type CloudArchiveConfig struct {
+	Provider     string // google or onedrive; empty disables the archive
+	ClientID     string // OAuth client; defaults to the sign-in client of the provider
+	ClientSecret string
+	Folder       string // folder template faxes are filed in
+}
+
+// Cloud archive providers
+const (
+	cloudGoogle   = "google"
+	cloudOneDrive = "onedrive"
+)
+
+// defaultCloudFolder files faxes by direction and month
+const defaultCloudFolder = "Faxes/{direction}/{year}/{month}"
+
+// cloudFolderPlaceholders are the placeholders a cloud archive folder template may use
+var cloudFolderPlaceholders = []string{"{direction}", "{year}", "{month}", "{date}", "{number}", "{profile}"}
+
+// Cloud upload states
+const (
+	cloudPending  = "pending"
+	cloudUploaded = "uploaded"
+	cloudFailed   = "failed"
+)
+
+// Uploads are tried cloudAttempts times, backing off from a minute up to an hour
+const (
+	cloudAttempts   = 8
+	cloudMaxBackoff = time.Hour
+	cloudInterval   = time.Minute
+	cloudTimeout    = 5 * time.Minute
+	cloudBatch      = 10
+)
+
+// cloudArchive uploads faxes to the connected Google Drive or OneDrive account
+type cloudArchive struct {
+	cfg   CloudArchiveConfig
+	oauth *oauth2.Config
+	wake  chan struct{}
+
+	mu      sync.Mutex
+	folders map[string]string // Google Drive folder IDs by path
+}
+
+// cloudAccount is the connected account of the cloud archive
+type cloudAccount struct {
+	Provider    string
+	Account     string // email address of the account
+	Token       *oauth2.Token
+	ConnectedBy string
+	ConnectedAt time.Time
+}
+
+// cloudUpload is a fax queued for the cloud archive
+type cloudUpload struct {
+	ID          int64
+	Profile     string
+	FaxID       string
+	Direction   string
+	MediaURL    string // where the provider keeps a received document
+	Status      string
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time
+	UpdatedAt   time.Time
+}
+
+// cloudMetadata is the JSON file archived next to each fax's document
+type cloudMetadata struct {
+	ID              string    `json:"id"`
+	Profile         string    `json:"profile"`
+	Direction       string    `json:"direction"`
+	Status          string    `json:"status"`
+	From            string    `json:"from"`
+	To              string    `json:"to"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	Pages           int       `json:"pages"`
+	DurationSeconds int       `json:"duration_seconds"`
+	SentBy          string    `json:"sent_by,omitempty"`
+	Tags            []string  `json:"tags"`
+	Document        string    `json:"document"` // file name of the archived document
+}
+
+// cloudRefused is an upload the provider refused; retrying it would not help
+type cloudRefused struct{ error }
+
+// newCloudArchive checks the cloud archive configuration
+func newCloudArchive(cfg CloudArchiveConfig, publicBaseURL string) (*cloudArchive, error) {
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, fmt.Errorf("--cloud_archive=%s needs an OAuth client (CLOUD_ARCHIVE_CLIENT_ID and CLOUD_ARCHIVE_CLIENT_SECRET)", cfg.Provider)
+	}
+	cfg.Folder = strings.Trim(firstNonEmpty(cfg.Folder, defaultCloudFolder), "/")
+	for rest := cfg.Folder; ; {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 || !containsFold(cloudFolderPlaceholders, rest[start:start+end+1]) {
+			return nil, fmt.Errorf("cloud archive folder %q: unknown placeholder (expected %s)", cfg.Folder, strings.Join(cloudFolderPlaceholders, ", "))
+		}
+		rest = rest[start+end+1:]
+	}
+	oc := &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  publicBaseURL + "/admin/cloud/callback",
+	}
+	switch cfg.Provider {
+	case cloudGoogle:
+		// drive.file only reaches the files and folders fax-ui creates
+		oc.Endpoint = google.Endpoint
+		oc.Scopes = []string{"https://www.googleapis.com/auth/drive.file", "https://www.googleapis.com/auth/userinfo.email"}
+	case cloudOneDrive:
+		oc.Endpoint = microsoft.AzureADEndpoint("common")
+		oc.Scopes = []string{"Files.ReadWrite", "User.Read", "offline_access"}
+	default:
+		return nil, fmt.Errorf("unknown cloud archive %q (expected google or onedrive)", cfg.Provider)
+	}
+	return &cloudArchive{cfg: cfg, oauth: oc, wake: make(chan struct{}, 1), folders: map[string]string{}}, nil
+}
+
+// name returns the provider's product name
+func (c *cloudArchive) name() string {
+	if c.cfg.Provider == cloudGoogle {
+		return "Google Drive"
+	}
+	return "OneDrive"
+}
+
+// folder fills in the folder template for a fax
+func (c *cloudArchive) folder(profile string, fax *Fax, loc *time.Location) string {
+	at := firstTime(fax.CreatedAt, fax.UpdatedAt, time.Now()).In(loc)
+	direction, number := "Sent", fax.From
+	if fax.Direction == "inbound" {
+		direction, number = "Received", fax.To
+	}
+	r := strings.NewReplacer(
+		"{direction}", direction,
+		"{year}", at.Format("2006"),
+		"{month}", at.Format("01"),
+		"{date}", at.Format("2006-01-02"),
+		"{number}", sanitizeFilename(number),
+		"{profile}", sanitizeFilename(profile),
+	)
+	return path.Clean(r.Replace(c.cfg.Folder))
+}
+
+// queueCloudArchive queues a received or delivered fax for the cloud archive
+func (a *App) queueCloudArchive(ctx context.Context, eventType string, fax Fax) {
+	if a.Cloud == nil || (eventType != "fax.received" && eventType != "fax.delivered") {
+		return
+	}
+	profile, direction := a.defaultProfile().Name, "inbound"
+	if eventType == "fax.delivered" {
+		direction = "outbound"
+		// Sent faxes belong to the profile that sent them
+		profiles, err := a.Store.faxProfiles(ctx, fax.ID)
+		if err != nil || len(profiles) == 0 {
+			return
+		}
+		profile = profiles[0]
+	}
+	if err := a.Store.queueCloudUpload(ctx, cloudUpload{Profile: profile, FaxID: fax.ID, Direction: direction, MediaURL: fax.MediaURL}); err != nil {
+		log.Printf("Warning: could not queue fax %s for the cloud archive: %v", fax.ID, err)
+		return
+	}
+	select {
+	case a.Cloud.wake <- struct{}{}:
+	default:
+	}
+}
+
+// startCloudArchive uploads queued faxes as they come in, and retries failed uploads periodically
+func (a *App) startCloudArchive() {
+	backgroundTasks.register("cloud archive", cloudInterval)
+	go func() {
+		ticker := time.NewTicker(cloudInterval)
+		defer ticker.Stop()
+		for {
+			backgroundTasks.beat("cloud archive")
+			a.uploadCloudArchive(context.Background())
+			select {
+			case <-ticker.C:
+			case <-a.Cloud.wake:
+			}
+		}
+	}()
+}
+
+// uploadCloudArchive uploads the queued faxes that are due, once an account is connected
+func (a *App) uploadCloudArchive(ctx context.Context) {
+	for {
+		account, err := a.Store.cloudAccount(ctx, a.Cloud.cfg.Provider)
+		if err != nil {
+			log.Printf("Warning: could not load the cloud archive account: %v", err)
+			return
+		}
+		if account == nil {
+			return
+		}
+		jobs, err := a.Store.dueCloudUploads(ctx, time.Now().UTC(), cloudBatch)
+		if err != nil {
+			log.Printf("Warning: could not load the cloud archive queue: %v", err)
+			return
+		}
+		for _, job := range jobs {
+			claimed, err := a.Store.claimCloudUpload(ctx, job.ID, time.Now().UTC(), cloudTimeout)
+			if err != nil {
+				log.Printf("Warning: could not claim cloud upload %d: %v", job.ID, err)
+				return
+			}
+			if claimed {
+				a.uploadCloudJob(ctx, account, job)
+			}
+		}
+		if len(jobs) < cloudBatch {
+			return
+		}
+	}
+}
+
+// uploadCloudJob uploads one queued fax, rescheduling it or marking it failed when that fails
+func (a *App) uploadCloudJob(ctx context.Context, account *cloudAccount, job cloudUpload) {
+	ctx, cancel := context.WithTimeout(ctx, cloudTimeout)
+	defer cancel()
+	job.Attempts++
+	err := a.archiveToCloud(ctx, account, job)
+	switch {
+	case err == nil:
+		job.Status, job.LastError = cloudUploaded, ""
+	case errors.As(err, &cloudRefused{}) || job.Attempts >= cloudAttempts:
+		job.Status, job.LastError = cloudFailed, err.Error()
+		log.Printf("Warning: gave up archiving fax %s to %s: %v", job.FaxID, a.Cloud.name(), err)
+	default:
+		job.LastError = err.Error()
+		job.NextAttempt = time.Now().UTC().Add(min(time.Minute<<(job.Attempts-1), cloudMaxBackoff))
+	}
+	if err := a.Store.updateCloudUpload(context.Background(), job); err != nil {
+		log.Printf("Warning: could not update cloud upload %d: %v", job.ID, err)
+	}
+	if err != nil {
+		if err := a.Store.recordJobError(context.Background(), jobQueueCloud, job.ID, job.Attempts, err.Error()); err != nil {
+			log.Printf("Warning: could not record the error of cloud upload %d: %v", job.ID, err)
+		}
+	}
+}
+
+// archiveToCloud uploads a fax's document and details
+func (a *App) archiveToCloud(ctx context.Context, account *cloudAccount, job cloudUpload) error {
+	profile := a.profileByName(job.Profile)
+	if profile == nil {
+		return cloudRefused{fmt.Errorf("profile %q is no longer configured", job.Profile)}
+	}
+	fax, err := profile.provider.Get(ctx, job.FaxID)
+	if err != nil {
+		return fmt.Errorf("look up fax: %w", err)
+	}
+	var data []byte
+	ext := ".pdf"
+	if fax.Direction == "inbound" {
+		doc, err := a.receivedDocument(ctx, profile, fax.ID, firstNonEmpty(fax.MediaURL, job.MediaURL))
+		if err != nil {
+			return fmt.Errorf("document: %w", err)
+		}
+		data = doc.Data
+		if doc.Type != "application/pdf" {
+			ext = firstNonEmpty(path.Ext(doc.Name), ext)
+		}
+	} else if data, ext, err = a.exportMedia(ctx, profile, fax); err != nil {
+		if errors.Is(err, errNoMedia) {
+			return cloudRefused{err}
+		}
+		return fmt.Errorf("document: %w", err)
+	}
+
+	meta := cloudMetadata{
+		ID:              fax.ID,
+		Profile:         profile.Name,
+		Direction:       fax.Direction,
+		Status:          fax.Status,
+		From:            fax.From,
+		To:              fax.To,
+		CreatedAt:       fax.CreatedAt,
+		UpdatedAt:       fax.UpdatedAt,
+		Pages:           fax.Pages,
+		DurationSeconds: int(fax.Duration.Seconds()),
+		Tags:            []string{},
+		Document:        sanitizeFilename(fax.ID) + firstNonEmpty(ext, ".pdf"),
+	}
+	if sent, err := a.Store.sentFax(ctx, profile.Name, fax.ID); err == nil && sent != nil {
+		meta.SentBy = sent.SentBy
+	}
+	if tags, err := a.Store.faxTags(ctx, profile.Name, []string{fax.ID}); err == nil && tags[fax.ID] != nil {
+		meta.Tags = tags[fax.ID]
+	}
+	metaJSON, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	client, err := a.cloudClient(ctx, account)
+	if err != nil {
+		return err
+	}
+	folder := a.Cloud.folder(profile.Name, fax, a.Location)
+	// The details go last, so a fax whose details are archived has its document archived too
+	if err := a.Cloud.upload(ctx, client, folder, meta.Document, data); err != nil {
+		return err
+	}
+	return a.Cloud.upload(ctx, client, folder, sanitizeFilename(fax.ID)+".json", metaJSON)
+}
+
+// cloudClient returns an HTTP client authorized as the connected account, saving refreshed tokens
+func (a *App) cloudClient(ctx context.Context, account *cloudAccount) (*http.Client, error) {
+	token, err := a.Cloud.oauth.TokenSource(ctx, account.Token).Token()
+	if err != nil {
+		var re *oauth2.RetrieveError
+		if errors.As(err, &re) && re.Response != nil && re.Response.StatusCode < 500 {
+			return nil, fmt.Errorf("the %s connection was revoked; reconnect it: %w", a.Cloud.name(), err)
+		}
+		return nil, err
+	}
+	if token.AccessToken != account.Token.AccessToken {
+		// Microsoft rotates refresh tokens, so every refresh is kept
+		account.Token = token
+		if err := a.Store.saveCloudToken(ctx, account.Provider, token); err != nil {
+			log.Printf("Warning: could not save the refreshed %s token: %v", a.Cloud.name(), err)
+		}
+	}
+	return oauth2.NewClient(ctx, oauth2.StaticTokenSource(token)), nil
+}
+
+// upload writes a file into folder, replacing a file of the same name
+func (c *cloudArchive) upload(ctx context.Context, client *http.Client, folder, name string, data []byte) error {
+	if c.cfg.Provider == cloudOneDrive {
+		return c.uploadOneDrive(ctx, client, folder, name, data)
+	}
+	return c.uploadDrive(ctx, client, folder, name, data)
+}
+
+// oneDriveSimpleUpload is the largest file Microsoft Graph accepts in one PUT; larger ones need an upload session
+const oneDriveSimpleUpload = 4 << 20
+
+// uploadOneDrive uploads a file by its path, which creates missing folders and replaces an existing file
+func (c *cloudArchive) uploadOneDrive(ctx context.Context, client *http.Client, folder, name string, data []byte) error {
+	item := "https://graph.microsoft.com/v1.0/me/drive/root:/" + escapePath(path.Join(folder, name)) + ":"
+	if len(data) <= oneDriveSimpleUpload {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, item+"/content", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		return cloudDo(client, req, nil)
+	}
+	body := strings.NewReader(`{"item": {"@microsoft.graph.conflictBehavior": "replace"}}`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, item+"/createUploadSession", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	if err := cloudDo(client, req, &session); err != nil {
+		return err
+	}
+	// Faxes fit in one chunk (Graph takes up to 60 MiB); the upload URL is pre-authorized
+	req, err = http.NewRequestWithContext(ctx, http.MethodPut, session.UploadURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+	return cloudDo(http.DefaultClient, req, nil)
+}
+
+// escapePath escapes each segment of a slash-separated path
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// uploadDrive uploads a file into a Google Drive folder, creating the folders on the way, or replaces the
+// content of the file of the same name
+func (c *cloudArchive) uploadDrive(ctx context.Context, client *http.Client, folder, name string, data []byte) error {
+	parent, err := c.driveFolder(ctx, client, folder)
+	if err != nil {
+		return err
+	}
+	existing, err := driveFind(ctx, client, parent, name, false)
+	if err != nil {
+		return err
+	}
+	method, link := http.MethodPost, "https://www.googleapis.com/upload/drive/v3/files?uploadType=multipart"
+	meta := map[string]any{"name": name, "parents": []string{parent}}
+	if existing != "" {
+		method, link = http.MethodPatch, "https://www.googleapis.com/upload/drive/v3/files/"+url.PathEscape(existing)+"?uploadType=multipart"
+		meta = map[string]any{}
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", "application/json; charset=UTF-8")
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(part).Encode(meta); err != nil {
+		return err
+	}
+	h = textproto.MIMEHeader{}
+	h.Set("Content-Type", mediaType(name, data))
+	if part, err = mw.CreatePart(h); err != nil {
+		return err
+	}
+	part.Write(data)
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, link, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+	return cloudDo(client, req, nil)
+}
+
+// driveFolder returns the ID of a Google Drive folder path under My Drive, creating missing folders
+func (c *cloudArchive) driveFolder(ctx context.Context, client *http.Client, folder string) (string, error) {
+	c.mu.Lock()
+	id, ok := c.folders[folder]
+	c.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	parent := "root"
+	for i, name := range strings.Split(folder, "/") {
+		sub := strings.Join(strings.Split(folder, "/")[:i+1], "/")
+		c.mu.Lock()
+		id, ok := c.folders[sub]
+		c.mu.Unlock()
+		if !ok {
+			var err error
+			if id, err = driveFind(ctx, client, parent, name, true); err != nil {
+				return "", err
+			}
+			if id == "" {
+				if id, err = driveCreateFolder(ctx, client, parent, name); err != nil {
+					return "", err
+				}
+			}
+			c.mu.Lock()
+			c.folders[sub] = id
+			c.mu.Unlock()
+		}
+		parent = id
+	}
+	return parent, nil
+}
+
+// driveFind returns the ID of the file or folder named name in parent, or ""
+func driveFind(ctx context.Context, client *http.Client, parent, name string, folder bool) (string, error) {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", quote.Replace(name), quote.Replace(parent))
+	if folder {
+		q += " and mimeType = 'application/vnd.google-apps.folder'"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"https://www.googleapis.com/drive/v3/files?fields=files(id)&q="+url.QueryEscape(q), nil)
+	if err != nil {
+		return "", err
+	}
+	var found struct {
+		Files []struct {
+			ID string `json:"id"`
+		} `json:"files"`
+	}
+	if err := cloudDo(client, req, &found); err != nil {
+		return "", err
+	}
+	if len(found.Files) == 0 {
+		return "", nil
+	}
+	return found.Files[0].ID, nil
+}
+
+// driveCreateFolder creates a folder in parent and returns its ID
+func driveCreateFolder(ctx context.Context, client *http.Client, parent, name string) (string, error) {
+	body, err := json.Marshal(map[string]any{"name": name, "mimeType": "application/vnd.google-apps.folder", "parents": []string{parent}})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://www.googleapis.com/drive/v3/files?fields=id", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := cloudDo(client, req, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// cloudDo sends a request, decoding a JSON answer into v when it is not nil. Refusals the provider will
+// repeat (4xx other than 401, 408 and 429) are cloudRefused; the rest are worth retrying.
+func cloudDo(client *http.Client, req *http.Request, v any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Host, resp.Status, strings.TrimSpace(string(msg)))
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return err
+		}
+		if resp.StatusCode < 500 {
+			return cloudRefused{err}
+		}
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// cloudCookie keeps the state and PKCE verifier of a connection in progress
+const cloudCookie = "cloud_connect"
+
+// handleAdminCloud shows the cloud archive's account and uploads (GET), and connects or disconnects the
+// account or retries a failed upload (POST action=connect|disconnect|retry)
+func (a *App) handleAdminCloud(w http.ResponseWriter, r *http.Request) {
+	if a.Cloud == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		account, err := a.Store.cloudAccount(r.Context(), a.Cloud.cfg.Provider)
+		if err != nil {
+			http.Error(w, "failed to load cloud archive: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		counts, err := a.Store.cloudUploadCounts(r.Context())
+		if err != nil {
+			http.Error(w, "failed to load cloud archive: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		uploads, err := a.Store.cloudUploads(r.Context(), []string{cloudFailed, cloudPending}, auditPageSize)
+		if err != nil {
+			http.Error(w, "failed to load cloud archive: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.render(w, r, "admin_cloud.html", map[string]any{
+			"Service": a.Cloud.name(),
+			"Folder":  a.Cloud.cfg.Folder,
+			"Account": account,
+			"Counts":  counts,
+			"Uploads": uploads,
+			"Success": r.URL.Query().Get("success"),
+			"Error":   r.URL.Query().Get("error"),
+		})
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		user, _ := a.sessionUser(r)
+		switch r.FormValue("action") {
+		case "connect":
+			a.connectCloud(w, r)
+			return
+		case "disconnect":
+			if err := a.Store.deleteCloudAccount(r.Context(), a.Cloud.cfg.Provider); err != nil {
+				http.Error(w, "failed to disconnect: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			a.Cloud.mu.Lock()
+			a.Cloud.folders = map[string]string{}
+			a.Cloud.mu.Unlock()
+			a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "cloud_archive.disconnected", Detail: a.Cloud.name()})
+			http.Redirect(w, r, "/admin/cloud?success="+url.QueryEscape(a.T(r, "Disconnected from %s", a.Cloud.name())), http.StatusSeeOther)
+		case "retry":
+			id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.retryCloudUpload(r.Context(), id); err != nil {
+				http.Error(w, "failed to retry: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			select {
+			case a.Cloud.wake <- struct{}{}:
+			default:
+			}
+			http.Redirect(w, r, "/admin/cloud?success="+url.QueryEscape(a.T(r, "Upload queued again")), http.StatusSeeOther)
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// connectCloud sends the admin to the provider to grant fax-ui access to their files
+func (a *App) connectCloud(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "failed to start connecting", http.StatusInternalServerError)
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+	verifier := oauth2.GenerateVerifier()
+	http.SetCookie(w, &http.Cookie{
+		Name:     cloudCookie,
+		Value:    state + "." + verifier,
+		Path:     "/admin/cloud/callback",
+		MaxAge:   int(oauthLoginMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.PublicBaseURL(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Offline access with a fresh consent returns the refresh token uploads run on
+	link := a.Cloud.oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+	http.Redirect(w, r, link, http.StatusSeeOther)
+}
+
+// handleAdminCloudCallback completes connecting the cloud archive's account
+func (a *App) handleAdminCloudCallback(w http.ResponseWriter, r *http.Request) {
+	if a.Cloud == nil {
+		http.NotFound(w, r)
+		return
+	}
+	cookie, err := r.Cookie(cloudCookie)
+	state, verifier, ok := strings.Cut(func() string {
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}(), ".")
+	if !ok || state == "" || r.URL.Query().Get("state") != state {
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: cloudCookie, MaxAge: -1, Path: "/admin/cloud/callback"})
+	fail := func(msg string) {
+		http.Redirect(w, r, "/admin/cloud?error="+url.QueryEscape(msg), http.StatusSeeOther)
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		fail(a.T(r, "%s did not grant access: %s", a.Cloud.name(), e))
+		return
+	}
+	token, err := a.Cloud.oauth.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Printf("Warning: could not connect %s: %v", a.Cloud.name(), err)
+		fail(a.T(r, "Could not connect %s", a.Cloud.name()))
+		return
+	}
+	if token.RefreshToken == "" {
+		fail(a.T(r, "%s returned no refresh token; remove fax-ui's access in your account settings and connect again", a.Cloud.name()))
+		return
+	}
+	provider := "microsoft"
+	if a.Cloud.cfg.Provider == cloudGoogle {
+		provider = "google"
+	}
+	email, err := oauthUserEmail(r.Context(), provider, a.Cloud.oauth.Client(r.Context(), token))
+	if err != nil {
+		log.Printf("Warning: could not fetch the %s account: %v", a.Cloud.name(), err)
+	}
+	user, _ := a.sessionUser(r)
+	account := cloudAccount{Provider: a.Cloud.cfg.Provider, Account: email, Token: token, ConnectedBy: user, ConnectedAt: time.Now().UTC()}
+	if err := a.Store.saveCloudAccount(r.Context(), account); err != nil {
+		http.Error(w, "failed to save connection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.Cloud.mu.Lock()
+	a.Cloud.folders = map[string]string{}
+	a.Cloud.mu.Unlock()
+	a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "cloud_archive.connected", Detail: a.Cloud.name() + " " + email})
+	select {
+	case a.Cloud.wake <- struct{}{}:
+	default:
+	}
+	http.Redirect(w, r, "/admin/cloud?success="+url.QueryEscape(a.T(r, "Connected to %s", a.Cloud.name())), http.StatusSeeOther)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxCommentLength caps a single comment, in characters
+const maxCommentLength = 4000
+
+// handleFaxComments adds a comment to a fax's thread on the fax details page
+func (a *App) handleFaxComments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !a.requireFaxVisible(w, r, profile, id) {
+		return
+	}
+	body := strings.TrimSpace(r.FormValue("body"))
+	if body == "" {
+		http.Error(w, "comment is empty", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(body)) > maxCommentLength {
+		http.Error(w, "comment is too long", http.StatusBadRequest)
+		return
+	}
+
+	// Open mode has no user identities
+	author, _ := a.sessionUser(r)
+	if err := a.Store.addComment(r.Context(), profile.Name, id, firstNonEmpty(author, "anonymous"), body); err != nil {
+		http.Error(w, "failed to save comment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	q := url.Values{"id": {id}, "profile": {profile.Name}}
+	http.Redirect(w, r, "/fax?"+q.Encode()+"#comments", http.StatusSeeOther)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Compatibility mode renders PDFs the way a fax machine would receive them: bilevel Group 4 (CCITT T.6)
+// TIFF at fine resolution, 1728 dots across. Old machines that choke on carrier-side PDF rendering
+// take these as they are.
+const (
+	faxTIFFResolution = "204x196"
+	faxTIFFPageSize   = "1728x2156" // US Letter at 204x196 DPI, trimmed to the standard fax line width
+	compatTimeout     = 2 * time.Minute
+)
+
+// compatMode reports whether the form asks for compatibility mode, falling back to the global default.
+// The send form posts a hidden "off" ahead of the checkbox, so the last value wins.
+func (a *App) compatMode(r *http.Request) bool {
+	if a.Ghostscript == "" {
+		return false
+	}
+	values := r.Form["compat_mode"]
+	if len(values) == 0 {
+		return a.CompatMode
+	}
+	switch strings.ToLower(values[len(values)-1]) {
+	case "on", "1", "true":
+		return true
+	}
+	return false
+}
+
+// convertToFaxTIFF renders a PDF as a multi-page Group 4 TIFF with Ghostscript. Pages are scaled to
+// fit a letter-sized fax page.
+func convertToFaxTIFF(ctx context.Context, gs string, doc *document) (*document, error) {
+	dir, err := os.MkdirTemp("", "fax-ui-tiff-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "in.pdf"), filepath.Join(dir, "out.tiff")
+	if err := os.WriteFile(in, doc.Data, 0o600); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, compatTimeout)
+	defer cancel()
+	// TIFF devices need a seekable output, so Ghostscript writes to a file rather than stdout
+	cmd := exec.CommandContext(ctx, gs, "-q", "-dSAFER", "-dBATCH", "-dNOPAUSE",
+		"-sDEVICE=tiffg4", "-r"+faxTIFFResolution, "-g"+faxTIFFPageSize, "-dFIXEDMEDIA", "-dPDFFitPage",
+		"-sOutputFile="+out, in)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ghostscript: %v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("ghostscript: %w", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return nil, err
+	}
+	name := firstNonEmpty(doc.Name, "document.pdf")
+	return &document{Name: strings.TrimSuffix(name, filepath.Ext(name)) + ".tiff", Type: "image/tiff", Data: data}, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinBytes is the smallest response worth compressing when its length is known up front;
+// below it the gzip header and the CPU cost outweigh the bytes saved
+const compressMinBytes = 1024
+
+// compressibleTypes are the text responses compressed. Documents (PDF, TIFF) and images are left as
+// they are: they are compressed already, and Telnyx fetches /media/ with Range requests.
+var compressibleTypes = map[string]bool{
+	"text/html":              true,
+	"text/plain":             true,
+	"text/css":               true,
+	"text/csv":               true,
+	"text/javascript":        true,
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"application/fhir+json":  true,
+	"image/svg+xml":          true,
+}
+
+var (
+	gzipWriters  = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	flateWriters = sync.Pool{New: func() any { w, _ := flate.NewWriter(nil, flate.DefaultCompression); return w }}
+)
+
+// compressResponses gzip- or deflate-compresses HTML, JSON and other text responses for clients that
+// accept it, which makes the fax list and the API much quicker over slow lines
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressingWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks gzip, or else deflate, from an Accept-Encoding header; "" when neither is accepted
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		accepted[name] = q > 0
+	}
+	switch {
+	case accepted["gzip"] || accepted["x-gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressingWriter decides from the headers the handler set whether a response is worth compressing.
+// A text response of unknown length is held back until compressMinBytes have been written, so short
+// responses such as small JSON replies go out as they are.
+type compressingWriter struct {
+	http.ResponseWriter
+	encoding    string
+	status      int
+	wroteHeader bool
+	pending     bool           // holding back a text response until it is known to be worth compressing
+	buf         []byte         // what was held back
+	w           io.WriteCloser // the compressor once started; nil when passing through
+}
+
+func (cw *compressingWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+	if cw.compressible(status) {
+		cw.pending = true
+		return
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressingWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.pending {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) >= compressMinBytes {
+			if err := cw.start(); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if cw.w != nil {
+		return cw.w.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// compressible reports whether the response may be compressed: text that is not already encoded,
+// partial, empty or known to be too small
+func (cw *compressingWriter) compressible(status int) bool {
+	h := cw.Header()
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if !compressibleTypes[mediaType] {
+		return false
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" ||
+		status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < compressMinBytes {
+		return false
+	}
+	return true
+}
+
+// start sends the headers of the compressed response and compresses what was held back
+func (cw *compressingWriter) start() error {
+	cw.pending = false
+	h := cw.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", cw.encoding)
+	// A strong ETag names the uncompressed bytes, so it must not be sent for the compressed ones
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if cw.encoding == "gzip" {
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(cw.ResponseWriter)
+		cw.w = gz
+	} else {
+		fl := flateWriters.Get().(*flate.Writer)
+		fl.Reset(cw.ResponseWriter)
+		cw.w = fl
+	}
+	_, err := cw.w.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// Flush sends what has been written so far, e.g. for exports streamed row by row. A held-back
+// response starts compressing, as more is evidently coming.
+func (cw *compressingWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.pending {
+		cw.start()
+	}
+	switch w := cw.w.(type) {
+	case *gzip.Writer:
+		w.Flush()
+	case *flate.Writer:
+		w.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response: a short held-back one goes out uncompressed, a compressed one is
+// completed and its compressor returned to the pool
+func (cw *compressingWriter) Close() {
+	if cw.pending {
+		cw.pending = false
+		cw.ResponseWriter.WriteHeader(cw.status)
+		cw.ResponseWriter.Write(cw.buf)
+		return
+	}
+	if cw.w == nil {
+		return
+	}
+	cw.w.Close()
+	switch w := cw.w.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(w)
+	case *flate.Writer:
+		flateWriters.Put(w)
+	}
+	cw.w = nil
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to upgrade /ws
+func (cw *compressingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
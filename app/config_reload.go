@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fax-ui reloads its config file on SIGHUP (systemctl reload, docker kill -s HUP) and when the file
+// changes. The settings that can change while fax-ui runs are applied in place, so sessions and sends in
+// progress carry on: retention, departments, sign-in exemptions and kiosks. Changes to the sections that
+// need a restart are logged as such. Flags and environment variables cannot change in a running process;
+// they keep overriding the file as at startup.
+
+// configWatchInterval is how often the config file is checked for changes
+const configWatchInterval = 5 * time.Second
+
+// configReloadActor records reloads in the audit log
+const configReloadActor = "config reload"
+
+// configReload is the config file as last loaded
+type configReload struct {
+	mu      sync.Mutex // one reload at a time
+	path    string
+	file    *fileConfig
+	modTime time.Time
+}
+
+// startConfigReload reloads the config file at path on SIGHUP and when it changes; without a config file,
+// SIGHUP only logs that there is nothing to reload
+func (a *App) startConfigReload(path string) {
+	a.reload.path = path
+	if path != "" {
+		if fc, err := loadConfigFile(path); err == nil {
+			a.reload.file = fc
+		}
+		if info, err := os.Stat(path); err == nil {
+			a.reload.modTime = info.ModTime()
+		}
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if path == "" {
+				log.Println("Received SIGHUP; there is no config file to reload (set --config or CONFIG_FILE)")
+				continue
+			}
+			log.Printf("Received SIGHUP; reloading %s", path)
+			a.reloadConfig()
+		}
+	}()
+	if path == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(configWatchInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			a.reload.mu.Lock()
+			changed := !info.ModTime().Equal(a.reload.modTime)
+			a.reload.mu.Unlock()
+			if changed {
+				log.Printf("%s changed; reloading it", path)
+				a.reloadConfig()
+			}
+		}
+	}()
+}
+
+// flagOrEnv returns a string flag's value when it was given, else the environment variable's
+func flagOrEnv(name, env string) string {
+	if f := flag.Lookup(name); f != nil && f.Value.String() != "" {
+		return f.Value.String()
+	}
+	return os.Getenv(env)
+}
+
+// reloadConfig reads the config file again and applies what can change while fax-ui runs, logging what
+// changed. A file that does not load is logged and the configuration in effect is kept.
+func (a *App) reloadConfig() {
+	a.reload.mu.Lock()
+	defer a.reload.mu.Unlock()
+	if info, err := os.Stat(a.reload.path); err == nil {
+		// A file that does not load is not retried until it changes again
+		a.reload.modTime = info.ModTime()
+	}
+	if err := a.applyConfigFile(); err != nil {
+		log.Printf("Warning: could not reload %s, keeping the current configuration: %v", a.reload.path, err)
+	}
+}
+
+// applyConfigFile loads the config file and puts its runtime settings into effect, under a.reload.mu
+func (a *App) applyConfigFile() error {
+	fc, err := loadConfigFile(a.reload.path)
+	if err != nil {
+		return err
+	}
+	for _, k := range fc.Kiosks {
+		if k.Profile != "" && a.profileByName(k.Profile) == nil {
+			return fmt.Errorf("kiosk %s: unknown profile %q", strings.Join(k.Users, ", "), k.Profile)
+		}
+	}
+	retention := fc.Retention
+	if err := overrideRetention(&retention, flagOrEnv("retention_media", "RETENTION_MEDIA"),
+		flagOrEnv("retention_metadata", "RETENTION_METADATA")); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stored, err := a.Store.settings(ctx)
+	if err != nil {
+		return fmt.Errorf("load settings: %w", err)
+	}
+
+	a.settings.mu.Lock()
+	before := a.settings.startup
+	startup := before
+	startup.Retention, startup.Departments, startup.Exemptions, startup.Kiosks = retention, fc.Departments, fc.AuthExemptions, fc.Kiosks
+	a.settings.startup = startup
+	a.settings.mu.Unlock()
+	a.applySettings(stored)
+
+	diff := diffSettings(before, startup)
+	a.settings.mu.RLock()
+	for i, line := range diff {
+		name, _, _ := strings.Cut(line, ":")
+		if _, ok := a.settings.changed[name]; ok {
+			diff[i] += " (overridden at /admin/config)"
+		}
+	}
+	a.settings.mu.RUnlock()
+	var restart []string
+	if old := a.reload.file; old != nil {
+		for _, section := range []struct {
+			name          string
+			before, after any
+		}{
+			{"applications", old.Applications, fc.Applications},
+			{"profiles", old.Profiles, fc.Profiles},
+			{"default_profile", old.DefaultProfile, fc.DefaultProfile},
+			{"intake", old.Intake, fc.Intake},
+		} {
+			if !reflect.DeepEqual(section.before, section.after) {
+				restart = append(restart, section.name)
+			}
+		}
+	}
+	a.reload.file = fc
+
+	if len(diff) == 0 {
+		log.Printf("Reloaded %s: no runtime settings changed", a.reload.path)
+	} else {
+		log.Printf("Reloaded %s: %s", a.reload.path, strings.Join(diff, "; "))
+		a.writeAudit(ctx, auditEntry{Actor: configReloadActor, Action: "config.reloaded", Detail: strings.Join(diff, "; ")})
+	}
+	if len(restart) > 0 {
+		log.Printf("Warning: %s changed in %s; restart fax-ui to apply them", strings.Join(restart, ", "), a.reload.path)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// confirmationThumbnailDesc places the first page of the document, scaled down, below the report text
+const confirmationThumbnailDesc = "scale:0.4 rel, pos:bc, off:0 72, rot:0"
+
+// handleFaxConfirmation downloads the delivery confirmation of a delivered fax as a PDF: who it went to,
+// when, how many pages and the remote ID, with a thumbnail of the first page, for filing as proof of transmission
+func (a *App) handleFaxConfirmation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !a.requireFaxVisible(w, r, profile, id) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+	fax, err := profile.provider.Get(ctx, id)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	if fax.Status != "delivered" {
+		http.Error(w, "only delivered faxes have a delivery confirmation", http.StatusConflict)
+		return
+	}
+	sent, err := a.Store.sentFax(ctx, profile.Name, id)
+	if err != nil {
+		log.Printf("Warning: could not load send record for fax %s: %v", id, err)
+	}
+
+	data, ext, err := a.exportMedia(ctx, profile, fax)
+	if err != nil && !errors.Is(err, errNoMedia) {
+		log.Printf("Warning: confirmation for fax %s has no thumbnail: %v", id, err)
+	}
+	report := confirmationPDF(fax, sent, data != nil, time.Now().In(a.location(r)))
+	if data != nil {
+		if withThumb, err := addThumbnail(report, data, ext); err != nil {
+			log.Printf("Warning: confirmation for fax %s has no thumbnail: %v", id, err)
+		} else {
+			report = withThumb
+		}
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="fax-%s-confirmation.pdf"`, sanitizeFilename(id)))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(report)
+	a.recordAccess(r, accessConfirmation, profile.Name, id)
+}
+
+// confirmationPDF renders the one-page report, with times in now's time zone. withDocument says whether a
+// thumbnail will be added below it.
+func confirmationPDF(fax *Fax, sent *sentFax, withDocument bool, now time.Time) []byte {
+	const timeLayout = "January 2, 2006 15:04:05 MST"
+	pages, duration := "not reported", "not reported"
+	if fax.Pages > 0 {
+		pages = fmt.Sprint(fax.Pages)
+	}
+	if fax.Duration > 0 {
+		duration = fax.Duration.String()
+	}
+	fields := [][2]string{
+		{"Result", "Delivered"},
+		{"Fax ID", fax.ID},
+		{"From", fax.From},
+		{"To", fax.To},
+		{"Remote ID", firstNonEmpty(fax.RemoteID, "not reported")},
+		{"Submitted", fax.CreatedAt.In(now.Location()).Format(timeLayout)},
+		{"Delivered", fax.UpdatedAt.In(now.Location()).Format(timeLayout)},
+		{"Pages", pages},
+		{"Call duration", duration},
+	}
+	if sent != nil {
+		fields = append(fields, [2]string{"Sent via", sent.DeliveryPath()})
+		if sent.SentBy != "" {
+			fields = append(fields, [2]string{"Sent by", sent.SentBy})
+		}
+	}
+	fields = append(fields, [2]string{"Report created", now.Format(timeLayout)})
+
+	var content bytes.Buffer
+	content.WriteString("BT\n/F2 20 Tf\n72 710 Td\n(Fax Delivery Confirmation) Tj\n/F1 11 Tf\n16 TL\n0 -36 Td\n")
+	for _, f := range fields {
+		fmt.Fprintf(&content, "/F2 11 Tf (%s:) Tj /F1 11 Tf 110 0 Td (%s) Tj -110 0 Td T*\n", pdfString(f[0]), pdfString(f[1]))
+	}
+	content.WriteString("ET\n")
+	caption := "The document is no longer stored, so no image of it is included."
+	if withDocument {
+		caption = "First page of the document as sent:"
+	}
+	fmt.Fprintf(&content, "BT\n/F1 10 Tf\n72 400 Td\n(%s) Tj\nET\n", pdfString(caption))
+
+	return writePDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [5 0 R] /Count 1 >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents 6 0 R >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	})
+}
+
+// addThumbnail stamps the first page of the fax document (PDF or TIFF) onto the report
+func addThumbnail(report, doc []byte, ext string) ([]byte, error) {
+	var wm *model.Watermark
+	var err error
+	if bytes.HasPrefix(doc, []byte("%PDF-")) {
+		wm, err = api.PDFWatermarkForReadSeeker(bytes.NewReader(doc), 1, confirmationThumbnailDesc, true, false, types.POINTS)
+	} else {
+		wm, err = api.ImageWatermarkForReader(bytes.NewReader(doc), confirmationThumbnailDesc, true, false, types.POINTS)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s document: %w", ext, err)
+	}
+	var out bytes.Buffer
+	if err := api.AddWatermarks(bytes.NewReader(report), &out, nil, wm, nil); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
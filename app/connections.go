@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+)
+
+// connectionCacheTTL is how long the fax application list is reused before refetching from Telnyx
+const connectionCacheTTL = 10 * time.Minute
+
+// connectionOption is a fax application (connection) that can be selected in the UI
+type connectionOption struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+// listConnections returns the profile account's fax applications, served from cache unless expired or refresh is set
+func (p *Profile) listConnections(ctx context.Context, refresh bool) ([]connectionOption, error) {
+	if p.client == nil {
+		return nil, errNotSupported
+	}
+	return p.connCache.get(connectionCacheTTL, refresh, func() ([]connectionOption, error) {
+		ctx, cancel := context.WithTimeout(ctx, p.pageTimeout)
+		defer cancel()
+
+		var conns []connectionOption
+		iter := p.client.FaxApplications.ListAutoPaging(ctx, telnyx.FaxApplicationListParams{
+			PageSize: telnyx.Int(100),
+		})
+		for iter.Next() {
+			app := iter.Current()
+			conns = append(conns, connectionOption{
+				ID:     app.ID,
+				Name:   firstNonEmpty(app.ApplicationName, app.ID),
+				Active: app.Active,
+			})
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+		sort.Slice(conns, func(i, j int) bool { return conns[i].Name < conns[j].Name })
+		return conns, nil
+	})
+}
+
+// cachedConnections returns the connection list for rendering a picker, or nil if it cannot be fetched.
+// Fetch errors are not fatal: templates fall back to a free-text connection ID field.
+func (p *Profile) cachedConnections(ctx context.Context) []connectionOption {
+	conns, err := p.listConnections(ctx, false)
+	if err != nil {
+		return nil
+	}
+	return conns
+}
+
+// handleAPIConnections returns the account's fax applications as JSON.
+// Pass ?refresh=1 to bypass the cache and ?profile= to list another profile's account.
+func (a *App) handleAPIConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		return
+	}
+	refresh := r.URL.Query().Get("refresh") != ""
+	conns, err := profile.listConnections(r.Context(), refresh)
+	if err != nil {
+		writeJSON(w, upstreamStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	if conns == nil {
+		conns = []connectionOption{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": conns})
+}
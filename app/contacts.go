@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// Staff recognize "Northside Clinic" or (312) 555-0100 at a glance, but not +13125550100. Lists and
+// fax details show numbers in national format, or international format for other countries, with the
+// name admins gave the number in the contact directory at /admin/contacts.
+
+// contactCacheTTL is how long the contact directory is reused, so other instances' changes show within it
+const contactCacheTTL = time.Minute
+
+// contact is a named number in the contact directory
+type contact struct {
+	Number string // E.164
+	Name   string
+}
+
+// formatNumber shows an E.164 number in national format when it is in country, in international format
+// otherwise; SIP URIs and numbers that do not parse are shown as they are
+func formatNumber(number, country string) string {
+	if number == "" || isSIPURI(number) {
+		return number
+	}
+	num, err := phonenumbers.Parse(number, strings.ToUpper(country))
+	if err != nil || !phonenumbers.IsValidNumber(num) {
+		return number
+	}
+	if phonenumbers.GetRegionCodeForNumber(num) == strings.ToUpper(country) {
+		return phonenumbers.Format(num, phonenumbers.NATIONAL)
+	}
+	return phonenumbers.Format(num, phonenumbers.INTERNATIONAL)
+}
+
+// numberFuncs are the template functions that show numbers: phone formats one, and contact returns its
+// name in the directory, loaded the first time a page asks. names is nil where there is no directory.
+func numberFuncs(country string, names func() map[string]string) template.FuncMap {
+	load := sync.OnceValue(func() map[string]string {
+		if names == nil {
+			return nil
+		}
+		return names()
+	})
+	return template.FuncMap{
+		"phone":   func(number string) string { return formatNumber(number, country) },
+		"contact": func(number string) string { return load()[number] },
+	}
+}
+
+// contactNames returns the contact directory's names by number; an unavailable directory names no one
+func (a *App) contactNames(ctx context.Context) map[string]string {
+	contacts, err := a.contacts.get(contactCacheTTL, false, func() ([]contact, error) {
+		return a.Store.contacts(ctx)
+	})
+	if err != nil {
+		log.Printf("Warning: could not load contacts: %v", err)
+	}
+	names := make(map[string]string, len(contacts))
+	for _, c := range contacts {
+		names[c.Number] = c.Name
+	}
+	return names
+}
+
+// contacts returns the contact directory, by name
+func (s *Store) contacts(ctx context.Context) ([]contact, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT number, name FROM contacts ORDER BY name, number`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var contacts []contact
+	for rows.Next() {
+		var c contact
+		if err := rows.Scan(&c.Number, &c.Name); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts, rows.Err()
+}
+
+// saveContact names a number, replacing its earlier name
+func (s *Store) saveContact(ctx context.Context, c contact) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO contacts (number, name, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (number) DO UPDATE SET name = excluded.name, updated_at = excluded.updated_at`,
+		c.Number, c.Name, time.Now().UTC())
+	return err
+}
+
+// deleteContact removes a number from the contact directory
+func (s *Store) deleteContact(ctx context.Context, number string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM contacts WHERE number = ?`, number)
+	return err
+}
+
+// handleAdminContacts lists the contact directory (GET) and adds or deletes contacts (POST)
+func (a *App) handleAdminContacts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		contacts, err := a.Store.contacts(r.Context())
+		if err != nil {
+			http.Error(w, "failed to load contacts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		q := r.URL.Query()
+		a.render(w, r, "admin_contacts.html", map[string]any{
+			"Contacts":       contacts,
+			"DefaultCountry": a.DefaultCountry,
+			"Success":        q.Get("success"),
+			"Error":          q.Get("error"),
+		})
+	case http.MethodPost:
+		a.handleUpdateAdminContacts(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUpdateAdminContacts adds, renames or deletes a contact
+func (a *App) handleUpdateAdminContacts(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	redirect := func(status url.Values) {
+		http.Redirect(w, r, "/admin/contacts?"+status.Encode(), http.StatusSeeOther)
+	}
+
+	number, err := normalizePhoneNumber(r.FormValue("number"), a.DefaultCountry)
+	if err != nil || number == "" {
+		redirect(url.Values{"error": {a.T(r, "Enter a valid fax number")}})
+		return
+	}
+	switch r.FormValue("action") {
+	case "save":
+		name := strings.Join(strings.Fields(r.FormValue("name")), " ")
+		if name == "" || len(name) > 80 {
+			redirect(url.Values{"error": {a.T(r, "Contact names are 1 to 80 characters")}})
+			return
+		}
+		if err := a.Store.saveContact(r.Context(), contact{Number: number, Name: name}); err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "Failed to save contact")}})
+			return
+		}
+		a.contacts.get(0, true, func() ([]contact, error) { return a.Store.contacts(r.Context()) })
+		redirect(url.Values{"success": {a.T(r, "Saved contact %s", name)}})
+	case "delete":
+		if err := a.Store.deleteContact(r.Context(), number); err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "Failed to delete contact")}})
+			return
+		}
+		a.contacts.get(0, true, func() ([]contact, error) { return a.Store.contacts(r.Context()) })
+		redirect(url.Values{"success": {a.T(r, "Deleted contact %s", formatNumber(number, a.DefaultCountry))}})
+	default:
+		redirect(url.Values{"error": {a.T(r, "unknown action")}})
+	}
+}
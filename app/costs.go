@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+)
+
+// Date ranges of Telnyx detail records read by each cost sync. Records can arrive a while after the fax,
+// so each sync rereads a few days; the first sync of a profile backfills further.
+const (
+	costSyncRange     = "last_3_days"
+	costBackfillRange = "last_90_days"
+)
+
+// costMonths is how many months the cost dashboard totals
+const costMonths = 12
+
+// startCostSync pulls fax costs from Telnyx now and then periodically
+func (a *App) startCostSync(interval time.Duration) {
+	backgroundTasks.register("cost sync", interval)
+	run := func() {
+		backgroundTasks.beat("cost sync")
+		if a.leads("cost sync", interval) {
+			a.syncCosts(context.Background())
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run()
+		}
+	}()
+}
+
+// syncCosts stores the billed cost of recent faxes from every Telnyx profile's detail records
+func (a *App) syncCosts(ctx context.Context) error {
+	var errs []error
+	for _, p := range a.Profiles {
+		if p.client == nil {
+			continue
+		}
+		if err := a.syncProfileCosts(ctx, p); err != nil {
+			log.Printf("Warning: could not sync fax costs for profile %s: %v", p.Name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncProfileCosts stores the costs in a profile's fax detail records
+func (a *App) syncProfileCosts(ctx context.Context, p *Profile) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	dateRange := costSyncRange
+	if ok, err := a.Store.hasCosts(ctx, p.Name); err != nil {
+		return err
+	} else if !ok {
+		dateRange = costBackfillRange
+	}
+	iter := p.client.DetailRecords.ListAutoPaging(ctx, telnyx.DetailRecordListParams{
+		Filter:   telnyx.DetailRecordListParamsFilter{RecordType: "fax", DateRange: dateRange},
+		PageSize: telnyx.Int(100),
+	})
+	var costs []faxCost
+	for iter.Next() {
+		rec := iter.Current()
+		cost, err := strconv.ParseFloat(rec.Cost, 64)
+		if err != nil {
+			continue // not rated yet
+		}
+		// Fax records are not one of the SDK's detail record variants; they may name the fax in fax_id
+		var fax struct {
+			FaxID string `json:"fax_id"`
+		}
+		_ = json.Unmarshal([]byte(rec.RawJSON()), &fax)
+		costs = append(costs, faxCost{
+			FaxID:     firstNonEmpty(fax.FaxID, rec.ID),
+			Direction: rec.Direction,
+			Cost:      cost,
+			Currency:  strings.ToUpper(rec.Currency),
+			CreatedAt: rec.CreatedAt,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return a.Store.saveCosts(ctx, p.Name, costs)
+}
+
+// handleAdminCosts shows fax costs by month, profile, user and tag (GET), downloads a month's billed
+// faxes for chargeback (GET with format=csv) and syncs costs on demand (POST)
+func (a *App) handleAdminCosts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Get("format") == "csv" {
+			a.handleCostsCSV(w, r)
+			return
+		}
+		a.handleListCosts(w, r)
+	case http.MethodPost:
+		err := a.syncCosts(r.Context())
+		status := url.Values{"success": {a.T(r, "Costs synced from Telnyx")}}
+		if err != nil {
+			status = url.Values{"error": {a.failureMessage(r, err, "Failed to sync costs")}}
+		}
+		status.Set("month", r.FormValue("month"))
+		http.Redirect(w, r, "/admin/costs?"+status.Encode(), http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// costMonth parses the month query parameter (YYYY-MM), defaulting to the current month in UTC
+func costMonth(r *http.Request) (time.Time, error) {
+	v := r.URL.Query().Get("month")
+	if v == "" {
+		now := time.Now().UTC()
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Parse("2006-01", v)
+}
+
+// handleListCosts renders the cost dashboard for a month
+func (a *App) handleListCosts(w http.ResponseWriter, r *http.Request) {
+	month, err := costMonth(r)
+	if err != nil {
+		http.Error(w, "invalid month", http.StatusBadRequest)
+		return
+	}
+	m := month.Format("2006-01")
+	data := map[string]any{
+		"Month":   m,
+		"Prev":    month.AddDate(0, -1, 0).Format("2006-01"),
+		"Next":    month.AddDate(0, 1, 0).Format("2006-01"),
+		"Syncing": a.CostSyncInterval > 0,
+		"Success": r.URL.Query().Get("success"),
+		"Error":   r.URL.Query().Get("error"),
+	}
+	groups := map[string]string{"month": "Months", "profile": "ByProfile", "user": "ByUser", "tag": "ByTag"}
+	for group, key := range groups {
+		from := m
+		if group == "month" {
+			from = month.AddDate(0, 1-costMonths, 0).Format("2006-01")
+		}
+		costs, err := a.Store.costsBy(r.Context(), group, from, m)
+		if err != nil {
+			http.Error(w, "failed to load costs: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data[key] = costs
+	}
+	a.render(w, r, "admin_costs.html", data)
+}
+
+// handleCostsCSV downloads every billed fax of a month with the user and tags it is charged back to
+func (a *App) handleCostsCSV(w http.ResponseWriter, r *http.Request) {
+	month, err := costMonth(r)
+	if err != nil {
+		http.Error(w, "invalid month", http.StatusBadRequest)
+		return
+	}
+	m := month.Format("2006-01")
+	items, err := a.Store.costItems(r.Context(), m)
+	if err != nil {
+		http.Error(w, "failed to load costs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="fax-costs-`+m+`.csv"`)
+	// A byte order mark makes Excel read the file as UTF-8
+	w.Write([]byte("\ufeff"))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"month", "profile", "fax_id", "direction", "created_at", "sent_by", "tags", "cost", "currency"})
+	for _, i := range items {
+		cw.Write([]string{m, i.Profile, i.FaxID, i.Direction, i.CreatedAt.UTC().Format(time.RFC3339), i.SentBy, i.Tags,
+			strconv.FormatFloat(i.Cost, 'f', 4, 64), i.Currency})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+func init() {
+	// Keep pdfcpu from creating a configuration directory in the user's home
+	model.ConfigPath = "disable"
+}
+
+// Cover page layout: characters per line and lines per page (the first page also holds the header)
+const (
+	coverPageLineWidth     = 85
+	coverPageLinesPerPage  = 42
+	coverPageHeaderLines   = 7
+	coverPageFirstPageText = coverPageLinesPerPage - coverPageHeaderLines
+)
+
+// coverPagePDF renders a US Letter cover sheet with the sender, recipient, date and note.
+// Long notes continue on further pages.
+func coverPagePDF(from, to, note string, date time.Time) []byte {
+	lines := wrapText(note, coverPageLineWidth)
+
+	// Split the note into pages; the first page starts with the heading and header fields
+	var pages []string
+	for first := true; first || len(lines) > 0; first = false {
+		var content bytes.Buffer
+		content.WriteString("BT\n")
+		n := coverPageLinesPerPage
+		if first {
+			content.WriteString("/F2 28 Tf\n72 700 Td\n(FAX) Tj\n/F1 12 Tf\n16 TL\n0 -40 Td\n")
+			for _, line := range []string{"To: " + to, "From: " + from, "Date: " + date.Format("January 2, 2006 15:04 MST")} {
+				fmt.Fprintf(&content, "(%s) Tj T*\n", pdfString(line))
+			}
+			content.WriteString("T*\n")
+			n = coverPageFirstPageText
+		} else {
+			content.WriteString("/F1 12 Tf\n16 TL\n72 720 Td\n")
+		}
+		n = min(n, len(lines))
+		for _, line := range lines[:n] {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", pdfString(line))
+		}
+		lines = lines[n:]
+		content.WriteString("ET\n")
+		pages = append(pages, content.String())
+	}
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page adds a page and a content stream
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	for i, content := range pages {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+		)
+	}
+	return writePDF(objects)
+}
+
+// writePDF assembles a PDF from its objects, numbered from 1; the first must be the catalog
+func writePDF(objects []string) []byte {
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+// prependCoverPage returns doc (a PDF) with the cover page as its first page
+func prependCoverPage(cover, doc []byte) ([]byte, error) {
+	var out bytes.Buffer
+	if err := api.MergeRaw([]io.ReadSeeker{bytes.NewReader(cover), bytes.NewReader(doc)}, &out, false, nil); err != nil {
+		return nil, fmt.Errorf("failed to add cover page: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// pdfString escapes text for a PDF literal string, replacing characters outside Latin-1 with '?'
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case r < 0x20:
+			// drop control characters
+		case r > 0xff:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+// wrapText splits text into lines of at most width characters, breaking at spaces and keeping blank lines
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			for len([]rune(word)) > width {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				runes := []rune(word)
+				lines = append(lines, string(runes[:width]))
+				word = string(runes[width:])
+			}
+			switch {
+			case line == "":
+				line = word
+			case len([]rune(line))+1+len([]rune(word)) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// The store's queries are written for SQLite, with ? and ?N placeholders. A Postgres database (DATABASE_URL)
+// lets several instances share state; its statements are rewritten to $N placeholders as they are run, and
+// the few expressions the two databases spell differently come from the database's methods below.
+
+// database is the SQLite or Postgres database behind the store
+type database struct {
+	*sql.DB
+	postgres bool
+}
+
+// isPostgresURL reports whether a database location is a Postgres connection URL rather than a SQLite path
+func isPostgresURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// openDatabase connects to the Postgres database at a postgres:// URL, or opens (creating if needed) the
+// SQLite database at a path
+func openDatabase(dsn string) (*database, error) {
+	if isPostgresURL(dsn) {
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("connect to %s: %w", redactDatabaseURL(dsn), err)
+		}
+		return &database{DB: db, postgres: true}, nil
+	}
+	db, err := sql.Open("sqlite", "file:"+dsn+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time
+	db.SetMaxOpenConns(1)
+	return &database{DB: db}, nil
+}
+
+// redactDatabaseURL hides the password of a database URL for logs and errors
+func redactDatabaseURL(dsn string) string {
+	scheme, rest, _ := strings.Cut(dsn, "://")
+	if userinfo, host, ok := strings.Cut(rest, "@"); ok {
+		if user, _, hasPassword := strings.Cut(userinfo, ":"); hasPassword {
+			return scheme + "://" + user + ":xxxxx@" + host
+		}
+	}
+	return dsn
+}
+
+func (db *database) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = db.rebind(query, args)
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+func (db *database) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = db.rebind(query, args)
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+func (db *database) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = db.rebind(query, args)
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (db *database) BeginTx(ctx context.Context, opts *sql.TxOptions) (*dbTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &dbTx{Tx: tx, db: db}, nil
+}
+
+// dbTx is a transaction on the database, whose statements are rewritten like the database's
+type dbTx struct {
+	*sql.Tx
+	db *database
+}
+
+func (tx *dbTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = tx.db.rebind(query, args)
+	return tx.Tx.ExecContext(ctx, query, args...)
+}
+
+func (tx *dbTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = tx.db.rebind(query, args)
+	return tx.Tx.QueryContext(ctx, query, args...)
+}
+
+func (tx *dbTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = tx.db.rebind(query, args)
+	return tx.Tx.QueryRowContext(ctx, query, args...)
+}
+
+// rebind rewrites a statement for Postgres: ? placeholders become $1, $2... in order and ?N becomes $N.
+// Flags are stored as integers, as in SQLite, so booleans are passed as 0 or 1.
+func (db *database) rebind(query string, args []any) (string, []any) {
+	if !db.postgres {
+		return query, args
+	}
+	var b strings.Builder
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			// Comments in the schema run to the end of the line
+			quote = '\n'
+		case c == '?':
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			if j > i+1 {
+				b.WriteString("$" + query[i+1:j])
+				i = j - 1
+			} else {
+				n++
+				b.WriteString("$" + strconv.Itoa(n))
+			}
+			continue
+		}
+		b.WriteByte(c)
+	}
+	bound := make([]any, len(args))
+	for i, arg := range args {
+		bound[i] = arg
+		if v, ok := arg.(bool); ok {
+			bound[i] = 0
+			if v {
+				bound[i] = 1
+			}
+		}
+	}
+	return b.String(), bound
+}
+
+// postgresTypes translates the SQLite column types of the schema
+var postgresTypes = strings.NewReplacer(
+	"INTEGER PRIMARY KEY AUTOINCREMENT", "BIGSERIAL PRIMARY KEY",
+	"INTEGER", "BIGINT",
+	"REAL", "DOUBLE PRECISION",
+	"TIMESTAMP", "TIMESTAMPTZ",
+	"BLOB", "BYTEA",
+)
+
+// ddl returns a schema statement written for SQLite in the database's dialect
+func (db *database) ddl(stmt string) string {
+	if !db.postgres {
+		return stmt
+	}
+	return postgresTypes.Replace(stmt)
+}
+
+// columnExists reports whether a table has a column
+func (db *database) columnExists(ctx context.Context, q querier, table, column string) (bool, error) {
+	query := `SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`
+	if db.postgres {
+		query = `SELECT COUNT(*) > 0 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`
+	}
+	var exists bool
+	err := q.QueryRowContext(ctx, query, table, column).Scan(&exists)
+	return exists, err
+}
+
+// querier runs statements on the database or in a transaction
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// month returns the UTC month (YYYY-MM) of a timestamp column
+func (db *database) month(column string) string {
+	if db.postgres {
+		return `to_char(` + column + ` AT TIME ZONE 'UTC', 'YYYY-MM')`
+	}
+	return `substr(` + column + `, 1, 7)`
+}
+
+// joined returns an aggregate joining an expression's values with ", "
+func (db *database) joined(expr string) string {
+	if db.postgres {
+		return `string_agg(` + expr + `, ', ' ORDER BY ` + expr + `)`
+	}
+	return `group_concat(` + expr + `, ', ')`
+}
+
+// like returns the operator matching a pattern regardless of case, as SQLite's LIKE does
+func (db *database) like() string {
+	if db.postgres {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	pg := &database{postgres: true}
+	many := "INSERT INTO t VALUES (?" + strings.Repeat(", ?", 11) + ")"
+	tests := []struct {
+		name, query, want string
+	}{
+		{"placeholders in order", `SELECT * FROM t WHERE a = ? AND b = ?`, `SELECT * FROM t WHERE a = $1 AND b = $2`},
+		{"numbered", `UPDATE t SET a = ?2 WHERE b = ?1 OR c = ?1`, `UPDATE t SET a = $2 WHERE b = $1 OR c = $1`},
+		{"many", many, "INSERT INTO t VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)"},
+		{"in a string", `SELECT '?' || a FROM t WHERE b = ?`, `SELECT '?' || a FROM t WHERE b = $1`},
+		{"after an escaped quote", `SELECT 'it''s ?' WHERE a = ?`, `SELECT 'it''s ?' WHERE a = $1`},
+		{"in an identifier", `SELECT "odd?" FROM t WHERE "user" = ?`, `SELECT "odd?" FROM t WHERE "user" = $1`},
+		{"in a comment", "CREATE TABLE t (a TEXT -- why?\n, b TEXT DEFAULT ?)", "CREATE TABLE t (a TEXT -- why?\n, b TEXT DEFAULT $1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := pg.rebind(tt.query, nil); got != tt.want {
+				t.Fatalf("rebind:\n got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+
+	args := []any{"a", true, false, 3}
+	if _, got := pg.rebind("?", args); !slices.Equal(got, []any{"a", 1, 0, 3}) {
+		t.Fatalf("Postgres arguments %v", got)
+	}
+	if args[1] != true {
+		t.Fatal("rebind changed the caller's arguments")
+	}
+	sqlite := &database{}
+	if q, got := sqlite.rebind("SELECT ?", args); q != "SELECT ?" || !slices.Equal(got, args) {
+		t.Fatalf("SQLite statement rewritten to %s with %v", q, got)
+	}
+}
+
+func TestDialectExpressions(t *testing.T) {
+	pg, sqlite := &database{postgres: true}, &database{}
+	tests := []struct{ name, sqlite, postgres, wantSQLite, wantPostgres string }{
+		{"like", sqlite.like(), pg.like(), "LIKE", "ILIKE"},
+		{"month", sqlite.month("created_at"), pg.month("created_at"),
+			"substr(created_at, 1, 7)", "to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM')"},
+		{"joined", sqlite.joined("tag"), pg.joined("tag"), "group_concat(tag, ', ')", "string_agg(tag, ', ' ORDER BY tag)"},
+		{"ddl", sqlite.ddl("id INTEGER PRIMARY KEY AUTOINCREMENT, at TIMESTAMP"), pg.ddl("id INTEGER PRIMARY KEY AUTOINCREMENT, at TIMESTAMP"),
+			"id INTEGER PRIMARY KEY AUTOINCREMENT, at TIMESTAMP", "id BIGSERIAL PRIMARY KEY, at TIMESTAMPTZ"},
+	}
+	for _, tt := range tests {
+		if tt.sqlite != tt.wantSQLite || tt.postgres != tt.wantPostgres {
+			t.Errorf("%s: SQLite %q, Postgres %q; want %q and %q", tt.name, tt.sqlite, tt.postgres, tt.wantSQLite, tt.wantPostgres)
+		}
+	}
+}
+
+func TestCaseInsensitiveMatching(t *testing.T) {
+	db, err := openDatabase(filepath.Join(t.TempDir(), "fax-ui.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE t (name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO t VALUES (?), (?)`, "Alice@Example.com", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, where string
+		arg         string
+		want        int
+	}{
+		{"nocase", `name = ? COLLATE NOCASE`, "alice@example.COM", 1},
+		{"case-sensitive", `name = ?`, "alice@example.com", 0},
+		{"like", `name ` + db.like() + ` ?`, "%EXAMPLE.com", 2},
+	}
+	for _, tt := range tests {
+		var n int
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM t WHERE `+tt.where, tt.arg).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if n != tt.want {
+			t.Errorf("%s matched %d rows, want %d", tt.name, n, tt.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Document management systems that watch folders can pick up received faxes from a folder fax-ui writes
+// them to: a local path, e.g. a mounted network share, or a path on an SFTP server. The path is a template,
+// e.g. /faxes/{number}/{date}/{id}.pdf, so faxes can be filed by line and day. Files are written under a
+// temporary name and renamed into place, so a watcher never picks up half a fax.
+
+// DeliveryConfig configures the delivery of received faxes to a folder
+type DeliveryConfig struct {
+	Destination  string // path template, local or sftp://user@host[:port]/path; empty disables delivery
+	SFTPKey      string // PEM private key file for SFTP
+	SFTPPassword string // password for SFTP, when no key is given
+	KnownHosts   string // known_hosts file SFTP host keys are checked against (default ~/.ssh/known_hosts)
+}
+
+// deliveryPlaceholders are the placeholders a delivery path template may use
+var deliveryPlaceholders = []string{"{number}", "{from}", "{to}", "{date}", "{time}", "{id}", "{profile}"}
+
+// deliveryTimeout bounds delivering one fax; deliveryRetryAfter is how long a failed fax waits before the
+// list pages try it again
+const (
+	deliveryTimeout    = 2 * time.Minute
+	deliveryRetryAfter = 10 * time.Minute
+)
+
+// folderDelivery writes received faxes to a local or SFTP folder
+type folderDelivery struct {
+	template string // path template
+	sftp     *ssh.ClientConfig
+	addr     string   // SFTP server host:port; empty for local paths
+	busy     sync.Map // "<profile>/<fax ID>" of the faxes being delivered
+	retryAt  sync.Map // "<profile>/<fax ID>" of failed deliveries, to the time they may be tried again
+}
+
+// newFolderDelivery parses the destination and loads the SFTP credentials it needs
+func newFolderDelivery(cfg DeliveryConfig) (*folderDelivery, error) {
+	if !strings.Contains(cfg.Destination, "{id}") {
+		return nil, fmt.Errorf("delivery path %q must contain {id}", cfg.Destination)
+	}
+	for rest := cfg.Destination; ; {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 || !containsFold(deliveryPlaceholders, rest[start:start+end+1]) {
+			return nil, fmt.Errorf("delivery path %q: unknown placeholder (expected %s)", cfg.Destination, strings.Join(deliveryPlaceholders, ", "))
+		}
+		rest = rest[start+end+1:]
+	}
+	if !strings.HasPrefix(cfg.Destination, "sftp://") {
+		if !filepath.IsAbs(cfg.Destination) {
+			return nil, fmt.Errorf("delivery path %q must be absolute", cfg.Destination)
+		}
+		return &folderDelivery{template: cfg.Destination}, nil
+	}
+	u, err := url.Parse(cfg.Destination)
+	if err != nil || u.Host == "" || u.User == nil || u.Path == "" {
+		return nil, fmt.Errorf("invalid SFTP destination %q (expected sftp://user@host[:port]/path)", cfg.Destination)
+	}
+	var auth []ssh.AuthMethod
+	switch {
+	case cfg.SFTPKey != "":
+		data, err := os.ReadFile(cfg.SFTPKey)
+		if err != nil {
+			return nil, fmt.Errorf("SFTP key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("SFTP key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	case cfg.SFTPPassword != "":
+		auth = append(auth, ssh.Password(cfg.SFTPPassword))
+	default:
+		return nil, fmt.Errorf("SFTP delivery needs --sftp_key or SFTP_PASSWORD")
+	}
+	hostKeys, err := knownHostsCallback(cfg.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("SFTP known hosts: %w", err)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	// The URL's path is escaped; the placeholders are not
+	tmpl, err := url.PathUnescape(u.EscapedPath())
+	if err != nil {
+		return nil, err
+	}
+	return &folderDelivery{
+		template: tmpl,
+		addr:     addr,
+		sftp: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+// knownHostsCallback checks SSH host keys against a known_hosts file, ~/.ssh/known_hosts when file is empty
+func knownHostsCallback(file string) (ssh.HostKeyCallback, error) {
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	return knownhosts.New(file)
+}
+
+// path fills in the template for a fax. Numbers lose their +, and every value is made safe for file names.
+func (d *folderDelivery) path(profile string, fax Fax, loc *time.Location) string {
+	received := firstTime(fax.CreatedAt, fax.UpdatedAt, time.Now()).In(loc)
+	r := strings.NewReplacer(
+		"{number}", sanitizeFilename(fax.To),
+		"{from}", sanitizeFilename(fax.From),
+		"{to}", sanitizeFilename(fax.To),
+		"{date}", received.Format("2006-01-02"),
+		"{time}", received.Format("150405"),
+		"{id}", sanitizeFilename(fax.ID),
+		"{profile}", sanitizeFilename(profile),
+	)
+	return path.Clean(r.Replace(d.template))
+}
+
+// put writes data to name, creating its folder, under a temporary name first
+func (d *folderDelivery) put(ctx context.Context, name string, data []byte) error {
+	tmp := path.Join(path.Dir(name), "."+path.Base(name)+".part")
+	if d.sftp == nil {
+		local := filepath.FromSlash(name)
+		if err := os.MkdirAll(filepath.Dir(local), 0o750); err != nil {
+			return err
+		}
+		tmp := filepath.FromSlash(tmp)
+		if err := os.WriteFile(tmp, data, 0o640); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, local); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return nil
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, d.addr, d.sftp)
+	if err != nil {
+		return err
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	defer sshClient.Close()
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	f, err := client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		client.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		client.Remove(tmp)
+		return err
+	}
+	// Plain SFTP renames refuse to replace a file; the POSIX extension does, where the server has it
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		err = client.PosixRename(tmp, name)
+	} else {
+		client.Remove(name)
+		err = client.Rename(tmp, name)
+	}
+	if err != nil {
+		client.Remove(tmp)
+	}
+	return err
+}
+
+// deliverReceived writes a received fax to the delivery folder in the background, unless it was
+// delivered before
+func (a *App) deliverReceived(p *Profile, fax Fax) {
+	if a.Delivery == nil || fax.Direction != "inbound" || fax.Status != "received" {
+		return
+	}
+	key := p.Name + "/" + fax.ID
+	if at, ok := a.Delivery.retryAt.Load(key); ok && time.Now().Before(at.(time.Time)) {
+		return
+	}
+	if _, busy := a.Delivery.busy.LoadOrStore(key, true); busy {
+		return
+	}
+	go func() {
+		defer a.Delivery.busy.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+		defer cancel()
+		if err := a.deliverReceivedFax(ctx, p, fax); err != nil {
+			a.Delivery.retryAt.Store(key, time.Now().Add(deliveryRetryAfter))
+			log.Printf("Warning: could not deliver received fax %s to the folder: %v", fax.ID, err)
+			return
+		}
+		a.Delivery.retryAt.Delete(key)
+	}()
+}
+
+// deliverInbox delivers the received faxes among faxes that have not been delivered yet, catching up on
+// missed webhooks and failed deliveries
+func (a *App) deliverInbox(ctx context.Context, p *Profile, faxes []Fax) {
+	if a.Delivery == nil {
+		return
+	}
+	for _, f := range faxes {
+		if f.Direction != "inbound" || f.Status != "received" {
+			continue
+		}
+		if delivered, err := a.Store.receivedDelivery(ctx, p.Name, f.ID); err != nil || delivered != "" {
+			continue
+		}
+		a.deliverReceived(p, f)
+	}
+}
+
+func (a *App) deliverReceivedFax(ctx context.Context, p *Profile, fax Fax) error {
+	if delivered, err := a.Store.receivedDelivery(ctx, p.Name, fax.ID); err != nil || delivered != "" {
+		return err
+	}
+	doc, err := a.receivedDocument(ctx, p, fax.ID, fax.MediaURL)
+	if err != nil {
+		return err
+	}
+	name := a.Delivery.path(p.Name, fax, a.Location)
+	if err := a.Delivery.put(ctx, name, doc.Data); err != nil {
+		return err
+	}
+	return a.Store.saveReceivedDelivery(ctx, p.Name, fax.ID, name)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// Some fax numbers fail often: a busy machine, a bad line, a number that is sometimes a phone. The send
+// form looks up the recent faxes a profile sent to the number being typed and warns when most of them
+// failed, with how many attempts it usually takes before one goes through.
+
+// destinationHistory is how many of the latest faxes to a number its statistics are taken from
+const destinationHistory = 20
+
+// destinationRecent is how many of the latest faxes to a number the send form's warning counts
+const destinationRecent = 5
+
+// destinationStats is how faxes to a number went
+type destinationStats struct {
+	Attempts int `json:"attempts"` // of the last destinationRecent finished faxes
+	Failed   int `json:"failed"`   // of those Attempts
+	Retries  int `json:"retries"`  // failed faxes before one is delivered, typically; 0 when they go through the first time
+}
+
+// statsFromOutcomes computes a number's statistics from whether its finished faxes were delivered, newest first
+func statsFromOutcomes(delivered []bool) destinationStats {
+	var s destinationStats
+	for _, ok := range delivered[:min(len(delivered), destinationRecent)] {
+		s.Attempts++
+		if !ok {
+			s.Failed++
+		}
+	}
+	// Every delivered fax ends a run of failed ones: the retries it took
+	var runs []int
+	failed := 0
+	for i := len(delivered) - 1; i >= 0; i-- {
+		if !delivered[i] {
+			failed++
+			continue
+		}
+		runs = append(runs, failed)
+		failed = 0
+	}
+	if len(runs) > 0 {
+		sort.Ints(runs)
+		s.Retries = runs[len(runs)/2]
+	}
+	return s
+}
+
+// destinationOutcomes returns whether the profile's latest finished faxes to a number were delivered, newest first
+func (s *Store) destinationOutcomes(ctx context.Context, profile, to string) ([]bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT status FROM faxes
+		WHERE profile = ? AND to_number = ? AND direction = 'outbound' AND status IN ('delivered', 'failed')
+		ORDER BY created_at DESC LIMIT ?`, profile, to, destinationHistory)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var delivered []bool
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			return nil, err
+		}
+		delivered = append(delivered, status == "delivered")
+	}
+	return delivered, rows.Err()
+}
+
+// destinationHint warns about a number most of whose recent faxes failed; it is empty for the others
+func (a *App) destinationHint(r *http.Request, s destinationStats) string {
+	if s.Failed < 2 || s.Failed*2 < s.Attempts {
+		return ""
+	}
+	hint := a.T(r, "This number failed %d of the last %d attempts.", s.Failed, s.Attempts)
+	switch {
+	case s.Retries == 1:
+		hint += " " + a.T(r, "Faxes to it usually go through on the second attempt.")
+	case s.Retries > 1:
+		hint += " " + a.T(r, "Faxes to it usually go through after %d failed attempts.", s.Retries)
+	}
+	return hint
+}
+
+// handleAPIDestination returns the statistics of the number in ?to= for ?profile=, with the send form's
+// warning in hint, so the form can show it while the number is typed
+func (a *App) handleAPIDestination(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		return
+	}
+	to, err := normalizePhoneNumber(r.URL.Query().Get("to"), a.DefaultCountry)
+	if err != nil {
+		writeJSON(w, http.StatusOK, map[string]any{"data": destinationStats{}, "hint": ""})
+		return
+	}
+	delivered, err := a.Store.destinationOutcomes(r.Context(), profile.Name, to)
+	if err != nil {
+		log.Printf("Warning: could not load the faxes sent to a destination: %v", err)
+	}
+	stats := statsFromOutcomes(delivered)
+	writeJSON(w, http.StatusOK, map[string]any{"data": stats, "hint": a.destinationHint(r, stats)})
+}
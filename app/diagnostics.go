@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// startedAt is when the process started, for the uptime on /debug/status
+var startedAt = time.Now()
+
+// backgroundTasks records when each periodic background task last ran, so /debug/status can tell
+// a stuck or crashed ticker from one that is merely waiting
+var backgroundTasks = &taskHeartbeats{tasks: make(map[string]*taskHeartbeat)}
+
+// taskHeartbeats tracks the periodic background tasks by name
+type taskHeartbeats struct {
+	mu    sync.Mutex
+	tasks map[string]*taskHeartbeat
+}
+
+type taskHeartbeat struct {
+	interval time.Duration
+	started  time.Time
+	lastRun  time.Time
+	standby  bool // another instance on the database holds the task's lease
+}
+
+// taskStatus is a background task as shown on /debug/status
+type taskStatus struct {
+	Name     string    `json:"name"`
+	Interval string    `json:"interval"`
+	LastRun  time.Time `json:"last_run"` // zero until the task first runs
+	Healthy  bool      `json:"healthy"`
+	Standby  bool      `json:"standby,omitempty"` // another instance runs it
+}
+
+// register announces a task that runs about every interval
+func (h *taskHeartbeats) register(name string, interval time.Duration) {
+	h.mu.Lock()
+	h.tasks[name] = &taskHeartbeat{interval: interval, started: time.Now()}
+	h.mu.Unlock()
+}
+
+// beat records that a task ran
+func (h *taskHeartbeats) beat(name string) {
+	h.mu.Lock()
+	if t := h.tasks[name]; t != nil {
+		t.lastRun = time.Now()
+	}
+	h.mu.Unlock()
+}
+
+// standby records whether another instance runs a task instead of this one
+func (h *taskHeartbeats) standby(name string, standby bool) {
+	h.mu.Lock()
+	if t := h.tasks[name]; t != nil {
+		t.standby = standby
+	}
+	h.mu.Unlock()
+}
+
+// snapshot returns the tasks by name. A task is healthy while it has run (or started) within two of
+// its intervals, plus a minute for runs that take a while.
+func (h *taskHeartbeats) snapshot() []taskStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tasks := make([]taskStatus, 0, len(h.tasks))
+	for name, t := range h.tasks {
+		since := t.started
+		if t.lastRun.After(since) {
+			since = t.lastRun
+		}
+		tasks = append(tasks, taskStatus{
+			Name:     name,
+			Interval: t.interval.String(),
+			LastRun:  t.lastRun,
+			Healthy:  time.Since(since) < 2*t.interval+time.Minute,
+			Standby:  t.standby,
+		})
+	}
+	slices.SortFunc(tasks, func(a, b taskStatus) int { return strings.Compare(a.Name, b.Name) })
+	return tasks
+}
+
+// diagnostics is the runtime summary shown on /debug/status and published in /debug/vars
+type diagnostics struct {
+	Version          string       `json:"version"`
+	Instance         string       `json:"instance"` // names this instance in the leases it holds
+	GoVersion        string       `json:"go_version"`
+	Uptime           string       `json:"uptime"`
+	Goroutines       int          `json:"goroutines"`
+	HeapBytes        uint64       `json:"heap_bytes"`
+	MemoryMedia      int          `json:"memory_media"`       // documents held by the in-memory media store
+	MemoryMediaBytes int64        `json:"memory_media_bytes"` // their size
+	Uploads          int          `json:"uploads"`            // chunked uploads in progress
+	UploadBytes      int64        `json:"upload_bytes"`       // bytes received for them so far
+	IntakeQueue      int          `json:"intake_queue"`       // intake deliveries waiting to go out
+	CloudQueue       int          `json:"cloud_queue"`        // cloud archive uploads waiting to go out
+	Tasks            []taskStatus `json:"tasks"`
+	QueueError       string       `json:"queue_error,omitempty"`
+}
+
+// diagnostics collects the runtime summary
+func (a *App) diagnostics(ctx context.Context) diagnostics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	d := diagnostics{
+		Version:    Version,
+		Instance:   a.instanceID,
+		GoVersion:  runtime.Version(),
+		Uptime:     time.Since(startedAt).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapBytes:  mem.HeapAlloc,
+		Tasks:      backgroundTasks.snapshot(),
+	}
+	if a.memMedia != nil {
+		d.MemoryMedia, d.MemoryMediaBytes = a.memMedia.usage()
+	}
+	d.Uploads, d.UploadBytes = a.uploads.usage()
+	intake, cloud, err := a.Store.pendingJobs(ctx)
+	if err != nil {
+		d.QueueError = err.Error()
+	}
+	d.IntakeQueue, d.CloudQueue = intake, cloud
+	return d
+}
+
+// handleDebugStatus shows the runtime summary, or returns it as JSON with format=json
+func (a *App) handleDebugStatus(w http.ResponseWriter, r *http.Request) {
+	d := a.diagnostics(r.Context())
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, d)
+		return
+	}
+	a.render(w, r, "debug_status.html", map[string]any{"Status": d})
+}
+
+// debugRoutes serves the Go profiler, expvar and the runtime summary to admins
+func (a *App) debugRoutes(mux *http.ServeMux) {
+	expvar.Publish("fax_ui", expvar.Func(func() any {
+		return a.diagnostics(context.Background())
+	}))
+	mux.HandleFunc("/debug/status", a.requireAdmin(a.handleDebugStatus))
+	mux.HandleFunc("/debug/vars", a.requireAdmin(expvar.Handler().ServeHTTP))
+	mux.HandleFunc("/debug/pprof/", a.requireAdmin(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", a.requireAdmin(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", a.requireAdmin(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", a.requireAdmin(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", a.requireAdmin(pprof.Trace))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// handleDrafts lists the user's drafts (GET) and saves or deletes drafts (POST)
+func (a *App) handleDrafts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.handleListDrafts(w, r)
+	case http.MethodPost:
+		a.handleUpdateDrafts(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleListDrafts renders the drafts saved by the current user
+func (a *App) handleListDrafts(w http.ResponseWriter, r *http.Request) {
+	owner, _ := a.sessionUser(r)
+	drafts, err := a.Store.drafts(r.Context(), owner)
+	if err != nil {
+		http.Error(w, "failed to load drafts: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Drafts":  drafts,
+		"Success": r.URL.Query().Get("success"),
+	}
+	a.render(w, r, "drafts.html", data)
+}
+
+// handleUpdateDrafts saves the send form as a draft, or deletes a draft
+func (a *App) handleUpdateDrafts(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(25 << 20); err != nil {
+			http.Error(w, "invalid multipart form", http.StatusBadRequest)
+			return
+		}
+	} else if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	owner, _ := a.sessionUser(r)
+
+	if r.FormValue("action") == "delete" {
+		if err := a.Store.deleteDraft(r.Context(), owner, r.FormValue("draft_id")); err != nil {
+			http.Error(w, "failed to delete draft: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/drafts?success="+url.QueryEscape(a.T(r, "Draft deleted")), http.StatusSeeOther)
+		return
+	}
+
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	d := &draft{
+		ID:           r.FormValue("draft_id"),
+		Owner:        owner,
+		Profile:      profile.Name,
+		To:           strings.TrimSpace(r.FormValue("to")),
+		From:         strings.TrimSpace(r.FormValue("from")),
+		ConnectionID: strings.TrimSpace(r.FormValue("connection_id")),
+		MediaURL:     strings.TrimSpace(r.FormValue("media_url")),
+		CoverText:    r.FormValue("cover_text"),
+	}
+	if d.ID == "" {
+		if d.ID, err = generateSecureToken(16); err != nil {
+			http.Error(w, "failed to create draft", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	msg := "Draft saved"
+	file, err := a.requestDocument(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if file != nil {
+		// HIPAA mode keeps documents in memory only, so they are not written to the database
+		if a.Hipaa {
+			msg = "Draft saved without the attached file (files are not stored in HIPAA mode)"
+		} else {
+			d.File = file
+		}
+	}
+	if err := a.Store.saveDraft(r.Context(), d); err != nil {
+		http.Error(w, "failed to save draft: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/drafts?success="+url.QueryEscape(a.T(r, msg)), http.StatusSeeOther)
+}
+
+// requestDraft loads the draft named by the request's "draft" or "draft_id" parameter for the current user.
+// Returns nil when no draft is named or it does not exist.
+func (a *App) requestDraft(r *http.Request) *draft {
+	id := firstNonEmpty(r.FormValue("draft"), r.FormValue("draft_id"))
+	if id == "" {
+		return nil
+	}
+	owner, _ := a.sessionUser(r)
+	d, err := a.Store.draft(r.Context(), owner, id)
+	if err != nil {
+		log.Printf("Warning: could not load draft: %v", err)
+		return nil
+	}
+	return d
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// errorMessage is what the user is told about an error response. Client errors keep the handler's
+// message, which describes what to fix. Server errors may carry the provider's error detail, which can
+// include fax numbers or document names, so that stays in the server log under the request ID.
+func (a *App) errorMessage(r *http.Request, status int, detail string) string {
+	switch {
+	case status == http.StatusServiceUnavailable:
+		// The circuit breaker's message is written for users
+		return a.T(r, detail)
+	case status == http.StatusBadGateway:
+		return a.T(r, "The fax service could not complete the request.")
+	case status >= 500:
+		return a.T(r, "Something went wrong on our side.")
+	}
+	return a.T(r, detail)
+}
+
+// failureMessage logs why an action failed under the request ID and returns what the page it redirects
+// to says instead: msg, a fixed message from the translations, and the reference to quote. The error
+// stays out of the URL and the page, since it can carry database or provider detail.
+func (a *App) failureMessage(r *http.Request, err error, msg string, args ...any) string {
+	id := requestID(r.Context())
+	log.Printf("[%s] %s: %v", id, fmt.Sprintf(msg, args...), err)
+	return a.T(r, msg, args...) + ". " + a.T(r, "Reference: %s", id)
+}
+
+// errorTitle names the kind of error in words users know
+func (a *App) errorTitle(r *http.Request, status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return a.T(r, "Access denied")
+	case status == http.StatusNotFound:
+		return a.T(r, "Not found")
+	case status == http.StatusRequestEntityTooLarge:
+		return a.T(r, "Document too large")
+	case status == http.StatusTooManyRequests:
+		return a.T(r, "Too many requests")
+	case status == http.StatusServiceUnavailable:
+		return a.T(r, "Temporarily unavailable")
+	case status >= 500:
+		return a.T(r, "Something went wrong")
+	}
+	return a.T(r, "Could not complete your request")
+}
+
+// errorGuidance suggests what the user can do next about an error response
+func (a *App) errorGuidance(r *http.Request, status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return a.T(r, "You do not have access to this. Ask your administrator if you need it.")
+	case status == http.StatusNotFound:
+		return a.T(r, "It may have been deleted, or the link is incomplete. Go back and open it again from the list.")
+	case status == http.StatusRequestEntityTooLarge:
+		return a.T(r, "Send a smaller document, or split it into several faxes.")
+	case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+		return a.T(r, "Wait a few minutes and try again.")
+	case status >= 500:
+		return a.T(r, "Try again. If it keeps happening, give your administrator the reference below.")
+	}
+	return a.T(r, "Go back, correct what is described above and try again.")
+}
+
+// wantsErrorPage reports whether the request is a page the browser navigated to, which gets a rendered
+// error page, rather than a script or API client, which gets the message as plain text
+func wantsErrorPage(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "" && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// renderErrorPage writes the error page for a failed request: what went wrong, what to do next and the
+// request ID to quote. It is written once the failed handler is done, so it does not use render.
+func (a *App) renderErrorPage(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := a.tmpl(r).ExecuteTemplate(w, "error.html", map[string]any{
+		"Status":    status,
+		"Title":     a.errorTitle(r, status),
+		"Message":   a.errorMessage(r, status, detail),
+		"Guidance":  a.errorGuidance(r, status),
+		"Reference": requestID(r.Context()),
+	})
+	if err != nil {
+		log.Printf("[%s] Warning: could not render the error page: %v", requestID(r.Context()), err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFailureMessage(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	r := httptest.NewRequest(http.MethodPost, "/contacts", nil)
+	r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, "req-123"))
+	err := errors.New(`pq: duplicate key value violates unique constraint "contacts_pkey"`)
+
+	msg := app.failureMessage(r, err, "Failed to save contact")
+	if msg != "Failed to save contact. Reference: req-123" {
+		t.Fatalf("message %q", msg)
+	}
+
+	r.Header.Set("Accept-Language", "es")
+	msg = app.failureMessage(r, err, "Imported %d numbers, then failed", 3)
+	if msg != "Se importaron 3 números y luego falló. Referencia: req-123" || strings.Contains(msg, "pq:") {
+		t.Fatalf("message %q", msg)
+	}
+}
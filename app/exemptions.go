@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Operators can let some networks use some pages without signing in, e.g. the office LAN reaching the
+// send form while everyone else signs in with SSO. Everything not listed requires a sign-in: a rule needs
+// both networks and a matching path, requests are matched by the address they connect from (not by
+// forwarding headers), and admin pages always require a sign-in.
+
+// AuthExemption lets requests from some networks use some pages without signing in
+type AuthExemption struct {
+	Networks []string `yaml:"networks"` // addresses or CIDRs, e.g. 192.168.1.0/24
+	Paths    []string `yaml:"paths"`    // exact paths, or prefixes ending in /*; empty for every page but admin pages
+	User     string   `yaml:"user"`     // identity the requests act as (default anonymous)
+
+	prefixes []netip.Prefix
+}
+
+// exemptUserKey carries the identity of a request let in by an exemption in its context
+type exemptUserKey struct{}
+
+// parse checks the exemption and parses its networks
+func (e *AuthExemption) parse() error {
+	if len(e.Networks) == 0 {
+		return fmt.Errorf("no networks")
+	}
+	e.prefixes = nil
+	for _, n := range e.Networks {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(n))
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(strings.TrimSpace(n))
+			if addrErr != nil {
+				return fmt.Errorf("invalid network %q", n)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		e.prefixes = append(e.prefixes, prefix.Masked())
+	}
+	for _, p := range e.Paths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("path %q must start with /", p)
+		}
+	}
+	if e.User == "" {
+		e.User = "anonymous"
+	}
+	return nil
+}
+
+// matches checks whether the exemption covers a request from addr for path
+func (e *AuthExemption) matches(addr netip.Addr, path string) bool {
+	network := false
+	for _, p := range e.prefixes {
+		if p.Contains(addr) {
+			network = true
+			break
+		}
+	}
+	if !network {
+		return false
+	}
+	if len(e.Paths) == 0 {
+		return true
+	}
+	for _, p := range e.Paths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// exempt lets a request that is not signed in through when an exemption covers it, acting as the
+// exemption's user
+func (a *App) exempt(r *http.Request) (*http.Request, bool) {
+	exemptions := a.runtime().Exemptions
+	if len(exemptions) == 0 {
+		return r, false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r, false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return r, false
+	}
+	for _, e := range exemptions {
+		if e.matches(addr.Unmap(), r.URL.Path) {
+			return r.WithContext(context.WithValue(r.Context(), exemptUserKey{}, e.User)), true
+		}
+	}
+	return r, false
+}
+
+// exemptUser returns the identity of a request let in by an exemption
+func exemptUser(r *http.Request) (string, bool) {
+	user, ok := r.Context().Value(exemptUserKey{}).(string)
+	return user, ok
+}
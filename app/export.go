@@ -0,0 +1,385 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxExportFaxes caps how many faxes one archive holds
+const maxExportFaxes = 1000
+
+// exportPageSize is the provider page size used to walk a date range
+const exportPageSize = 100
+
+// errExportTooLarge is returned when a date range holds more than maxExportFaxes faxes
+var errExportTooLarge = fmt.Errorf("exports are limited to %d faxes", maxExportFaxes)
+
+// errNoMedia is reported in the manifest for faxes without a document to export
+var errNoMedia = errors.New("no stored document")
+
+// handleExport shows the export form (GET) and streams a ZIP archive of faxes (POST)
+func (a *App) handleExport(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.handleExportForm(w, r)
+	case http.MethodPost:
+		a.handleDownloadExport(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleExportForm renders the date range export form, defaulting to the previous calendar quarter
+func (a *App) handleExportForm(w http.ResponseWriter, r *http.Request) {
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	tags, err := a.Store.tags(r.Context())
+	if err != nil {
+		log.Printf("Warning: could not load tags: %v", err)
+	}
+	now := time.Now()
+	quarter := time.Date(now.Year(), time.Month((int(now.Month())-1)/3*3+1), 1, 0, 0, 0, 0, time.Local)
+	data := map[string]any{
+		"Profile":  profile.Name,
+		"Profiles": profileNames(a.allowedProfiles(r)),
+		"Tags":     tags,
+		"From":     quarter.AddDate(0, -3, 0).Format("2006-01-02"),
+		"To":       quarter.AddDate(0, 0, -1).Format("2006-01-02"),
+		"Max":      maxExportFaxes,
+		"Error":    r.URL.Query().Get("error"),
+	}
+	a.render(w, r, "export.html", data)
+}
+
+// handleDownloadExport streams a ZIP of fax documents plus a manifest.csv of their metadata.
+// The faxes are the fax_id values posted from the list pages (which also post selected=1), or else those created
+// in the from/to date range.
+func (a *App) handleDownloadExport(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	fail := func(msg string, args ...any) {
+		q := url.Values{"profile": {profile.Name}, "error": {a.T(r, msg, args...)}}
+		http.Redirect(w, r, "/export?"+q.Encode(), http.StatusSeeOther)
+	}
+
+	ids := r.Form["fax_id"]
+	if len(ids) == 0 && r.FormValue("selected") != "" {
+		fail("Select the faxes to export")
+		return
+	}
+	if len(ids) == 0 {
+		from, err := time.ParseInLocation("2006-01-02", r.FormValue("from"), time.Local)
+		if err != nil {
+			fail("Enter a start date")
+			return
+		}
+		to, err := time.ParseInLocation("2006-01-02", r.FormValue("to"), time.Local)
+		if err != nil || to.Before(from) {
+			fail("Enter an end date on or after the start date")
+			return
+		}
+		ids, err = a.exportRange(r.Context(), profile, r.FormValue("direction"), r.FormValue("tag"), from, to.AddDate(0, 0, 1))
+		if errors.Is(err, errExportTooLarge) {
+			fail("More than %d faxes were created in that range; narrow it", maxExportFaxes)
+			return
+		}
+		if err != nil {
+			fail("Failed to list faxes: " + err.Error())
+			return
+		}
+		if len(ids) == 0 {
+			fail("No faxes were created in that range")
+			return
+		}
+	}
+	if len(ids) > maxExportFaxes {
+		fail("Exports are limited to %d faxes; narrow the selection", maxExportFaxes)
+		return
+	}
+	if scope := a.faxScope(r); scope != nil {
+		if ids, err = a.Store.visibleFaxes(r.Context(), profile.Name, ids, scope); err != nil {
+			fail("Failed to list faxes: " + err.Error())
+			return
+		}
+		if len(ids) == 0 {
+			fail("None of these faxes are yours to export")
+			return
+		}
+	}
+	faxTags, err := a.Store.faxTags(r.Context(), profile.Name, ids)
+	if err != nil {
+		log.Printf("Warning: could not load fax tags: %v", err)
+	}
+
+	name := fmt.Sprintf("faxes-%s-%s.zip", sanitizeFilename(profile.Name), time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	zw := zip.NewWriter(w)
+	var manifest bytes.Buffer
+	cw := csv.NewWriter(&manifest)
+	cw.Write([]string{"id", "direction", "status", "from", "to", "created_at", "updated_at", "tags", "file", "note"})
+	documents := 0
+	for _, id := range ids {
+		row, data, created := a.exportFax(r.Context(), profile, id, faxTags[id])
+		if data != nil {
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: row[8], Method: zip.Deflate, Modified: created})
+			if err == nil {
+				_, err = f.Write(data)
+			}
+			if err != nil {
+				// The client went away; the archive cannot be finished
+				log.Printf("Warning: export of %d faxes aborted: %v", len(ids), err)
+				return
+			}
+			a.recordAccess(r, accessExported, profile.Name, id)
+			documents++
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	if f, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.csv", Method: zip.Deflate, Modified: time.Now()}); err == nil {
+		f.Write(manifest.Bytes())
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Warning: export of %d faxes aborted: %v", len(ids), err)
+		return
+	}
+
+	user, _ := a.sessionUser(r)
+	a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "fax.exported", Profile: profile.Name,
+		Detail: fmt.Sprintf("%d faxes, %d documents", len(ids), documents)})
+}
+
+// exportRange returns the IDs of the faxes created in [from, to), walking the provider's newest-first list
+func (a *App) exportRange(ctx context.Context, profile *Profile, direction, tag string, from, to time.Time) ([]string, error) {
+	var ids []string
+	for page := int64(1); ; page++ {
+		listCtx, cancel := context.WithTimeout(ctx, a.Resilience.ActionTimeout)
+		faxes, err := profile.provider.List(listCtx, direction, page, exportPageSize)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		done := len(faxes) < exportPageSize
+		for _, f := range faxes {
+			if f.CreatedAt.Before(from) {
+				done = true
+				continue
+			}
+			if f.CreatedAt.Before(to) {
+				ids = append(ids, f.ID)
+			}
+		}
+		if len(ids) > maxExportFaxes {
+			return nil, errExportTooLarge
+		}
+		if done {
+			break
+		}
+	}
+	if tag == "" {
+		return ids, nil
+	}
+	faxTags, err := a.Store.faxTags(ctx, profile.Name, ids)
+	if err != nil {
+		return nil, err
+	}
+	var tagged []string
+	for _, id := range ids {
+		if containsFold(faxTags[id], tag) {
+			tagged = append(tagged, id)
+		}
+	}
+	return tagged, nil
+}
+
+// exportFax looks up a fax and its document, returning its manifest row, the document (nil if there is none)
+// and when the fax was created.
+// Lookup failures are reported in the row's note column so one missing fax does not spoil the archive.
+func (a *App) exportFax(ctx context.Context, profile *Profile, id string, tags []string) ([]string, []byte, time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	row := []string{id, "", "", "", "", "", "", strings.Join(tags, "; "), "", ""}
+	fax, err := profile.provider.Get(ctx, id)
+	if err != nil {
+		row[9] = "lookup failed: " + err.Error()
+		return row, nil, time.Time{}
+	}
+	row[1], row[2], row[3], row[4] = fax.Direction, fax.Status, fax.From, fax.To
+	if !fax.CreatedAt.IsZero() {
+		row[5] = fax.CreatedAt.Format(time.RFC3339)
+	}
+	if !fax.UpdatedAt.IsZero() {
+		row[6] = fax.UpdatedAt.Format(time.RFC3339)
+	}
+	data, ext, err := a.exportMedia(ctx, profile, fax)
+	if err != nil {
+		row[9] = err.Error()
+		return row, nil, fax.CreatedAt
+	}
+	prefix := "undated"
+	if !fax.CreatedAt.IsZero() {
+		prefix = fax.CreatedAt.Local().Format("2006-01-02")
+	}
+	row[8] = sanitizeFilename(fmt.Sprintf("%s_%s_%s%s", prefix, firstNonEmpty(fax.Direction, "fax"), id, ext))
+	return row, data, fax.CreatedAt
+}
+
+// exportMedia returns a fax's document and its file extension: the stored copy of a sent document,
+// or else the media the provider stored
+func (a *App) exportMedia(ctx context.Context, profile *Profile, fax *Fax) ([]byte, string, error) {
+	if sent, err := a.Store.sentFax(ctx, profile.Name, fax.ID); err == nil && sent != nil && sent.MediaFile != "" {
+		if doc, err := a.Media.Get(ctx, sent.MediaFile); err == nil {
+			return doc.Data, filepath.Ext(sent.MediaFile), nil
+		}
+	}
+	if name, _, err := a.Store.receivedMedia(ctx, profile.Name, fax.ID); err == nil && name != "" {
+		if doc, err := a.Media.Get(ctx, name); err == nil {
+			return doc.Data, filepath.Ext(name), nil
+		}
+	}
+	if fax.StoredMediaURL == "" {
+		return nil, "", errNoMedia
+	}
+	data, name, _, err := fetchMedia(ctx, providerHTTPClient, fax.StoredMediaURL)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, path.Ext(name), nil
+}
+
+// csvPageSize is the page size used to walk the fax history for CSV exports
+const csvPageSize = 100
+
+// handleFaxesCSV streams every fax matching the list filters as CSV, fetching a page at a time so
+// long histories are never held in memory
+func (a *App) handleFaxesCSV(w http.ResponseWriter, r *http.Request, q faxQuery) {
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	q.PageSize = csvPageSize
+	q.Scope = a.faxScope(r)
+	q, _ = requestFilters(r, q)
+	fetch := func(page int64) ([]Fax, error) {
+		ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.ActionTimeout)
+		defer cancel()
+		q.PageNumber = page
+		return a.listFaxes(ctx, profile, q)
+	}
+	faxes, err := fetch(1)
+	if err != nil {
+		writeListError(w, q, err)
+		return
+	}
+
+	name := fmt.Sprintf("faxes-%s-%s.csv", sanitizeFilename(profile.Name), time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	// A byte order mark makes Excel read the file as UTF-8
+	io.WriteString(w, "\ufeff")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "direction", "status", "from", "to", "created_at", "updated_at", "pages", "duration_secs",
+		"cost", "currency", "sent_by", "failure_reason", "tags"})
+	rows := 0
+	for page := int64(1); ; page++ {
+		if page > 1 {
+			if faxes, err = fetch(page); err != nil {
+				// Headers are gone; abort the response so the download fails rather than looking complete
+				log.Printf("Warning: CSV export stopped after %d faxes: %v", rows, err)
+				panic(http.ErrAbortHandler)
+			}
+		}
+		ids := make([]string, len(faxes))
+		for i, f := range faxes {
+			ids[i] = f.ID
+		}
+		faxTags, err := a.Store.faxTags(r.Context(), profile.Name, ids)
+		if err != nil {
+			log.Printf("Warning: could not load fax tags: %v", err)
+		}
+		senders, err := a.Store.senders(r.Context(), profile.Name, ids)
+		if err != nil {
+			log.Printf("Warning: could not load fax senders: %v", err)
+		}
+		costs, err := a.Store.faxCosts(r.Context(), profile.Name, ids)
+		if err != nil {
+			log.Printf("Warning: could not load fax costs: %v", err)
+		}
+		for _, f := range faxes {
+			if c, ok := costs[f.ID]; ok && f.Currency == "" {
+				f.Cost, f.Currency = c.Cost, c.Currency
+			}
+			cw.Write(faxCSVRow(f, senders[f.ID], faxTags[f.ID]))
+		}
+		rows += len(faxes)
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Printf("Warning: CSV export stopped after %d faxes: %v", rows, err)
+			return
+		}
+		if len(faxes) < csvPageSize {
+			break
+		}
+	}
+
+	user, _ := a.sessionUser(r)
+	a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "fax.csv_exported", Profile: profile.Name,
+		Detail: fmt.Sprintf("%d faxes", rows)})
+}
+
+// faxCSVRow formats a fax for the CSV export; details the provider did not report are left empty
+func faxCSVRow(f Fax, sender string, tags []string) []string {
+	row := []string{csvText(f.ID), csvText(f.Direction), csvText(f.Status), csvText(f.From), csvText(f.To), "", "", "", "", "",
+		csvText(f.Currency), csvText(sender), csvText(f.FailureReason), csvText(strings.Join(tags, "; "))}
+	if !f.CreatedAt.IsZero() {
+		row[5] = f.CreatedAt.Format(time.RFC3339)
+	}
+	if !f.UpdatedAt.IsZero() {
+		row[6] = f.UpdatedAt.Format(time.RFC3339)
+	}
+	if f.Pages > 0 {
+		row[7] = strconv.Itoa(f.Pages)
+	}
+	if f.Duration > 0 {
+		row[8] = strconv.FormatFloat(f.Duration.Seconds(), 'f', 0, 64)
+	}
+	if f.Currency != "" {
+		row[9] = strconv.FormatFloat(f.Cost, 'f', 2, 64)
+	}
+	return row
+}
+
+// csvText keeps a text cell from being read as a formula by spreadsheets: one starting with =, +, -, @,
+// a tab or a carriage return gets a leading apostrophe. Failure reasons, tags and the like come from
+// carriers and users.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
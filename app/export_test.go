@@ -0,0 +1,24 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFaxCSVRowEscapesFormulas(t *testing.T) {
+	f := Fax{ID: "f-1", Direction: "outbound", Status: "failed", From: "+13125550100", To: "+13125550199",
+		CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Pages: 2, Cost: -0.5, Currency: "USD",
+		FailureReason: `=HYPERLINK("http://evil.test","Click")`}
+	got := faxCSVRow(f, "@admin", []string{"-urgent", "billing"})
+	want := []string{"f-1", "outbound", "failed", "'+13125550100", "'+13125550199", "2026-01-02T03:04:05Z", "", "2", "",
+		"-0.50", "USD", "'@admin", `'=HYPERLINK("http://evil.test","Click")`, "'-urgent; billing"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("row\n%q\nwant\n%q", got, want)
+	}
+	for _, s := range []string{"", "plain", "a=b", "\tcmd", "\rcmd"} {
+		if got := csvText(s); (got != s) != (s != "" && (s[0] == '\t' || s[0] == '\r')) {
+			t.Errorf("csvText(%q) = %q", s, got)
+		}
+	}
+}
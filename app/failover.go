@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+)
+
+// sendPath is a profile and connection a fax can be sent through
+type sendPath struct {
+	profile      *Profile
+	connectionID string
+}
+
+// deliveryPath describes a send path for display and logs, e.g. "default (telnyx, 1293384261075731499)"
+func deliveryPath(profile, provider, connectionID string) string {
+	if connectionID == "" {
+		return fmt.Sprintf("%s (%s)", profile, provider)
+	}
+	return fmt.Sprintf("%s (%s, %s)", profile, provider, connectionID)
+}
+
+func (s sendPath) String() string {
+	return deliveryPath(s.profile.Name, s.profile.provider.Name(), s.connectionID)
+}
+
+// sendPaths returns the primary path followed by the profile's failover paths:
+// a secondary Telnyx connection on the same account, then another profile.
+func (a *App) sendPaths(p *Profile, connectionID string) []sendPath {
+	paths := []sendPath{{profile: p, connectionID: connectionID}}
+	if p.FailoverConnectionID != "" && p.client != nil && p.FailoverConnectionID != connectionID {
+		paths = append(paths, sendPath{profile: p, connectionID: p.FailoverConnectionID})
+	}
+	if target := a.profileByName(p.Failover); target != nil && target != p {
+		conn := target.ConnectionID
+		if target == a.defaultProfile() {
+			conn = a.runtime().DefaultConnectionID
+		}
+		paths = append(paths, sendPath{profile: target, connectionID: conn})
+	}
+	return paths
+}
+
+// sendFax sends through the profile, failing over to the configured secondary paths when the
+// primary failed before it could have created a fax (rate limits, the open breaker, connections
+// that never opened). Returns the fax and a record of the path that accepted it.
+func (a *App) sendFax(ctx context.Context, p *Profile, req SendRequest) (*Fax, *sentFax, error) {
+	// A tunnel that restarted since the document was uploaded has a new URL
+	a.checkTunnel()
+	paths := a.sendPaths(p, req.ConnectionID)
+	var primaryErr, lastErr error
+	for i, path := range paths {
+		attempt := req
+		attempt.ConnectionID = path.connectionID
+		attempt.MediaURL = a.currentMediaURL(req.MediaURL)
+		// Each attempt is bounded so a hanging primary leaves time for the failover path
+		attemptCtx, cancel := context.WithTimeout(ctx, a.Resilience.ActionTimeout)
+		fax, err := path.profile.provider.Send(attemptCtx, attempt)
+		cancel()
+		if err == nil {
+			rec := &sentFax{
+				FaxID:        fax.ID,
+				Profile:      path.profile.Name,
+				Provider:     path.profile.provider.Name(),
+				ConnectionID: path.connectionID,
+				From:         req.From,
+				To:           req.To,
+				PrimaryPath:  paths[0].String(),
+				Failover:     i > 0,
+				CreatedAt:    time.Now().UTC(),
+			}
+			if primaryErr != nil {
+				rec.Failure = primaryErr.Error()
+				log.Printf("Fax %s sent via failover path %s after: %v", fax.ID, path, primaryErr)
+			}
+			return fax, rec, nil
+		}
+
+		if i == 0 {
+			primaryErr = err
+		}
+		lastErr = err
+		if !failoverSafe(err) {
+			break
+		}
+		if i+1 < len(paths) {
+			log.Printf("Warning: sending via %s failed, failing over to %s: %v", path, paths[i+1], err)
+		}
+	}
+	err := primaryErr
+	if lastErr != primaryErr {
+		err = fmt.Errorf("%w (failover also failed: %v)", primaryErr, lastErr)
+	}
+	// Every path before the last failed safely, so the last decides whether a fax may exist
+	if !sendRejected(lastErr) {
+		err = uncertainSendError{err}
+	}
+	return nil, nil, err
+}
+
+// uncertainSendError is a failed send that may still have created a fax: it timed out, Telnyx answered
+// with a server error or the connection was lost mid-request
+type uncertainSendError struct{ error }
+
+func (e uncertainSendError) Unwrap() error { return e.error }
+
+// sendRejected reports whether a failed send certainly created no fax: it failed before reaching the
+// carrier, or Telnyx refused it
+func sendRejected(err error) bool {
+	var apiErr *telnyx.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 && apiErr.StatusCode != http.StatusRequestTimeout
+	}
+	return failoverSafe(err)
+}
+
+// failoverSafe reports whether a send failed before any fax could have been created, so another path
+// sends it once: Telnyx rate-limited it, the open breaker kept it from Telnyx, or the connection was
+// never opened. After a timeout, a server error or a connection lost mid-request the carrier may have
+// queued the fax, and sending it elsewhere too would send it twice; the user decides instead.
+// Rejected requests (bad numbers, invalid media) would fail the same way elsewhere.
+func failoverSafe(err error) bool {
+	var apiErr *telnyx.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return errors.Is(err, errTelnyxUnavailable) || requestNotSent(err)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+)
+
+func TestFailoverSafe(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", &telnyx.Error{StatusCode: 429}, true},
+		{"breaker open", fmt.Errorf("send: %w", errTelnyxUnavailable), true},
+		{"connection refused", fmt.Errorf("post: %w", dialErr), true},
+		{"server error", &telnyx.Error{StatusCode: 500}, false},
+		{"gateway timeout", &telnyx.Error{StatusCode: 504}, false},
+		{"request timeout", &telnyx.Error{StatusCode: 408}, false},
+		{"rejected", &telnyx.Error{StatusCode: 422}, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"cancelled", context.Canceled, false},
+		{"connection lost after writing", fmt.Errorf("post: %w", readErr), false},
+		{"other provider", errors.New("phaxio: internal error"), false},
+	}
+	for _, tt := range tests {
+		if got := failoverSafe(tt.err); got != tt.want {
+			t.Errorf("%s: failoverSafe = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSendRejected(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rejected", &telnyx.Error{StatusCode: 422}, true},
+		{"rate limited", &telnyx.Error{StatusCode: 429}, true},
+		{"breaker open", fmt.Errorf("send: %w", errTelnyxUnavailable), true},
+		{"connection refused", fmt.Errorf("post: %w", dialErr), true},
+		{"request timeout", &telnyx.Error{StatusCode: 408}, false},
+		{"server error", &telnyx.Error{StatusCode: 500}, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"connection lost after writing", fmt.Errorf("post: %w", readErr), false},
+		{"other provider", errors.New("phaxio: internal error"), false},
+	}
+	for _, tt := range tests {
+		if got := sendRejected(tt.err); got != tt.want {
+			t.Errorf("%s: sendRejected = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import "strings"
+
+// failureExplanation says what a provider's failure reason means and what to do about it
+type failureExplanation struct {
+	Summary string
+	Remedy  string
+}
+
+// failureExplanations maps Telnyx failure reasons to explanations shown on the fax details page and in
+// notifications. The texts go through the message catalogs like any other; reasons not listed here,
+// and the free-text reasons of other providers, are shown as reported.
+var failureExplanations = map[string]failureExplanation{
+	"user_busy": {"The receiving fax machine was busy",
+		"Send the fax again in a few minutes."},
+	"receiver_user_busy": {"The receiving fax machine was busy",
+		"Send the fax again in a few minutes."},
+	"no_answer": {"Nobody answered the call",
+		"Check that the receiving fax machine is switched on and connected, then send the fax again."},
+	"receiver_no_answer": {"Nobody answered the call",
+		"Check that the receiving fax machine is switched on and connected, then send the fax again."},
+	"receiver_no_response": {"The receiving fax machine did not respond",
+		"Check that the receiving fax machine is switched on and has paper, then send the fax again."},
+	"receiver_call_dropped": {"The call was dropped during the transmission",
+		"This is usually a bad line. Send the fax again; if it keeps failing, try Normal quality."},
+	"fax_signaling_error": {"The fax machines could not agree on how to transmit",
+		"Send the fax again with Normal quality, or with compatibility mode if it is available."},
+	"receiver_communication_error": {"The connection to the receiving fax machine broke down",
+		"Send the fax again with Normal quality."},
+	"invalid_ecm_response_from_receiver": {"The receiving fax machine's error correction failed",
+		"Send the fax again with Normal quality, or with compatibility mode if it is available."},
+	"receiver_recovery_on_timer_expire": {"The receiving fax machine stopped responding during the transmission",
+		"Send the fax again; large documents may need to be split."},
+	"receiver_incompatible_destination": {"The number is not a fax line",
+		"Check the number with the recipient; a person or voicemail answered instead of a fax machine."},
+	"receiver_decline": {"The receiving side declined the call",
+		"The recipient may be blocking this number. Contact them to confirm the fax number."},
+	"receiver_unallocated_number": {"The number is not in service",
+		"Check the number with the recipient."},
+	"receiver_invalid_number_format": {"The number is not valid",
+		"Correct the number, including the country code, and send the fax again."},
+	"destination_invalid": {"The number is not valid",
+		"Correct the number, including the country code, and send the fax again."},
+	"destination_unreachable": {"The number could not be reached",
+		"Send the fax again later; if it keeps failing, check the number with the recipient."},
+	"destination_not_in_countries_whitelist": {"Faxes to this country are not allowed",
+		"An admin can allow the country in the outbound voice profile in the Telnyx portal."},
+	"destination_not_in_service_plan": {"Faxes to this destination are not part of the service plan",
+		"An admin can change the outbound voice profile in the Telnyx portal."},
+	"no_outbound_profile": {"The fax application has no outbound voice profile",
+		"An admin must assign an outbound voice profile to the fax application in the Telnyx portal."},
+	"outbound_profile_channel_limit_exceeded": {"Too many faxes are being sent at once",
+		"Send the fax again when the others have finished, or ask an admin to raise the channel limit."},
+	"connection_channel_limit_exceeded": {"Too many faxes are being sent at once",
+		"Send the fax again when the others have finished, or ask an admin to raise the channel limit."},
+	"user_channel_limit_exceeded": {"Too many faxes are being sent at once",
+		"Send the fax again when the others have finished, or ask an admin to raise the channel limit."},
+	"outbound_profile_daily_spend_limit_exceeded": {"The daily spending limit has been reached",
+		"Send the fax again tomorrow, or ask an admin to raise the limit of the outbound voice profile."},
+	"account_disabled": {"The Telnyx account is disabled",
+		"An admin must contact Telnyx; no faxes can be sent until the account is enabled."},
+	"service_unavailable": {"The fax service was temporarily unavailable",
+		"Send the fax again in a few minutes."},
+}
+
+// explainFailure returns the explanation of a failure reason, if there is one
+func explainFailure(reason string) (failureExplanation, bool) {
+	e, ok := failureExplanations[strings.ToLower(strings.TrimSpace(reason))]
+	return e, ok
+}
@@ -0,0 +1,526 @@
+// Package faketelnyx is an in-memory stand-in for the parts of the Telnyx API that fax-ui uses:
+// faxes, fax applications, fax webhooks and media storage. End-to-end tests point the app at it with
+// TELNYX_BASE_URL (or ResilienceConfig.BaseURL) so they run in CI without credentials.
+//
+//	fake := faketelnyx.New()
+//	defer fake.Close()
+//	fake.AddApplication("app-1", "Main", "https://fax-ui.test/webhooks/telnyx")
+//	// ...send a fax through the app, then:
+//	fake.SetStatus(id, "delivered", "")
+package faketelnyx
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fax is a fax resource as the Telnyx API returns it, including the delivery details
+// (page count, call duration, failure reason) that the SDK only exposes as extra fields
+type Fax struct {
+	ID               string    `json:"id"`
+	RecordType       string    `json:"record_type"`
+	ConnectionID     string    `json:"connection_id"`
+	Direction        string    `json:"direction"` // inbound or outbound
+	From             string    `json:"from"`
+	To               string    `json:"to"`
+	MediaURL         string    `json:"media_url,omitempty"`
+	MediaName        string    `json:"media_name,omitempty"`
+	Quality          string    `json:"quality,omitempty"`
+	Status           string    `json:"status"`
+	StoreMedia       bool      `json:"store_media"`
+	StoredMediaURL   string    `json:"stored_media_url,omitempty"`
+	PreviewURL       string    `json:"preview_url,omitempty"`
+	WebhookURL       string    `json:"webhook_url,omitempty"`
+	PageCount        int       `json:"page_count,omitempty"`
+	CallDurationSecs float64   `json:"call_duration_secs,omitempty"`
+	FailureReason    string    `json:"failure_reason,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// eventTypes maps fax statuses to the webhook event Telnyx sends when a fax reaches them
+var eventTypes = map[string]string{
+	"queued":    "fax.queued",
+	"sending":   "fax.sending.started",
+	"delivered": "fax.delivered",
+	"failed":    "fax.failed",
+	"received":  "fax.received",
+}
+
+// Server is a fake Telnyx API served over HTTP on a local port
+type Server struct {
+	*httptest.Server
+	key ed25519.PrivateKey
+
+	mu    sync.Mutex
+	faxes map[string]*Fax
+	apps  map[string]map[string]any // fax applications as raw JSON objects, so updates keep unknown fields
+	media map[string][]byte         // documents uploaded to media storage, by media_name
+}
+
+// New starts a fake Telnyx API. Close it when done.
+func New() *Server {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic("faketelnyx: " + err.Error())
+	}
+	s := &Server{key: key, faxes: make(map[string]*Fax), apps: make(map[string]map[string]any), media: make(map[string][]byte)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/faxes", s.handleFaxes)
+	mux.HandleFunc("/faxes/", s.handleFax)
+	mux.HandleFunc("/fax_applications", s.handleApplications)
+	mux.HandleFunc("/fax_applications/", s.handleApplication)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "10005", "Resource not found", r.URL.Path+" is not served by faketelnyx")
+	})
+	// Previews and stored media are pre-signed links in the real API, so they need no key
+	files := http.NewServeMux()
+	files.HandleFunc("/previews/", s.handleDocument)
+	files.HandleFunc("/media/", s.handleDocument)
+	files.Handle("/media", requireAPIKey(http.HandlerFunc(s.handleMediaUpload)))
+	files.Handle("/", requireAPIKey(mux))
+	s.Server = httptest.NewServer(files)
+	return s
+}
+
+// BaseURL is the API base URL to configure the Telnyx client with
+func (s *Server) BaseURL() string {
+	return s.URL + "/"
+}
+
+// PublicKey is the base64 public key webhooks are signed with, for TELNYX_PUBLIC_KEY
+func (s *Server) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// AddApplication creates a fax application whose events go to webhookURL (may be empty)
+func (s *Server) AddApplication(id, name, webhookURL string) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apps[id] = map[string]any{
+		"id":                id,
+		"record_type":       "fax_application",
+		"application_name":  name,
+		"active":            true,
+		"webhook_event_url": webhookURL,
+		"inbound":           map[string]any{"channel_limit": nil, "sip_subdomain": ""},
+		"outbound":          map[string]any{"channel_limit": nil, "outbound_voice_profile_id": ""},
+		"created_at":        now,
+		"updated_at":        now,
+	}
+}
+
+// Fax returns a copy of the fax with the given ID
+func (s *Server) Fax(id string) (Fax, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.faxes[id]
+	if !ok {
+		return Fax{}, false
+	}
+	return *f, true
+}
+
+// Faxes returns copies of all faxes, newest first
+func (s *Server) Faxes() []Fax {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedFaxes("")
+}
+
+// Media returns a document uploaded to media storage
+func (s *Server) Media(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.media[name]
+	return data, ok
+}
+
+// SetStatus moves a fax to status (queued, sending, delivered or failed) and posts the matching
+// signed webhook event. Delivered faxes get a page count and call duration; failed ones the reason.
+func (s *Server) SetStatus(id, status, failureReason string) error {
+	s.mu.Lock()
+	f, ok := s.faxes[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("faketelnyx: no fax %s", id)
+	}
+	f.Status = status
+	f.FailureReason = failureReason
+	f.UpdatedAt = time.Now().UTC()
+	if status == "delivered" || status == "failed" {
+		f.CallDurationSecs = 30
+	}
+	if status == "delivered" && f.PageCount == 0 {
+		f.PageCount = 1
+	}
+	fax := *f
+	s.mu.Unlock()
+	return s.notify(fax)
+}
+
+// Receive records an inbound fax on a fax application and posts its fax.received event.
+// It returns the new fax's ID.
+func (s *Server) Receive(connectionID, from, to string, pages int) (string, error) {
+	now := time.Now().UTC()
+	fax := &Fax{
+		ID:           newID(),
+		RecordType:   "fax",
+		ConnectionID: connectionID,
+		Direction:    "inbound",
+		From:         from,
+		To:           to,
+		Status:       "received",
+		PageCount:    pages,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	fax.MediaURL = s.URL + "/media/" + fax.ID + ".pdf"
+	fax.StoredMediaURL = fax.MediaURL
+	s.mu.Lock()
+	s.faxes[fax.ID] = fax
+	s.mu.Unlock()
+	return fax.ID, s.notify(*fax)
+}
+
+// notify posts a signed event for the fax's status to its webhook URL, falling back to its fax application's
+func (s *Server) notify(fax Fax) error {
+	target := fax.WebhookURL
+	if target == "" {
+		s.mu.Lock()
+		if app, ok := s.apps[fax.ConnectionID]; ok {
+			target, _ = app["webhook_event_url"].(string)
+		}
+		s.mu.Unlock()
+	}
+	eventType, ok := eventTypes[fax.Status]
+	if target == "" || !ok {
+		return nil
+	}
+	body, err := json.Marshal(map[string]any{
+		"data": map[string]any{
+			"record_type": "event",
+			"id":          newID(),
+			"event_type":  eventType,
+			"occurred_at": fax.UpdatedAt,
+			"payload": map[string]any{
+				"fax_id":             fax.ID,
+				"connection_id":      fax.ConnectionID,
+				"status":             fax.Status,
+				"direction":          fax.Direction,
+				"from":               fax.From,
+				"to":                 fax.To,
+				"page_count":         fax.PageCount,
+				"call_duration_secs": fax.CallDurationSecs,
+				"failure_reason":     fax.FailureReason,
+				"media_url":          fax.MediaURL,
+				"occurred_at":        fax.UpdatedAt,
+			},
+		},
+		"meta": map[string]any{"attempt": 1, "delivered_to": target},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("telnyx-timestamp", timestamp)
+	req.Header.Set("telnyx-signature-ed25519", base64.StdEncoding.EncodeToString(
+		ed25519.Sign(s.key, append([]byte(timestamp+"|"), body...))))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("faketelnyx: post %s to %s: %w", eventType, target, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("faketelnyx: post %s to %s: %s", eventType, target, resp.Status)
+	}
+	return nil
+}
+
+// handleFaxes sends a fax (POST) or lists faxes newest first (GET)
+func (s *Server) handleFaxes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			ConnectionID string `json:"connection_id"`
+			From         string `json:"from"`
+			To           string `json:"to"`
+			MediaURL     string `json:"media_url"`
+			MediaName    string `json:"media_name"`
+			Quality      string `json:"quality"`
+			StoreMedia   bool   `json:"store_media"`
+			StorePreview bool   `json:"store_preview"`
+			WebhookURL   string `json:"webhook_url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "10002", "Invalid request body", err.Error())
+			return
+		}
+		switch {
+		case req.ConnectionID == "":
+			writeError(w, http.StatusUnprocessableEntity, "10032", "Missing required parameter", "connection_id is required")
+			return
+		case req.From == "" || req.To == "":
+			writeError(w, http.StatusUnprocessableEntity, "10032", "Missing required parameter", "from and to are required")
+			return
+		case req.MediaURL == "" && req.MediaName == "":
+			writeError(w, http.StatusUnprocessableEntity, "10032", "Missing required parameter", "media_url or media_name is required")
+			return
+		case req.MediaURL != "" && req.MediaName != "":
+			writeError(w, http.StatusUnprocessableEntity, "10015", "Invalid parameters", "media_url and media_name can't be submitted together")
+			return
+		}
+		if req.MediaName != "" {
+			if _, ok := s.Media(req.MediaName); !ok {
+				writeError(w, http.StatusUnprocessableEntity, "10015", "Invalid parameters", "media_name "+req.MediaName+" not found")
+				return
+			}
+		}
+		now := time.Now().UTC()
+		fax := &Fax{
+			ID:           newID(),
+			RecordType:   "fax",
+			ConnectionID: req.ConnectionID,
+			Direction:    "outbound",
+			From:         req.From,
+			To:           req.To,
+			MediaURL:     req.MediaURL,
+			MediaName:    req.MediaName,
+			Quality:      req.Quality,
+			Status:       "queued",
+			StoreMedia:   req.StoreMedia,
+			WebhookURL:   req.WebhookURL,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		if req.StoreMedia {
+			fax.StoredMediaURL = req.MediaURL
+		}
+		if req.StorePreview {
+			fax.PreviewURL = s.URL + "/previews/" + fax.ID + ".pdf"
+		}
+		s.mu.Lock()
+		s.faxes[fax.ID] = fax
+		s.mu.Unlock()
+		writeJSON(w, http.StatusAccepted, map[string]any{"data": fax})
+	case http.MethodGet:
+		q := r.URL.Query()
+		s.mu.Lock()
+		faxes := s.sortedFaxes(q.Get("filter[direction][eq]"))
+		s.mu.Unlock()
+		writePage(w, q, faxes)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "10015", "Method not allowed", r.Method)
+	}
+}
+
+// handleFax gets (GET) or deletes (DELETE) a fax
+func (s *Server) handleFax(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/faxes/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.faxes[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "10005", "Resource not found", "fax "+id+" not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"data": f})
+	case http.MethodDelete:
+		delete(s.faxes, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "10015", "Method not allowed", r.Method)
+	}
+}
+
+// handleApplications lists fax applications by name
+func (s *Server) handleApplications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "10015", "Method not allowed", r.Method)
+		return
+	}
+	s.mu.Lock()
+	apps := make([]map[string]any, 0, len(s.apps))
+	for _, app := range s.apps {
+		apps = append(apps, app)
+	}
+	s.mu.Unlock()
+	sort.Slice(apps, func(i, j int) bool {
+		return fmt.Sprint(apps[i]["application_name"]) < fmt.Sprint(apps[j]["application_name"])
+	})
+	writePage(w, r.URL.Query(), apps)
+}
+
+// handleApplication gets (GET) or updates (PATCH) a fax application. Updates replace the top-level fields given.
+func (s *Server) handleApplication(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/fax_applications/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app, ok := s.apps[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "10005", "Resource not found", "fax application "+id+" not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var update map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeError(w, http.StatusBadRequest, "10002", "Invalid request body", err.Error())
+			return
+		}
+		for k, v := range update {
+			if k != "id" && k != "record_type" {
+				app[k] = v
+			}
+		}
+		app["updated_at"] = time.Now().UTC().Format(time.RFC3339)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "10015", "Method not allowed", r.Method)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": app})
+}
+
+// handleMediaUpload stores a document uploaded as multipart/form-data in the media field
+func (s *Server) handleMediaUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "10015", "Method not allowed", r.Method)
+		return
+	}
+	file, header, err := r.FormFile("media")
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "10032", "Missing required parameter", "media is required")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "10002", "Invalid request body", err.Error())
+		return
+	}
+	ttl, err := strconv.Atoi(r.FormValue("ttl_secs"))
+	if err != nil || ttl <= 0 {
+		ttl = 2 * 24 * 60 * 60
+	}
+	name := r.FormValue("media_name")
+	if name == "" {
+		name = newID()
+	}
+	s.mu.Lock()
+	s.media[name] = data
+	s.mu.Unlock()
+	now := time.Now().UTC()
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{
+		"media_name":   name,
+		"content_type": header.Header.Get("Content-Type"),
+		"created_at":   now.Format(time.RFC3339),
+		"updated_at":   now.Format(time.RFC3339),
+		"expires_at":   now.Add(time.Duration(ttl) * time.Second).Format(time.RFC3339),
+	}})
+}
+
+// handleDocument serves a placeholder PDF as the preview or stored media of a known fax
+func (s *Server) handleDocument(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(path.Base(r.URL.Path), ".pdf")
+	s.mu.Lock()
+	_, ok := s.faxes[id]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	fmt.Fprintf(w, "%%PDF-1.4\n%% faketelnyx document for fax %s\n%%%%EOF\n", id)
+}
+
+// sortedFaxes returns copies of the faxes in direction (all when empty), newest first. s.mu must be held.
+func (s *Server) sortedFaxes(direction string) []Fax {
+	var faxes []Fax
+	for _, f := range s.faxes {
+		if direction == "" || f.Direction == direction {
+			faxes = append(faxes, *f)
+		}
+	}
+	sort.Slice(faxes, func(i, j int) bool { return faxes[i].CreatedAt.After(faxes[j].CreatedAt) })
+	return faxes
+}
+
+// writePage writes the page[number]/page[size] page of items with Telnyx pagination metadata
+func writePage[T any](w http.ResponseWriter, q url.Values, items []T) {
+	number, size := 1, 20
+	if v, err := strconv.Atoi(q.Get("page[number]")); err == nil && v > 0 {
+		number = v
+	}
+	if v, err := strconv.Atoi(q.Get("page[size]")); err == nil && v > 0 {
+		size = v
+	}
+	start := min((number-1)*size, len(items))
+	end := min(start+size, len(items))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"data": append([]T{}, items[start:end]...),
+		"meta": map[string]any{
+			"page_number":   number,
+			"page_size":     size,
+			"total_pages":   (len(items) + size - 1) / size,
+			"total_results": len(items),
+		},
+	})
+}
+
+// requireAPIKey rejects requests without a bearer token, like the real API
+func requireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) == "" {
+			writeError(w, http.StatusUnauthorized, "10009", "Authentication failed", "missing API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeError writes an error in the Telnyx API error format
+func writeError(w http.ResponseWriter, status int, code, title, detail string) {
+	writeJSON(w, status, map[string]any{
+		"errors": []map[string]string{{"code": code, "title": title, "detail": detail}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newID returns a random UUID-formatted ID
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
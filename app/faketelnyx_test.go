@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"fax-ui/app/faketelnyx"
+)
+
+// newFakeTelnyxApp starts an app sending through a fake Telnyx API, whose fax application posts its
+// events to the app's webhook handler
+func newFakeTelnyxApp(t *testing.T) (*App, *faketelnyx.Server) {
+	t.Helper()
+	fake := faketelnyx.New()
+	t.Cleanup(fake.Close)
+	key, err := parseWebhookPublicKey(fake.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewApp(&Config{
+		APIKey:         "KEY_TEST",
+		DefaultFrom:    "+13125550100",
+		DefaultConn:    "app-1",
+		DefaultCountry: "US",
+		Language:       "en",
+		SendProfile:    defaultProfileName,
+		DatabasePath:   filepath.Join(t.TempDir(), "fax-ui.db"),
+		Resilience: ResilienceConfig{
+			BaseURL:       fake.BaseURL(),
+			Timeout:       5 * time.Second,
+			PageTimeout:   5 * time.Second,
+			ActionTimeout: 5 * time.Second,
+		},
+		WebhookKey:    key,
+		PublicBaseURL: "http://fax-ui.test",
+		Port:          "8080",
+		Ghostscript:   "gs-not-installed",
+		Tesseract:     "tesseract-not-installed",
+		NoBackground:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	t.Cleanup(func() { app.Store.Close() })
+	hooks := httptest.NewServer(http.HandlerFunc(app.handleTelnyxWebhook))
+	t.Cleanup(hooks.Close)
+	fake.AddApplication("app-1", "Main", hooks.URL)
+	return app, fake
+}
+
+func TestSendFaxThroughFakeTelnyx(t *testing.T) {
+	app, fake := newFakeTelnyxApp(t)
+
+	form := url.Values{"to": {"+13125550199"}, "cover_text": {"Hello from the test"}}
+	req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	app.handleSendFax(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("send: status %d, body %s", rec.Code, rec.Body)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := location.Query().Get("id")
+	if location.Query().Get("success") != "sent" {
+		t.Fatalf("redirected to %s, want the sent flag", location)
+	}
+	sent, ok := fake.Fax(id)
+	if !ok {
+		t.Fatalf("Telnyx has no fax %q (redirected to %s)", id, location)
+	}
+	if sent.From != "+13125550100" || sent.To != "+13125550199" || sent.ConnectionID != "app-1" {
+		t.Fatalf("sent %s → %s through %s", sent.From, sent.To, sent.ConnectionID)
+	}
+
+	// The details page confirms the send in its own words, not the link's
+	rec = httptest.NewRecorder()
+	app.handleShowFax(rec, httptest.NewRequest(http.MethodGet, location.String(), nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "has been queued for sending") {
+		t.Fatalf("fax page: status %d without the confirmation", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	app.handleShowFax(rec, httptest.NewRequest(http.MethodGet, "/fax?id="+id+"&success=Call+555-0100+now", nil))
+	if strings.Contains(rec.Body.String(), `class="success"`) {
+		t.Fatal("fax page confirms the text of the success parameter")
+	}
+
+	// The signed event updates the stored fax
+	if err := fake.SetStatus(id, "delivered", ""); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	ctx := context.Background()
+	fax, _, err := app.Store.cachedFax(ctx, defaultProfileName, id)
+	if err != nil || fax == nil {
+		t.Fatalf("stored fax: %v, %v", fax, err)
+	}
+	if fax.Status != "delivered" || fax.Pages != 1 {
+		t.Fatalf("stored fax is %s with %d pages, want delivered with 1", fax.Status, fax.Pages)
+	}
+	events, err := app.Store.faxEvents(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(events, func(e faxEvent) bool { return e.EventType == "fax.delivered" }) {
+		t.Fatalf("no fax.delivered event recorded: %+v", events)
+	}
+}
+
+func TestFakeTelnyxWebhookNeedsSignature(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	body := `{"data":{"event_type":"fax.delivered","payload":{"fax_id":"f-1","status":"delivered"}}}`
+	req := httptest.NewRequest(http.MethodPost, telnyxWebhookPath, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	app.handleTelnyxWebhook(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unsigned event: status %d, want 401", rec.Code)
+	}
+}
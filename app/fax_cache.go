@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// cachingProvider wraps a provider so repeated list and detail lookups within the TTL are served
+// locally instead of calling the carrier again. Looked-up faxes are kept in the local store, and
+// finished faxes without expiring media links are served from it indefinitely.
+type cachingProvider struct {
+	FaxProvider
+	store   *Store
+	profile string
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	lists map[string]cachedList // "direction/page/size" -> page of faxes
+}
+
+// cachedList is a page of faxes and when it was fetched
+type cachedList struct {
+	faxes     []Fax
+	totals    listTotals
+	fetchedAt time.Time
+}
+
+// newCachingProvider wraps p; a zero TTL disables caching
+func newCachingProvider(p FaxProvider, store *Store, profile string, ttl time.Duration) FaxProvider {
+	if ttl <= 0 {
+		return p
+	}
+	return &cachingProvider{FaxProvider: p, store: store, profile: profile, ttl: ttl, lists: make(map[string]cachedList)}
+}
+
+func (c *cachingProvider) Send(ctx context.Context, req SendRequest) (*Fax, error) {
+	fax, err := c.FaxProvider.Send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	c.remember(ctx, []Fax{*fax})
+	// The new fax belongs at the top of the list
+	c.forgetLists()
+	return fax, nil
+}
+
+// forgetLists drops the cached list pages, e.g. after a fax changed
+func (c *cachingProvider) forgetLists() {
+	c.mu.Lock()
+	clear(c.lists)
+	c.mu.Unlock()
+}
+
+func (c *cachingProvider) Get(ctx context.Context, id string) (*Fax, error) {
+	cached, fetchedAt, err := c.store.cachedFax(ctx, c.profile, id)
+	if err != nil {
+		log.Printf("Warning: could not read cached fax %s: %v", id, err)
+	}
+	if cached != nil && (time.Since(fetchedAt) < c.ttl || isFinalWithoutLinks(cached)) {
+		return cached, nil
+	}
+	fax, err := c.FaxProvider.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.remember(ctx, []Fax{*fax})
+	return fax, nil
+}
+
+func (c *cachingProvider) List(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, error) {
+	faxes, _, err := c.ListWithTotals(ctx, direction, pageNumber, pageSize)
+	return faxes, err
+}
+
+func (c *cachingProvider) ListWithTotals(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, listTotals, error) {
+	key := fmt.Sprintf("%s/%d/%d", direction, pageNumber, pageSize)
+	c.mu.Lock()
+	cached, ok := c.lists[key]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < c.ttl {
+		return cached.faxes, cached.totals, nil
+	}
+
+	faxes, totals, err := listWithTotals(ctx, c.FaxProvider, direction, pageNumber, pageSize)
+	if err != nil {
+		return nil, listTotals{}, err
+	}
+	c.mu.Lock()
+	c.lists[key] = cachedList{faxes: faxes, totals: totals, fetchedAt: time.Now()}
+	c.mu.Unlock()
+	c.remember(ctx, faxes)
+	return faxes, totals, nil
+}
+
+// remember saves fetched faxes to the local store so detail lookups can be served from it
+func (c *cachingProvider) remember(ctx context.Context, faxes []Fax) {
+	if err := c.store.saveFaxes(ctx, c.profile, faxes); err != nil {
+		log.Printf("Warning: could not cache faxes: %v", err)
+	}
+}
+
+// isFinalWithoutLinks reports whether a fax can no longer change: it has finished and carries no
+// preview or media links (Telnyx links expire after a few minutes and must be refetched)
+func isFinalWithoutLinks(f *Fax) bool {
+	return f.Final() && f.PreviewURL == "" && f.StoredMediaURL == ""
+}
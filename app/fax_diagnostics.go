@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Clinic staff open a support ticket for most failed faxes. The troubleshooting page of a failed fax puts
+// what fax-ui knows about it side by side: the failure reason, the events Telnyx posted, what kind of
+// line the number is and how earlier faxes to it went, and the pages of the document, and turns them
+// into the fixes to try.
+
+// faxEvent is a status event Telnyx posted for a fax
+type faxEvent struct {
+	EventType     string
+	Status        string
+	FailureReason string
+	OccurredAt    time.Time
+}
+
+// recordFaxEvent adds a webhook event to the fax's timeline
+func (s *Store) recordFaxEvent(ctx context.Context, eventType string, f Fax) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO fax_events (fax_id, event_type, status, failure_reason, occurred_at)
+		VALUES (?, ?, ?, ?, ?)`, f.ID, eventType, f.Status, f.FailureReason, f.UpdatedAt.UTC())
+	return err
+}
+
+// faxEvents returns the events Telnyx posted for a fax, oldest first
+func (s *Store) faxEvents(ctx context.Context, faxID string) ([]faxEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT event_type, status, failure_reason, occurred_at FROM fax_events
+		WHERE fax_id = ? ORDER BY occurred_at, id`, faxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []faxEvent
+	for rows.Next() {
+		var e faxEvent
+		if err := rows.Scan(&e.EventType, &e.Status, &e.FailureReason, &e.OccurredAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Failure reasons by what usually fixes them
+var (
+	// The line or the receiving machine garbled the transmission
+	lineFailures = map[string]bool{"receiver_call_dropped": true, "fax_signaling_error": true, "receiver_communication_error": true,
+		"invalid_ecm_response_from_receiver": true, "receiver_recovery_on_timer_expire": true}
+	// The number is wrong or not a fax line
+	numberFailures = map[string]bool{"receiver_incompatible_destination": true, "receiver_unallocated_number": true,
+		"receiver_invalid_number_format": true, "destination_invalid": true, "receiver_decline": true}
+	// Nobody picked up
+	answerFailures = map[string]bool{"user_busy": true, "receiver_user_busy": true, "no_answer": true, "receiver_no_answer": true,
+		"receiver_no_response": true}
+)
+
+// Page sizes fax machines print without scaling, in points
+var faxPageSizes = map[string][2]float64{"Letter": {612, 792}, "A4": {595, 842}}
+
+// longFaxPages is the page count from which a fax on a poor line is better split
+const longFaxPages = 20
+
+// documentPages describes the page sizes of a document, e.g. "Letter" or "Letter, 8.5 × 14 in"; oversized
+// is set when some page is larger than Letter and A4
+func documentPages(facts imageFacts) (sizes string, oversized bool) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, d := range facts.Pages {
+		w, h := math.Min(d.Width, d.Height), math.Max(d.Width, d.Height)
+		name := fmt.Sprintf("%.1f × %.1f in", w/72, h/72)
+		for n, size := range faxPageSizes {
+			if math.Abs(w-size[0]) < 3 && math.Abs(h-size[1]) < 3 {
+				name = n
+			}
+		}
+		if w > 612+3 || h > 842+3 {
+			oversized = true
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", "), oversized
+}
+
+// faxDiagnosis is what the troubleshooting page shows
+type faxDiagnosis struct {
+	Failure     *failureExplanation
+	Events      []faxEvent
+	LineType    string // from the carrier lookup; empty when there is none
+	Destination destinationStats
+	Document    bool // the sent document is still kept and could be read
+	PageCount   int
+	PageSizes   string
+	Oversized   bool
+	Color       bool
+	Shaded      bool
+	DPI         int
+	Fixes       []string // suggestions, translated
+}
+
+// diagnoseFax gathers what is known about a failed fax and suggests fixes
+func (a *App) diagnoseFax(r *http.Request, profile *Profile, fax *Fax, sent *sentFax) faxDiagnosis {
+	ctx := r.Context()
+	var d faxDiagnosis
+	if e, ok := explainFailure(fax.FailureReason); ok {
+		d.Failure = &e
+	}
+	var err error
+	if d.Events, err = a.Store.faxEvents(ctx, fax.ID); err != nil {
+		log.Printf("Warning: could not load events of fax %s: %v", fax.ID, err)
+	}
+	if d.LineType, err = profile.lookupLineType(ctx, fax.To); err != nil {
+		log.Printf("Warning: number lookup for destination failed: %v", err)
+	}
+	if delivered, err := a.Store.destinationOutcomes(ctx, profile.Name, fax.To); err != nil {
+		log.Printf("Warning: could not load the faxes sent to a destination: %v", err)
+	} else {
+		d.Destination = statsFromOutcomes(delivered)
+	}
+	if sent != nil && sent.MediaFile != "" {
+		if doc, err := a.Media.Get(ctx, sent.MediaFile); err == nil {
+			if facts, ok := documentImages(doc); ok {
+				d.Document = true
+				d.PageCount = len(facts.Pages)
+				d.PageSizes, d.Oversized = documentPages(facts)
+				d.Color, d.Shaded, d.DPI = facts.Color, facts.Shaded, facts.DPI
+			}
+		}
+	}
+
+	reason := strings.ToLower(strings.TrimSpace(fax.FailureReason))
+	fix := func(msg string, args ...any) { d.Fixes = append(d.Fixes, a.T(r, msg, args...)) }
+	switch {
+	case d.Failure != nil:
+		fix(d.Failure.Remedy)
+	case reason != "":
+		fix("The carrier reported the failure as shown above; send the fax again, and contact support with the fax ID if it keeps failing.")
+	}
+	switch d.LineType {
+	case "mobile":
+		fix("The number is a mobile line, which usually cannot receive faxes. Ask the recipient for their fax number.")
+	case "voip":
+		if lineFailures[reason] && profile.client != nil {
+			fix("The number is an internet phone line, where T.38 often fails. Send again with Turn off T.38 under Advanced.")
+		}
+	}
+	if lineFailures[reason] {
+		if d.Shaded {
+			fix("The document has photos or shaded images, which take longest to send. Send again with Monochrome under Advanced, or at Normal quality.")
+		}
+		if d.PageCount >= longFaxPages {
+			fix("The document has %d pages. Split it into several faxes, so a dropped call does not lose all of it.", d.PageCount)
+		}
+		if d.DPI > fineScanDPI {
+			fix("The document was scanned at %d dpi. Scanning at 200 to 300 dpi makes it smaller and faster to send.", d.DPI)
+		}
+	}
+	if d.Oversized {
+		fix("Some pages are larger than Letter and A4 and are shrunk to fit. Print or scan them at Letter or A4 size.")
+	}
+	if numberFailures[reason] && d.Destination.Attempts > d.Destination.Failed {
+		fix("Earlier faxes to this number were delivered; the recipient may have changed their fax number.")
+	}
+	if answerFailures[reason] || lineFailures[reason] {
+		switch s := d.Destination; {
+		case s.Retries > 0:
+			fix("Faxes to this number usually go through after %d failed attempts. Send it again.", s.Retries)
+		case s.Failed >= 2 && s.Failed == s.Attempts:
+			fix("The last %d faxes to this number all failed. Check the number with the recipient.", s.Failed)
+		}
+	}
+	if len(d.Events) == 0 && profile.client != nil {
+		fix("No status events were received for this fax. Check that the fax application's webhook points at fax-ui (Settings).")
+	}
+	if sent != nil && sent.Failover {
+		fix("The fax was sent through the failover path because %s failed. Check that path in Settings.", sent.PrimaryPath)
+	}
+	return d
+}
+
+// handleFaxDiagnostics shows the troubleshooting page of a failed fax
+func (a *App) handleFaxDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !a.requireFaxVisible(w, r, profile, id) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.PageTimeout)
+	defer cancel()
+	fax, err := profile.provider.Get(ctx, id)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	sent, err := a.Store.sentFax(ctx, profile.Name, id)
+	if err != nil {
+		log.Printf("Warning: could not load send record for fax %s: %v", id, err)
+	}
+	a.recordAccess(r, accessViewed, profile.Name, id)
+	a.render(w, r, "fax_diagnostics.html", map[string]any{
+		"Fax":       fax,
+		"Profile":   profile.Name,
+		"Sent":      sent,
+		"Diagnosis": a.diagnoseFax(r.WithContext(ctx), profile, fax, sent),
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Telnyx takes a few transmission options beyond quality: monochrome for true black and white, T.38 off
+// for lines where it fails, and a caller ID name. The send form offers them under Advanced, and users
+// can keep what they chose as their defaults. Other providers ignore them.
+
+// faxOptions are the advanced options of a send
+type faxOptions struct {
+	Monochrome      bool   // send true black and white, without grays
+	BlackThreshold  int    // percentage from which a gray turns black with Monochrome; 0 for Telnyx's default
+	DisableT38      bool   // send as audio instead of T.38
+	FromDisplayName string // caller ID name presented to the destination; empty shows the from number
+}
+
+// validate checks the options as Telnyx does
+func (o faxOptions) validate() error {
+	if o.BlackThreshold < 0 || o.BlackThreshold > 100 {
+		return errors.New("the black threshold is a percentage from 1 to 100")
+	}
+	if len(o.FromDisplayName) > 128 {
+		return errors.New("the display name may be at most 128 characters")
+	}
+	for _, c := range o.FromDisplayName {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(" -_~!.+", c)) {
+			return errors.New("the display name may only contain letters, numbers, spaces and - _ ~ ! . +")
+		}
+	}
+	return nil
+}
+
+// formFaxOptions reads the advanced options of the send form
+func formFaxOptions(r *http.Request) (faxOptions, error) {
+	o := faxOptions{
+		Monochrome:      r.FormValue("monochrome") == "on",
+		DisableT38:      r.FormValue("disable_t38") == "on",
+		FromDisplayName: strings.TrimSpace(r.FormValue("from_display_name")),
+	}
+	if s := strings.TrimSpace(r.FormValue("black_threshold")); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return o, errors.New("the black threshold is a percentage from 1 to 100")
+		}
+		o.BlackThreshold = n
+	}
+	return o, o.validate()
+}
+
+// userFaxOptions returns the advanced options the user keeps as defaults
+func (s *Store) userFaxOptions(ctx context.Context, user string) (faxOptions, error) {
+	var o faxOptions
+	err := s.db.QueryRowContext(ctx, `SELECT fax_monochrome, fax_black_threshold, fax_disable_t38, fax_display_name
+		FROM user_preferences WHERE "user" = ?`, user).Scan(&o.Monochrome, &o.BlackThreshold, &o.DisableT38, &o.FromDisplayName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return faxOptions{}, nil
+	}
+	return o, err
+}
+
+// setUserFaxOptions keeps advanced options as the user's defaults
+func (s *Store) setUserFaxOptions(ctx context.Context, user string, o faxOptions) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO user_preferences ("user", fax_monochrome, fax_black_threshold, fax_disable_t38, fax_display_name)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT ("user") DO UPDATE SET fax_monochrome = excluded.fax_monochrome, fax_black_threshold = excluded.fax_black_threshold,
+			fax_disable_t38 = excluded.fax_disable_t38, fax_display_name = excluded.fax_display_name`,
+		user, o.Monochrome, o.BlackThreshold, o.DisableT38, o.FromDisplayName)
+	return err
+}
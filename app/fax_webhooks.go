@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A fax can carry a webhook URL its events are posted to: typed on the send form, or else the default,
+// --webhook_url. URLs are templates: {profile}, {user}, {from} and {to} are filled in as the fax is sent.
+// The fax ID only exists once the carrier has accepted the fax, so a URL with {fax_id} is not given to
+// the carrier; fax-ui forwards the Telnyx events it receives for the fax instead, unchanged and with
+// Telnyx's signature, so the receiver can verify them with the Telnyx public key.
+
+// faxWebhookKeep is how long a sent fax's events are forwarded; its last arrive within hours of sending
+const faxWebhookKeep = 7 * 24 * time.Hour
+
+// webhookForwardClient forwards events to URLs the deployment trusts: the default and allowed hosts
+var webhookForwardClient = &http.Client{Timeout: hookTimeout}
+
+// publicWebhookClient forwards events to other URLs users typed
+var publicWebhookClient = newPublicClient(hookTimeout)
+
+// checkWebhookURL validates a webhook URL a user typed. With an allowlist its host must be on it;
+// without one it may not point at this host or a private network.
+func (a *App) checkWebhookURL(ctx context.Context, raw string) error {
+	u, err := checkUserURL(strings.NewReplacer("{", "", "}", "").Replace(raw), a.WebhookHosts)
+	if err != nil {
+		return err
+	}
+	if len(a.WebhookHosts) > 0 {
+		return nil
+	}
+	return checkPublicHost(ctx, u.Hostname(), false)
+}
+
+// faxWebhook returns where a fax's events go: the URL typed, or else the default, with what is known before
+// sending filled in. A URL waiting for {fax_id} is returned as forward, and carrier is left empty.
+func (a *App) faxWebhook(typed, user, profile, from, to string) (carrier, forward string) {
+	raw := firstNonEmpty(typed, a.runtime().DefaultWebhook)
+	if raw == "" {
+		return "", ""
+	}
+	filled := strings.NewReplacer(
+		"{profile}", url.QueryEscape(profile),
+		"{user}", url.QueryEscape(user),
+		"{from}", url.QueryEscape(from),
+		"{to}", url.QueryEscape(to),
+	).Replace(raw)
+	if strings.Contains(filled, "{fax_id}") {
+		return "", filled
+	}
+	return filled, ""
+}
+
+// recordFaxWebhook keeps the URL a sent fax's events are forwarded to, with its ID filled in
+func (a *App) recordFaxWebhook(ctx context.Context, profile, faxID, forward string) {
+	if forward == "" {
+		return
+	}
+	target := strings.ReplaceAll(forward, "{fax_id}", url.QueryEscape(faxID))
+	if err := a.Store.saveFaxWebhook(ctx, profile, faxID, target); err != nil {
+		log.Printf("Warning: could not record the webhook URL of fax %s: %v", faxID, err)
+	}
+}
+
+// forwardFaxEvent posts a Telnyx event to the webhook URL recorded for its fax, if any, in the background
+func (a *App) forwardFaxEvent(faxID string, h http.Header, body []byte) {
+	target, ok, err := a.Store.faxWebhook(context.Background(), faxID)
+	if err != nil {
+		log.Printf("Warning: could not look up the webhook URL of fax %s: %v", faxID, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		log.Printf("Warning: invalid webhook URL for fax %s: %v", faxID, err)
+		return
+	}
+	client := publicWebhookClient
+	if hostMatches(a.WebhookHosts, u.Hostname()) || a.isDefaultWebhookHost(u.Hostname()) {
+		client = webhookForwardClient
+	}
+	headers := http.Header{}
+	for _, k := range []string{"telnyx-signature-ed25519", "telnyx-timestamp"} {
+		if v := h.Get(k); v != "" {
+			headers.Set(k, v)
+		}
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		var lastErr error
+		for attempt := 0; attempt < hookAttempts; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt*attempt) * 5 * time.Second)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+			if err != nil {
+				lastErr = err
+				break
+			}
+			req.Header = headers.Clone()
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("User-Agent", "fax-ui/"+Version)
+			resp, err := client.Do(req)
+			if err != nil {
+				lastErr = err
+				if errors.Is(err, errHostNotPublic) {
+					break
+				}
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			lastErr = fmt.Errorf("%s", resp.Status)
+			if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+				break
+			}
+		}
+		log.Printf("Warning: could not forward an event of fax %s to its webhook URL: %v", faxID, lastErr)
+	}()
+}
+
+// validateWebhookTemplate checks a default webhook URL template; empty means there is none
+func validateWebhookTemplate(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(strings.NewReplacer("{", "", "}", "").Replace(raw))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid WEBHOOK_URL %q (expected e.g. https://hooks.example.com/fax/{fax_id})", raw)
+	}
+	return nil
+}
+
+// isDefaultWebhookHost reports whether a host is the default webhook URL's, which admins set
+func (a *App) isDefaultWebhookHost(host string) bool {
+	hook := a.runtime().DefaultWebhook
+	if hook == "" {
+		return false
+	}
+	u, err := url.Parse(strings.NewReplacer("{", "", "}", "").Replace(hook))
+	return err == nil && strings.EqualFold(u.Hostname(), host)
+}
+
+// saveFaxWebhook records the URL a sent fax's events are forwarded to, dropping those of faxes long finished
+func (s *Store) saveFaxWebhook(ctx context.Context, profile, faxID, target string) error {
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM fax_webhooks WHERE created_at < ?`, now.Add(-faxWebhookKeep)); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO fax_webhooks (profile, fax_id, url, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (profile, fax_id) DO UPDATE SET url = excluded.url, created_at = excluded.created_at`,
+		profile, faxID, target, now)
+	return err
+}
+
+// faxWebhook returns the URL a fax's events are forwarded to, if it has one
+func (s *Store) faxWebhook(ctx context.Context, faxID string) (string, bool, error) {
+	var target string
+	err := s.db.QueryRowContext(ctx, `SELECT url FROM fax_webhooks WHERE fax_id = ? AND created_at >= ?`,
+		faxID, time.Now().UTC().Add(-faxWebhookKeep)).Scan(&target)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return target, err == nil, err
+}
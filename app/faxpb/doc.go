@@ -0,0 +1,5 @@
+// Package faxpb holds the protobuf definitions of the fax-ui gRPC API and the code generated from them.
+// Regenerate after changing fax.proto (needs protoc, protoc-gen-go and protoc-gen-go-grpc).
+package faxpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fax.proto
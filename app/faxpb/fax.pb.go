@@ -0,0 +1,599 @@
+// The fax-ui gRPC API sends faxes and looks them up for internal services, with the same profiles,
+// failover and records as the web UI. Clients authenticate with a TLS client certificate; the user it
+// names must be allowed to use the profile.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: fax.proto
+
+package faxpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SendFaxRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Profile         string                 `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`                                           // profile to send with; empty for the default profile
+	From            string                 `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`                                                 // number to send from; empty for the profile's default
+	To              string                 `protobuf:"bytes,3,opt,name=to,proto3" json:"to,omitempty"`                                                     // number to send to
+	ConnectionId    string                 `protobuf:"bytes,4,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`             // Telnyx connection; empty for the profile's default
+	Document        []byte                 `protobuf:"bytes,5,opt,name=document,proto3" json:"document,omitempty"`                                         // PDF or TIFF to send
+	DocumentName    string                 `protobuf:"bytes,6,opt,name=document_name,json=documentName,proto3" json:"document_name,omitempty"`             // file name of the document, e.g. referral.pdf
+	MediaUrl        string                 `protobuf:"bytes,7,opt,name=media_url,json=mediaUrl,proto3" json:"media_url,omitempty"`                         // URL of a document Telnyx can fetch, instead of document
+	CoverText       string                 `protobuf:"bytes,8,opt,name=cover_text,json=coverText,proto3" json:"cover_text,omitempty"`                      // note for a cover page in front of the PDF, or sent alone without a document
+	Quality         string                 `protobuf:"bytes,9,opt,name=quality,proto3" json:"quality,omitempty"`                                           // normal, high, very_high, ultra_light or ultra_dark; empty for the default
+	IdempotencyKey  string                 `protobuf:"bytes,10,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`      // retries with the same key return the fax the first request sent
+	Monochrome      bool                   `protobuf:"varint,11,opt,name=monochrome,proto3" json:"monochrome,omitempty"`                                   // true black and white, without grays (Telnyx)
+	BlackThreshold  int32                  `protobuf:"varint,12,opt,name=black_threshold,json=blackThreshold,proto3" json:"black_threshold,omitempty"`     // with monochrome, the percentage from which a gray turns black; 0 for the default
+	DisableT38      bool                   `protobuf:"varint,13,opt,name=disable_t38,json=disableT38,proto3" json:"disable_t38,omitempty"`                 // send as audio instead of T.38, for lines where T.38 fails (Telnyx)
+	FromDisplayName string                 `protobuf:"bytes,14,opt,name=from_display_name,json=fromDisplayName,proto3" json:"from_display_name,omitempty"` // caller ID name presented to the destination (Telnyx)
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *SendFaxRequest) Reset() {
+	*x = SendFaxRequest{}
+	mi := &file_fax_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendFaxRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendFaxRequest) ProtoMessage() {}
+
+func (x *SendFaxRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fax_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendFaxRequest.ProtoReflect.Descriptor instead.
+func (*SendFaxRequest) Descriptor() ([]byte, []int) {
+	return file_fax_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SendFaxRequest) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *SendFaxRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *SendFaxRequest) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *SendFaxRequest) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *SendFaxRequest) GetDocument() []byte {
+	if x != nil {
+		return x.Document
+	}
+	return nil
+}
+
+func (x *SendFaxRequest) GetDocumentName() string {
+	if x != nil {
+		return x.DocumentName
+	}
+	return ""
+}
+
+func (x *SendFaxRequest) GetMediaUrl() string {
+	if x != nil {
+		return x.MediaUrl
+	}
+	return ""
+}
+
+func (x *SendFaxRequest) GetCoverText() string {
+	if x != nil {
+		return x.CoverText
+	}
+	return ""
+}
+
+func (x *SendFaxRequest) GetQuality() string {
+	if x != nil {
+		return x.Quality
+	}
+	return ""
+}
+
+func (x *SendFaxRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+func (x *SendFaxRequest) GetMonochrome() bool {
+	if x != nil {
+		return x.Monochrome
+	}
+	return false
+}
+
+func (x *SendFaxRequest) GetBlackThreshold() int32 {
+	if x != nil {
+		return x.BlackThreshold
+	}
+	return 0
+}
+
+func (x *SendFaxRequest) GetDisableT38() bool {
+	if x != nil {
+		return x.DisableT38
+	}
+	return false
+}
+
+func (x *SendFaxRequest) GetFromDisplayName() string {
+	if x != nil {
+		return x.FromDisplayName
+	}
+	return ""
+}
+
+type ListFaxesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Profile       string                 `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`                    // empty for the default profile
+	Direction     string                 `protobuf:"bytes,2,opt,name=direction,proto3" json:"direction,omitempty"`                // inbound or outbound; empty for both
+	Page          int64                  `protobuf:"varint,3,opt,name=page,proto3" json:"page,omitempty"`                         // starting at 1
+	PageSize      int64                  `protobuf:"varint,4,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"` // default 20
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListFaxesRequest) Reset() {
+	*x = ListFaxesRequest{}
+	mi := &file_fax_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListFaxesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListFaxesRequest) ProtoMessage() {}
+
+func (x *ListFaxesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fax_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListFaxesRequest.ProtoReflect.Descriptor instead.
+func (*ListFaxesRequest) Descriptor() ([]byte, []int) {
+	return file_fax_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListFaxesRequest) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *ListFaxesRequest) GetDirection() string {
+	if x != nil {
+		return x.Direction
+	}
+	return ""
+}
+
+func (x *ListFaxesRequest) GetPage() int64 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *ListFaxesRequest) GetPageSize() int64 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+type ListFaxesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Faxes         []*Fax                 `protobuf:"bytes,1,rep,name=faxes,proto3" json:"faxes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListFaxesResponse) Reset() {
+	*x = ListFaxesResponse{}
+	mi := &file_fax_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListFaxesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListFaxesResponse) ProtoMessage() {}
+
+func (x *ListFaxesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_fax_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListFaxesResponse.ProtoReflect.Descriptor instead.
+func (*ListFaxesResponse) Descriptor() ([]byte, []int) {
+	return file_fax_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListFaxesResponse) GetFaxes() []*Fax {
+	if x != nil {
+		return x.Faxes
+	}
+	return nil
+}
+
+type GetFaxRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Profile       string                 `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"` // empty for the default profile
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetFaxRequest) Reset() {
+	*x = GetFaxRequest{}
+	mi := &file_fax_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetFaxRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetFaxRequest) ProtoMessage() {}
+
+func (x *GetFaxRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fax_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetFaxRequest.ProtoReflect.Descriptor instead.
+func (*GetFaxRequest) Descriptor() ([]byte, []int) {
+	return file_fax_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetFaxRequest) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *GetFaxRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type Fax struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Id                 string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Profile            string                 `protobuf:"bytes,2,opt,name=profile,proto3" json:"profile,omitempty"`
+	Status             string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`       // queued, media.processed, sending, delivered, failed, received, ...
+	Final              bool                   `protobuf:"varint,4,opt,name=final,proto3" json:"final,omitempty"`        // the fax has finished and its status will not change
+	Direction          string                 `protobuf:"bytes,5,opt,name=direction,proto3" json:"direction,omitempty"` // inbound or outbound
+	From               string                 `protobuf:"bytes,6,opt,name=from,proto3" json:"from,omitempty"`
+	To                 string                 `protobuf:"bytes,7,opt,name=to,proto3" json:"to,omitempty"`
+	CreatedAt          *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt          *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	Pages              int32                  `protobuf:"varint,10,opt,name=pages,proto3" json:"pages,omitempty"`
+	DurationSeconds    int32                  `protobuf:"varint,11,opt,name=duration_seconds,json=durationSeconds,proto3" json:"duration_seconds,omitempty"`
+	FailureReason      string                 `protobuf:"bytes,12,opt,name=failure_reason,json=failureReason,proto3" json:"failure_reason,omitempty"`
+	FailureExplanation string                 `protobuf:"bytes,13,opt,name=failure_explanation,json=failureExplanation,proto3" json:"failure_explanation,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *Fax) Reset() {
+	*x = Fax{}
+	mi := &file_fax_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Fax) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Fax) ProtoMessage() {}
+
+func (x *Fax) ProtoReflect() protoreflect.Message {
+	mi := &file_fax_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Fax.ProtoReflect.Descriptor instead.
+func (*Fax) Descriptor() ([]byte, []int) {
+	return file_fax_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Fax) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Fax) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *Fax) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Fax) GetFinal() bool {
+	if x != nil {
+		return x.Final
+	}
+	return false
+}
+
+func (x *Fax) GetDirection() string {
+	if x != nil {
+		return x.Direction
+	}
+	return ""
+}
+
+func (x *Fax) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *Fax) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *Fax) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Fax) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *Fax) GetPages() int32 {
+	if x != nil {
+		return x.Pages
+	}
+	return 0
+}
+
+func (x *Fax) GetDurationSeconds() int32 {
+	if x != nil {
+		return x.DurationSeconds
+	}
+	return 0
+}
+
+func (x *Fax) GetFailureReason() string {
+	if x != nil {
+		return x.FailureReason
+	}
+	return ""
+}
+
+func (x *Fax) GetFailureExplanation() string {
+	if x != nil {
+		return x.FailureExplanation
+	}
+	return ""
+}
+
+var File_fax_proto protoreflect.FileDescriptor
+
+const file_fax_proto_rawDesc = "" +
+	"\n" +
+	"\tfax.proto\x12\bfaxui.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xc9\x03\n" +
+	"\x0eSendFaxRequest\x12\x18\n" +
+	"\aprofile\x18\x01 \x01(\tR\aprofile\x12\x12\n" +
+	"\x04from\x18\x02 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x03 \x01(\tR\x02to\x12#\n" +
+	"\rconnection_id\x18\x04 \x01(\tR\fconnectionId\x12\x1a\n" +
+	"\bdocument\x18\x05 \x01(\fR\bdocument\x12#\n" +
+	"\rdocument_name\x18\x06 \x01(\tR\fdocumentName\x12\x1b\n" +
+	"\tmedia_url\x18\a \x01(\tR\bmediaUrl\x12\x1d\n" +
+	"\n" +
+	"cover_text\x18\b \x01(\tR\tcoverText\x12\x18\n" +
+	"\aquality\x18\t \x01(\tR\aquality\x12'\n" +
+	"\x0fidempotency_key\x18\n" +
+	" \x01(\tR\x0eidempotencyKey\x12\x1e\n" +
+	"\n" +
+	"monochrome\x18\v \x01(\bR\n" +
+	"monochrome\x12'\n" +
+	"\x0fblack_threshold\x18\f \x01(\x05R\x0eblackThreshold\x12\x1f\n" +
+	"\vdisable_t38\x18\r \x01(\bR\n" +
+	"disableT38\x12*\n" +
+	"\x11from_display_name\x18\x0e \x01(\tR\x0ffromDisplayName\"{\n" +
+	"\x10ListFaxesRequest\x12\x18\n" +
+	"\aprofile\x18\x01 \x01(\tR\aprofile\x12\x1c\n" +
+	"\tdirection\x18\x02 \x01(\tR\tdirection\x12\x12\n" +
+	"\x04page\x18\x03 \x01(\x03R\x04page\x12\x1b\n" +
+	"\tpage_size\x18\x04 \x01(\x03R\bpageSize\"8\n" +
+	"\x11ListFaxesResponse\x12#\n" +
+	"\x05faxes\x18\x01 \x03(\v2\r.faxui.v1.FaxR\x05faxes\"9\n" +
+	"\rGetFaxRequest\x12\x18\n" +
+	"\aprofile\x18\x01 \x01(\tR\aprofile\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\"\xae\x03\n" +
+	"\x03Fax\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\aprofile\x18\x02 \x01(\tR\aprofile\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x14\n" +
+	"\x05final\x18\x04 \x01(\bR\x05final\x12\x1c\n" +
+	"\tdirection\x18\x05 \x01(\tR\tdirection\x12\x12\n" +
+	"\x04from\x18\x06 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\a \x01(\tR\x02to\x129\n" +
+	"\n" +
+	"created_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12\x14\n" +
+	"\x05pages\x18\n" +
+	" \x01(\x05R\x05pages\x12)\n" +
+	"\x10duration_seconds\x18\v \x01(\x05R\x0fdurationSeconds\x12%\n" +
+	"\x0efailure_reason\x18\f \x01(\tR\rfailureReason\x12/\n" +
+	"\x13failure_explanation\x18\r \x01(\tR\x12failureExplanation2\xb8\x01\n" +
+	"\n" +
+	"FaxService\x122\n" +
+	"\aSendFax\x12\x18.faxui.v1.SendFaxRequest\x1a\r.faxui.v1.Fax\x12D\n" +
+	"\tListFaxes\x12\x1a.faxui.v1.ListFaxesRequest\x1a\x1b.faxui.v1.ListFaxesResponse\x120\n" +
+	"\x06GetFax\x12\x17.faxui.v1.GetFaxRequest\x1a\r.faxui.v1.FaxB\x12Z\x10fax-ui/app/faxpbb\x06proto3"
+
+var (
+	file_fax_proto_rawDescOnce sync.Once
+	file_fax_proto_rawDescData []byte
+)
+
+func file_fax_proto_rawDescGZIP() []byte {
+	file_fax_proto_rawDescOnce.Do(func() {
+		file_fax_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_fax_proto_rawDesc), len(file_fax_proto_rawDesc)))
+	})
+	return file_fax_proto_rawDescData
+}
+
+var file_fax_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_fax_proto_goTypes = []any{
+	(*SendFaxRequest)(nil),        // 0: faxui.v1.SendFaxRequest
+	(*ListFaxesRequest)(nil),      // 1: faxui.v1.ListFaxesRequest
+	(*ListFaxesResponse)(nil),     // 2: faxui.v1.ListFaxesResponse
+	(*GetFaxRequest)(nil),         // 3: faxui.v1.GetFaxRequest
+	(*Fax)(nil),                   // 4: faxui.v1.Fax
+	(*timestamppb.Timestamp)(nil), // 5: google.protobuf.Timestamp
+}
+var file_fax_proto_depIdxs = []int32{
+	4, // 0: faxui.v1.ListFaxesResponse.faxes:type_name -> faxui.v1.Fax
+	5, // 1: faxui.v1.Fax.created_at:type_name -> google.protobuf.Timestamp
+	5, // 2: faxui.v1.Fax.updated_at:type_name -> google.protobuf.Timestamp
+	0, // 3: faxui.v1.FaxService.SendFax:input_type -> faxui.v1.SendFaxRequest
+	1, // 4: faxui.v1.FaxService.ListFaxes:input_type -> faxui.v1.ListFaxesRequest
+	3, // 5: faxui.v1.FaxService.GetFax:input_type -> faxui.v1.GetFaxRequest
+	4, // 6: faxui.v1.FaxService.SendFax:output_type -> faxui.v1.Fax
+	2, // 7: faxui.v1.FaxService.ListFaxes:output_type -> faxui.v1.ListFaxesResponse
+	4, // 8: faxui.v1.FaxService.GetFax:output_type -> faxui.v1.Fax
+	6, // [6:9] is the sub-list for method output_type
+	3, // [3:6] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_fax_proto_init() }
+func file_fax_proto_init() {
+	if File_fax_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_fax_proto_rawDesc), len(file_fax_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_fax_proto_goTypes,
+		DependencyIndexes: file_fax_proto_depIdxs,
+		MessageInfos:      file_fax_proto_msgTypes,
+	}.Build()
+	File_fax_proto = out.File
+	file_fax_proto_goTypes = nil
+	file_fax_proto_depIdxs = nil
+}
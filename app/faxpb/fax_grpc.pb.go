@@ -0,0 +1,207 @@
+// The fax-ui gRPC API sends faxes and looks them up for internal services, with the same profiles,
+// failover and records as the web UI. Clients authenticate with a TLS client certificate; the user it
+// names must be allowed to use the profile.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: fax.proto
+
+package faxpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	FaxService_SendFax_FullMethodName   = "/faxui.v1.FaxService/SendFax"
+	FaxService_ListFaxes_FullMethodName = "/faxui.v1.FaxService/ListFaxes"
+	FaxService_GetFax_FullMethodName    = "/faxui.v1.FaxService/GetFax"
+)
+
+// FaxServiceClient is the client API for FaxService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FaxServiceClient interface {
+	// SendFax sends a document, a cover page or a media URL and returns the queued fax
+	SendFax(ctx context.Context, in *SendFaxRequest, opts ...grpc.CallOption) (*Fax, error)
+	// ListFaxes returns a page of a profile's faxes, newest first
+	ListFaxes(ctx context.Context, in *ListFaxesRequest, opts ...grpc.CallOption) (*ListFaxesResponse, error)
+	// GetFax returns a fax's current status
+	GetFax(ctx context.Context, in *GetFaxRequest, opts ...grpc.CallOption) (*Fax, error)
+}
+
+type faxServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFaxServiceClient(cc grpc.ClientConnInterface) FaxServiceClient {
+	return &faxServiceClient{cc}
+}
+
+func (c *faxServiceClient) SendFax(ctx context.Context, in *SendFaxRequest, opts ...grpc.CallOption) (*Fax, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Fax)
+	err := c.cc.Invoke(ctx, FaxService_SendFax_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *faxServiceClient) ListFaxes(ctx context.Context, in *ListFaxesRequest, opts ...grpc.CallOption) (*ListFaxesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListFaxesResponse)
+	err := c.cc.Invoke(ctx, FaxService_ListFaxes_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *faxServiceClient) GetFax(ctx context.Context, in *GetFaxRequest, opts ...grpc.CallOption) (*Fax, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Fax)
+	err := c.cc.Invoke(ctx, FaxService_GetFax_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FaxServiceServer is the server API for FaxService service.
+// All implementations must embed UnimplementedFaxServiceServer
+// for forward compatibility.
+type FaxServiceServer interface {
+	// SendFax sends a document, a cover page or a media URL and returns the queued fax
+	SendFax(context.Context, *SendFaxRequest) (*Fax, error)
+	// ListFaxes returns a page of a profile's faxes, newest first
+	ListFaxes(context.Context, *ListFaxesRequest) (*ListFaxesResponse, error)
+	// GetFax returns a fax's current status
+	GetFax(context.Context, *GetFaxRequest) (*Fax, error)
+	mustEmbedUnimplementedFaxServiceServer()
+}
+
+// UnimplementedFaxServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFaxServiceServer struct{}
+
+func (UnimplementedFaxServiceServer) SendFax(context.Context, *SendFaxRequest) (*Fax, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendFax not implemented")
+}
+func (UnimplementedFaxServiceServer) ListFaxes(context.Context, *ListFaxesRequest) (*ListFaxesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFaxes not implemented")
+}
+func (UnimplementedFaxServiceServer) GetFax(context.Context, *GetFaxRequest) (*Fax, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFax not implemented")
+}
+func (UnimplementedFaxServiceServer) mustEmbedUnimplementedFaxServiceServer() {}
+func (UnimplementedFaxServiceServer) testEmbeddedByValue()                    {}
+
+// UnsafeFaxServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FaxServiceServer will
+// result in compilation errors.
+type UnsafeFaxServiceServer interface {
+	mustEmbedUnimplementedFaxServiceServer()
+}
+
+func RegisterFaxServiceServer(s grpc.ServiceRegistrar, srv FaxServiceServer) {
+	// If the following call pancis, it indicates UnimplementedFaxServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&FaxService_ServiceDesc, srv)
+}
+
+func _FaxService_SendFax_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendFaxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FaxServiceServer).SendFax(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FaxService_SendFax_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FaxServiceServer).SendFax(ctx, req.(*SendFaxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FaxService_ListFaxes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFaxesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FaxServiceServer).ListFaxes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FaxService_ListFaxes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FaxServiceServer).ListFaxes(ctx, req.(*ListFaxesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FaxService_GetFax_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFaxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FaxServiceServer).GetFax(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FaxService_GetFax_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FaxServiceServer).GetFax(ctx, req.(*GetFaxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// FaxService_ServiceDesc is the grpc.ServiceDesc for FaxService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FaxService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "faxui.v1.FaxService",
+	HandlerType: (*FaxServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SendFax",
+			Handler:    _FaxService_SendFax_Handler,
+		},
+		{
+			MethodName: "ListFaxes",
+			Handler:    _FaxService_ListFaxes_Handler,
+		},
+		{
+			MethodName: "GetFax",
+			Handler:    _FaxService_GetFax_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "fax.proto",
+}
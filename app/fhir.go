@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Healthcare deployments can have every received fax posted to a FHIR server as a DocumentReference with
+// the document attached, so the EHR files it without anyone downloading it. An optional patient-matching
+// hook is asked which patient each fax belongs to; faxes it cannot match are posted without a subject,
+// for the EHR's own unmatched-documents queue. Exports are conditional creates keyed by the fax ID, so a
+// fax is filed once however often its webhook is redelivered.
+
+// FHIRConfig configures the export of received faxes to a FHIR server
+type FHIRConfig struct {
+	URL         string // base URL of the FHIR server, e.g. https://ehr.example.com/fhir; empty disables the export
+	Token       string // bearer token the server is called with; empty sends none
+	PatientHook string // URL asked for the patient of each fax; empty posts every fax without a subject
+}
+
+// fhirIdentifierSystem is the system of the identifier DocumentReferences carry the fax ID in
+const fhirIdentifierSystem = "urn:fax-ui:fax"
+
+// fhirTimeout bounds exporting one fax; fhirRetryAfter is how long a failed fax waits before the list
+// pages try it again
+const (
+	fhirTimeout    = 2 * time.Minute
+	fhirRetryAfter = 10 * time.Minute
+)
+
+// fhirExport posts received faxes to a FHIR server
+type fhirExport struct {
+	cfg     FHIRConfig
+	client  *http.Client
+	busy    sync.Map // "<profile>/<fax ID>" of the faxes being exported
+	retryAt sync.Map // "<profile>/<fax ID>" of failed exports, to the time they may be tried again
+}
+
+// newFHIRExport checks the FHIR configuration
+func newFHIRExport(cfg FHIRConfig) (*fhirExport, error) {
+	for _, u := range []string{cfg.URL, cfg.PatientHook} {
+		if u != "" && !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+			return nil, fmt.Errorf("FHIR export: %q is not an http or https URL", u)
+		}
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	return &fhirExport{cfg: cfg, client: &http.Client{Timeout: time.Minute}}, nil
+}
+
+// patientMatch is what the patient-matching hook is sent about a fax
+type patientMatch struct {
+	FaxID       string    `json:"fax_id"`
+	Profile     string    `json:"profile"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Pages       int       `json:"pages"`
+	ReceivedAt  time.Time `json:"received_at"`
+	ContentType string    `json:"content_type"`
+	Document    []byte    `json:"document"` // base64 in JSON
+}
+
+// exportFHIR posts a received fax to the FHIR server in the background, unless it was posted before
+func (a *App) exportFHIR(p *Profile, fax Fax) {
+	if a.FHIR == nil || fax.Direction != "inbound" || fax.Status != "received" {
+		return
+	}
+	key := p.Name + "/" + fax.ID
+	if at, ok := a.FHIR.retryAt.Load(key); ok && time.Now().Before(at.(time.Time)) {
+		return
+	}
+	if _, busy := a.FHIR.busy.LoadOrStore(key, true); busy {
+		return
+	}
+	go func() {
+		defer a.FHIR.busy.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), fhirTimeout)
+		defer cancel()
+		if err := a.exportFHIRFax(ctx, p, fax); err != nil {
+			a.FHIR.retryAt.Store(key, time.Now().Add(fhirRetryAfter))
+			log.Printf("Warning: could not export received fax %s to FHIR: %v", fax.ID, err)
+			return
+		}
+		a.FHIR.retryAt.Delete(key)
+	}()
+}
+
+// exportFHIRInbox exports the received faxes among faxes that have not been exported yet, catching up on
+// missed webhooks and failed exports
+func (a *App) exportFHIRInbox(ctx context.Context, p *Profile, faxes []Fax) {
+	if a.FHIR == nil {
+		return
+	}
+	for _, f := range faxes {
+		if f.Direction != "inbound" || f.Status != "received" {
+			continue
+		}
+		if exported, err := a.Store.fhirExported(ctx, p.Name, f.ID); err != nil || exported != "" {
+			continue
+		}
+		a.exportFHIR(p, f)
+	}
+}
+
+func (a *App) exportFHIRFax(ctx context.Context, p *Profile, fax Fax) error {
+	if exported, err := a.Store.fhirExported(ctx, p.Name, fax.ID); err != nil || exported != "" {
+		return err
+	}
+	doc, err := a.receivedDocument(ctx, p, fax.ID, fax.MediaURL)
+	if err != nil {
+		return err
+	}
+	received := firstTime(fax.CreatedAt, fax.UpdatedAt, time.Now()).UTC()
+	patient, err := a.FHIR.matchPatient(ctx, patientMatch{
+		FaxID:       fax.ID,
+		Profile:     p.Name,
+		From:        fax.From,
+		To:          fax.To,
+		Pages:       fax.Pages,
+		ReceivedAt:  received,
+		ContentType: doc.Type,
+		Document:    doc.Data,
+	})
+	if err != nil {
+		return fmt.Errorf("patient matching: %w", err)
+	}
+	resource, err := a.FHIR.createDocumentReference(ctx, fax.ID, documentReference(fax, doc, patient, received))
+	if err != nil {
+		return err
+	}
+	return a.Store.saveFHIRExport(ctx, p.Name, fax.ID, resource)
+}
+
+// matchPatient asks the patient-matching hook for the fax's patient, e.g. "Patient/123". The hook answers
+// {"patient": "..."}; an empty patient or 204 No Content means it found no match.
+func (e *fhirExport) matchPatient(ctx context.Context, m patientMatch) (string, error) {
+	if e.cfg.PatientHook == "" {
+		return "", nil
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.PatientHook, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return "", nil
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("hook answered %s", resp.Status)
+	}
+	var match struct {
+		Patient string `json:"patient"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&match); err != nil {
+		return "", fmt.Errorf("invalid hook answer: %w", err)
+	}
+	return strings.TrimSpace(match.Patient), nil
+}
+
+// documentReference builds the DocumentReference filing a received fax
+func documentReference(fax Fax, doc *document, patient string, received time.Time) map[string]any {
+	ref := map[string]any{
+		"resourceType": "DocumentReference",
+		"status":       "current",
+		"docStatus":    "final",
+		"identifier":   []any{map[string]string{"system": fhirIdentifierSystem, "value": fax.ID}},
+		"type":         map[string]string{"text": "Fax"},
+		"date":         received.Format(time.RFC3339),
+		"description":  fmt.Sprintf("Fax from %s to %s", fax.From, fax.To),
+		"content": []any{map[string]any{
+			"attachment": map[string]any{
+				"contentType": doc.Type,
+				"data":        base64.StdEncoding.EncodeToString(doc.Data),
+				"title":       "fax-" + fax.ID + path.Ext(doc.Name),
+				"creation":    received.Format(time.RFC3339),
+			},
+		}},
+	}
+	if patient != "" {
+		ref["subject"] = map[string]string{"reference": patient}
+	}
+	return ref
+}
+
+// createDocumentReference posts the DocumentReference of a fax to the FHIR server, unless one with the
+// fax's ID exists, and returns the resource's location
+func (e *fhirExport) createDocumentReference(ctx context.Context, faxID string, ref map[string]any) (string, error) {
+	body, err := json.Marshal(ref)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.URL+"/DocumentReference", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/fhir+json")
+	req.Header.Set("Accept", "application/fhir+json")
+	req.Header.Set("If-None-Exist", "identifier="+fhirIdentifierSystem+"|"+faxID)
+	if e.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.cfg.Token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("FHIR server answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	// 200 OK answers a conditional create that matched an existing resource
+	location := resp.Header.Get("Location")
+	if location == "" {
+		var created struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&created); err == nil && created.ID != "" {
+			location = "DocumentReference/" + created.ID
+		}
+	}
+	return firstNonEmpty(location, "DocumentReference"), nil
+}
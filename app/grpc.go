@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"fax-ui/app/faxpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Internal services can send faxes and look them up over gRPC (faxpb/fax.proto) instead of scraping the
+// pages. The API is served over TLS with the --tls_cert key pair, and every call needs a client certificate
+// issued by --grpc_client_ca. The certificate's email address, or its common name, is the user: it must be
+// allowed to use the profile, sees the faxes the web UI would show it, and is recorded as the sender.
+
+// GRPCConfig configures the gRPC API
+type GRPCConfig struct {
+	Port     string // empty disables the gRPC API
+	ClientCA string // PEM bundle of the CAs client certificates must be issued by
+}
+
+// grpcMaxMessage bounds requests, which carry the document to send
+const grpcMaxMessage = 32 << 20
+
+// grpcListPageSize is the number of faxes ListFaxes returns when the request names none
+const grpcListPageSize = 20
+
+// grpcService implements faxpb.FaxServiceServer
+type grpcService struct {
+	faxpb.UnimplementedFaxServiceServer
+	app *App
+}
+
+// serveGRPC serves the gRPC API until it fails
+func (a *App) serveGRPC(cfg GRPCConfig, certFile, keyFile string) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		log.Fatalf("gRPC API: %v", err)
+	}
+	data, err := os.ReadFile(cfg.ClientCA)
+	if err != nil {
+		log.Fatalf("gRPC API client CA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		log.Fatalf("gRPC API client CA: no certificates in %s", cfg.ClientCA)
+	}
+	srv := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    roots,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		})),
+		grpc.MaxRecvMsgSize(grpcMaxMessage),
+	)
+	faxpb.RegisterFaxServiceServer(srv, &grpcService{app: a})
+	lis, err := net.Listen("tcp", ":"+cfg.Port)
+	if err != nil {
+		log.Fatalf("gRPC API: %v", err)
+	}
+	log.Printf("gRPC API listening on localhost:%s", cfg.Port)
+	if err := srv.Serve(lis); err != nil {
+		log.Fatalf("gRPC API error: %v", err)
+	}
+}
+
+// caller returns the user named by the client certificate of a call
+func (s *grpcService) caller(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "no client certificate")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return "", status.Error(codes.Unauthenticated, "no client certificate")
+	}
+	user, ok := certUser(info.State.PeerCertificates[0])
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "the client certificate names no user")
+	}
+	return user, nil
+}
+
+// profile returns the named profile, or the send profile, if the user may use it
+func (s *grpcService) profile(user, name string) (*Profile, error) {
+	p := s.app.profileByName(firstNonEmpty(name, s.app.SendProfile))
+	if p == nil || !p.allows(user) {
+		return nil, status.Errorf(codes.NotFound, "unknown profile %q", name)
+	}
+	return p, nil
+}
+
+// visible reports which of the faxes the user sees, by ID, or nil when it sees all of them
+func (s *grpcService) visible(ctx context.Context, user string, profile *Profile, ids []string) (map[string]bool, error) {
+	if s.app.isAdminUser(user) {
+		return nil, nil
+	}
+	scope := s.app.userScope(user)
+	if scope == nil {
+		return nil, nil
+	}
+	ids, err := s.app.Store.visibleFaxes(ctx, profile.Name, ids, scope)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check fax access: %v", err)
+	}
+	visible := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		visible[id] = true
+	}
+	return visible, nil
+}
+
+func (s *grpcService) SendFax(ctx context.Context, req *faxpb.SendFaxRequest) (*faxpb.Fax, error) {
+	user, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.profile(user, req.GetProfile())
+	if err != nil {
+		return nil, err
+	}
+
+	// Deduplicate retried calls by the caller's key, kept apart from the send form's keys
+	key := ""
+	if req.GetIdempotencyKey() != "" {
+		key = "grpc:" + req.GetIdempotencyKey()
+		claimed, err := s.app.Store.claimIdempotencyKey(ctx, user, key)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not claim idempotency key: %v", err)
+		}
+		if !claimed {
+			return s.duplicate(ctx, user, key)
+		}
+	}
+	sent, uncertain := false, false
+	defer func() {
+		switch {
+		case key == "" || sent:
+		case uncertain:
+			// The fax may exist, so a retry must not send it again
+			if err := s.app.Store.keepIdempotencyKeyUnknown(context.Background(), user, key); err != nil {
+				log.Printf("Warning: could not keep idempotency key: %v", err)
+			}
+		default:
+			if err := s.app.Store.releaseIdempotencyKey(context.Background(), user, key); err != nil {
+				log.Printf("Warning: could not release idempotency key: %v", err)
+			}
+		}
+	}()
+
+	var doc *document
+	if len(req.GetDocument()) > 0 {
+		name := filepath.Base(firstNonEmpty(req.GetDocumentName(), "document"))
+		doc = &document{Name: name, Type: mediaType(name, req.GetDocument()), Data: req.GetDocument()}
+	}
+	fax, err := s.app.sendDocument(ctx, profile, headlessSend{
+		From:         req.GetFrom(),
+		To:           req.GetTo(),
+		ConnectionID: req.GetConnectionId(),
+		Document:     doc,
+		MediaURL:     req.GetMediaUrl(),
+		CoverText:    req.GetCoverText(),
+		Quality:      req.GetQuality(),
+		SentBy:       user,
+		Options: faxOptions{
+			Monochrome:      req.GetMonochrome(),
+			BlackThreshold:  int(req.GetBlackThreshold()),
+			DisableT38:      req.GetDisableT38(),
+			FromDisplayName: req.GetFromDisplayName(),
+		},
+	})
+	if err != nil {
+		uncertain = errors.As(err, &uncertainSendError{})
+		return nil, grpcSendError(err)
+	}
+	if key != "" {
+		if err := s.app.Store.completeIdempotencyKey(context.Background(), user, key, profile.Name, fax.ID); err != nil {
+			log.Printf("Warning: could not complete idempotency key: %v", err)
+		}
+		sent = true
+	}
+	return grpcFax(profile, *fax), nil
+}
+
+// duplicate answers a SendFax whose idempotency key was already used with the fax the first call sent,
+// waiting for it if it is still being sent
+func (s *grpcService) duplicate(ctx context.Context, user, key string) (*faxpb.Fax, error) {
+	deadline := time.Now().Add(duplicateSubmitWait)
+	for {
+		send, err := s.app.Store.idempotentFax(ctx, user, key)
+		switch {
+		case err != nil:
+			return nil, status.Errorf(codes.Internal, "could not look up idempotency key: %v", err)
+		case send == nil:
+			return nil, status.Error(codes.Aborted, "the first call with this idempotency key failed; retry with a new key")
+		case send.FaxID != "":
+			return s.GetFax(ctx, &faxpb.GetFaxRequest{Profile: send.Profile, Id: send.FaxID})
+		case send.Unknown:
+			return nil, status.Error(codes.Aborted, "the first call with this idempotency key failed, but the fax may have been sent anyway; check ListFaxes before retrying with a new key")
+		}
+		if time.Now().After(deadline) {
+			return nil, status.Error(codes.Unavailable, "the first call with this idempotency key is still sending")
+		}
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+func (s *grpcService) ListFaxes(ctx context.Context, req *faxpb.ListFaxesRequest) (*faxpb.ListFaxesResponse, error) {
+	user, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.profile(user, req.GetProfile())
+	if err != nil {
+		return nil, err
+	}
+	switch req.GetDirection() {
+	case "", "inbound", "outbound":
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown direction %q", req.GetDirection())
+	}
+	page, pageSize := max(req.GetPage(), 1), req.GetPageSize()
+	if pageSize <= 0 {
+		pageSize = grpcListPageSize
+	}
+	faxes, err := profile.provider.List(ctx, req.GetDirection(), page, pageSize)
+	if err != nil {
+		return nil, grpcSendError(err)
+	}
+	ids := make([]string, len(faxes))
+	for i, f := range faxes {
+		ids[i] = f.ID
+	}
+	visible, err := s.visible(ctx, user, profile, ids)
+	if err != nil {
+		return nil, err
+	}
+	resp := &faxpb.ListFaxesResponse{}
+	for _, f := range faxes {
+		if visible == nil || visible[f.ID] {
+			resp.Faxes = append(resp.Faxes, grpcFax(profile, f))
+		}
+	}
+	return resp, nil
+}
+
+func (s *grpcService) GetFax(ctx context.Context, req *faxpb.GetFaxRequest) (*faxpb.Fax, error) {
+	user, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.profile(user, req.GetProfile())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing id")
+	}
+	// Faxes the user may not see are reported as missing so their IDs reveal nothing
+	visible, err := s.visible(ctx, user, profile, []string{req.GetId()})
+	if err != nil {
+		return nil, err
+	}
+	if visible != nil && !visible[req.GetId()] {
+		return nil, status.Error(codes.NotFound, "fax not found")
+	}
+	fax, err := profile.provider.Get(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcSendError(err)
+	}
+	return grpcFax(profile, *fax), nil
+}
+
+// grpcSendError maps an error of sendDocument or a provider call to a gRPC status
+func grpcSendError(err error) error {
+	var invalid invalidSendError
+	switch {
+	case errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errTelnyxUnavailable):
+		return status.Error(codes.Unavailable, errTelnyxUnavailable.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Unknown, fmt.Sprintf("fax provider: %v", err))
+}
+
+// grpcFax converts a fax to its protobuf message
+func grpcFax(profile *Profile, f Fax) *faxpb.Fax {
+	explanation := ""
+	if e, ok := explainFailure(f.FailureReason); ok {
+		explanation = e.Summary
+	}
+	pb := &faxpb.Fax{
+		Id:                 f.ID,
+		Profile:            profile.Name,
+		Status:             f.Status,
+		Final:              f.Final(),
+		Direction:          f.Direction,
+		From:               f.From,
+		To:                 f.To,
+		Pages:              int32(f.Pages),
+		DurationSeconds:    int32(f.Duration.Seconds()),
+		FailureReason:      f.FailureReason,
+		FailureExplanation: explanation,
+	}
+	if !f.CreatedAt.IsZero() {
+		pb.CreatedAt = timestamppb.New(f.CreatedAt)
+	}
+	if !f.UpdatedAt.IsZero() {
+		pb.UpdatedAt = timestamppb.New(f.UpdatedAt)
+	}
+	return pb
+}
@@ -3,16 +3,18 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
-	"strconv"
+	"path"
+	"slices"
 	"strings"
 	"time"
-
-	"github.com/team-telnyx/telnyx-go/v4"
 )
 
 // handleHome renders the main fax sending form
@@ -21,22 +23,131 @@ func (a *App) handleHome(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	fromQS := r.URL.Query().Get("from")
-	prefillFrom := firstNonEmpty(fromQS, a.DefaultFrom)
-	connQS := r.URL.Query().Get("connection_id")
-	prefillConn := firstNonEmpty(connQS, a.DefaultConnectionID)
+
+	// Resume a saved draft when one is named
+	d := a.requestDraft(r)
+	profileName := r.URL.Query().Get("profile")
+	if d != nil && profileName == "" {
+		profileName = d.Profile
+	}
+	profile, err := a.userProfile(r, profileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if d == nil {
+		data := a.sendFormData(r, profile, r.URL.Query().Get("from"), r.URL.Query().Get("connection_id"))
+		// Send a document from the scan folder, to the number in its name if it has one
+		if name := r.URL.Query().Get("scan"); a.Scans != nil && validScanName(name) && a.kiosk(r) == nil {
+			data["ScanFile"] = name
+			data["PrefillTo"] = scanDestination(name)
+		}
+		a.render(w, r, "index.html", data)
+		return
+	}
+	data := a.sendFormData(r, profile, d.From, d.ConnectionID)
+	data["PrefillTo"] = d.To
+	data["MediaURL"] = d.MediaURL
+	data["CoverText"] = d.CoverText
+	data["DraftID"] = d.ID
+	data["DraftFile"] = d.FileName
+	// Show the fields the draft filled in so they can be reviewed
+	data["HideFrom"] = data["HideFrom"] == true && d.From == ""
+	data["HideConnectionID"] = data["HideConnectionID"] == true && d.ConnectionID == ""
+	a.render(w, r, "index.html", data)
+}
+
+// sendFormData builds the template data for the send form, prefilled from the given values or the profile's defaults
+func (a *App) sendFormData(r *http.Request, profile *Profile, from, connectionID string) map[string]any {
+	defaultFrom, defaultConn := a.profileDefaults(r, profile)
+	prefillFrom := firstNonEmpty(from, defaultFrom)
+	prefillConn := firstNonEmpty(connectionID, defaultConn)
 	data := map[string]any{
-		"HasAPIKey":           os.Getenv("TELNYX_API_KEY") != "",
+		"HasAPIKey":           profile != a.defaultProfile() || a.Sandbox || os.Getenv("TELNYX_API_KEY") != "",
 		"PrefillFrom":         prefillFrom,
 		"PrefillConnectionID": prefillConn,
-		"ShowSettings":        a.FaxApplicationID != "",
 		"Hipaa":               a.Hipaa,
 		"HideFrom":            strings.TrimSpace(prefillFrom) != "",
-		"HideConnectionID":    strings.TrimSpace(prefillConn) != "",
+		"HideConnectionID":    strings.TrimSpace(prefillConn) != "" || profile.client == nil, // only Telnyx uses connections
+		"DefaultCountry":      a.DefaultCountry,
+		"Profile":             profile.Name,
+		"Profiles":            profileNames(a.allowedProfiles(r)),
+		"Connections":         profile.cachedConnections(r.Context()),
+		"FromNumbers":         profile.faxNumbers(r.Context()),
+		"IdempotencyKey":      newIdempotencyKey(),
+		"UploadChunkSize":     uploadChunkSize,
+		"CanConvertTIFF":      a.Ghostscript != "",
+		"CompatMode":          a.Ghostscript != "" && a.CompatMode,
+		"DefaultWebhook":      a.runtime().DefaultWebhook,
+		"ErrorField":          "", // form field a rejected submission is marked at
+	}
+	// Advanced options start from the ones the user keeps as defaults
+	if user, ok := a.sessionUser(r); ok {
+		options, err := a.Store.userFaxOptions(r.Context(), user)
+		if err != nil {
+			log.Printf("Warning: could not load the advanced fax options of %s: %v", user, err)
+		}
+		data["Options"] = options
+		data["CanSaveOptions"] = true
+	}
+	// Kiosks get the fields of a single fax and nothing else; the fixed values are applied again when sending
+	if k := a.kiosk(r); k != nil {
+		data["Kiosk"] = true
+		data["Profiles"] = []string(nil)
+		data["FixedCover"] = k.CoverText != ""
+		if k.From != "" {
+			data["PrefillFrom"] = k.From
+			data["HideFrom"] = true
+		}
+	}
+	return data
+}
+
+// renderSendFormError re-renders the send form with a validation error instead of submitting to Telnyx.
+// The error is shown at the offending field (or above the form when field is empty), and the fields
+// the user filled in stay visible so they can be corrected.
+func (a *App) renderSendFormError(w http.ResponseWriter, r *http.Request, profile *Profile, field string, msg string) {
+	data := a.sendFormData(r, profile, r.FormValue("from"), r.FormValue("connection_id"))
+	data["Error"] = a.T(r, msg)
+	data["ErrorField"] = field
+	if field == "cover_text" && data["FixedCover"] == true {
+		// Kiosks do not show the cover page field
+		data["ErrorField"] = ""
+	}
+	data["PrefillTo"] = r.FormValue("to")
+	data["ConfirmDestination"] = field == "confirm_destination"
+	data["MediaURL"] = r.FormValue("media_url")
+	data["CoverText"] = r.FormValue("cover_text")
+	data["WebhookURL"] = r.FormValue("webhook_url")
+	data["Quality"] = r.FormValue("quality")
+	data["StorePreview"] = r.FormValue("store_preview") == "on"
+	data["StoreMedia"] = r.FormValue("store_media") == "on"
+	data["CompatMode"] = a.compatMode(r)
+	data["Options"], _ = formFaxOptions(r)
+	data["SaveOptions"] = r.FormValue("save_options") == "on"
+	if d := a.requestDraft(r); d != nil {
+		data["DraftID"] = d.ID
+		data["DraftFile"] = d.FileName
+	}
+	if name := r.FormValue("scan"); a.Scans != nil && validScanName(name) {
+		data["ScanFile"] = name
+	}
+	// Keep a finished chunked upload so the document does not have to be uploaded again
+	if id := r.FormValue("upload_id"); id != "" && field != "media_file" {
+		owner, _ := a.sessionUser(r)
+		if name, ok := a.uploads.finished(id, owner); ok {
+			data["UploadID"] = id
+			data["UploadName"] = name
+		}
+	}
+	if field == "from" || r.FormValue("from") != "" {
+		data["HideFrom"] = false
 	}
-	if err := a.Tmpl.ExecuteTemplate(w, "index.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if field == "connection_id" || r.FormValue("connection_id") != "" {
+		data["HideConnectionID"] = false
 	}
+	w.WriteHeader(http.StatusBadRequest)
+	a.render(w, r, "index.html", data)
 }
 
 // handleFax routes POST requests to send a fax and GET requests to show fax details
@@ -53,99 +164,332 @@ func (a *App) handleFax(w http.ResponseWriter, r *http.Request) {
 
 // handleSendFax processes the fax send form and sends a fax via Telnyx API
 func (a *App) handleSendFax(w http.ResponseWriter, r *http.Request) {
+	var formErr error
 	if strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
-		if err := r.ParseMultipartForm(25 << 20); err != nil {
-			http.Error(w, "invalid multipart form", http.StatusBadRequest)
-			return
-		}
+		formErr = r.ParseMultipartForm(25 << 20)
 	} else {
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "invalid form", http.StatusBadRequest)
-			return
-		}
+		formErr = r.ParseForm()
+	}
+
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	}
+	if formErr != nil {
+		// Usually a dropped connection; whatever was parsed is shown again
+		a.renderSendFormError(w, r, profile, "", "The form could not be read. Check the fields, attach the document again and send.")
+		return
+	}
+	defaultFrom, defaultConn := a.profileDefaults(r, profile)
+	kiosk := a.kiosk(r)
 
 	connectionID := r.FormValue("connection_id")
-	if connectionID == "" {
-		connectionID = a.DefaultConnectionID
+	if connectionID == "" || kiosk != nil {
+		connectionID = defaultConn
+	}
+	fromValue := firstNonEmpty(r.FormValue("from"), defaultFrom)
+	if kiosk != nil && kiosk.From != "" {
+		fromValue = kiosk.From
 	}
-	from := normalizePhoneNumber(r.FormValue("from"))
-	if from == "" {
-		from = a.DefaultFrom
+	from, err := normalizePhoneNumber(fromValue, a.DefaultCountry)
+	if err != nil {
+		a.renderSendFormError(w, r, profile, "from", "From number: "+err.Error())
+		return
+	}
+	if err := profile.validateFromNumber(r.Context(), from); err != nil {
+		a.renderSendFormError(w, r, profile, "from", "From number: "+err.Error())
+		return
+	}
+	to, err := normalizePhoneNumber(r.FormValue("to"), a.DefaultCountry)
+	if err != nil {
+		a.renderSendFormError(w, r, profile, "to", "To number: "+err.Error())
+		return
 	}
-	to := normalizePhoneNumber(r.FormValue("to"))
 	mediaURL := r.FormValue("media_url")
 	webhookURL := r.FormValue("webhook_url")
 	storePreview := r.FormValue("store_preview") == "on"
 	storeMedia := r.FormValue("store_media") == "on"
 	quality := r.FormValue("quality")
+	if kiosk != nil {
+		// Kiosks send uploads with the deployment's defaults and keep nothing beyond the fax itself
+		mediaURL, webhookURL, storePreview, storeMedia = "", "", false, false
+	}
+	if mediaURL != "" {
+		if err := a.checkMediaURL(r.Context(), mediaURL); err != nil {
+			a.renderSendFormError(w, r, profile, "media_url", "Media URL: "+err.Error())
+			return
+		}
+	}
+	if webhookURL != "" {
+		if err := a.checkWebhookURL(r.Context(), webhookURL); err != nil {
+			a.renderSendFormError(w, r, profile, "webhook_url", "Webhook URL: "+err.Error())
+			return
+		}
+	}
+	var options faxOptions
+	if kiosk == nil {
+		if options, err = formFaxOptions(r); err != nil {
+			a.renderSendFormError(w, r, profile, "advanced", "Advanced options: "+err.Error())
+			return
+		}
+	}
+
+	switch {
+	case to == "":
+		a.renderSendFormError(w, r, profile, "to", "Enter the number to send the fax to.")
+		return
+	case from == "":
+		a.renderSendFormError(w, r, profile, "from", "Choose the number to send from.")
+		return
+	case connectionID == "" && profile.client != nil:
+		a.renderSendFormError(w, r, profile, "connection_id", "Choose the fax application to send through.")
+		return
+	}
 
-	if connectionID == "" || from == "" || to == "" {
-		http.Error(w, "connection_id, from and to are required", http.StatusBadRequest)
+	if msg := a.checkBlocked(r.Context(), to); msg != "" {
+		a.renderSendFormError(w, r, profile, "to", msg)
 		return
 	}
 
-	// Handle file upload if present
-	uploadedURL, err := a.handleFileUpload(r)
+	// Warn or block when the destination looks unable to receive faxes
+	if msg := a.checkDestination(r.Context(), profile, to); msg != "" {
+		if a.runtime().NumberLookup == lookupBlock {
+			a.renderSendFormError(w, r, profile, "to", msg)
+			return
+		}
+		if r.FormValue("confirm_destination") != "on" {
+			a.renderSendFormError(w, r, profile, "confirm_destination", msg)
+			return
+		}
+	}
+
+	// Deduplicate double-clicked or retried submissions by the key rendered into the form
+	user, _ := a.sessionUser(r)
+	key := r.FormValue("idempotency_key")
+	if key != "" {
+		claimed, err := a.Store.claimIdempotencyKey(r.Context(), user, key)
+		if err != nil {
+			log.Printf("Warning: could not claim idempotency key, sending without deduplication: %v", err)
+			key = ""
+		} else if !claimed {
+			a.handleDuplicateSubmission(w, r, user, key)
+			return
+		}
+	}
+	sent, uncertain := false, false
+	defer func() {
+		switch {
+		case key == "" || sent:
+		case uncertain:
+			// The fax may exist, so a resubmission must not send it again
+			if err := a.Store.keepIdempotencyKeyUnknown(context.Background(), user, key); err != nil {
+				log.Printf("Warning: could not keep idempotency key: %v", err)
+			}
+		default:
+			// Let the form be resubmitted if this attempt certainly did not produce a fax
+			if err := a.Store.releaseIdempotencyKey(context.Background(), user, key); err != nil {
+				log.Printf("Warning: could not release idempotency key: %v", err)
+			}
+		}
+	}()
+
+	// Use the uploaded file, or the one attached to the draft or picked from the scan folder
+	d := a.requestDraft(r)
+	doc, err := a.requestDocument(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		a.renderSendFormError(w, r, profile, "media_file", err.Error())
 		return
 	}
+	if doc == nil && d != nil {
+		doc = d.File
+	}
+	scan := r.FormValue("scan")
+	if doc == nil && scan != "" && a.Scans != nil && kiosk == nil {
+		if doc, err = a.Scans.read(scan); err != nil {
+			a.renderSendFormError(w, r, profile, "media_file", err.Error())
+			return
+		}
+	} else {
+		scan = ""
+	}
+	if doc == nil && mediaURL != "" && a.FetchMedia {
+		if doc, err = downloadMedia(r.Context(), mediaURL); err != nil {
+			a.renderSendFormError(w, r, profile, "media_url", "Media URL: "+err.Error())
+			return
+		}
+		mediaURL = ""
+	}
+	// Clean up scans before the cover page goes in front; a failed clean-up sends the document as it is
+	if doc != nil && doc.isPDF() && a.Preprocess.enabled() && a.Ghostscript != "" {
+		if cleaned, err := preprocessPDF(r.Context(), a.Ghostscript, a.Preprocess, doc); err != nil {
+			log.Printf("Warning: could not preprocess %s, sending it unchanged: %v", doc.Name, err)
+		} else {
+			doc = cleaned
+		}
+	}
+
+	// Put the cover page in front of the document, or send it alone
+	text := strings.TrimSpace(r.FormValue("cover_text"))
+	if kiosk != nil && kiosk.CoverText != "" {
+		text = kiosk.CoverText
+	}
+	if text != "" {
+		cover := coverPagePDF(from, to, text, time.Now().In(a.location(r)))
+		switch {
+		case doc != nil && doc.isPDF():
+			merged, err := prependCoverPage(cover, doc.Data)
+			if err != nil {
+				a.renderSendFormError(w, r, profile, "cover_text", err.Error())
+				return
+			}
+			doc = &document{Name: doc.Name, Type: "application/pdf", Data: merged}
+		case doc != nil || mediaURL != "":
+			a.renderSendFormError(w, r, profile, "cover_text", "A cover page can only be added to an uploaded PDF.")
+			return
+		default:
+			doc = &document{Name: "cover.pdf", Type: "application/pdf", Data: cover}
+		}
+	}
+	if doc != nil && doc.isPDF() && a.Stamp.enabled() {
+		stamped, err := stampPDF(doc.Data, a.Stamp, from, to, time.Now().In(a.location(r)))
+		if err != nil {
+			a.renderSendFormError(w, r, profile, "media_file", err.Error())
+			return
+		}
+		doc = &document{Name: doc.Name, Type: "application/pdf", Data: stamped}
+	}
+	// Look at the document before compatibility mode turns photos into black and white
+	advice, advised := adviseQuality(doc)
+	if doc != nil && doc.isPDF() && a.compatMode(r) {
+		if doc, err = convertToFaxTIFF(r.Context(), a.Ghostscript, doc); err != nil {
+			log.Printf("Warning: compatibility mode conversion failed: %v", err)
+			a.renderSendFormError(w, r, profile, "media_file", "Could not convert the document for compatibility mode. Send it without compatibility mode, or try another PDF.")
+			return
+		}
+	}
+
+	uploadedURL := ""
+	if doc != nil {
+		if uploadedURL, err = a.storeDocument(r.Context(), doc); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	// Build fax parameters
-	params := telnyx.FaxNewParams{
+	// Build fax parameters; HIPAA mode never stores previews or media
+	carrierHook, forwardHook := a.faxWebhook(webhookURL, user, profile.Name, from, to)
+	req := SendRequest{
 		ConnectionID: connectionID,
 		From:         from,
 		To:           to,
-	}
-
-	// Set HIPAA defaults
-	if a.Hipaa {
-		params.StorePreview = telnyx.Bool(false)
-		params.StoreMedia = telnyx.Bool(false)
+		WebhookURL:   carrierHook,
+		StorePreview: storePreview && !a.Hipaa,
+		StoreMedia:   storeMedia && !a.Hipaa,
+		Options:      options,
 	}
 
 	// Set media URL from upload or form field
 	if uploadedURL != "" {
-		params.MediaURL = telnyx.String(uploadedURL)
+		req.MediaURL = uploadedURL
+		req.Document = doc
 	} else if mediaURL != "" {
-		params.MediaURL = telnyx.String(mediaURL)
+		req.MediaURL = mediaURL
 	} else {
-		http.Error(w, "media_url or media_file is required", http.StatusBadRequest)
+		a.renderSendFormError(w, r, profile, "media_file", "Attach a document, enter a media URL or write a cover page.")
 		return
 	}
 
-	// Optional parameters
-	if webhookURL != "" {
-		params.WebhookURL = telnyx.String(webhookURL)
-	}
-	if storePreview && !a.Hipaa {
-		params.StorePreview = telnyx.Bool(true)
-	}
-	if storeMedia && !a.Hipaa {
-		params.StoreMedia = telnyx.Bool(true)
-	}
 	switch quality {
 	case "normal", "high", "very_high", "ultra_light", "ultra_dark":
-		params.Quality = telnyx.FaxNewParamsQuality(quality)
+		req.Quality = quality
+	case "":
+		if advised {
+			req.Quality = advice.Quality
+		}
 	}
 
-	// Send the fax
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-	defer cancel()
-
-	res, err := a.Client.Faxes.New(ctx, params)
+	// Send the fax, failing over to the profile's secondary paths if configured
+	fax, record, err := a.sendFax(r.Context(), profile, req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		uncertain = errors.As(err, &uncertainSendError{})
+		writeUpstreamError(w, err)
+		return
+	}
+	record.SentBy = user
+	a.recordFaxWebhook(r.Context(), record.Profile, fax.ID, forwardHook)
+	if user != "" && r.FormValue("save_options") == "on" {
+		if err := a.Store.setUserFaxOptions(r.Context(), user, options); err != nil {
+			log.Printf("Warning: could not save the advanced fax options of %s: %v", user, err)
+		}
+	}
+	// Remember the stored copy of the document so retention can delete it
+	if uploadedURL != "" {
+		record.MediaFile = path.Base(uploadedURL)
+	}
+	if err := a.Store.recordSentFax(r.Context(), record); err != nil {
+		log.Printf("Warning: could not record sent fax %s: %v", fax.ID, err)
+	}
+	// Lists limited to the faxes a user may see come from the local database, so it needs the fax right away
+	if err := a.Store.saveFaxes(r.Context(), record.Profile, []Fax{*fax}); err != nil {
+		log.Printf("Warning: could not save sent fax %s: %v", fax.ID, err)
+	}
+	if key != "" {
+		if err := a.Store.completeIdempotencyKey(context.Background(), user, key, record.Profile, fax.ID); err != nil {
+			log.Printf("Warning: could not complete idempotency key: %v", err)
+		}
+		sent = true
+	}
+	if id := r.FormValue("upload_id"); id != "" {
+		a.uploads.remove(id)
+	}
+	if d != nil {
+		if err := a.Store.deleteDraft(context.Background(), d.Owner, d.ID); err != nil {
+			log.Printf("Warning: could not delete sent draft: %v", err)
+		}
+	}
+	if scan != "" {
+		if err := a.Scans.done(scan, a.Hipaa); err != nil {
+			log.Printf("Warning: could not move sent scan %s out of the scan folder: %v", scan, err)
+		}
+	}
+	if kiosk != nil {
+		a.kioskSent(w, r, fax)
 		return
 	}
 
-	data := map[string]any{
-		"Fax": res.Data,
+	// Redirect to the details page so a refresh does not resubmit the form and the URL can be shared
+	q := url.Values{"id": {fax.ID}, "profile": {record.Profile}, "success": {"sent"}}
+	if advised && a.qualityNote(r, quality, advice) != "" {
+		adviceQuery(q, quality, advice)
+	}
+	http.Redirect(w, r, "/fax?"+q.Encode(), http.StatusSeeOther)
+}
+
+// faxConfirmations are the messages the fax page confirms an action with, by the success flag of its
+// link. They are built here rather than taken from the link, which anyone can send a user.
+var faxConfirmations = map[string]string{
+	"hold_placed": "Legal hold placed",
+	"hold_lifted": "Legal hold lifted",
+	"tags_saved":  "Tags saved",
+}
+
+// faxConfirmation returns the message the fax page shows for the success flag of its link, if any
+func (a *App) faxConfirmation(r *http.Request, fax *Fax) string {
+	q := r.URL.Query()
+	if q.Get("success") == "sent" {
+		msg := a.T(r, "Your fax to %s has been queued for sending.", fax.To)
+		if chosen, advice, ok := adviceFromQuery(q); ok {
+			if note := a.qualityNote(r, chosen, advice); note != "" {
+				msg += " " + note
+			}
+		}
+		return msg
 	}
-	if err := a.Tmpl.ExecuteTemplate(w, "fax_show.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if msg, ok := faxConfirmations[q.Get("success")]; ok {
+		return a.T(r, msg)
 	}
+	return ""
 }
 
 // handleShowFax retrieves and displays details for a specific fax by ID
@@ -155,19 +499,72 @@ func (a *App) handleShowFax(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing id", http.StatusBadRequest)
 		return
 	}
-	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !a.requireFaxVisible(w, r, profile, id) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.PageTimeout)
 	defer cancel()
-	res, err := a.Client.Faxes.Get(ctx, id)
+	fax, err := profile.provider.Get(ctx, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeUpstreamError(w, err)
 		return
 	}
-	data := map[string]any{
-		"Fax": res.Data,
+	sent, err := a.Store.sentFax(ctx, profile.Name, id)
+	if err != nil {
+		log.Printf("Warning: could not load send record for fax %s: %v", id, err)
 	}
-	if err := a.Tmpl.ExecuteTemplate(w, "fax_show.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	tags, err := a.Store.tags(ctx)
+	if err != nil {
+		log.Printf("Warning: could not load tags: %v", err)
+	}
+	faxTags, err := a.Store.faxTags(ctx, profile.Name, []string{id})
+	if err != nil {
+		log.Printf("Warning: could not load tags for fax %s: %v", id, err)
 	}
+	selected := make(map[string]bool)
+	for _, t := range faxTags[id] {
+		selected[t] = true
+	}
+	comments, err := a.Store.comments(ctx, profile.Name, id)
+	if err != nil {
+		log.Printf("Warning: could not load comments for fax %s: %v", id, err)
+	}
+	if costs, err := a.Store.faxCosts(ctx, profile.Name, []string{id}); err != nil {
+		log.Printf("Warning: could not load cost for fax %s: %v", id, err)
+	} else if c, ok := costs[id]; ok && fax.Currency == "" {
+		fax.Cost, fax.Currency = c.Cost, c.Currency
+	}
+	hold, err := a.Store.faxHold(ctx, profile.Name, id)
+	if err != nil {
+		log.Printf("Warning: could not load legal hold for fax %s: %v", id, err)
+	}
+	archived, _, err := a.Store.receivedMedia(ctx, profile.Name, id)
+	if err != nil {
+		log.Printf("Warning: could not load archived media for fax %s: %v", id, err)
+	}
+	a.recordAccess(r, accessViewed, profile.Name, id)
+	var failure *failureExplanation
+	if e, ok := explainFailure(fax.FailureReason); ok {
+		failure = &e
+	}
+	data := map[string]any{
+		"Failure":  failure,
+		"Archived": archived != "",
+		"Hold":     hold,
+		"Comments": comments,
+		"Fax":      fax,
+		"Profile":  profile.Name,
+		"Sent":     sent,
+		"Tags":     tags,
+		"FaxTags":  selected,
+		"Success":  a.faxConfirmation(r, fax),
+	}
+	a.render(w, r, "fax_show.html", data)
 }
 
 // handleFaxes lists all faxes with pagination support
@@ -176,46 +573,152 @@ func (a *App) handleFaxes(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	size := int64(10)
-	number := int64(1)
-	if v := r.URL.Query().Get("page_size"); v != "" {
-		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
-			size = n
-		}
+	q := faxQuery{Tag: r.URL.Query().Get("tag")}
+	if r.URL.Query().Get("format") == "csv" {
+		a.handleFaxesCSV(w, r, q)
+		return
 	}
-	if v := r.URL.Query().Get("page_number"); v != "" {
-		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
-			number = n
-		}
+	data, ok := a.faxListData(w, r, q)
+	if !ok {
+		return
+	}
+	data["Success"] = r.URL.Query().Get("success")
+	data["Error"] = r.URL.Query().Get("error")
+	a.render(w, r, "faxes.html", data)
+}
+
+// faxListData loads the page of faxes matching q for the list templates, writing an error response if that fails
+func (a *App) faxListData(w http.ResponseWriter, r *http.Request, q faxQuery) (map[string]any, bool) {
+	q.PageNumber, q.PageSize = requestPage(r)
+	q, sort := requestFilters(r, q)
+
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil, false
 	}
+	q.Scope = a.faxScope(r)
 
-	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.PageTimeout)
 	defer cancel()
-	res, err := a.Client.Faxes.List(ctx, telnyx.FaxListParams{
-		PageNumber: telnyx.Int(number),
-		PageSize:   telnyx.Int(size),
-	})
+	if q.local() && q.Direction == "inbound" {
+		a.syncInbox(ctx, profile)
+	}
+	faxes, totals, err := a.listFaxPage(ctx, profile, q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
-		return
+		writeListError(w, q, err)
+		return nil, false
+	}
+	// A page past the end, e.g. from a stale link after faxes were deleted, goes to the last page
+	if q.PageNumber > 1 && (len(faxes) == 0 || totals.Pages > 0 && q.PageNumber > totals.Pages) {
+		http.Redirect(w, r, pageLink(r, max(totals.Pages, 1), q.PageSize), http.StatusSeeOther)
+		return nil, false
+	}
+	// Catch up on received faxes whose webhook was missed, or that arrived before archiving was set up
+	a.archiveInbox(ctx, profile, faxes)
+	a.exportFHIRInbox(ctx, profile, faxes)
+	a.deliverInbox(ctx, profile, faxes)
+
+	tags, err := a.Store.tags(ctx)
+	if err != nil {
+		log.Printf("Warning: could not load tags: %v", err)
+	}
+	ids := make([]string, len(faxes))
+	for i, f := range faxes {
+		ids[i] = f.ID
+	}
+	faxTags, err := a.Store.faxTags(ctx, profile.Name, ids)
+	if err != nil {
+		log.Printf("Warning: could not load fax tags: %v", err)
 	}
 
 	data := map[string]any{
-		"Faxes":      res.Data,
-		"PageSize":   size,
-		"PageNumber": number,
+		"Faxes":      faxes,
+		"Rows":       faxRows(faxes, profile.Name, faxTags),
+		"FaxTags":    faxTags,
+		"Tags":       tags,
+		"Tag":        q.Tag,
+		"PageSize":   q.PageSize,
+		"PageNumber": q.PageNumber,
+		"Pager":      newPager(r, q.PageNumber, q.PageSize, len(faxes), totals),
+		"Sort":       sort,
+		"Sorted":     q.Sort != "",
+		"Status":     q.Status,
+		"Statuses":   listStatuses,
+		"Within":     r.URL.Query().Get("within"),
+		"Periods":    listPeriods,
+		"Profile":    profile.Name,
+		"Profiles":   profileNames(a.allowedProfiles(r)),
+	}
+	a.addSavedViews(r, data)
+	return data, true
+}
+
+// listStatuses are the statuses the fax lists can be filtered by
+var listStatuses = []string{"queued", "sending", "delivered", "failed", "received"}
+
+// listPeriods are the "created within" filters offered by the fax lists; within accepts any period such as "90d"
+var listPeriods = []struct{ Value, Label string }{
+	{"1d", "Last 24 hours"},
+	{"7d", "Last 7 days"},
+	{"30d", "Last 30 days"},
+}
+
+// requestFilters adds the status, within (e.g. "7d") and sort parameters to q
+func requestFilters(r *http.Request, q faxQuery) (faxQuery, listSort) {
+	query := r.URL.Query()
+	if status := query.Get("status"); slices.Contains(listStatuses, status) {
+		q.Status = status
+	}
+	if p, err := parseRetentionPeriod(query.Get("within")); err == nil && p > 0 {
+		q.Since = time.Now().Add(-time.Duration(p))
+	}
+	sort := requestSort(r)
+	q.Sort, q.Ascending = sort.query(), sort.Ascending
+	return q, sort
+}
+
+// listFaxes returns the page of faxes matching q. Pages come from the provider unless q filters on tags
+// or triage, which only the local database knows about.
+func (a *App) listFaxes(ctx context.Context, profile *Profile, q faxQuery) ([]Fax, error) {
+	if q.local() {
+		return a.Store.queryFaxes(ctx, profile.Name, q)
 	}
-	if err := a.Tmpl.ExecuteTemplate(w, "faxes.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	return profile.provider.List(ctx, q.Direction, q.PageNumber, q.PageSize)
+}
+
+// listFaxPage is listFaxes with the totals of the whole list, where known
+func (a *App) listFaxPage(ctx context.Context, profile *Profile, q faxQuery) ([]Fax, listTotals, error) {
+	if q.local() {
+		faxes, err := a.Store.queryFaxes(ctx, profile.Name, q)
+		if err != nil {
+			return nil, listTotals{}, err
+		}
+		n, err := a.Store.countFaxes(ctx, profile.Name, q)
+		if err != nil {
+			return nil, listTotals{}, err
+		}
+		return faxes, listTotals{Results: n, Pages: (n + q.PageSize - 1) / q.PageSize}, nil
 	}
+	return listWithTotals(ctx, profile.provider, q.Direction, q.PageNumber, q.PageSize)
+}
+
+// writeListError reports a listFaxes failure: local database errors are ours, anything else the provider's
+func writeListError(w http.ResponseWriter, q faxQuery, err error) {
+	if q.local() {
+		http.Error(w, "failed to load faxes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeUpstreamError(w, err)
 }
 
 // handleMediaServe serves uploaded files for Telnyx to fetch.
 // This endpoint is publicly accessible (no auth required) but uses unguessable tokens for security.
-// In HIPAA mode: files are in-memory and automatically cleaned up after expiration.
-// In non-HIPAA mode with persistence: files are served from disk.
+// Files come from the media store: memory (always in HIPAA mode), the upload directory or S3.
+// Conditional and Range requests are answered from the document's ETag and upload time, so Telnyx
+// retries and preview fetches do not download the whole document again.
 func (a *App) handleMediaServe(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -228,50 +731,37 @@ func (a *App) handleMediaServe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Non-HIPAA mode with disk storage: serve from filesystem
-	if !a.Hipaa && a.UploadDir != "" {
-		filePath := filepath.Join(a.UploadDir, filepath.Clean(token))
-		// Ensure the path is within UploadDir (prevent directory traversal)
-		if !strings.HasPrefix(filePath, filepath.Clean(a.UploadDir)+string(filepath.Separator)) {
-			http.NotFound(w, r)
-			return
-		}
-		http.ServeFile(w, r, filePath)
-		return
-	}
-
-	// HIPAA mode or no disk storage: serve from memory
-	a.memMu.RLock()
-	file, ok := a.uploadedFiles[token]
-	a.memMu.RUnlock()
-
-	if !ok {
+	file, err := a.Media.Get(r.Context(), token)
+	if errors.Is(err, errMediaNotFound) {
 		http.NotFound(w, r)
 		return
-	}
-
-	// Check if file has expired
-	if time.Now().After(file.ExpiresAt) {
-		// Clean up expired file
-		a.memMu.Lock()
-		delete(a.uploadedFiles, token)
-		a.memMu.Unlock()
-		http.NotFound(w, r)
+	} else if err != nil {
+		log.Printf("Warning: could not read media %s: %v", token[:min(len(token), 8)]+"...", err)
+		http.Error(w, "failed to read media", http.StatusInternalServerError)
 		return
 	}
 
 	if file.Type != "" {
 		w.Header().Set("Content-Type", file.Type)
 	}
-	http.ServeContent(w, r, token, time.Now(), bytesReader(file.Data))
+	w.Header().Set("ETag", mediaETag(file.Data))
+	// Caches may keep the document but must check it is still there, as it is deleted once sent
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, token, file.Stored, bytesReader(file.Data))
+}
+
+// mediaETag names a document's content, so it is the same whichever store or server instance serves it
+func mediaETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
 }
 
-// logRequests is a middleware that logs HTTP requests
+// logRequests is a middleware that logs HTTP requests with their request ID
 func logRequests(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		next.ServeHTTP(w, r)
-		log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(start))
+		log.Printf("[%s] %s %s %s", requestID(r.Context()), r.Method, r.URL.Path, time.Since(start))
 	})
 }
 
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// handleFaxHold places or lifts a legal hold on a fax from the fax details page
+func (a *App) handleFaxHold(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	user, _ := a.sessionUser(r)
+
+	entry := auditEntry{Actor: firstNonEmpty(user, "anonymous"), Profile: profile.Name, FaxID: id}
+	success := ""
+	switch r.FormValue("action") {
+	case "place":
+		entry.Action = "hold.placed"
+		entry.Detail = strings.TrimSpace(r.FormValue("reason"))
+		err = a.Store.placeHold(r.Context(), profile.Name, id, entry.Detail, entry.Actor)
+		success = "hold_placed"
+	case "lift":
+		entry.Action = "hold.lifted"
+		err = a.Store.liftHold(r.Context(), profile.Name, id)
+		success = "hold_lifted"
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to update legal hold: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.writeAudit(r.Context(), entry)
+	q := url.Values{"id": {id}, "profile": {profile.Name}, "success": {success}}
+	http.Redirect(w, r, "/fax?"+q.Encode(), http.StatusSeeOther)
+}
+
+// handleDeleteFax deletes a fax at the provider along with everything stored about it locally,
+// unless it is on legal hold
+func (a *App) handleDeleteFax(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	hold, err := a.Store.faxHold(ctx, profile.Name, id)
+	if err != nil {
+		http.Error(w, "failed to check legal hold: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if hold != nil {
+		http.Error(w, "fax is "+holdMessage(hold)+" and cannot be deleted until an admin lifts the hold", http.StatusConflict)
+		return
+	}
+	sent, err := a.Store.sentFax(ctx, profile.Name, id)
+	if err != nil {
+		http.Error(w, "failed to load fax: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	mediaFile := ""
+	if sent != nil {
+		mediaFile = sent.MediaFile
+	}
+	note, err := a.deleteFaxData(ctx, profile.Name, id, mediaFile, true)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	user, _ := a.sessionUser(r)
+	a.writeAudit(ctx, auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "fax.deleted", Profile: profile.Name, FaxID: id, Detail: note})
+	q := url.Values{"profile": {profile.Name}, "success": {a.T(r, "Deleted fax %s", id)}}
+	http.Redirect(w, r, "/faxes?"+q.Encode(), http.StatusSeeOther)
+}
+
+// heldTagRemoved returns the first held tag a fax carries that is missing from tags, or ""
+func heldTagRemoved(all []tag, current, tags []string) string {
+	for _, t := range all {
+		if t.Hold && containsFold(current, t.Name) && !containsFold(tags, t.Name) {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+// writeAudit appends an entry to the audit log, logging failures
+func (a *App) writeAudit(ctx context.Context, e auditEntry) {
+	e.Actor = auditActor(ctx, e.Actor)
+	if err := a.Store.audit(ctx, e); err != nil {
+		log.Printf("Warning: could not write audit entry %s: %v", e.Action, err)
+	}
+}
+
+// holdMessage describes a legal hold for error messages
+func holdMessage(h *legalHold) string {
+	if h.Tag != "" {
+		return fmt.Sprintf("on legal hold through the %q tag", h.Tag)
+	}
+	return "on legal hold"
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pages and messages are written in English, which doubles as the message ID: web/locales/<tag>.json maps
+// the English text to a translation, and anything it leaves out stays in English. Each language gets its own
+// copy of the templates, with {{ t "text" }} translating into it.
+
+// languageCookieName remembers the language picked in the navigation bar, for users without an account
+const languageCookieName = "fax_ui_lang"
+
+// languageNameKey names the language in its own catalog, for the picker
+const languageNameKey = "@name"
+
+// language is a message catalog and the templates rendered with it
+type language struct {
+	Tag      string // e.g. "es"
+	Name     string // e.g. "Español"
+	messages map[string]string
+	tmpl     *template.Template
+}
+
+// T translates msg, formatting it with args like fmt.Sprintf when there are any
+func (l *language) T(msg string, args ...any) string {
+	s := l.translate(msg)
+	if len(args) > 0 {
+		s = fmt.Sprintf(s, args...)
+	}
+	return s
+}
+
+// translate returns the translation of msg, or msg when there is none. Messages built as
+// "<text>: <detail>", such as an error with its cause, translate the text and keep the detail.
+func (l *language) translate(msg string) string {
+	if s, ok := l.messages[msg]; ok && s != "" {
+		return s
+	}
+	if head, tail, ok := strings.Cut(msg, ": "); ok {
+		if s, ok := l.messages[head]; ok && s != "" {
+			return s + ": " + tail
+		}
+	}
+	return msg
+}
+
+// loadLanguages parses the template files, with funcs, once per language: English, plus a catalog for each
+// JSON file in localesDir
+func loadLanguages(files []string, localesDir string, funcs template.FuncMap) (map[string]*language, error) {
+	languages := map[string]*language{"en": {Tag: "en", Name: "English"}}
+	catalogs, _ := filepath.Glob(filepath.Join(localesDir, "*.json"))
+	for _, file := range catalogs {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("message catalog %s: %w", file, err)
+		}
+		tag := strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".json"))
+		languages[tag] = &language{Tag: tag, Name: firstNonEmpty(messages[languageNameKey], tag), messages: messages}
+	}
+	for _, l := range languages {
+		// Times are shown in UTC until tmpl binds the request's time zone
+		tmpl, err := template.New("").Funcs(template.FuncMap{
+			"t":    l.T,
+			"lang": func() string { return l.Tag },
+		}).Funcs(timeFuncs(l, time.UTC)).Funcs(funcs).ParseFiles(files...)
+		if err != nil {
+			return nil, err
+		}
+		l.tmpl = tmpl
+	}
+	return languages, nil
+}
+
+// languageList is the languages for the picker, by name
+func (a *App) languageList() []*language {
+	list := make([]*language, 0, len(a.Languages))
+	for _, l := range a.Languages {
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// language picks the request's language: the signed-in user's preference, then the language picked in this
+// browser, then the browser's Accept-Language, then the default
+func (a *App) language(r *http.Request) *language {
+	if user, ok := a.sessionUser(r); ok {
+		if tag, err := a.Store.userLanguage(r.Context(), user); err != nil {
+			log.Printf("Warning: could not load the language of %s: %v", user, err)
+		} else if l := a.Languages[tag]; l != nil {
+			return l
+		}
+	}
+	if c, err := r.Cookie(languageCookieName); err == nil {
+		if l := a.Languages[c.Value]; l != nil {
+			return l
+		}
+	}
+	if l := a.acceptLanguage(r.Header.Get("Accept-Language")); l != nil {
+		return l
+	}
+	return a.Languages[a.DefaultLanguage]
+}
+
+// acceptLanguage returns the most preferred language of an Accept-Language header that fax-ui speaks
+func (a *App) acceptLanguage(header string) *language {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if base != "" && q > 0 {
+			choices = append(choices, choice{base, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, c := range choices {
+		if l := a.Languages[c.tag]; l != nil {
+			return l
+		}
+	}
+	return nil
+}
+
+// T translates msg into the request's language, formatting it with args when there are any
+func (a *App) T(r *http.Request, msg string, args ...any) string {
+	return a.language(r).T(msg, args...)
+}
+
+// tmpl returns the templates in the request's language, showing times in its time zone and numbers with
+// their contact names. Each request executes its own copy, since these are bound into the template functions.
+func (a *App) tmpl(r *http.Request) *template.Template {
+	l := a.language(r)
+	tmpl, err := l.tmpl.Clone()
+	if err != nil {
+		log.Printf("Warning: could not copy the %s templates: %v", l.Tag, err)
+		return l.tmpl
+	}
+	names := func() map[string]string { return a.contactNames(r.Context()) }
+	return tmpl.Funcs(timeFuncs(l, a.location(r))).Funcs(numberFuncs(a.DefaultCountry, names))
+}
+
+// handleLanguage switches the language: for the signed-in user, and in this browser
+func (a *App) handleLanguage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	l := a.Languages[r.FormValue("lang")]
+	if l == nil {
+		http.Error(w, "unknown language", http.StatusBadRequest)
+		return
+	}
+	if user, ok := a.sessionUser(r); ok {
+		if err := a.Store.setUserLanguage(r.Context(), user, l.Tag); err != nil {
+			http.Error(w, "failed to save language: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     languageCookieName,
+		Value:    l.Tag,
+		Path:     "/",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Validate redirect to prevent open redirect attacks
+	redirect := r.FormValue("redirect")
+	if redirect == "" || !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/"
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// translateErrors turns the plain-text error responses written by http.Error into an error page in the
+// request's language, or translated plain text for scripts, with the request ID as a reference users can
+// quote. Server errors are logged with their detail under that ID.
+func (a *App) translateErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &translatingWriter{ResponseWriter: w}
+		next.ServeHTTP(tw, r)
+		if tw.status != 0 {
+			detail := strings.TrimSuffix(tw.body.String(), "\n")
+			id := requestID(r.Context())
+			if tw.status >= 500 {
+				log.Printf("[%s] %d %s %s: %s", id, tw.status, r.Method, r.URL.Path, detail)
+			}
+			w.Header().Del("Content-Length")
+			if wantsErrorPage(r) {
+				a.renderErrorPage(w, r, tw.status, detail)
+				return
+			}
+			w.WriteHeader(tw.status)
+			fmt.Fprintln(w, a.errorMessage(r, tw.status, detail))
+			if id != "" {
+				fmt.Fprintln(w, a.T(r, "Reference: %s", id))
+			}
+		}
+	})
+}
+
+// translatingWriter holds back plain-text error responses so they can be translated once complete
+type translatingWriter struct {
+	http.ResponseWriter
+	status int // set while an error response is held back
+	body   strings.Builder
+}
+
+func (w *translatingWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *translatingWriter) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to upgrade /ws
+func (w *translatingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// duplicateSubmitWait bounds how long a duplicate submission waits for the original to finish
+// sending, which may include failover attempts
+const duplicateSubmitWait = 70 * time.Second
+
+// newIdempotencyKey returns a key for a freshly rendered send form, or "" if none can be generated
+func newIdempotencyKey() string {
+	key, err := generateSecureToken(16)
+	if err != nil {
+		log.Printf("Warning: could not generate idempotency key: %v", err)
+		return ""
+	}
+	return key
+}
+
+// handleDuplicateSubmission answers a send whose idempotency key the user already used by redirecting
+// to the fax the original submission created, waiting for it if it is still being sent
+func (a *App) handleDuplicateSubmission(w http.ResponseWriter, r *http.Request, user, key string) {
+	deadline := time.Now().Add(duplicateSubmitWait)
+	for {
+		send, err := a.Store.idempotentFax(r.Context(), user, key)
+		switch {
+		case err != nil:
+			log.Printf("Warning: could not look up idempotency key: %v", err)
+			http.Error(w, "this form was already submitted", http.StatusConflict)
+			return
+		case send == nil:
+			http.Error(w, "the original submission of this form failed; reload the form and try again", http.StatusConflict)
+			return
+		case send.FaxID != "":
+			http.Redirect(w, r, "/fax?"+url.Values{"id": {send.FaxID}, "profile": {send.Profile}}.Encode(), http.StatusSeeOther)
+			return
+		case send.Unknown:
+			http.Error(w, "the original submission of this form failed, but the fax may have been sent anyway; check the fax list before sending it again", http.StatusConflict)
+			return
+		}
+		if time.Now().After(deadline) {
+			http.Error(w, "this form is still being sent; check the fax list in a moment", http.StatusConflict)
+			return
+		}
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
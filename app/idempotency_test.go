@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	store, ctx := app.Store, context.Background()
+
+	claim := func(user, key string) bool {
+		t.Helper()
+		claimed, err := store.claimIdempotencyKey(ctx, user, key)
+		if err != nil {
+			t.Fatalf("claim %s/%s: %v", user, key, err)
+		}
+		return claimed
+	}
+	lookup := func(user, key string) *idempotentSend {
+		t.Helper()
+		send, err := store.idempotentFax(ctx, user, key)
+		if err != nil {
+			t.Fatalf("look up %s/%s: %v", user, key, err)
+		}
+		return send
+	}
+
+	// Keys are scoped to the user who claimed them
+	if !claim("alice", "k1") || claim("alice", "k1") {
+		t.Fatal("alice claimed k1 other than once")
+	}
+	if !claim("bob", "k1") {
+		t.Fatal("bob could not claim the key alice used")
+	}
+
+	// A send that certainly failed frees the key for a resubmission
+	if err := store.releaseIdempotencyKey(ctx, "bob", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if send := lookup("bob", "k1"); send != nil {
+		t.Fatalf("released key still answers %+v", send)
+	}
+	if send := lookup("alice", "k1"); send == nil || send.FaxID != "" || send.Unknown {
+		t.Fatalf("alice's key after bob's release: %+v", send)
+	}
+
+	// One that may have created a fax keeps it, so the resubmission is refused
+	if err := store.keepIdempotencyKeyUnknown(ctx, "alice", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if send := lookup("alice", "k1"); send == nil || !send.Unknown {
+		t.Fatalf("alice's key after an uncertain send: %+v", send)
+	}
+	if claim("alice", "k1") {
+		t.Fatal("reclaimed the key of a send with an unknown outcome")
+	}
+
+	claim("alice", "k2")
+	if err := store.completeIdempotencyKey(ctx, "alice", "k2", "default", "fax-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.releaseIdempotencyKey(ctx, "alice", "k2"); err != nil {
+		t.Fatal(err)
+	}
+	if send := lookup("alice", "k2"); send == nil || send.FaxID != "fax-1" || send.Profile != "default" {
+		t.Fatalf("completed key: %+v", send)
+	}
+}
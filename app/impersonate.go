@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Admins can view fax-ui as another user to troubleshoot what that user sees. While they do, every page
+// shows a banner, requests act as the other user, and each request and audited action is recorded under
+// the admin's name. Viewing as someone ends after impersonationMaxAge, on logout, or from the banner.
+const (
+	impersonationCookieName = "fax_ui_view_as"
+	impersonationMaxAge     = time.Hour
+)
+
+// impersonatorKey carries the admin behind an impersonated request in its context
+type impersonatorKey struct{}
+
+// impersonation returns the signed-in admin and the user they are viewing as, if they are.
+// The cookie is signed together with the admin's session, so it is useless once that session ends.
+func (a *App) impersonation(r *http.Request) (admin, user string, ok bool) {
+	cookie, err := r.Cookie(impersonationCookieName)
+	if err != nil {
+		return "", "", false
+	}
+	session, err := r.Cookie(a.AuthConfig.CookieName)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(cookie.Value, ".", 3)
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	encodedUser, expires, signature := parts[0], parts[1], parts[2]
+	if !verifySessionToken(session.Value+"."+encodedUser+"."+expires, signature, a.AuthConfig.SessionSecret) {
+		return "", "", false
+	}
+	if unix, err := strconv.ParseInt(expires, 10, 64); err != nil || time.Now().Unix() > unix {
+		return "", "", false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(encodedUser)
+	if err != nil {
+		return "", "", false
+	}
+	// An admin who loses admin rights stops viewing as others at once
+	admin, signedIn := a.signedInUser(r)
+	if !signedIn || !a.isAdminUser(admin) {
+		return "", "", false
+	}
+	return admin, string(decoded), true
+}
+
+// setImpersonationCookie starts viewing as user for the admin signed in to r
+func (a *App) setImpersonationCookie(w http.ResponseWriter, r *http.Request, user string) error {
+	session, err := r.Cookie(a.AuthConfig.CookieName)
+	if err != nil {
+		return err
+	}
+	encodedUser := base64.RawURLEncoding.EncodeToString([]byte(user))
+	expires := strconv.FormatInt(time.Now().Add(impersonationMaxAge).Unix(), 10)
+	signature := signSessionToken(session.Value+"."+encodedUser+"."+expires, a.AuthConfig.SessionSecret)
+	a.writeAuthCookie(w, impersonationCookieName, encodedUser+"."+expires+"."+signature, impersonationMaxAge)
+	return nil
+}
+
+// clearImpersonationCookie stops viewing as another user
+func (a *App) clearImpersonationCookie(w http.ResponseWriter) {
+	a.writeAuthCookie(w, impersonationCookieName, "", -1)
+}
+
+// impersonating records a request made while viewing as another user and marks its context, so the
+// actions it audits are attributed to the admin. Status polling by open pages is left out.
+func (a *App) impersonating(r *http.Request, admin, user string) *http.Request {
+	r = r.WithContext(context.WithValue(r.Context(), impersonatorKey{}, admin))
+	if !strings.HasPrefix(r.URL.Path, "/partials/") && r.URL.Path != "/fax/status" {
+		// Only the query is read; the handler parses the body with its own limits
+		q := r.URL.Query()
+		a.writeAudit(r.Context(), auditEntry{Actor: user, Action: "impersonation.request", Profile: q.Get("profile"),
+			FaxID: q.Get("id"), Detail: r.Method + " " + r.URL.Path})
+	}
+	return r
+}
+
+// auditActor names who did something: the user, or the admin viewing as them
+func auditActor(ctx context.Context, user string) string {
+	if admin, ok := ctx.Value(impersonatorKey{}).(string); ok && !strings.EqualFold(admin, user) {
+		return admin + " (as " + user + ")"
+	}
+	return user
+}
+
+// handleAdminImpersonate shows the form for viewing as another user, and starts doing so
+func (a *App) handleAdminImpersonate(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.render(w, r, "admin_impersonate.html", map[string]any{
+			"Users":   a.knownUsers(r.Context(), ""),
+			"MaxAge":  impersonationMaxAge.String(),
+			"Enabled": a.hasAuthConfigured(),
+			"Error":   r.URL.Query().Get("error"),
+		})
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		admin, _ := a.signedInUser(r)
+		user := strings.TrimSpace(r.FormValue("user"))
+		switch {
+		case !a.hasAuthConfigured():
+			http.Error(w, "viewing as another user needs sign-in to be configured", http.StatusBadRequest)
+			return
+		case user == "" || strings.EqualFold(user, admin):
+			http.Redirect(w, r, "/admin/impersonate?error="+url.QueryEscape(a.T(r, "Enter the user to view as")), http.StatusSeeOther)
+			return
+		}
+		if err := a.setImpersonationCookie(w, r, user); err != nil {
+			http.Error(w, "failed to start viewing as another user: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.writeAudit(r.Context(), auditEntry{Actor: admin, Action: "impersonation.started", Detail: "viewing as " + user})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleStopImpersonating returns an admin to their own view. Admin pages are closed to the user being
+// viewed as, so this is open to every signed-in user.
+func (a *App) handleStopImpersonating(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if admin, user, ok := a.impersonation(r); ok {
+		a.writeAudit(r.Context(), auditEntry{Actor: admin, Action: "impersonation.stopped", Detail: "viewed as " + user})
+	}
+	a.clearImpersonationCookie(w)
+	http.Redirect(w, r, "/admin/impersonate", http.StatusSeeOther)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// inboxSyncSize is how many of the newest received faxes are pulled from the provider before the
+// inbox is served from the local database, so queue views include faxes nobody has listed yet
+const inboxSyncSize = 100
+
+// handleInbox lists received faxes (GET) and applies bulk triage actions (POST)
+func (a *App) handleInbox(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.handleListInbox(w, r)
+	case http.MethodPost:
+		a.handleUpdateInbox(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleListInbox renders received faxes with their triage state. The view parameter selects
+// the unassigned queue ("unassigned") or the current user's faxes ("mine").
+func (a *App) handleListInbox(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	user, _ := a.sessionUser(r)
+	view := query.Get("view")
+	q := faxQuery{
+		Direction:  "inbound",
+		Tag:        query.Get("tag"),
+		State:      query.Get("state"),
+		Unassigned: view == "unassigned",
+	}
+	if view == "mine" {
+		q.Assignee = user
+	}
+	data, ok := a.faxListData(w, r, q)
+	if !ok {
+		return
+	}
+
+	faxes := data["Faxes"].([]Fax)
+	ids := make([]string, len(faxes))
+	for i, f := range faxes {
+		ids[i] = f.ID
+	}
+	triages, err := a.Store.faxTriage(r.Context(), data["Profile"].(string), ids)
+	if err != nil {
+		log.Printf("Warning: could not load inbox triage: %v", err)
+	}
+	data["Triage"] = triages
+	data["View"] = view
+	data["State"] = q.State
+	data["States"] = triageStates
+	data["Users"] = a.knownUsers(r.Context(), user)
+	data["Query"] = query.Encode()
+	data["Success"] = query.Get("success")
+	data["Error"] = query.Get("error")
+	a.render(w, r, "inbox.html", data)
+}
+
+// handleUpdateInbox sets the triage state or assignee of the selected faxes
+func (a *App) handleUpdateInbox(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	user, _ := a.sessionUser(r)
+
+	// Return to the inbox view the action was taken from
+	back, _ := url.ParseQuery(r.FormValue("return"))
+	back.Del("success")
+	back.Del("error")
+	redirect := func(key, msg string, args ...any) {
+		back.Set(key, a.T(r, msg, args...))
+		http.Redirect(w, r, "/inbox?"+back.Encode(), http.StatusSeeOther)
+	}
+	failed := func(err error) {
+		back.Set("error", a.failureMessage(r, err, "Failed to update faxes"))
+		http.Redirect(w, r, "/inbox?"+back.Encode(), http.StatusSeeOther)
+	}
+
+	ids := r.Form["fax_id"]
+	if scope := a.faxScope(r); scope != nil && len(ids) > 0 {
+		if ids, err = a.Store.visibleFaxes(r.Context(), profile.Name, ids, scope); err != nil {
+			failed(err)
+			return
+		}
+	}
+	if len(ids) == 0 {
+		redirect("error", "Select one or more faxes first")
+		return
+	}
+	switch r.FormValue("action") {
+	case "state":
+		state := r.FormValue("state")
+		if !slices.Contains(triageStates, state) {
+			redirect("error", "Unknown state")
+			return
+		}
+		err = a.Store.setTriageState(r.Context(), profile.Name, ids, state, user)
+	case "assign":
+		assignee := strings.TrimSpace(r.FormValue("assignee"))
+		if assignee == "me" {
+			if user == "" {
+				redirect("error", "Sign in to assign faxes to yourself")
+				return
+			}
+			assignee = user
+		}
+		err = a.Store.setTriageAssignee(r.Context(), profile.Name, ids, assignee, user)
+	default:
+		redirect("error", "unknown action")
+		return
+	}
+	if err != nil {
+		failed(err)
+		return
+	}
+	if len(ids) == 1 {
+		redirect("success", "Updated 1 fax")
+		return
+	}
+	redirect("success", "Updated %d faxes", len(ids))
+}
+
+// syncInbox stores the newest received faxes from the profile's provider. Failures are logged and
+// the inbox is served from what the database already holds.
+func (a *App) syncInbox(ctx context.Context, p *Profile) {
+	faxes, err := p.provider.List(ctx, "inbound", 1, inboxSyncSize)
+	if err != nil {
+		log.Printf("Warning: could not refresh inbox for profile %s: %v", p.Name, err)
+		return
+	}
+	if err := a.Store.saveFaxes(ctx, p.Name, faxes); err != nil {
+		log.Printf("Warning: could not store inbox for profile %s: %v", p.Name, err)
+	}
+}
+
+// knownUsers lists the identities faxes can be assigned to: admins, profile and department users, current assignees,
+// users who have sent faxes or saved preferences, and the current user
+func (a *App) knownUsers(ctx context.Context, current string) []string {
+	seen := make(map[string]bool)
+	var users []string
+	add := func(u string) {
+		if u != "" && !seen[strings.ToLower(u)] {
+			seen[strings.ToLower(u)] = true
+			users = append(users, u)
+		}
+	}
+	add(current)
+	for _, u := range a.AuthConfig.AdminUsers {
+		add(u)
+	}
+	for _, p := range a.Profiles {
+		for _, u := range p.Users {
+			add(u)
+		}
+	}
+	for _, d := range a.runtime().Departments {
+		for _, u := range d.Users {
+			add(u)
+		}
+	}
+	assignees, err := a.Store.assignees(ctx)
+	if err != nil {
+		log.Printf("Warning: could not load assignees: %v", err)
+	}
+	for _, u := range assignees {
+		add(u)
+	}
+	stored, err := a.Store.users(ctx)
+	if err != nil {
+		log.Printf("Warning: could not load users: %v", err)
+	}
+	for _, u := range stored {
+		add(u)
+	}
+	sort.Strings(users)
+	return users
+}
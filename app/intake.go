@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// Referral offices can have received faxes pushed to their own intake systems. Each intake pipeline in the
+// config file names the faxes it takes (by profile and numbers) and the endpoint they go to. A matching fax
+// is queued when its webhook arrives; the queue worker splits it into single pages, reads their text with
+// Tesseract when it is installed, and posts the pages with the fax's details as JSON or multipart form
+// data. Failed deliveries are retried with backoff, and ones that keep failing, or that the endpoint
+// rejects, end up in the dead letters on the admin Intake page to be retried or discarded.
+
+// IntakePipeline pushes matching received faxes to an intake endpoint
+type IntakePipeline struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`     // endpoint the faxes are posted to
+	Format  string            `yaml:"format"`  // json (default) or multipart
+	Headers map[string]string `yaml:"headers"` // sent with every delivery, e.g. Authorization; values expand ${ENV} variables
+	Match   IntakeMatch       `yaml:"match"`
+}
+
+// IntakeMatch selects the received faxes of a pipeline; empty lists match every fax
+type IntakeMatch struct {
+	Profiles []string `yaml:"profiles"`
+	To       []string `yaml:"to"`   // numbers the fax was sent to; a trailing * matches a prefix, e.g. +1202555*
+	From     []string `yaml:"from"` // numbers the fax came from, likewise
+}
+
+// Intake job states
+const (
+	intakePending   = "pending"
+	intakeDelivered = "delivered"
+	intakeDead      = "dead"
+)
+
+// intakeAttempts is how often a delivery is tried before it becomes a dead letter. Retries back off from
+// a minute up to intakeMaxBackoff, so a fax is given up on after about four hours.
+const (
+	intakeAttempts   = 8
+	intakeMaxBackoff = time.Hour
+	intakeInterval   = 30 * time.Second
+	intakeTimeout    = 5 * time.Minute
+	intakeBatch      = 10
+)
+
+// intakeJob is a received fax queued for delivery to a pipeline
+type intakeJob struct {
+	ID          int64
+	Pipeline    string
+	Profile     string
+	FaxID       string
+	From        string
+	To          string
+	MediaURL    string
+	ReceivedAt  time.Time
+	Status      string
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time
+	UpdatedAt   time.Time
+}
+
+// intakePage is one page of a delivered fax
+type intakePage struct {
+	Number      int    `json:"number"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Text        string `json:"text"`           // OCR text; empty without Tesseract
+	Data        []byte `json:"data,omitempty"` // base64 in JSON deliveries; a file part in multipart ones
+}
+
+// intakePayload is what an intake endpoint receives about a fax
+type intakePayload struct {
+	Pipeline   string       `json:"pipeline"`
+	FaxID      string       `json:"fax_id"`
+	Profile    string       `json:"profile"`
+	From       string       `json:"from"`
+	To         string       `json:"to"`
+	ReceivedAt time.Time    `json:"received_at"`
+	PageCount  int          `json:"page_count"`
+	Text       string       `json:"text"` // OCR text of every page
+	OCR        bool         `json:"ocr"`  // whether the text was read; false when Tesseract is not installed
+	Pages      []intakePage `json:"pages"`
+}
+
+// intakeRejected is a delivery the endpoint refused; retrying it would not help
+type intakeRejected struct{ error }
+
+// parse checks the pipeline
+func (p *IntakePipeline) parse() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("missing name")
+	}
+	if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	switch strings.ToLower(p.Format) {
+	case "", "json":
+		p.Format = "json"
+	case "multipart":
+		p.Format = "multipart"
+	default:
+		return fmt.Errorf("unknown format %q (expected json or multipart)", p.Format)
+	}
+	for k, v := range p.Headers {
+		p.Headers[k] = os.ExpandEnv(v)
+	}
+	return nil
+}
+
+// matches checks whether the pipeline takes a fax received under profile
+func (m IntakeMatch) matches(profile string, fax Fax) bool {
+	return (len(m.Profiles) == 0 || containsFold(m.Profiles, profile)) &&
+		matchesNumber(m.To, fax.To) && matchesNumber(m.From, fax.From)
+}
+
+// matchesNumber checks a number against a list of numbers and prefixes ending in *; an empty list matches
+func matchesNumber(patterns []string, number string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(number, prefix) {
+				return true
+			}
+		} else if p == number {
+			return true
+		}
+	}
+	return false
+}
+
+// intakePipeline returns the configured pipeline with the name, or nil
+func (a *App) intakePipeline(name string) *IntakePipeline {
+	for i := range a.Intake {
+		if a.Intake[i].Name == name {
+			return &a.Intake[i]
+		}
+	}
+	return nil
+}
+
+// queueIntake queues a received fax for the pipelines it matches, and wakes the worker
+func (a *App) queueIntake(ctx context.Context, profile string, fax Fax) {
+	queued := false
+	for _, p := range a.Intake {
+		if !p.Match.matches(profile, fax) {
+			continue
+		}
+		job := intakeJob{Pipeline: p.Name, Profile: profile, FaxID: fax.ID, From: fax.From, To: fax.To, MediaURL: fax.MediaURL,
+			ReceivedAt: firstTime(fax.CreatedAt, fax.UpdatedAt, time.Now())}
+		if err := a.Store.queueIntakeJob(ctx, job); err != nil {
+			log.Printf("Warning: could not queue fax %s for intake %s: %v", fax.ID, p.Name, err)
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		select {
+		case a.intakeWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// startIntake delivers queued faxes as they come in, and retries failed deliveries periodically
+func (a *App) startIntake() {
+	backgroundTasks.register("intake", intakeInterval)
+	go func() {
+		ticker := time.NewTicker(intakeInterval)
+		defer ticker.Stop()
+		for {
+			backgroundTasks.beat("intake")
+			a.deliverIntakeJobs(context.Background())
+			select {
+			case <-ticker.C:
+			case <-a.intakeWake:
+			}
+		}
+	}()
+}
+
+// deliverIntakeJobs delivers the queued faxes that are due
+func (a *App) deliverIntakeJobs(ctx context.Context) {
+	for {
+		jobs, err := a.Store.dueIntakeJobs(ctx, time.Now().UTC(), intakeBatch)
+		if err != nil {
+			log.Printf("Warning: could not load intake queue: %v", err)
+			return
+		}
+		for _, job := range jobs {
+			claimed, err := a.Store.claimIntakeJob(ctx, job.ID, time.Now().UTC(), intakeTimeout)
+			if err != nil {
+				log.Printf("Warning: could not claim intake job %d: %v", job.ID, err)
+				return
+			}
+			if claimed {
+				a.deliverIntakeJob(ctx, job)
+			}
+		}
+		if len(jobs) < intakeBatch {
+			return
+		}
+	}
+}
+
+// deliverIntakeJob delivers one queued fax, rescheduling it or making it a dead letter when that fails
+func (a *App) deliverIntakeJob(ctx context.Context, job intakeJob) {
+	ctx, cancel := context.WithTimeout(ctx, intakeTimeout)
+	defer cancel()
+	job.Attempts++
+	err := a.deliverIntake(ctx, job)
+	switch {
+	case err == nil:
+		job.Status, job.LastError = intakeDelivered, ""
+	case errors.As(err, &intakeRejected{}) || job.Attempts >= intakeAttempts:
+		job.Status, job.LastError = intakeDead, err.Error()
+		log.Printf("Warning: gave up delivering fax %s to intake %s: %v", job.FaxID, job.Pipeline, err)
+	default:
+		job.LastError = err.Error()
+		job.NextAttempt = time.Now().UTC().Add(min(time.Minute<<(job.Attempts-1), intakeMaxBackoff))
+	}
+	if err := a.Store.updateIntakeJob(context.Background(), job); err != nil {
+		log.Printf("Warning: could not update intake job %d: %v", job.ID, err)
+	}
+	if err != nil {
+		if err := a.Store.recordJobError(context.Background(), jobQueueIntake, job.ID, job.Attempts, err.Error()); err != nil {
+			log.Printf("Warning: could not record the error of intake job %d: %v", job.ID, err)
+		}
+	}
+}
+
+// deliverIntake builds a queued fax's payload and posts it to its pipeline's endpoint
+func (a *App) deliverIntake(ctx context.Context, job intakeJob) error {
+	pipeline := a.intakePipeline(job.Pipeline)
+	if pipeline == nil {
+		return intakeRejected{fmt.Errorf("pipeline %q is no longer configured", job.Pipeline)}
+	}
+	profile := a.profileByName(job.Profile)
+	if profile == nil {
+		return intakeRejected{fmt.Errorf("profile %q is no longer configured", job.Profile)}
+	}
+	// The webhook's link expires, so retries look the document up afresh
+	mediaURL := job.MediaURL
+	if job.Attempts > 1 {
+		mediaURL = ""
+	}
+	doc, err := a.receivedDocument(ctx, profile, job.FaxID, mediaURL)
+	if err != nil {
+		return fmt.Errorf("document: %w", err)
+	}
+	payload := intakePayload{
+		Pipeline:   job.Pipeline,
+		FaxID:      job.FaxID,
+		Profile:    job.Profile,
+		From:       job.From,
+		To:         job.To,
+		ReceivedAt: job.ReceivedAt.UTC(),
+	}
+	if payload.Pages, err = splitPages(job.FaxID, doc); err != nil {
+		return fmt.Errorf("split pages: %w", err)
+	}
+	payload.PageCount = len(payload.Pages)
+	if a.Ghostscript != "" && a.Tesseract != "" && doc.Type == "application/pdf" {
+		texts, err := ocrPages(ctx, a.Ghostscript, a.Tesseract, doc.Data)
+		if err != nil {
+			log.Printf("Warning: could not read the text of fax %s: %v", job.FaxID, err)
+		} else {
+			payload.OCR = true
+			for i := range payload.Pages {
+				if i < len(texts) {
+					payload.Pages[i].Text = texts[i]
+				}
+			}
+			payload.Text = strings.Join(texts, "\f")
+		}
+	}
+
+	body, contentType, err := intakeBody(pipeline.Format, payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pipeline.URL, body)
+	if err != nil {
+		return intakeRejected{err}
+	}
+	for k, v := range pipeline.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Idempotency-Key", job.Pipeline+":"+job.Profile+":"+job.FaxID)
+	req.Header.Set("User-Agent", "fax-ui/"+Version)
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("endpoint answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return intakeRejected{err}
+	}
+	return err
+}
+
+// splitPages splits a PDF into single-page PDFs; other documents are delivered whole, as one page
+func splitPages(faxID string, doc *document) ([]intakePage, error) {
+	if doc.Type != "application/pdf" {
+		name := "fax-" + faxID + filepath.Ext(doc.Name)
+		return []intakePage{{Number: 1, Filename: name, ContentType: doc.Type, Data: doc.Data}}, nil
+	}
+	spans, err := api.SplitRaw(bytes.NewReader(doc.Data), 1, nil)
+	if err != nil {
+		return nil, err
+	}
+	pages := make([]intakePage, len(spans))
+	for i, span := range spans {
+		data, err := io.ReadAll(span.Reader)
+		if err != nil {
+			return nil, err
+		}
+		pages[i] = intakePage{
+			Number:      span.From,
+			Filename:    fmt.Sprintf("fax-%s-page-%03d.pdf", faxID, span.From),
+			ContentType: "application/pdf",
+			Data:        data,
+		}
+	}
+	return pages, nil
+}
+
+// ocrPages renders each page of a PDF with Ghostscript and reads its text with Tesseract
+func ocrPages(ctx context.Context, gs, tesseract string, pdf []byte) ([]string, error) {
+	dir, err := os.MkdirTemp("", "fax-ui-ocr-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.pdf")
+	if err := os.WriteFile(in, pdf, 0o600); err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, gs, "-q", "-dSAFER", "-dBATCH", "-dNOPAUSE",
+		"-sDEVICE=pnggray", "-r300", "-sOutputFile="+filepath.Join(dir, "page-%04d.png"), in)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ghostscript: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// Glob sorts the zero-padded page numbers in order
+	files, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err != nil || len(files) == 0 {
+		return nil, fmt.Errorf("ghostscript rendered no pages")
+	}
+	texts := make([]string, len(files))
+	for i, file := range files {
+		var stdout bytes.Buffer
+		stderr.Reset()
+		cmd := exec.CommandContext(ctx, tesseract, file, "stdout")
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("tesseract: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		texts[i] = strings.TrimSpace(stdout.String())
+	}
+	return texts, nil
+}
+
+// intakeBody encodes a payload as JSON with base64 pages, or as multipart form data with the details in a
+// "metadata" JSON part and each page in a "page" file part
+func intakeBody(format string, payload intakePayload) (io.Reader, string, error) {
+	if format != "multipart" {
+		data, err := json.Marshal(payload)
+		return bytes.NewReader(data), "application/json", err
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	meta := payload
+	meta.Pages = make([]intakePage, len(payload.Pages))
+	for i, p := range payload.Pages {
+		p.Data = nil
+		meta.Pages[i] = p
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, "", err
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="metadata"`)
+	h.Set("Content-Type", "application/json")
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return nil, "", err
+	}
+	part.Write(data)
+	for _, p := range payload.Pages {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="page"; filename=%q`, p.Filename))
+		h.Set("Content-Type", p.ContentType)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, "", err
+		}
+		part.Write(p.Data)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return &buf, mw.FormDataContentType(), nil
+}
+
+// handleAdminIntake shows the intake queue's dead letters and waiting deliveries (GET), and retries or
+// discards a dead letter (POST action=retry|discard, id)
+func (a *App) handleAdminIntake(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		jobs, err := a.Store.intakeJobs(r.Context(), []string{intakeDead, intakePending}, auditPageSize)
+		if err != nil {
+			http.Error(w, "failed to load intake queue: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var dead, pending []intakeJob
+		for _, j := range jobs {
+			if j.Status == intakeDead {
+				dead = append(dead, j)
+			} else {
+				pending = append(pending, j)
+			}
+		}
+		a.render(w, r, "admin_intake.html", map[string]any{
+			"Pipelines": a.Intake,
+			"Dead":      dead,
+			"Pending":   pending,
+			"OCR":       a.Ghostscript != "" && a.Tesseract != "",
+			"Success":   r.URL.Query().Get("success"),
+		})
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		user, _ := a.sessionUser(r)
+		var msg string
+		switch r.FormValue("action") {
+		case "retry":
+			err = a.Store.retryIntakeJob(r.Context(), id)
+			msg = a.T(r, "Delivery queued again")
+			select {
+			case a.intakeWake <- struct{}{}:
+			default:
+			}
+		case "discard":
+			err = a.Store.deleteIntakeJob(r.Context(), id)
+			msg = a.T(r, "Delivery discarded")
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to update intake queue: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "intake." + r.FormValue("action"), Detail: strconv.FormatInt(id, 10)})
+		http.Redirect(w, r, "/admin/intake?success="+url.QueryEscape(msg), http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPPConfig configures the optional network printer. Documents printed to it are saved as fax drafts
+// of the printing user, who picks a recipient and sends them from the Drafts page.
+type IPPConfig struct {
+	Port     string // empty disables the printer
+	Name     string // printer name shown in print dialogs
+	Password string // shared password printing clients may send; defaults to the login password
+}
+
+// ippPath is where the printer lives on its port, as in ipp://host:port/ipp/print
+const ippPath = "/ipp/print"
+
+// ippMaxRequest bounds a print job, matching the send form's upload limit plus room for the IPP header
+const ippMaxRequest = 25<<20 + 64<<10
+
+// ippRecentJobs is how many finished jobs the printer remembers for clients checking on them
+const ippRecentJobs = 50
+
+// IPP delimiter and value tags (RFC 8010)
+const (
+	ippOperationGroup = 0x01
+	ippJobGroup       = 0x02
+	ippEndOfAttrs     = 0x03
+	ippPrinterGroup   = 0x04
+
+	ippTagInteger  = 0x21
+	ippTagBoolean  = 0x22
+	ippTagEnum     = 0x23
+	ippTagRange    = 0x33
+	ippTagText     = 0x41
+	ippTagName     = 0x42
+	ippTagKeyword  = 0x44
+	ippTagURI      = 0x45
+	ippTagCharset  = 0x47
+	ippTagLanguage = 0x48
+	ippTagMimeType = 0x49
+)
+
+// IPP operations the printer supports (RFC 8011)
+const (
+	ippPrintJob             = 0x0002
+	ippValidateJob          = 0x0004
+	ippCancelJob            = 0x0008
+	ippGetJobAttributes     = 0x0009
+	ippGetJobs              = 0x000A
+	ippGetPrinterAttributes = 0x000B
+)
+
+// IPP status codes
+const (
+	ippOK                    = 0x0000
+	ippBadRequest            = 0x0400
+	ippNotPossible           = 0x0404
+	ippNotFound              = 0x0406
+	ippTooLarge              = 0x0409
+	ippFormatNotSupported    = 0x040A
+	ippInternalError         = 0x0500
+	ippOperationNotSupported = 0x0501
+	ippVersionNotSupported   = 0x0503
+)
+
+// ippJobCompleted is the job-state of every job: printing finishes as soon as the draft is saved
+const ippJobCompleted = 9
+
+// ippAttr is one attribute of an IPP message. Collection members are kept as extra values; the printer
+// never needs to look inside them.
+type ippAttr struct {
+	tag    byte
+	name   string
+	values [][]byte
+}
+
+// ippRequest is a parsed IPP request and the document data following its attributes
+type ippRequest struct {
+	version   [2]byte
+	operation uint16
+	requestID uint32
+	groups    map[byte][]ippAttr
+	data      []byte
+}
+
+// parseIPP decodes an IPP request body
+func parseIPP(body []byte) (*ippRequest, error) {
+	if len(body) < 9 {
+		return nil, errors.New("truncated IPP request")
+	}
+	req := &ippRequest{
+		version:   [2]byte{body[0], body[1]},
+		operation: binary.BigEndian.Uint16(body[2:4]),
+		requestID: binary.BigEndian.Uint32(body[4:8]),
+		groups:    make(map[byte][]ippAttr),
+	}
+	var group byte
+	for off := 8; off < len(body); {
+		tag := body[off]
+		off++
+		if tag == ippEndOfAttrs {
+			req.data = body[off:]
+			return req, nil
+		}
+		if tag < 0x10 {
+			group = tag
+			continue
+		}
+		if off+2 > len(body) {
+			break
+		}
+		nameLen := int(binary.BigEndian.Uint16(body[off:]))
+		off += 2
+		if off+nameLen+2 > len(body) {
+			break
+		}
+		name := string(body[off : off+nameLen])
+		off += nameLen
+		valueLen := int(binary.BigEndian.Uint16(body[off:]))
+		off += 2
+		if off+valueLen > len(body) {
+			break
+		}
+		value := body[off : off+valueLen]
+		off += valueLen
+		attrs := req.groups[group]
+		if nameLen == 0 && len(attrs) > 0 {
+			attrs[len(attrs)-1].values = append(attrs[len(attrs)-1].values, value)
+			continue
+		}
+		req.groups[group] = append(attrs, ippAttr{tag: tag, name: name, values: [][]byte{value}})
+	}
+	return nil, errors.New("truncated IPP request")
+}
+
+// attr returns the values of an attribute in the operation or job group
+func (r *ippRequest) attr(name string) [][]byte {
+	for _, group := range []byte{ippOperationGroup, ippJobGroup} {
+		for _, a := range r.groups[group] {
+			if a.name == name {
+				return a.values
+			}
+		}
+	}
+	return nil
+}
+
+// text returns the first value of an attribute as a string
+func (r *ippRequest) text(name string) string {
+	if values := r.attr(name); len(values) > 0 {
+		return string(values[0])
+	}
+	return ""
+}
+
+// integer returns the first value of an integer or enum attribute, or 0
+func (r *ippRequest) integer(name string) int {
+	if values := r.attr(name); len(values) > 0 && len(values[0]) == 4 {
+		return int(int32(binary.BigEndian.Uint32(values[0])))
+	}
+	return 0
+}
+
+// ippResponse builds an IPP response
+type ippResponse struct {
+	bytes.Buffer
+}
+
+func newIPPResponse(version [2]byte, status uint16, requestID uint32, message string) *ippResponse {
+	res := &ippResponse{}
+	res.Write(version[:])
+	binary.Write(res, binary.BigEndian, status)
+	binary.Write(res, binary.BigEndian, requestID)
+	res.WriteByte(ippOperationGroup)
+	res.strings(ippTagCharset, "attributes-charset", "utf-8")
+	res.strings(ippTagLanguage, "attributes-natural-language", "en")
+	if message != "" {
+		res.strings(ippTagText, "status-message", message)
+	}
+	return res
+}
+
+// attr writes an attribute with one or more encoded values
+func (res *ippResponse) attr(tag byte, name string, values ...[]byte) {
+	for i, v := range values {
+		res.WriteByte(tag)
+		if i > 0 {
+			name = ""
+		}
+		binary.Write(res, binary.BigEndian, uint16(len(name)))
+		res.WriteString(name)
+		binary.Write(res, binary.BigEndian, uint16(len(v)))
+		res.Write(v)
+	}
+}
+
+func (res *ippResponse) strings(tag byte, name string, values ...string) {
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		encoded[i] = []byte(v)
+	}
+	res.attr(tag, name, encoded...)
+}
+
+func (res *ippResponse) integers(tag byte, name string, values ...int) {
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		encoded[i] = binary.BigEndian.AppendUint32(nil, uint32(int32(v)))
+	}
+	res.attr(tag, name, encoded...)
+}
+
+func (res *ippResponse) boolean(name string, v bool) {
+	b := byte(0)
+	if v {
+		b = 1
+	}
+	res.attr(ippTagBoolean, name, []byte{b})
+}
+
+func (res *ippResponse) end() []byte {
+	res.WriteByte(ippEndOfAttrs)
+	return res.Bytes()
+}
+
+// ippJob is a finished print job, kept so clients can check on it
+type ippJob struct {
+	ID      int
+	Name    string
+	User    string // requesting-user-name as the client sent it
+	Owner   string // identity whose drafts the document went to
+	DraftID string
+	At      time.Time
+}
+
+// ippPrinter serves the IPP printer
+type ippPrinter struct {
+	app      *App
+	name     string
+	password string // shared HTTP Basic password for printing; empty when only API tokens are accepted
+	tls      bool   // served as ipps, so clients may send credentials
+	uuid     string
+	started  time.Time
+
+	mu     sync.Mutex
+	nextID int
+	jobs   []ippJob // recent jobs, oldest first
+}
+
+func newIPPPrinter(a *App, cfg IPPConfig, tls bool) *ippPrinter {
+	sum := sha256.Sum256([]byte(a.PublicBaseURL() + "\x00" + cfg.Name))
+	sum[6], sum[8] = sum[6]&0x0f|0x50, sum[8]&0x3f|0x80 // a stable name-based UUID
+	return &ippPrinter{
+		app:      a,
+		name:     cfg.Name,
+		password: firstNonEmpty(cfg.Password, a.AuthConfig.Password),
+		tls:      tls,
+		uuid:     fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16]),
+		started:  time.Now(),
+		nextID:   1,
+	}
+}
+
+// serveIPP runs the printer on its own port, with the same server limits as the pages, and advertises
+// it on the local network. With a certificate it is served as ipps, which sign-in requires, since
+// printing clients send their password with every job.
+func (a *App) serveIPP(cfg IPPConfig, sc ServerConfig, tlsCert, tlsKey string) {
+	switch {
+	case a.Hipaa:
+		log.Println("Warning: the IPP printer is off in HIPAA mode, because printed documents would be stored in drafts")
+		return
+	case a.hasAuthConfigured() && tlsCert == "":
+		log.Println("Warning: the IPP printer needs TLS_CERT when sign-in is configured, so printing clients do not send passwords in the clear; printer not started")
+		return
+	}
+	p := newIPPPrinter(a, cfg, tlsCert != "")
+	go p.advertise(cfg.Port)
+	srv := newHTTPServer(sc, logRequests(p))
+	srv.Addr = ":" + cfg.Port
+	var err error
+	if p.tls {
+		log.Printf("IPP printer %q listening on ipps://localhost:%s%s", p.name, cfg.Port, ippPath)
+		err = srv.ListenAndServeTLS(tlsCert, tlsKey)
+	} else {
+		log.Printf("IPP printer %q listening on ipp://localhost:%s%s", p.name, cfg.Port, ippPath)
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
+		log.Fatalf("IPP printer error: %v", err)
+	}
+}
+
+func (p *ippPrinter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// Someone opening the printer in a browser wants the drafts it fills
+		http.Redirect(w, r, p.app.PublicBaseURL()+"/drafts", http.StatusSeeOther)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/ipp" {
+		http.Error(w, "expected application/ipp", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, ippMaxRequest))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) && len(body) >= 8 {
+		req := &ippRequest{version: [2]byte{body[0], body[1]}, requestID: binary.BigEndian.Uint32(body[4:8])}
+		p.reply(w, newIPPResponse(req.version, ippTooLarge, req.requestID, "document is larger than 25 MB"))
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+	req, err := parseIPP(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.version[0] < 1 || req.version[0] > 2 {
+		p.reply(w, newIPPResponse([2]byte{2, 0}, ippVersionNotSupported, req.requestID, ""))
+		return
+	}
+
+	switch req.operation {
+	case ippGetPrinterAttributes:
+		res := newIPPResponse(req.version, ippOK, req.requestID, "")
+		p.printerAttributes(res, r, req.attr("requested-attributes"))
+		p.reply(w, res)
+	case ippValidateJob:
+		status, msg := p.checkFormat(req)
+		p.reply(w, newIPPResponse(req.version, status, req.requestID, msg))
+	case ippPrintJob, ippGetJobAttributes, ippGetJobs, ippCancelJob:
+		// Anyone may look the printer up, but jobs belong to signed-in users
+		owner, ok := p.owner(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fax-ui"`)
+			http.Error(w, "sign in to print", http.StatusUnauthorized)
+			return
+		}
+		p.reply(w, p.jobOperation(r, req, owner))
+	default:
+		p.reply(w, newIPPResponse(req.version, ippOperationNotSupported, req.requestID, ""))
+	}
+}
+
+func (p *ippPrinter) reply(w http.ResponseWriter, res *ippResponse) {
+	w.Header().Set("Content-Type", "application/ipp")
+	w.Write(res.end())
+}
+
+// owner checks the client's credentials and returns the identity whose drafts its jobs go to. A user
+// prints as themselves with one of their API tokens as the password; the shared password prints as the
+// password login. Credentials are only taken over TLS.
+func (p *ippPrinter) owner(r *http.Request) (string, bool) {
+	if !p.app.hasAuthConfigured() {
+		return "", true
+	}
+	_, pass, ok := r.BasicAuth()
+	if !ok || r.TLS == nil {
+		return "", false
+	}
+	if token := strings.TrimSpace(pass); strings.HasPrefix(token, apiTokenPrefix) {
+		t, found, err := p.app.Store.useAPIToken(r.Context(), hashSessionToken(token))
+		if err != nil {
+			log.Printf("Warning: could not look up API token: %v", err)
+			return "", false
+		}
+		return t.User, found
+	}
+	if p.password == "" || subtle.ConstantTimeCompare([]byte(pass), []byte(p.password)) != 1 {
+		return "", false
+	}
+	return "password", true
+}
+
+// checkFormat reports whether the job's document format is one the printer takes
+func (p *ippPrinter) checkFormat(req *ippRequest) (uint16, string) {
+	switch req.text("document-format") {
+	case "", "application/pdf", "application/octet-stream":
+		return ippOK, ""
+	}
+	return ippFormatNotSupported, "only PDF documents can be faxed"
+}
+
+// jobOperation handles the operations on jobs for an authenticated owner
+func (p *ippPrinter) jobOperation(r *http.Request, req *ippRequest, owner string) *ippResponse {
+	switch req.operation {
+	case ippPrintJob:
+		return p.printJob(r, req, owner)
+	case ippGetJobs:
+		res := newIPPResponse(req.version, ippOK, req.requestID, "")
+		// Jobs finish as soon as they arrive, so only "completed" lists any
+		if req.text("which-jobs") == "completed" {
+			for _, job := range p.recentJobs(owner) {
+				res.WriteByte(ippJobGroup)
+				p.jobAttributes(res, r, job)
+			}
+		}
+		return res
+	}
+	id := req.integer("job-id")
+	if id == 0 {
+		fmt.Sscanf(path.Base(req.text("job-uri")), "%d", &id)
+	}
+	job, ok := p.job(owner, id)
+	if !ok {
+		return newIPPResponse(req.version, ippNotFound, req.requestID, "no such job")
+	}
+	if req.operation == ippCancelJob {
+		return newIPPResponse(req.version, ippNotPossible, req.requestID, "the job is already saved as a draft; delete the draft instead")
+	}
+	res := newIPPResponse(req.version, ippOK, req.requestID, "")
+	res.WriteByte(ippJobGroup)
+	p.jobAttributes(res, r, job)
+	return res
+}
+
+// printJob saves the printed document as a draft of the owner
+func (p *ippPrinter) printJob(r *http.Request, req *ippRequest, owner string) *ippResponse {
+	if status, msg := p.checkFormat(req); status != ippOK {
+		return newIPPResponse(req.version, status, req.requestID, msg)
+	}
+	if !bytes.HasPrefix(req.data, []byte("%PDF-")) {
+		return newIPPResponse(req.version, ippFormatNotSupported, req.requestID, "only PDF documents can be faxed")
+	}
+	name := strings.TrimSpace(firstNonEmpty(req.text("document-name"), req.text("job-name"), "Printed document"))
+	fileName := sanitizeFilename(name)
+	if !strings.EqualFold(path.Ext(fileName), ".pdf") {
+		fileName += ".pdf"
+	}
+	id, err := generateSecureToken(16)
+	if err != nil {
+		return newIPPResponse(req.version, ippInternalError, req.requestID, "failed to create draft")
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	d := &draft{
+		ID:      id,
+		Owner:   owner,
+		Profile: p.app.SendProfile,
+		File:    &document{Name: fileName, Type: "application/pdf", Data: req.data},
+	}
+	if err := p.app.Store.saveDraft(ctx, d); err != nil {
+		log.Printf("Warning: could not save printed document as a draft: %v", err)
+		return newIPPResponse(req.version, ippInternalError, req.requestID, "failed to save draft")
+	}
+
+	p.mu.Lock()
+	job := ippJob{ID: p.nextID, Name: name, User: req.text("requesting-user-name"), Owner: owner, DraftID: id, At: time.Now()}
+	p.nextID++
+	p.jobs = append(p.jobs, job)
+	if len(p.jobs) > ippRecentJobs {
+		p.jobs = p.jobs[len(p.jobs)-ippRecentJobs:]
+	}
+	p.mu.Unlock()
+
+	res := newIPPResponse(req.version, ippOK, req.requestID, "Saved as a fax draft; open Drafts in fax-ui to send it")
+	res.WriteByte(ippJobGroup)
+	p.jobAttributes(res, r, job)
+	return res
+}
+
+func (p *ippPrinter) job(owner string, id int) (ippJob, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, job := range p.jobs {
+		if job.ID == id && job.Owner == owner {
+			return job, true
+		}
+	}
+	return ippJob{}, false
+}
+
+// recentJobs returns the owner's remembered jobs, newest first
+func (p *ippPrinter) recentJobs(owner string) []ippJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var jobs []ippJob
+	for i := len(p.jobs) - 1; i >= 0; i-- {
+		if p.jobs[i].Owner == owner {
+			jobs = append(jobs, p.jobs[i])
+		}
+	}
+	return jobs
+}
+
+func (p *ippPrinter) printerURI(r *http.Request) string {
+	if p.tls {
+		return "ipps://" + r.Host + ippPath
+	}
+	return "ipp://" + r.Host + ippPath
+}
+
+// upTime is the printer's clock for job timestamps: seconds since it started
+func (p *ippPrinter) upTime(t time.Time) int {
+	return max(int(t.Sub(p.started).Seconds()), 1)
+}
+
+func (p *ippPrinter) jobAttributes(res *ippResponse, r *http.Request, job ippJob) {
+	res.integers(ippTagInteger, "job-id", job.ID)
+	res.strings(ippTagURI, "job-uri", fmt.Sprintf("%s/%d", p.printerURI(r), job.ID))
+	res.strings(ippTagURI, "job-printer-uri", p.printerURI(r))
+	res.strings(ippTagName, "job-name", job.Name)
+	res.strings(ippTagName, "job-originating-user-name", firstNonEmpty(job.User, job.Owner, "anonymous"))
+	res.integers(ippTagEnum, "job-state", ippJobCompleted)
+	res.strings(ippTagKeyword, "job-state-reasons", "job-completed-successfully")
+	res.strings(ippTagText, "job-state-message", "Saved as a fax draft")
+	res.integers(ippTagInteger, "time-at-creation", p.upTime(job.At))
+	res.integers(ippTagInteger, "time-at-completed", p.upTime(job.At))
+}
+
+// printerAttributes writes the printer description, all of it unless the client asked for particular attributes
+func (p *ippPrinter) printerAttributes(res *ippResponse, r *http.Request, requested [][]byte) {
+	want := func(string) bool { return true }
+	if len(requested) > 0 {
+		names := make(map[string]bool)
+		for _, v := range requested {
+			names[string(v)] = true
+		}
+		if !names["all"] && !names["printer-description"] && !names["job-template"] && !names["printer-status"] {
+			want = func(name string) bool { return names[name] }
+		}
+	}
+	authentication, security := "none", "none"
+	if p.app.hasAuthConfigured() {
+		authentication = "basic"
+	}
+	if p.tls {
+		security = "tls"
+	}
+	res.WriteByte(ippPrinterGroup)
+	for _, a := range []struct {
+		tag    byte
+		name   string
+		values []any
+	}{
+		{ippTagURI, "printer-uri-supported", []any{p.printerURI(r)}},
+		{ippTagKeyword, "uri-authentication-supported", []any{authentication}},
+		{ippTagKeyword, "uri-security-supported", []any{security}},
+		{ippTagName, "printer-name", []any{p.name}},
+		{ippTagText, "printer-info", []any{p.name}},
+		{ippTagText, "printer-make-and-model", []any{"fax-ui " + Version}},
+		{ippTagURI, "printer-more-info", []any{p.app.PublicBaseURL() + "/drafts"}},
+		{ippTagURI, "printer-uuid", []any{"urn:uuid:" + p.uuid}},
+		{ippTagEnum, "printer-state", []any{3}}, // idle
+		{ippTagKeyword, "printer-state-reasons", []any{"none"}},
+		{ippTagInteger, "printer-up-time", []any{p.upTime(time.Now())}},
+		{ippTagInteger, "queued-job-count", []any{0}},
+		{ippTagKeyword, "ipp-versions-supported", []any{"1.1", "2.0"}},
+		{ippTagEnum, "operations-supported", []any{ippPrintJob, ippValidateJob, ippCancelJob, ippGetJobAttributes, ippGetJobs, ippGetPrinterAttributes}},
+		{ippTagCharset, "charset-configured", []any{"utf-8"}},
+		{ippTagCharset, "charset-supported", []any{"utf-8"}},
+		{ippTagLanguage, "natural-language-configured", []any{"en"}},
+		{ippTagLanguage, "generated-natural-language-supported", []any{"en"}},
+		{ippTagMimeType, "document-format-default", []any{"application/pdf"}},
+		{ippTagMimeType, "document-format-supported", []any{"application/pdf", "application/octet-stream"}},
+		{ippTagKeyword, "pdl-override-supported", []any{"not-attempted"}},
+		{ippTagKeyword, "compression-supported", []any{"none"}},
+		{ippTagKeyword, "media-default", []any{"na_letter_8.5x11in"}},
+		{ippTagKeyword, "media-supported", []any{"na_letter_8.5x11in", "iso_a4_210x297mm", "na_legal_8.5x14in"}},
+		{ippTagKeyword, "sides-default", []any{"one-sided"}},
+		{ippTagKeyword, "sides-supported", []any{"one-sided"}},
+		{ippTagKeyword, "print-color-mode-default", []any{"monochrome"}},
+		{ippTagKeyword, "print-color-mode-supported", []any{"monochrome"}},
+		{ippTagInteger, "copies-default", []any{1}},
+	} {
+		if !want(a.name) {
+			continue
+		}
+		switch a.values[0].(type) {
+		case int:
+			ints := make([]int, len(a.values))
+			for i, v := range a.values {
+				ints[i] = v.(int)
+			}
+			res.integers(a.tag, a.name, ints...)
+		default:
+			strs := make([]string, len(a.values))
+			for i, v := range a.values {
+				strs[i] = v.(string)
+			}
+			res.strings(a.tag, a.name, strs...)
+		}
+	}
+	if want("color-supported") {
+		res.boolean("color-supported", false)
+	}
+	if want("printer-is-accepting-jobs") {
+		res.boolean("printer-is-accepting-jobs", true)
+	}
+	if want("multiple-document-jobs-supported") {
+		res.boolean("multiple-document-jobs-supported", false)
+	}
+	if want("copies-supported") {
+		res.attr(ippTagRange, "copies-supported", binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 1), 1))
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The printer is advertised with multicast DNS service discovery (RFC 6762, RFC 6763), the way
+// AirPrint and IPP Everywhere printers are, so print dialogs on the local network list it.
+const (
+	mdnsTTL        = 120
+	mdnsService    = "_ipp._tcp.local"
+	mdnsServiceTLS = "_ipps._tcp.local"
+	mdnsServices   = "_services._dns-sd._udp.local"
+	mdnsTypeA      = 1
+	mdnsTypePTR    = 12
+	mdnsTypeTXT    = 16
+	mdnsTypeSRV    = 33
+	mdnsClassIN    = 1
+	mdnsCacheFlush = 0x8000
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// advertise answers mDNS queries for the printer until the socket fails
+func (p *ippPrinter) advertise(port string) {
+	portNum, _ := strconv.Atoi(port)
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		log.Printf("Warning: could not advertise the IPP printer on the local network: %v", err)
+		return
+	}
+	defer conn.Close()
+	host := mdnsHostname()
+	// Announce twice on startup, as RFC 6762 section 8.3 asks
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		if _, err := conn.WriteToUDP(p.mdnsResponse(host, portNum), mdnsGroup); err != nil {
+			log.Printf("Warning: could not advertise the IPP printer on the local network: %v", err)
+			return
+		}
+	}
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Printf("Warning: stopped advertising the IPP printer: %v", err)
+			return
+		}
+		if !p.mdnsAsked(buf[:n], host) {
+			continue
+		}
+		// Queries from a port other than 5353 come from simple resolvers expecting a unicast reply
+		dst := mdnsGroup
+		if src.Port != mdnsGroup.Port {
+			dst = src
+		}
+		conn.WriteToUDP(p.mdnsResponse(host, portNum), dst)
+	}
+}
+
+// mdnsHostname is the .local name the printer's address records are published under
+func mdnsHostname() string {
+	name, _ := os.Hostname()
+	name, _, _ = strings.Cut(name, ".")
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return -1
+	}, name)
+	return firstNonEmpty(name, "fax-ui") + ".local"
+}
+
+// mdnsServiceType is the service the printer is advertised as: ipps when it is served over TLS
+func (p *ippPrinter) mdnsServiceType() string {
+	if p.tls {
+		return mdnsServiceTLS
+	}
+	return mdnsService
+}
+
+func (p *ippPrinter) mdnsInstance() string {
+	return p.name + "." + p.mdnsServiceType()
+}
+
+// mdnsAsked reports whether a query asks about the printer's service, instance or host
+func (p *ippPrinter) mdnsAsked(msg []byte, host string) bool {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[2:])&0x8000 != 0 {
+		return false // too short, or a response
+	}
+	wanted := map[string]bool{
+		strings.ToLower(p.mdnsServiceType()): true,
+		strings.ToLower(mdnsServices):        true,
+		strings.ToLower(p.mdnsInstance()):    true,
+		strings.ToLower(host):                true,
+	}
+	off := 12
+	for range binary.BigEndian.Uint16(msg[4:]) {
+		name, next, err := readDNSName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return false
+		}
+		off = next + 4 // type and class
+		if wanted[strings.ToLower(name)] {
+			return true
+		}
+	}
+	return false
+}
+
+// readDNSName reads a possibly compressed name at off, returning it and the offset after it
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("truncated name")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("bad name pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errors.New("truncated label")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// appendDNSName encodes a dotted name
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(name, ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// mdnsResponse announces the printer: which service it offers, where it listens and what it prints
+func (p *ippPrinter) mdnsResponse(host string, port int) []byte {
+	authentication := "none"
+	if p.app.hasAuthConfigured() {
+		authentication = "username,password"
+	}
+	var txt []byte
+	for _, kv := range []string{
+		"txtvers=1", "qtotal=1", "rp=" + strings.TrimPrefix(ippPath, "/"), "ty=" + p.name,
+		"product=(fax-ui)", "pdl=application/pdf", "adminurl=" + p.app.PublicBaseURL() + "/drafts",
+		"UUID=" + p.uuid, "Color=F", "Duplex=F", "air=" + authentication,
+	} {
+		txt = append(txt, byte(len(kv)))
+		txt = append(txt, kv...)
+	}
+	srv := binary.BigEndian.AppendUint16(nil, 0) // priority
+	srv = binary.BigEndian.AppendUint16(srv, 0)  // weight
+	srv = binary.BigEndian.AppendUint16(srv, uint16(port))
+	srv = appendDNSName(srv, host)
+
+	type record struct {
+		name  []byte
+		typ   uint16
+		class uint16
+		data  []byte
+	}
+	// The instance name is a single label, spaces and all
+	service := p.mdnsServiceType()
+	instance := appendDNSName(append([]byte{byte(len(p.name))}, p.name...), service)
+	records := []record{
+		{appendDNSName(nil, mdnsServices), mdnsTypePTR, mdnsClassIN, appendDNSName(nil, service)},
+		{appendDNSName(nil, service), mdnsTypePTR, mdnsClassIN, instance},
+		{instance, mdnsTypeSRV, mdnsClassIN | mdnsCacheFlush, srv},
+		{instance, mdnsTypeTXT, mdnsClassIN | mdnsCacheFlush, txt},
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+				if ip4 := ipnet.IP.To4(); ip4 != nil {
+					records = append(records, record{appendDNSName(nil, host), mdnsTypeA, mdnsClassIN | mdnsCacheFlush, ip4})
+				}
+			}
+		}
+	}
+
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400) // response, authoritative
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(records)))
+	for _, rec := range records {
+		msg = append(msg, rec.name...)
+		msg = binary.BigEndian.AppendUint16(msg, rec.typ)
+		msg = binary.BigEndian.AppendUint16(msg, rec.class)
+		msg = binary.BigEndian.AppendUint32(msg, mdnsTTL)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rec.data)))
+		msg = append(msg, rec.data...)
+	}
+	return msg
+}
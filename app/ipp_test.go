@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ippMessage encodes a request: the header, an operation group with attributes-charset, the end tag
+// and the document
+func ippMessage(operation uint16, requestID uint32, doc string) []byte {
+	b := []byte{2, 0}
+	b = binary.BigEndian.AppendUint16(b, operation)
+	b = binary.BigEndian.AppendUint32(b, requestID)
+	b = append(b, ippOperationGroup)
+	b = appendIPPAttr(b, ippTagCharset, "attributes-charset", "utf-8")
+	b = appendIPPAttr(b, ippTagName, "job-name", "Letter")
+	b = appendIPPAttr(b, ippTagName, "", "second value")
+	b = append(b, ippEndOfAttrs)
+	return append(b, doc...)
+}
+
+func appendIPPAttr(b []byte, tag byte, name, value string) []byte {
+	b = append(b, tag)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(name)))
+	b = append(b, name...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+func TestParseIPP(t *testing.T) {
+	msg := ippMessage(ippPrintJob, 42, "%PDF-1.7")
+	req, err := parseIPP(msg)
+	if err != nil {
+		t.Fatalf("parseIPP: %v", err)
+	}
+	if req.operation != ippPrintJob || req.requestID != 42 || string(req.data) != "%PDF-1.7" {
+		t.Fatalf("parsed operation %#x, request %d, data %q", req.operation, req.requestID, req.data)
+	}
+	if req.text("attributes-charset") != "utf-8" || len(req.attr("job-name")) != 2 {
+		t.Fatalf("parsed attributes %+v", req.groups)
+	}
+
+	// Offset of the job-name attribute's name length, to corrupt it
+	jobName := 8 + 1 + 3 + len("attributes-charset") + 2 + len("utf-8") + 1
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"empty", nil},
+		{"header only", msg[:8]},
+		{"group without end", msg[:9]},
+		{"cut in a name length", msg[:jobName+1]},
+		{"cut in a name", msg[:jobName+4]},
+		{"cut in a value", msg[:jobName+2+len("job-name")+3]},
+		{"no end tag", msg[:len(msg)-len("%PDF-1.7")-1]},
+		{"name past the end", append(append([]byte{}, msg[:jobName]...), 0xff, 0xff)},
+		{"value past the end", appendIPPAttr(append([]byte{}, msg[:9]...), ippTagName, "x", "")[:9+3+1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if req, err := parseIPP(tt.body); err == nil {
+				t.Fatalf("parsed %+v", req)
+			}
+		})
+	}
+}
+
+func TestReadDNSName(t *testing.T) {
+	service := appendDNSName(nil, "_ipps._tcp.local")
+	compressed := append(append([]byte{}, service...), 7, 'p', 'r', 'i', 'n', 't', 'e', 'r', 0xC0, 0)
+	tests := []struct {
+		name    string
+		msg     []byte
+		off     int
+		want    string
+		wantEnd int
+		wantErr bool
+	}{
+		{"plain", service, 0, "_ipps._tcp.local", len(service), false},
+		{"root", []byte{0}, 0, "", 1, false},
+		{"compressed", compressed, len(service), "printer._ipps._tcp.local", len(compressed), false},
+		{"pointer loop", []byte{0xC0, 0}, 0, "", 0, true},
+		{"pointers to each other", []byte{0xC0, 2, 0xC0, 0}, 0, "", 0, true},
+		{"pointer past the end", []byte{0xC0, 9}, 0, "", 0, true},
+		{"truncated pointer", []byte{3, 'a', 'b', 'c', 0xC0}, 0, "", 0, true},
+		{"truncated label", []byte{5, 'a', 'b'}, 0, "", 0, true},
+		{"no terminator", []byte{3, 'a', 'b', 'c'}, 0, "", 0, true},
+		{"offset past the end", service, len(service) + 3, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, end, err := readDNSName(tt.msg, tt.off)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("read %q", name)
+				}
+				return
+			}
+			if err != nil || name != tt.want || end != tt.wantEnd {
+				t.Fatalf("read %q ending at %d (%v), want %q ending at %d", name, end, err, tt.want, tt.wantEnd)
+			}
+		})
+	}
+}
+
+// mdnsQuery encodes a query with one question per name
+func mdnsQuery(names ...string) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(names)))
+	for _, name := range names {
+		msg = appendDNSName(msg, name)
+		msg = binary.BigEndian.AppendUint16(msg, mdnsTypePTR)
+		msg = binary.BigEndian.AppendUint16(msg, mdnsClassIN)
+	}
+	return msg
+}
+
+func TestMDNSResponder(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	p := newIPPPrinter(app, IPPConfig{Name: "Fax via fax-ui"}, true)
+	const host = "faxhost.local"
+
+	response := mdnsQuery("_ipps._tcp.local")
+	binary.BigEndian.PutUint16(response[2:], 0x8400)
+	loop := mdnsQuery("x")
+	loop = append(loop[:12], 0xC0, 12, 0, mdnsTypePTR, 0, mdnsClassIN)
+	tests := []struct {
+		name string
+		msg  []byte
+		want bool
+	}{
+		{"service", mdnsQuery("_ipps._tcp.local"), true},
+		{"service in capitals", mdnsQuery("_IPPS._TCP.local"), true},
+		{"all services", mdnsQuery("_services._dns-sd._udp.local"), true},
+		{"instance", mdnsQuery("Fax via fax-ui._ipps._tcp.local"), true},
+		{"host", mdnsQuery(host), true},
+		{"second question", mdnsQuery("_http._tcp.local", host), true},
+		{"plain ipp", mdnsQuery("_ipp._tcp.local"), false},
+		{"other service", mdnsQuery("_http._tcp.local"), false},
+		{"response", response, false},
+		{"short", []byte{0, 0, 0, 0}, false},
+		{"no questions", mdnsQuery()[:12], false},
+		{"more questions than sent", mdnsQuery("_http._tcp.local", host)[:12+len(appendDNSName(nil, "_http._tcp.local"))+4], false},
+		{"truncated type", mdnsQuery("_ipps._tcp.local")[:12+len(appendDNSName(nil, "_ipps._tcp.local"))+2], false},
+		{"truncated name", mdnsQuery("_ipps._tcp.local")[:16], false},
+		{"pointer loop", loop, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.mdnsAsked(tt.msg, host); got != tt.want {
+				t.Fatalf("mdnsAsked = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The announcement is a well-formed response naming the service and the printer
+	msg := p.mdnsResponse(host, 8631)
+	if binary.BigEndian.Uint16(msg[2:])&0x8000 == 0 {
+		t.Fatal("announcement is not a response")
+	}
+	off, names := 12, map[string]bool{}
+	for i := range binary.BigEndian.Uint16(msg[6:]) {
+		name, next, err := readDNSName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		names[name] = true
+		off = next + 10 + int(binary.BigEndian.Uint16(msg[next+8:]))
+	}
+	if off != len(msg) {
+		t.Fatalf("records end at %d of %d bytes", off, len(msg))
+	}
+	if !names["_ipps._tcp.local"] || !names["Fax via fax-ui._ipps._tcp.local"] {
+		t.Fatalf("announced %v", names)
+	}
+}
+
+func TestIPPOwner(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	p := newIPPPrinter(app, IPPConfig{Name: "Fax via fax-ui"}, true)
+	owner := func(target, user, pass string) (string, bool) {
+		r := httptest.NewRequest("POST", target, nil)
+		if user != "" || pass != "" {
+			r.SetBasicAuth(user, pass)
+		}
+		return p.owner(r)
+	}
+	if user, ok := owner("https://printer"+ippPath, "", ""); !ok || user != "" {
+		t.Fatalf("without sign-in: %q, %v", user, ok)
+	}
+
+	app.AuthConfig.Password = "shared-pw"
+	p = newIPPPrinter(app, IPPConfig{Name: "Fax via fax-ui"}, true)
+	token := apiTokenPrefix + "printing"
+	err := app.Store.createAPIToken(context.Background(), apiToken{Hash: hashSessionToken(token), User: "alice@example.com", Name: "printer", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, target, user, pass string
+		want                     string
+		ok                       bool
+	}{
+		{"API token", "https://printer", "anyone", token, "alice@example.com", true},
+		{"shared password", "https://printer", "printer", "shared-pw", "password", true},
+		{"shared password claiming a user", "https://printer", "alice@example.com", "shared-pw", "password", true},
+		{"API token without TLS", "http://printer", "anyone", token, "", false},
+		{"shared password without TLS", "http://printer", "printer", "shared-pw", "", false},
+		{"unknown API token", "https://printer", "anyone", apiTokenPrefix + "revoked", "", false},
+		{"wrong password", "https://printer", "printer", "guess", "", false},
+		{"no credentials", "https://printer", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, ok := owner(tt.target+ippPath, tt.user, tt.pass)
+			if user != tt.want || ok != tt.ok {
+				t.Fatalf("owner = %q, %v; want %q, %v", user, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	// Without a shared password only API tokens print
+	p.password = ""
+	if user, ok := owner("https://printer"+ippPath, "printer", ""); ok {
+		t.Fatalf("empty password printed as %q", user)
+	}
+	if !strings.HasPrefix(p.printerURI(httptest.NewRequest("POST", "https://printer:8631"+ippPath, nil)), "ipps://") {
+		t.Fatal("printer URI is not ipps")
+	}
+}
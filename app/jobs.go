@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// fax-ui hands faxes to the carrier as they are sent, so its queues are the work that follows: intake
+// deliveries and cloud archive uploads. /admin/jobs shows both together, with the error of every failed
+// attempt, so an operator can see what a run of failures has in common, fix it, and requeue them at once.
+
+// Job queues, as recorded in job_errors
+const (
+	jobQueueIntake = "intake"
+	jobQueueCloud  = "cloud"
+)
+
+// jobQueues are the tables of the job queues, with the states of their waiting and dead jobs
+var jobQueues = map[string]struct{ table, pending, dead string }{
+	jobQueueIntake: {"intake_jobs", intakePending, intakeDead},
+	jobQueueCloud:  {"cloud_uploads", cloudPending, cloudFailed},
+}
+
+// Job states on the dashboard: a waiting job is queued until its first attempt fails, then retrying
+const (
+	jobQueued   = "queued"
+	jobRetrying = "retrying"
+	jobDead     = "dead"
+)
+
+// jobError is the error of one failed attempt at a job
+type jobError struct {
+	Attempt int
+	Error   string
+	At      time.Time
+}
+
+// queuedJob is an intake delivery or cloud archive upload as shown on the dashboard
+type queuedJob struct {
+	Queue       string
+	ID          int64
+	Target      string // intake pipeline, or the direction archived
+	Profile     string
+	FaxID       string
+	State       string
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time
+	UpdatedAt   time.Time
+	Errors      []jobError
+}
+
+// queuedJobs returns the waiting and dead jobs of the configured queues, most recently updated first
+func (a *App) queuedJobs(ctx context.Context) ([]queuedJob, error) {
+	var jobs []queuedJob
+	state := func(pending bool, attempts int) string {
+		switch {
+		case !pending:
+			return jobDead
+		case attempts > 0:
+			return jobRetrying
+		}
+		return jobQueued
+	}
+	if len(a.Intake) > 0 {
+		intake, err := a.Store.intakeJobs(ctx, []string{intakeDead, intakePending}, auditPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, j := range intake {
+			jobs = append(jobs, queuedJob{Queue: jobQueueIntake, ID: j.ID, Target: j.Pipeline, Profile: j.Profile, FaxID: j.FaxID,
+				State: state(j.Status == intakePending, j.Attempts), Attempts: j.Attempts, LastError: j.LastError,
+				NextAttempt: j.NextAttempt, UpdatedAt: j.UpdatedAt})
+		}
+	}
+	if a.Cloud != nil {
+		uploads, err := a.Store.cloudUploads(ctx, []string{cloudFailed, cloudPending}, auditPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range uploads {
+			jobs = append(jobs, queuedJob{Queue: jobQueueCloud, ID: u.ID, Target: u.Direction, Profile: u.Profile, FaxID: u.FaxID,
+				State: state(u.Status == cloudPending, u.Attempts), Attempts: u.Attempts, LastError: u.LastError,
+				NextAttempt: u.NextAttempt, UpdatedAt: u.UpdatedAt})
+		}
+	}
+	for queue := range jobQueues {
+		var ids []int64
+		for _, j := range jobs {
+			if j.Queue == queue {
+				ids = append(ids, j.ID)
+			}
+		}
+		errs, err := a.Store.jobErrors(ctx, queue, ids)
+		if err != nil {
+			return nil, err
+		}
+		for i := range jobs {
+			if jobs[i].Queue == queue {
+				jobs[i].Errors = errs[jobs[i].ID]
+			}
+		}
+	}
+	slices.SortStableFunc(jobs, func(x, y queuedJob) int { return y.UpdatedAt.Compare(x.UpdatedAt) })
+	return jobs, nil
+}
+
+// wakeJobs wakes the worker of a queue after jobs were requeued
+func (a *App) wakeJobs(queue string) {
+	wake := a.intakeWake
+	if queue == jobQueueCloud && a.Cloud != nil {
+		wake = a.Cloud.wake
+	}
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// handleAdminJobs shows the queued, retrying and dead jobs (GET, optionally state=queued|retrying|dead),
+// and requeues or discards one (POST action=requeue|discard, queue, id) or requeues every dead job of a
+// queue (POST action=requeue_dead, queue)
+func (a *App) handleAdminJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		jobs, err := a.queuedJobs(r.Context())
+		if err != nil {
+			http.Error(w, "failed to load job queues: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		counts := map[string]int{}
+		deadByQueue := map[string]int{}
+		for _, j := range jobs {
+			counts[j.State]++
+			if j.State == jobDead {
+				deadByQueue[j.Queue]++
+			}
+		}
+		filter := r.URL.Query().Get("state")
+		if filter != "" {
+			jobs = slices.DeleteFunc(jobs, func(j queuedJob) bool { return j.State != filter })
+		}
+		a.render(w, r, "admin_jobs.html", map[string]any{
+			"Jobs":    jobs,
+			"Counts":  counts,
+			"Dead":    deadByQueue,
+			"Filter":  filter,
+			"Intake":  len(a.Intake) > 0,
+			"Cloud":   a.Cloud != nil,
+			"Success": r.URL.Query().Get("success"),
+			"Error":   r.URL.Query().Get("error"),
+		})
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		queue := r.FormValue("queue")
+		if _, ok := jobQueues[queue]; !ok {
+			http.Error(w, "unknown queue", http.StatusBadRequest)
+			return
+		}
+		back := func(key, msg string) {
+			q := url.Values{key: {msg}}
+			if state := r.FormValue("state"); state != "" {
+				q.Set("state", state)
+			}
+			http.Redirect(w, r, "/admin/jobs?"+q.Encode(), http.StatusSeeOther)
+		}
+		user, _ := a.sessionUser(r)
+		action := r.FormValue("action")
+		if action == "requeue_dead" {
+			n, err := a.Store.requeueDeadJobs(r.Context(), queue)
+			if err != nil {
+				http.Error(w, "failed to update job queue: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			a.wakeJobs(queue)
+			a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "jobs.requeue_dead", Detail: queue + ": " + strconv.FormatInt(n, 10) + " dead jobs"})
+			back("success", a.T(r, "%d dead jobs queued again", n))
+			return
+		}
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var ok bool
+		var msg string
+		switch action {
+		case "requeue":
+			ok, err = a.Store.requeueJob(r.Context(), queue, id)
+			msg = a.T(r, "Job queued again")
+			a.wakeJobs(queue)
+		case "discard":
+			ok, err = a.Store.discardJob(r.Context(), queue, id, true)
+			msg = a.T(r, "Job discarded")
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to update job queue: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			back("error", a.T(r, "The job is no longer waiting; it may have gone through meanwhile."))
+			return
+		}
+		a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "jobs." + action, Detail: queue + " job " + strconv.FormatInt(id, 10)})
+		back("success", msg)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
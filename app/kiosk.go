@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Shared front-desk computers can sign in with kiosk accounts. A kiosk only gets the send form, with the
+// from number, profile and cover page fixed by the configuration and the history, drafts, scans and
+// settings pages out of reach. Each send signs the kiosk out, so the next person starts from the sign-in
+// page instead of the previous person's session. A kiosk account can also be the user of an auth
+// exemption, for a reception computer that never signs in.
+
+// Kiosk locks the users signed in as one of its accounts down to the send form
+type Kiosk struct {
+	Users     []string `yaml:"users"`      // sign-in identities of the shared terminals
+	Profile   string   `yaml:"profile"`    // profile faxes are sent with; empty for the default profile
+	From      string   `yaml:"from"`       // number faxes are sent from; empty for the profile's default
+	CoverText string   `yaml:"cover_text"` // cover page note put in front of every fax; empty lets the user write one
+}
+
+// kioskPaths are the pages a kiosk may use: the send form and the uploads it makes
+var kioskPaths = []string{"/", "/fax", "/uploads", "/uploads/*"}
+
+// parse checks the kiosk
+func (k *Kiosk) parse() error {
+	if len(k.Users) == 0 {
+		return fmt.Errorf("no users")
+	}
+	return nil
+}
+
+// kiosk returns the kiosk the request's user signed in at, or nil
+func (a *App) kiosk(r *http.Request) *Kiosk {
+	kiosks := a.runtime().Kiosks
+	if len(kiosks) == 0 {
+		return nil
+	}
+	user, ok := a.signedInUser(r)
+	if !ok {
+		return nil
+	}
+	for i, k := range kiosks {
+		if containsFold(k.Users, user) {
+			return &kiosks[i]
+		}
+	}
+	return nil
+}
+
+// kioskAllows checks whether a kiosk may use the page at path
+func kioskAllows(r *http.Request) bool {
+	if r.URL.Path == "/fax" {
+		// Sending only; fax details belong to the history
+		return r.Method == http.MethodPost
+	}
+	for _, p := range kioskPaths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				return true
+			}
+		} else if p == r.URL.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// kioskSent confirms a kiosk's fax and signs the kiosk out
+func (a *App) kioskSent(w http.ResponseWriter, r *http.Request, fax *Fax) {
+	_, signedIn := a.currentSession(r)
+	a.endSession(w, r)
+	a.render(w, r, "kiosk_sent.html", map[string]any{
+		"Fax":       fax,
+		"SignedOut": signedIn,
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// Instances sharing a database elect a leader for each periodic job that must not run twice at once:
+// sending from the scan folder, retention and the Telnyx cost sync. Each run, an instance takes or
+// renews the job's lease in the leases table; the others stand by until it expires, e.g. when the
+// leader's pod goes away. A SQLite database has one instance, which always runs them.
+
+// leaseTTL is how long a job's lease lasts: a few of its intervals, so a leader that misses a run
+// keeps it, but at least 30 seconds for jobs that run often
+func leaseTTL(interval time.Duration) time.Duration {
+	return max(3*interval, 30*time.Second)
+}
+
+// newInstanceID names this instance in the leases it holds: the host name (the pod name on
+// Kubernetes) and a random suffix, as a restarted pod keeps its name
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "fax-ui"
+	}
+	suffix, err := generateSecureToken(4)
+	if err != nil {
+		return host
+	}
+	return host + "-" + suffix
+}
+
+// leads takes or renews the lease on a periodic job, reporting whether this instance should run it.
+// A database error stands the job down for this run rather than risk running it twice.
+func (a *App) leads(job string, interval time.Duration) bool {
+	if !a.Store.postgres() {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ok, err := a.Store.acquireLease(ctx, job, a.instanceID, leaseTTL(interval))
+	if err != nil {
+		log.Printf("Warning: could not take the lease on %s: %v", job, err)
+		return false
+	}
+	backgroundTasks.standby(job, !ok)
+	return ok
+}
+
+// acquireLease takes the named lease for holder when it is free or has expired, or renews it when
+// holder has it, reporting whether holder has it now
+func (s *Store) acquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at < ?`,
+		name, holder, now.Add(ttl), now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
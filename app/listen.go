@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// ListenConfig chooses where the HTTP server accepts connections when not on the TCP port
+type ListenConfig struct {
+	Socket      string      // Unix socket path, e.g. /run/fax-ui.sock for nginx on the same host; empty listens on the port
+	SocketMode  fs.FileMode // permissions of the socket file
+	SocketGroup string      // group the socket file belongs to, e.g. www-data so nginx can connect; empty keeps ours
+}
+
+// defaultSocketMode lets the owner and group connect; others cannot reach the server through the socket
+const defaultSocketMode fs.FileMode = 0o660
+
+// systemdListenFDsStart is the first file descriptor systemd passes to socket-activated services
+const systemdListenFDsStart = 3
+
+// parseSocketMode parses an octal file mode such as 0660 or 660
+func parseSocketMode(s string) (fs.FileMode, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return defaultSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid LISTEN_SOCKET_MODE %q (expected octal permissions, e.g. 0660)", s)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// listen returns the listener the HTTP server accepts on and a description for the log: the socket
+// systemd passed in (socket activation), the Unix socket at cfg.Socket, or the TCP port
+func listen(cfg ListenConfig, port string) (net.Listener, string, error) {
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		return ln, "the socket passed by systemd", err
+	}
+	if cfg.Socket != "" {
+		ln, err := listenUnix(cfg)
+		return ln, "unix:" + cfg.Socket, err
+	}
+	ln, err := net.Listen("tcp", ":"+port)
+	return ln, "port " + port, err
+}
+
+// systemdListener returns the first socket systemd passed with socket activation (sd_listen_fds), or nil
+// when the process was not socket-activated. Further sockets are ignored.
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// Child processes (Ghostscript, Tesseract) must not think the sockets are theirs
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	f := os.NewFile(uintptr(systemdListenFDsStart), "LISTEN_FD_3")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not use the socket passed by systemd: %w", err)
+	}
+	return ln, nil
+}
+
+// listenUnix listens on the Unix socket, replacing one left behind by a previous run, and sets its
+// permissions before accepting connections
+func listenUnix(cfg ListenConfig) (net.Listener, error) {
+	if fi, err := os.Lstat(cfg.Socket); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", cfg.Socket)
+		}
+		if err := os.Remove(cfg.Socket); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", cfg.Socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(cfg.Socket, cfg.SocketMode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("could not set the permissions of %s: %w", cfg.Socket, err)
+	}
+	if cfg.SocketGroup != "" {
+		g, err := user.LookupGroup(cfg.SocketGroup)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		gid, _ := strconv.Atoi(g.Gid)
+		if err := os.Chown(cfg.Socket, -1, gid); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("could not give %s to group %s: %w", cfg.Socket, cfg.SocketGroup, err)
+		}
+	}
+	return ln, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+)
+
+// Number lookup modes controlling what happens when the destination is not fax-capable
+const (
+	lookupOff   = "off"   // no lookup before sending
+	lookupWarn  = "warn"  // warn and require confirmation before sending
+	lookupBlock = "block" // refuse to send
+)
+
+// parseLookupMode validates a number lookup mode, defaulting to off
+func parseLookupMode(mode string) (string, error) {
+	switch m := strings.ToLower(strings.TrimSpace(mode)); m {
+	case "", lookupOff, "false", "0":
+		return lookupOff, nil
+	case lookupWarn, lookupBlock:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid number lookup mode %q (expected off, warn or block)", mode)
+	}
+}
+
+// lookupLineType runs a Telnyx carrier lookup on the destination and returns its line type
+// (e.g. "fixed line", "mobile", "voip"). SIP URIs and non-Telnyx profiles are skipped and return an empty type.
+func (p *Profile) lookupLineType(ctx context.Context, to string) (string, error) {
+	if isSIPURI(to) || p.client == nil {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.pageTimeout)
+	defer cancel()
+
+	res, err := p.client.NumberLookup.Get(ctx, to, telnyx.NumberLookupGetParams{
+		Type: telnyx.NumberLookupGetParamsTypeCarrier,
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(res.Data.Carrier.Type), nil
+}
+
+// checkDestination applies the configured lookup mode to the destination number.
+// It returns a non-empty message when the number looks unable to receive faxes.
+// Lookup failures are logged and never prevent sending.
+func (a *App) checkDestination(ctx context.Context, p *Profile, to string) string {
+	if a.runtime().NumberLookup == lookupOff {
+		return ""
+	}
+	lineType, err := p.lookupLineType(ctx, to)
+	if err != nil {
+		log.Printf("Warning: number lookup for destination failed: %v", err)
+		return ""
+	}
+	if lineType == "mobile" {
+		return fmt.Sprintf("%s is a mobile line and most likely cannot receive faxes.", to)
+	}
+	return ""
+}
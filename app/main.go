@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"log"
 	"net/http"
 )
@@ -10,8 +9,16 @@ import (
 var Version = "dev"
 
 func main() {
+	// fax-ui send, list and status run without the server
+	if runCommand() {
+		return
+	}
+
 	// Load configuration from environment and flags
-	cfg := LoadConfig()
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
 
 	// Initialize the application
 	app, err := NewApp(cfg)
@@ -25,27 +32,116 @@ func main() {
 	// Auth routes (no auth required)
 	mux.HandleFunc("/login", app.handleLogin)
 	mux.HandleFunc("/logout", app.handleLogout)
+	mux.HandleFunc("/setup", app.handleSetup)
+	mux.HandleFunc("/language", app.handleLanguage)
 	mux.HandleFunc("/auth/login/", app.handleOAuthLogin)
 	mux.HandleFunc("/auth/callback/", app.handleOAuthCallback)
+	mux.HandleFunc("/auth/saml/metadata", app.handleSAMLMetadata)
+	mux.HandleFunc("/auth/saml/login", app.handleSAMLLogin)
+	mux.HandleFunc("/auth/saml/acs", app.handleSAMLACS)
 
 	// Public route for media files - Telnyx fetches from here during fax send
 	// Secured by unguessable tokens in the URL, not by authentication
 	mux.HandleFunc("/media/", app.handleMediaServe)
 
+	// Public route for Telnyx fax events, verified by signature when TELNYX_PUBLIC_KEY is set and by the
+	// secret token in the path once the webhook signing has been rotated; with neither, events are refused
+	mux.HandleFunc(telnyxWebhookPath, app.handleTelnyxWebhook)
+	mux.HandleFunc(telnyxWebhookPath+"/", app.handleTelnyxWebhook)
+
+	// Public route for the service worker showing push notifications; it is static script
+	mux.HandleFunc("/sw.js", app.handleServiceWorker)
+
+	// Public route for the logo, which the login page shows
+	mux.HandleFunc(brandLogoPath, app.handleBrandLogo)
+
 	// Protected routes
 	mux.HandleFunc("/", app.requireAuth(app.handleHome))
 	mux.HandleFunc("/fax", app.requireAuth(app.handleFax))
+	mux.HandleFunc("/fax/preview", app.requireAuth(app.handleFaxPreview))
+	mux.HandleFunc("/fax/confirmation", app.requireAuth(app.handleFaxConfirmation))
+	mux.HandleFunc("/fax/status", app.requireAuth(app.handleFaxStatus))
+	mux.HandleFunc("/fax/diagnostics", app.requireAuth(app.handleFaxDiagnostics))
+	mux.HandleFunc("/fax/tags", app.requireAuth(app.handleFaxTags))
+	mux.HandleFunc("/fax/comments", app.requireAuth(app.handleFaxComments))
+	mux.HandleFunc("/fax/hold", app.requireAdmin(app.handleFaxHold))
+	mux.HandleFunc("/fax/delete", app.requireAdmin(app.handleDeleteFax))
 	mux.HandleFunc("/faxes", app.requireAuth(app.handleFaxes))
+	mux.HandleFunc("/partials/fax-row", app.requireAuth(app.handleFaxRowPartial))
+	mux.HandleFunc("/partials/fax-status", app.requireAuth(app.handleFaxStatusPartial))
+	mux.HandleFunc("/inbox", app.requireAuth(app.handleInbox))
+	mux.HandleFunc("/views", app.requireAuth(app.handleSavedViews))
+	mux.HandleFunc("/ws", app.requireAuth(app.handleWebSocket))
+	mux.HandleFunc("/notifications", app.requireAuth(app.handleNotifications))
+	mux.HandleFunc("/preferences", app.requireAuth(app.handlePreferences))
+	mux.HandleFunc("/export", app.requireAuth(app.handleExport))
 	mux.HandleFunc("/settings", app.requireAuth(app.handleSettings))
+	mux.HandleFunc("/admin/webhook/rotate", app.requireAdmin(app.handleAdminRotateWebhook))
+	mux.HandleFunc("/switch", app.requireAuth(app.handleSwitchApp))
+	mux.HandleFunc("/drafts", app.requireAuth(app.handleDrafts))
+	mux.HandleFunc("/scans", app.requireAuth(app.handleScans))
+	mux.HandleFunc("/uploads", app.requireAuth(app.handleUploads))
+	mux.HandleFunc("/uploads/", app.requireAuth(app.handleUpload))
+	mux.HandleFunc("/api/connections", app.requireAuth(app.handleAPIConnections))
+	mux.HandleFunc("/api/destination", app.requireAuth(app.handleAPIDestination))
+	mux.HandleFunc("/api/hooks", app.requireAuth(app.handleHooks))
+	mux.HandleFunc("/api/hooks/", app.requireAuth(app.handleHook))
+	mux.HandleFunc("/preferences/tokens", app.requireAuth(app.handleAPITokens))
+
+	// Admin routes
+	mux.HandleFunc("/admin/numbers", app.requireAdmin(app.handleAdminNumbers))
+	mux.HandleFunc("/admin/tags", app.requireAdmin(app.handleAdminTags))
+	mux.HandleFunc("/admin/contacts", app.requireAdmin(app.handleAdminContacts))
+	mux.HandleFunc("/admin/blocklist", app.requireAdmin(app.handleAdminBlocklist))
+	mux.HandleFunc("/admin/config", app.requireAdmin(app.handleAdminConfig))
+	mux.HandleFunc("/admin/selftest", app.requireAdmin(app.handleAdminSelfTest))
+	mux.HandleFunc("/admin/audit", app.requireAdmin(app.handleAdminAudit))
+	mux.HandleFunc("/admin/impersonate", app.requireAdmin(app.handleAdminImpersonate))
+	mux.HandleFunc("/admin/backup", app.requireAdmin(app.handleAdminBackup))
+	mux.HandleFunc("/admin/privacy", app.requireAdmin(app.handleAdminPrivacy))
+	mux.HandleFunc("/admin/access", app.requireAdmin(app.handleAdminAccess))
+	mux.HandleFunc("/impersonate/stop", app.requireAuth(app.handleStopImpersonating))
+	mux.HandleFunc("/admin/costs", app.requireAdmin(app.handleAdminCosts))
+	mux.HandleFunc("/admin/intake", app.requireAdmin(app.handleAdminIntake))
+	mux.HandleFunc("/admin/cloud", app.requireAdmin(app.handleAdminCloud))
+	mux.HandleFunc("/admin/cloud/callback", app.requireAdmin(app.handleAdminCloudCallback))
+	mux.HandleFunc("/admin/jobs", app.requireAdmin(app.handleAdminJobs))
+	if cfg.Debug {
+		app.debugRoutes(mux)
+	}
 
 	// Create server with logging middleware
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", cfg.Port),
-		Handler: logRequests(mux),
+	handler := app.translateErrors(mux)
+	if cfg.Server.Compression {
+		handler = compressResponses(handler)
+	}
+	srv := newHTTPServer(cfg.Server, withRequestID(logRequests(handler)))
+	ln, where, err := listen(cfg.Listen, cfg.Port)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", where, err)
+	}
+
+	if app.relay != nil {
+		go app.serveRelay(cfg.Server, srv.Handler)
+	}
+	if cfg.IPP.Port != "" {
+		go app.serveIPP(cfg.IPP, cfg.Server, cfg.TLSCert, cfg.TLSKey)
+	}
+	if cfg.GRPC.Port != "" {
+		go app.serveGRPC(cfg.GRPC, cfg.TLSCert, cfg.TLSKey)
 	}
 
-	log.Printf("fax-ui v%s listening on http://localhost:%s (public: %s)", Version, cfg.Port, app.PublicBaseURL)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if cfg.TLSCert != "" {
+		if app.ClientCerts != nil {
+			srv.TLSConfig = app.ClientCerts.tlsConfig()
+		}
+		log.Printf("fax-ui v%s serving HTTPS on %s (public: %s)", Version, where, app.PublicBaseURL())
+		err = srv.ServeTLS(ln, cfg.TLSCert, cfg.TLSKey)
+	} else {
+		log.Printf("fax-ui v%s serving HTTP on %s (public: %s)", Version, where, app.PublicBaseURL())
+		err = srv.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("server error: %v", err)
 	}
 }
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryMediaTTL is how long in-memory uploads stay available; plenty for the carrier to fetch them
+const memoryMediaTTL = 30 * time.Minute
+
+// errMediaNotFound is returned when a stored document does not exist or has expired
+var errMediaNotFound = errors.New("media not found")
+
+// MediaStore keeps uploaded documents for the carrier to fetch from /media/{name}, and for exports
+type MediaStore interface {
+	// Put stores a document under a new unguessable name and returns the name
+	Put(ctx context.Context, doc *document) (string, error)
+	// Get returns a stored document, or errMediaNotFound
+	Get(ctx context.Context, name string) (*document, error)
+	// Delete removes a document; a missing document or empty name is not an error
+	Delete(ctx context.Context, name string) error
+	// ExpireBefore deletes the documents stored before t, except those named in keep, and returns their names
+	ExpireBefore(ctx context.Context, t time.Time, keep map[string]bool) ([]string, error)
+}
+
+// newMediaStore picks where uploads are kept. HIPAA mode keeps them in memory unless they are
+// encrypted; otherwise they go to S3 when a bucket is configured, the upload directory when one is set, or memory.
+func newMediaStore(cfg *Config) (MediaStore, error) {
+	switch {
+	case cfg.Hipaa && cfg.MediaKey == nil:
+		return newMemoryMediaStore(memoryMediaTTL), nil
+	case cfg.S3.Bucket != "":
+		return newS3MediaStore(cfg.S3)
+	case cfg.UploadDir != "":
+		return &diskMediaStore{dir: cfg.UploadDir}, nil
+	default:
+		return newMemoryMediaStore(memoryMediaTTL), nil
+	}
+}
+
+// newMediaName returns an unguessable name for a document, keeping the extension of its file name or type
+func newMediaName(doc *document) (string, error) {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate secure token: %w", err)
+	}
+	ext := filepath.Ext(doc.Name)
+	if ext == "" {
+		switch doc.Type {
+		case "application/pdf":
+			ext = ".pdf"
+		case "image/tiff":
+			ext = ".tiff"
+		}
+	}
+	return token + ext, nil
+}
+
+// mediaType returns the content type of a stored document from its name, sniffing the data when the extension is unknown
+func mediaType(name string, data []byte) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return http.DetectContentType(data)
+}
+
+// memoryMediaStore keeps documents in memory only and forgets them after the TTL (HIPAA compliant)
+type memoryMediaStore struct {
+	ttl   time.Duration
+	mu    sync.RWMutex
+	files map[string]memoryMedia
+}
+
+// memoryMedia is a document held in memory and when it was stored
+type memoryMedia struct {
+	doc      document
+	storedAt time.Time
+}
+
+func newMemoryMediaStore(ttl time.Duration) *memoryMediaStore {
+	return &memoryMediaStore{ttl: ttl, files: make(map[string]memoryMedia)}
+}
+
+func (m *memoryMediaStore) Put(ctx context.Context, doc *document) (string, error) {
+	name, err := newMediaName(doc)
+	if err != nil {
+		return "", err
+	}
+	stored := *doc
+	stored.Name = name
+	if stored.Type == "" {
+		stored.Type = "application/octet-stream"
+	}
+	m.mu.Lock()
+	m.files[name] = memoryMedia{doc: stored, storedAt: time.Now()}
+	m.mu.Unlock()
+	return name, nil
+}
+
+func (m *memoryMediaStore) Get(ctx context.Context, name string) (*document, error) {
+	m.mu.RLock()
+	file, ok := m.files[name]
+	m.mu.RUnlock()
+	if !ok || time.Since(file.storedAt) > m.ttl {
+		return nil, errMediaNotFound
+	}
+	doc := file.doc
+	doc.Stored = file.storedAt
+	return &doc, nil
+}
+
+func (m *memoryMediaStore) Delete(ctx context.Context, name string) error {
+	m.mu.Lock()
+	delete(m.files, name)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memoryMediaStore) ExpireBefore(ctx context.Context, t time.Time, keep map[string]bool) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted []string
+	for name, file := range m.files {
+		if file.storedAt.Before(t) && !keep[name] {
+			delete(m.files, name)
+			deleted = append(deleted, name)
+		}
+	}
+	return deleted, nil
+}
+
+// usage returns how many documents are held and their total size
+func (m *memoryMediaStore) usage() (int, int64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var size int64
+	for _, file := range m.files {
+		size += int64(len(file.doc.Data))
+	}
+	return len(m.files), size
+}
+
+// startCleanup periodically drops documents older than the TTL
+func (m *memoryMediaStore) startCleanup(interval time.Duration) {
+	backgroundTasks.register("media cleanup", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			backgroundTasks.beat("media cleanup")
+			deleted, _ := m.ExpireBefore(context.Background(), time.Now().Add(-m.ttl), nil)
+			for _, name := range deleted {
+				log.Printf("Cleaned up expired file: %s", name[:8]+"...")
+			}
+		}
+	}()
+}
+
+// diskMediaStore keeps documents as files in the upload directory
+type diskMediaStore struct {
+	dir string
+}
+
+// path returns where a document is kept, or false if the name would escape the upload directory
+func (d *diskMediaStore) path(name string) (string, bool) {
+	p := filepath.Join(d.dir, filepath.Clean(name))
+	return p, name != "" && strings.HasPrefix(p, filepath.Clean(d.dir)+string(filepath.Separator))
+}
+
+func (d *diskMediaStore) Put(ctx context.Context, doc *document) (string, error) {
+	name, err := newMediaName(doc)
+	if err != nil {
+		return "", err
+	}
+	return name, d.putAs(ctx, name, doc)
+}
+
+// putAs stores a document under the given name, e.g. one restored from a backup
+func (d *diskMediaStore) putAs(ctx context.Context, name string, doc *document) error {
+	if err := os.MkdirAll(d.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to prepare upload storage: %w", err)
+	}
+	p, ok := d.path(name)
+	if !ok {
+		return fmt.Errorf("invalid document name %q", name)
+	}
+	if err := os.WriteFile(p, doc.Data, 0o644); err != nil {
+		return fmt.Errorf("failed to save uploaded file: %w", err)
+	}
+	return nil
+}
+
+func (d *diskMediaStore) Get(ctx context.Context, name string) (*document, error) {
+	p, ok := d.path(name)
+	if !ok {
+		return nil, errMediaNotFound
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errMediaNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	doc := &document{Name: name, Type: mediaType(name, data), Data: data}
+	if info, err := os.Stat(p); err == nil {
+		doc.Stored = info.ModTime()
+	}
+	return doc, nil
+}
+
+func (d *diskMediaStore) Delete(ctx context.Context, name string) error {
+	p, ok := d.path(filepath.Base(name))
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d *diskMediaStore) ExpireBefore(ctx context.Context, t time.Time, keep map[string]bool) ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read upload directory: %w", err)
+	}
+	var deleted []string
+	var errs []error
+	for _, e := range entries {
+		if e.IsDir() || keep[e.Name()] {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(t) {
+			continue
+		}
+		if err := d.Delete(ctx, e.Name()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted = append(deleted, e.Name())
+	}
+	return deleted, errors.Join(errs...)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// databaseMediaStore keeps uploads in the shared database for the memory store's TTL. Instances sharing
+// a Postgres database use it instead of memory, so Telnyx can fetch a document from whichever instance
+// its request reaches.
+type databaseMediaStore struct {
+	store *Store
+	ttl   time.Duration
+}
+
+func newDatabaseMediaStore(store *Store, ttl time.Duration) *databaseMediaStore {
+	return &databaseMediaStore{store: store, ttl: ttl}
+}
+
+func (m *databaseMediaStore) Put(ctx context.Context, doc *document) (string, error) {
+	name, err := newMediaName(doc)
+	if err != nil {
+		return "", err
+	}
+	return name, m.putAs(ctx, name, doc)
+}
+
+// putAs stores a document under the given name, e.g. one restored from a backup
+func (m *databaseMediaStore) putAs(ctx context.Context, name string, doc *document) error {
+	typ := doc.Type
+	if typ == "" {
+		typ = "application/octet-stream"
+	}
+	_, err := m.store.db.ExecContext(ctx, `INSERT INTO shared_media (name, type, data, stored_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET type = excluded.type, data = excluded.data, stored_at = excluded.stored_at`,
+		name, typ, doc.Data, time.Now().UTC())
+	return err
+}
+
+func (m *databaseMediaStore) Get(ctx context.Context, name string) (*document, error) {
+	doc := &document{Name: name}
+	err := m.store.db.QueryRowContext(ctx, `SELECT type, data, stored_at FROM shared_media WHERE name = ? AND stored_at >= ?`,
+		name, time.Now().UTC().Add(-m.ttl)).Scan(&doc.Type, &doc.Data, &doc.Stored)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errMediaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (m *databaseMediaStore) Delete(ctx context.Context, name string) error {
+	_, err := m.store.db.ExecContext(ctx, `DELETE FROM shared_media WHERE name = ?`, name)
+	return err
+}
+
+func (m *databaseMediaStore) ExpireBefore(ctx context.Context, t time.Time, keep map[string]bool) ([]string, error) {
+	rows, err := m.store.db.QueryContext(ctx, `SELECT name FROM shared_media WHERE stored_at < ?`, t.UTC())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !keep[name] {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, name := range names {
+		if err := m.Delete(ctx, name); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+	}
+	return deleted, nil
+}
+
+// startSharedMediaCleanup periodically drops shared uploads older than the TTL, on one instance at a time
+func (a *App) startSharedMediaCleanup(m *databaseMediaStore, interval time.Duration) {
+	backgroundTasks.register("media cleanup", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			backgroundTasks.beat("media cleanup")
+			if !a.leads("media cleanup", interval) {
+				continue
+			}
+			deleted, err := m.ExpireBefore(context.Background(), time.Now().Add(-m.ttl), nil)
+			if err != nil {
+				log.Printf("Warning: could not clean up expired files: %v", err)
+			}
+			for _, name := range deleted {
+				log.Printf("Cleaned up expired file: %s", name[:8]+"...")
+			}
+		}
+	}()
+}
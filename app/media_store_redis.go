@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisMediaKeyPrefix namespaces fax-ui's uploads in a Redis database it may share
+const redisMediaKeyPrefix = "fax-ui:media:"
+
+// redisMediaStore keeps uploads in Redis, which forgets them after the memory store's TTL by itself.
+// Like memory, nothing is written to disk when Redis runs without persistence, so HIPAA mode can use it;
+// unlike memory, uploads survive a restart and any instance can serve them to Telnyx.
+type redisMediaStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// newRedisMediaStore connects to the Redis server at a redis:// or rediss:// URL. In HIPAA mode it warns
+// when the server saves its data to disk, as far as the server lets it tell.
+func newRedisMediaStore(ctx context.Context, rawURL string, ttl time.Duration, hipaa bool) (*redisMediaStore, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL %q: %w", redactDatabaseURL(rawURL), err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connect to %s: %w", redactDatabaseURL(rawURL), err)
+	}
+	if hipaa {
+		// Managed services often refuse CONFIG; then persistence is left to the operator
+		if save, err := client.ConfigGet(ctx, "save").Result(); err == nil && save["save"] != "" {
+			log.Printf("Warning: HIPAA mode: Redis saves snapshots to disk (save %q); turn persistence off to keep uploads in memory only", save["save"])
+		}
+		if aof, err := client.ConfigGet(ctx, "appendonly").Result(); err == nil && aof["appendonly"] == "yes" {
+			log.Printf("Warning: HIPAA mode: Redis writes an append-only file to disk; turn persistence off to keep uploads in memory only")
+		}
+	}
+	return &redisMediaStore{client: client, ttl: ttl}, nil
+}
+
+func (m *redisMediaStore) key(name string) string {
+	return redisMediaKeyPrefix + name
+}
+
+func (m *redisMediaStore) Put(ctx context.Context, doc *document) (string, error) {
+	name, err := newMediaName(doc)
+	if err != nil {
+		return "", err
+	}
+	return name, m.putAs(ctx, name, doc)
+}
+
+// putAs stores a document under the given name, e.g. one restored from a backup
+func (m *redisMediaStore) putAs(ctx context.Context, name string, doc *document) error {
+	typ := doc.Type
+	if typ == "" {
+		typ = "application/octet-stream"
+	}
+	key := m.key(name)
+	_, err := m.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, key, "type", typ, "data", doc.Data, "stored", time.Now().UTC().Format(time.RFC3339Nano))
+		p.Expire(ctx, key, m.ttl)
+		return nil
+	})
+	return err
+}
+
+func (m *redisMediaStore) Get(ctx context.Context, name string) (*document, error) {
+	fields, err := m.client.HGetAll(ctx, m.key(name)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := fields["data"]; !ok {
+		return nil, errMediaNotFound
+	}
+	doc := &document{Name: name, Type: fields["type"], Data: []byte(fields["data"])}
+	doc.Stored, _ = time.Parse(time.RFC3339Nano, fields["stored"])
+	return doc, nil
+}
+
+func (m *redisMediaStore) Delete(ctx context.Context, name string) error {
+	if name == "" {
+		return nil
+	}
+	return m.client.Del(ctx, m.key(name)).Err()
+}
+
+// ExpireBefore deletes the documents stored before t. Redis drops them after the TTL already, so this
+// only matters for a retention period shorter than that.
+func (m *redisMediaStore) ExpireBefore(ctx context.Context, t time.Time, keep map[string]bool) ([]string, error) {
+	var deleted []string
+	iter := m.client.Scan(ctx, 0, redisMediaKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		name := strings.TrimPrefix(iter.Val(), redisMediaKeyPrefix)
+		if keep[name] {
+			continue
+		}
+		stored, err := m.client.HGet(ctx, iter.Val(), "stored").Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		if at, err := time.Parse(time.RFC3339Nano, stored); err != nil || !at.Before(t) {
+			continue
+		}
+		if err := m.Delete(ctx, name); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+	}
+	return deleted, iter.Err()
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config configures keeping uploads in an S3 (or S3-compatible, e.g. MinIO) bucket
+type S3Config struct {
+	Bucket       string
+	Region       string // defaults to us-east-1
+	Endpoint     string // defaults to https://s3.<region>.amazonaws.com
+	Prefix       string // key prefix, e.g. fax-ui/
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// s3MediaStore keeps documents as objects in an S3 bucket. Requests use path-style URLs signed
+// with AWS Signature Version 4, so any S3-compatible service works.
+type s3MediaStore struct {
+	cfg S3Config
+}
+
+func newS3MediaStore(cfg S3Config) (*s3MediaStore, error) {
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("S3 uploads need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	cfg.Region = firstNonEmpty(cfg.Region, "us-east-1")
+	cfg.Endpoint = trimTrailingSlash(firstNonEmpty(cfg.Endpoint, "https://s3."+cfg.Region+".amazonaws.com"))
+	if u, err := url.Parse(cfg.Endpoint); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	return &s3MediaStore{cfg: cfg}, nil
+}
+
+func (s *s3MediaStore) Put(ctx context.Context, doc *document) (string, error) {
+	name, err := newMediaName(doc)
+	if err != nil {
+		return "", err
+	}
+	return name, s.putAs(ctx, name, doc)
+}
+
+// putAs stores a document under the given name, e.g. one restored from a backup
+func (s *s3MediaStore) putAs(ctx context.Context, name string, doc *document) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid document name %q", name)
+	}
+	h := http.Header{"Content-Type": {firstNonEmpty(doc.Type, "application/octet-stream")}}
+	resp, err := s.do(ctx, http.MethodPut, s.cfg.Prefix+name, nil, doc.Data, h)
+	if err != nil {
+		return fmt.Errorf("failed to save uploaded file: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3MediaStore) Get(ctx context.Context, name string) (*document, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, errMediaNotFound
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.cfg.Prefix+name, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := readDocument(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	doc := &document{Name: name, Type: firstNonEmpty(resp.Header.Get("Content-Type"), mediaType(name, data)), Data: data}
+	doc.Stored, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return doc, nil
+}
+
+func (s *s3MediaStore) Delete(ctx context.Context, name string) error {
+	if name == "" {
+		return nil
+	}
+	// S3 reports success for keys that do not exist
+	resp, err := s.do(ctx, http.MethodDelete, s.cfg.Prefix+name, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3MediaStore) ExpireBefore(ctx context.Context, t time.Time, keep map[string]bool) ([]string, error) {
+	var deleted []string
+	var errs []error
+	query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return deleted, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return deleted, fmt.Errorf("list S3 objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(obj.Key, s.cfg.Prefix)
+			if strings.Contains(name, "/") || keep[name] || !obj.LastModified.Before(t) {
+				continue
+			}
+			if err := s.Delete(ctx, name); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			deleted = append(deleted, name)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return deleted, errors.Join(errs...)
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// do sends a signed request for an object key (or the bucket when key is empty), failing on non-2xx responses.
+// A missing object is reported as errMediaNotFound.
+func (s *s3MediaStore) do(ctx context.Context, method, key string, query url.Values, body []byte, h http.Header) (*http.Response, error) {
+	u := s.cfg.Endpoint + "/" + url.PathEscape(s.cfg.Bucket) + "/"
+	for i, segment := range strings.Split(key, "/") {
+		if i > 0 {
+			u += "/"
+		}
+		u += url.PathEscape(segment)
+	}
+	if len(query) > 0 {
+		u += "?" + s3Query(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	s.sign(req, body, time.Now().UTC())
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		resp.Body.Close()
+		return nil, errMediaNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %s: %s: %s", method, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req
+func (s *s3MediaStore) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.cfg.SessionToken)
+	}
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if s.cfg.SessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.URL.Host
+		if name != "host" {
+			value = req.Header.Get(name)
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3Query(req.URL.Query()),
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := []byte("AWS4" + s.cfg.SecretKey)
+	for _, part := range []string{date, s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, strings.Join(signed, ";"), hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// s3Query encodes query parameters sorted by key with spaces as %20, as Signature Version 4 requires
+func s3Query(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// A media URL is a document the carrier fetches instead of an upload. Telnyx fetches it over the
+// internet, so it must be at a public address; Documo, Phaxio, SRFax and email profiles have fax-ui
+// download it, which is why it is checked before sending rather than left to the carrier. With
+// --fetch_media_url every media URL is downloaded when the fax is sent and sent as an upload, so it gets
+// the same preprocessing, compatibility conversion, stamps and cover pages.
+
+// mediaFetchClient downloads documents at media URLs, only ever from public addresses
+var mediaFetchClient = newPublicClient(60 * time.Second)
+
+// mediaProbeClient asks for the type and size of the document at a media URL before it is sent
+var mediaProbeClient = newPublicClient(10 * time.Second)
+
+// checkMediaURL validates a media URL a user typed: an http or https URL on the allowlist, when there is
+// one, whose host resolves to public addresses only, and which does not answer with a missing document,
+// one larger than 25 MB or one that is neither a PDF nor a TIFF. A document server that cannot be reached
+// from here is not held against the URL; the carrier may still reach it.
+func (a *App) checkMediaURL(ctx context.Context, raw string) error {
+	u, err := checkUserURL(raw, a.MediaHosts)
+	if err != nil {
+		return err
+	}
+	if err := checkPublicHost(ctx, u.Hostname(), true); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "fax-ui/"+Version)
+	resp, err := mediaProbeClient.Do(req)
+	if errors.Is(err, errHostNotPublic) {
+		return fmt.Errorf("%s redirects to an address that is not public", u.Hostname())
+	}
+	if err != nil {
+		log.Printf("Warning: could not check the document at media URL %s: %v", u.Redacted(), err)
+		return nil
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		// Servers that only answer GET are left to the carrier
+		return nil
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("the document could not be fetched: %s", resp.Status)
+	case resp.ContentLength > maxDocumentSize:
+		return errDocumentTooLarge
+	}
+	switch t, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); t {
+	case "", "application/pdf", "image/tiff", "application/octet-stream":
+		return nil
+	default:
+		return fmt.Errorf("the URL is %s, not a PDF or TIFF document", t)
+	}
+}
+
+// downloadMedia fetches the document at a media URL to send it as an upload
+func downloadMedia(ctx context.Context, mediaURL string) (*document, error) {
+	data, name, typ, err := fetchMedia(ctx, mediaFetchClient, mediaURL)
+	if err != nil {
+		return nil, err
+	}
+	if uploadType(typ) == "" {
+		return nil, fmt.Errorf("the URL is %s, not a PDF or TIFF document", typ)
+	}
+	return &document{Name: name, Type: typ, Data: data}, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The database schema is changed by numbered migrations, recorded in schema_migrations as they are applied.
+// Every instance applies the pending ones when it starts; fax-ui migrate applies them ahead of a rollout.
+// Migrations are only ever appended: a released migration must not change, as databases have applied it.
+
+// migration is one numbered change to the schema
+type migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, tx *dbTx) error
+}
+
+// migrations are the schema changes in the order they are applied
+var migrations = []migration{
+	{Version: 1, Name: "initial schema", Up: migrateInitialSchema},
+	{Version: 2, Name: "shared state for several instances", Up: migrateSharedState},
+	{Version: 3, Name: "job error history", Up: migrateJobErrors},
+	{Version: 4, Name: "per-fax webhook URLs", Up: migrateFaxWebhooks},
+	{Version: 5, Name: "advanced fax options per user", Up: migrateFaxOptions},
+	{Version: 6, Name: "index faxes by destination", Up: migrateDestinationIndex},
+	{Version: 7, Name: "fax event timeline", Up: migrateFaxEvents},
+	{Version: 8, Name: "contact directory", Up: migrateContacts},
+	{Version: 9, Name: "blocklist", Up: migrateBlocklist},
+	{Version: 10, Name: "runtime settings", Up: migrateSettings},
+	{Version: 11, Name: "first-run setup", Up: migrateSetup},
+	{Version: 12, Name: "idempotency keys per user", Up: migrateIdempotencyKeys},
+	{Version: 13, Name: "received fax archive attempts", Up: migrateArchiveAttempts},
+}
+
+// migrationLock is the Postgres advisory lock instances hold while migrating, so only one applies each migration
+const migrationLock = 0x6661782d7569 // "fax-ui"
+
+// migrateInitialSchema creates the tables as they were before versioned migrations. Databases created
+// before then already have them, perhaps missing columns added since, which are added.
+func migrateInitialSchema(ctx context.Context, tx *dbTx) error {
+	if tx.db.postgres {
+		// Case-insensitive names and users, as SQLite's NOCASE
+		if _, err := tx.ExecContext(ctx, `CREATE COLLATION IF NOT EXISTS nocase
+			(provider = icu, locale = 'und-u-ks-level2', deterministic = false)`); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range storeSchema {
+		if _, err := tx.ExecContext(ctx, tx.db.ddl(stmt)); err != nil {
+			return err
+		}
+	}
+	for _, c := range storeColumns {
+		exists, err := tx.db.columnExists(ctx, tx, c[0], c[1])
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `ALTER TABLE `+c[0]+` ADD COLUMN `+c[1]+` `+tx.db.ddl(c[2])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSharedState adds what instances sharing a database coordinate through: leases on the periodic
+// jobs, uploads for Telnyx to fetch from any instance, and SAML sign-ins waiting for the IdP
+func migrateSharedState(ctx context.Context, tx *dbTx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE leases (
+			name       TEXT PRIMARY KEY, -- periodic job, e.g. "retention"
+			holder     TEXT NOT NULL,    -- instance running it
+			expires_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE shared_media (
+			name      TEXT PRIMARY KEY,
+			type      TEXT NOT NULL,
+			data      BLOB NOT NULL,
+			stored_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE saml_logins (
+			state      TEXT PRIMARY KEY, -- relay state sent to the IdP
+			request_id TEXT NOT NULL,
+			redirect   TEXT NOT NULL,
+			remember   INTEGER NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		)`,
+	} {
+		if _, err := tx.ExecContext(ctx, tx.db.ddl(stmt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateJobErrors keeps the error of every failed attempt at a queued job, not only the last
+func migrateJobErrors(ctx context.Context, tx *dbTx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE job_errors (
+			id         INTEGER PRIMARY KEY AUTOINCREMENT,
+			queue      TEXT NOT NULL,    -- "intake" or "cloud"
+			job_id     INTEGER NOT NULL, -- intake_jobs or cloud_uploads row
+			attempt    INTEGER NOT NULL,
+			error      TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX job_errors_job ON job_errors (queue, job_id)`,
+	} {
+		if _, err := tx.ExecContext(ctx, tx.db.ddl(stmt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateFaxWebhooks keeps the webhook URLs sent faxes' events are forwarded to
+func migrateFaxWebhooks(ctx context.Context, tx *dbTx) error {
+	_, err := tx.ExecContext(ctx, tx.db.ddl(`CREATE TABLE fax_webhooks (
+		profile    TEXT NOT NULL,
+		fax_id     TEXT NOT NULL,
+		url        TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (profile, fax_id)
+	)`))
+	return err
+}
+
+// migrateFaxOptions keeps the advanced send options users choose as their defaults
+func migrateFaxOptions(ctx context.Context, tx *dbTx) error {
+	for _, column := range []string{
+		"fax_monochrome INTEGER NOT NULL DEFAULT 0",
+		"fax_black_threshold INTEGER NOT NULL DEFAULT 0",
+		"fax_disable_t38 INTEGER NOT NULL DEFAULT 0",
+		"fax_display_name TEXT NOT NULL DEFAULT ''",
+	} {
+		if _, err := tx.ExecContext(ctx, `ALTER TABLE user_preferences ADD COLUMN `+tx.db.ddl(column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateDestinationIndex looks up the faxes sent to a number for its delivery statistics
+func migrateDestinationIndex(ctx context.Context, tx *dbTx) error {
+	_, err := tx.ExecContext(ctx, `CREATE INDEX faxes_destination ON faxes (profile, to_number)`)
+	return err
+}
+
+// migrateFaxEvents keeps the status events Telnyx posts for a fax, for its troubleshooting page
+func migrateFaxEvents(ctx context.Context, tx *dbTx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE fax_events (
+			id             INTEGER PRIMARY KEY AUTOINCREMENT,
+			fax_id         TEXT NOT NULL,
+			event_type     TEXT NOT NULL, -- e.g. fax.sending.started
+			status         TEXT NOT NULL DEFAULT '',
+			failure_reason TEXT NOT NULL DEFAULT '',
+			occurred_at    TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX fax_events_fax ON fax_events (fax_id)`,
+	} {
+		if _, err := tx.ExecContext(ctx, tx.db.ddl(stmt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateContacts keeps the names admins give fax numbers
+func migrateContacts(ctx context.Context, tx *dbTx) error {
+	_, err := tx.ExecContext(ctx, tx.db.ddl(`CREATE TABLE contacts (
+		number     TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`))
+	return err
+}
+
+// migrateBlocklist creates the table of numbers faxes may not be sent to
+func migrateBlocklist(ctx context.Context, tx *dbTx) error {
+	_, err := tx.ExecContext(ctx, tx.db.ddl(`CREATE TABLE blocked_numbers (
+		number     TEXT PRIMARY KEY,
+		reason     TEXT NOT NULL DEFAULT '',
+		source     TEXT NOT NULL,
+		added_by   TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL
+	)`))
+	return err
+}
+
+// migrateSettings creates the table of the settings changed at /admin/config
+func migrateSettings(ctx context.Context, tx *dbTx) error {
+	_, err := tx.ExecContext(ctx, tx.db.ddl(`CREATE TABLE settings (
+		name       TEXT PRIMARY KEY, -- environment variable the setting overrides
+		value      TEXT NOT NULL,
+		updated_by TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`))
+	return err
+}
+
+// migrateSetup creates the table of what the first-run setup at /setup saved
+func migrateSetup(ctx context.Context, tx *dbTx) error {
+	_, err := tx.ExecContext(ctx, tx.db.ddl(`CREATE TABLE setup (
+		name       TEXT PRIMARY KEY,
+		value      TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`))
+	return err
+}
+
+// migrateIdempotencyKeys scopes send idempotency keys to the user who claimed them and keeps the keys of
+// sends whose outcome is unknown. Keys live a day, so the old ones are dropped rather than copied.
+func migrateIdempotencyKeys(ctx context.Context, tx *dbTx) error {
+	if _, err := tx.ExecContext(ctx, `DROP TABLE idempotency_keys`); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, tx.db.ddl(`CREATE TABLE idempotency_keys (
+		"user"     TEXT NOT NULL,
+		key        TEXT NOT NULL,
+		profile    TEXT NOT NULL DEFAULT '',
+		fax_id     TEXT NOT NULL DEFAULT '', -- empty while the send is in progress
+		outcome    TEXT NOT NULL DEFAULT '', -- "unknown" if the send failed but may have created a fax
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY ("user", key)
+	)`))
+	return err
+}
+
+// migrateArchiveAttempts records failed attempts at archiving received faxes, so inbox loads wait before
+// trying them again
+func migrateArchiveAttempts(ctx context.Context, tx *dbTx) error {
+	_, err := tx.ExecContext(ctx, tx.db.ddl(`CREATE TABLE archive_attempts (
+		profile      TEXT NOT NULL,
+		fax_id       TEXT NOT NULL,
+		attempts     INTEGER NOT NULL,
+		last_error   TEXT NOT NULL,
+		next_attempt TIMESTAMP NOT NULL,
+		PRIMARY KEY (profile, fax_id)
+	)`))
+	return err
+}
+
+// migrate applies the pending migrations, returning those it applied
+func (s *Store) migrate(ctx context.Context) ([]migration, error) {
+	if s.db.postgres {
+		// The lock belongs to the connection, which is kept until it is released
+		conn, err := s.db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+			return nil, fmt.Errorf("lock the database for migrations: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock)
+	}
+	if _, err := s.db.ExecContext(ctx, s.db.ddl(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)); err != nil {
+		return nil, err
+	}
+	var applied []migration
+	for _, m := range migrations {
+		done, err := s.applyMigration(ctx, m)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if done {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// applyMigration applies a migration unless it was applied before, reporting whether it did
+func (s *Store) applyMigration(ctx context.Context, m migration) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, m.Version).Scan(&n); err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+	if err := m.Up(ctx, tx); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, time.Now().UTC()); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// schemaVersion returns the latest migration applied to the database, 0 for none
+func (s *Store) schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
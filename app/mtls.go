@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Users can sign in with a TLS client certificate issued by the organization's CA, for networks that
+// require mutual TLS for internal tools. fax-ui checks the certificate itself when it serves HTTPS
+// (--tls_cert), or takes it from a header set by the TLS-terminating proxy in front of it. The user is
+// the certificate's email address, or its subject's common name when it has none. A certificate signs in
+// each request by itself; there is no session to log out of.
+
+// ClientCertConfig configures signing in with TLS client certificates
+type ClientCertConfig struct {
+	CAFile  string   // PEM bundle of the CAs client certificates must be issued by; empty disables client certificates
+	Header  string   // header a TLS-terminating proxy passes the client certificate in, e.g. X-SSL-Client-Cert
+	Proxies []string // addresses and CIDRs the header is accepted from
+}
+
+// defaultCertProxies are the addresses the client certificate header is accepted from by default
+var defaultCertProxies = []string{"127.0.0.1/32", "::1/128"}
+
+// clientCerts verifies client certificates
+type clientCerts struct {
+	roots   *x509.CertPool
+	header  string
+	proxies []netip.Prefix
+}
+
+// newClientCerts loads the CAs client certificates must be issued by
+func newClientCerts(cfg ClientCertConfig) (*clientCerts, error) {
+	data, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("client certificate CA: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("client certificate CA: no certificates in %s", cfg.CAFile)
+	}
+	c := &clientCerts{roots: roots, header: cfg.Header}
+	for _, p := range cfg.Proxies {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(p)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid client certificate proxy %q", p)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		c.proxies = append(c.proxies, prefix)
+	}
+	return c, nil
+}
+
+// tlsConfig asks browsers for a client certificate. Requests without one still reach fax-ui, since Telnyx
+// fetches documents and posts webhooks without one; pages require it as a sign-in.
+func (c *clientCerts) tlsConfig() *tls.Config {
+	return &tls.Config{ClientCAs: c.roots, ClientAuth: tls.VerifyClientCertIfGiven}
+}
+
+// user returns the user a request's client certificate identifies
+func (c *clientCerts) user(r *http.Request) (string, bool) {
+	var cert *x509.Certificate
+	switch {
+	case r.TLS != nil && len(r.TLS.VerifiedChains) > 0:
+		// Verified against the CAs during the handshake
+		cert = r.TLS.PeerCertificates[0]
+	case c.header != "" && r.Header.Get(c.header) != "" && c.fromProxy(r):
+		var err error
+		if cert, err = parseHeaderCert(r.Header.Get(c.header)); err != nil {
+			return "", false
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: c.roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return certUser(cert)
+}
+
+// certUser returns the user a client certificate identifies: its email address, or its subject's common name
+func certUser(cert *x509.Certificate) (string, bool) {
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0], true
+	}
+	return cert.Subject.CommonName, cert.Subject.CommonName != ""
+}
+
+// fromProxy checks that the request came from one of the proxies trusted with the certificate header
+func (c *clientCerts) fromProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	for _, p := range c.proxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHeaderCert parses a certificate as proxies pass it: URL-encoded PEM (nginx's $ssl_client_escaped_cert),
+// PEM, or base64 DER
+func parseHeaderCert(value string) (*x509.Certificate, error) {
+	if unescaped, err := url.QueryUnescape(value); err == nil && strings.Contains(unescaped, "-----BEGIN") {
+		value = unescaped
+	}
+	if strings.Contains(value, "-----BEGIN") {
+		// Some proxies fold the PEM onto one line
+		value = strings.ReplaceAll(value, "\t", "\n")
+		block, _ := pem.Decode([]byte(value))
+		if block == nil {
+			return nil, errors.New("invalid PEM certificate")
+		}
+		return x509.ParseCertificate(block.Bytes)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
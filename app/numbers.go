@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+)
+
+// numberCacheTTL is how long the owned phone number list is reused before refetching from Telnyx
+const numberCacheTTL = 10 * time.Minute
+
+// ownedNumber is a phone number on the Telnyx account
+type ownedNumber struct {
+	ID             string `json:"id"`
+	PhoneNumber    string `json:"phone_number"`
+	ConnectionID   string `json:"connection_id"`
+	ConnectionName string `json:"connection_name"`
+	FaxEnabled     bool   `json:"fax_enabled"` // assigned to one of the account's fax applications
+}
+
+// listOwnedNumbers returns the profile account's active phone numbers, served from cache unless expired or refresh is set
+func (p *Profile) listOwnedNumbers(ctx context.Context, refresh bool) ([]ownedNumber, error) {
+	// Resolve fax applications first so numbers can be flagged as fax-enabled
+	conns, err := p.listConnections(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+	faxApps := make(map[string]bool, len(conns))
+	for _, c := range conns {
+		faxApps[c.ID] = true
+	}
+
+	return p.numberCache.get(numberCacheTTL, refresh, func() ([]ownedNumber, error) {
+		ctx, cancel := context.WithTimeout(ctx, p.pageTimeout)
+		defer cancel()
+
+		var numbers []ownedNumber
+		iter := p.client.PhoneNumbers.ListAutoPaging(ctx, telnyx.PhoneNumberListParams{
+			PageSize: telnyx.Int(250),
+			Filter: telnyx.PhoneNumberListParamsFilter{
+				Status: "active",
+			},
+		})
+		for iter.Next() {
+			n := iter.Current()
+			numbers = append(numbers, ownedNumber{
+				ID:             n.ID,
+				PhoneNumber:    n.PhoneNumber,
+				ConnectionID:   n.ConnectionID,
+				ConnectionName: n.ConnectionName,
+				FaxEnabled:     n.ConnectionID != "" && faxApps[n.ConnectionID],
+			})
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+		sort.Slice(numbers, func(i, j int) bool { return numbers[i].PhoneNumber < numbers[j].PhoneNumber })
+		return numbers, nil
+	})
+}
+
+// faxNumbers returns the account's fax-enabled numbers for the from selector, or nil if they cannot be fetched.
+// Fetch errors are not fatal: templates fall back to a free-text from field.
+func (p *Profile) faxNumbers(ctx context.Context) []ownedNumber {
+	numbers, err := p.listOwnedNumbers(ctx, false)
+	if err != nil {
+		return nil
+	}
+	var fax []ownedNumber
+	for _, n := range numbers {
+		if n.FaxEnabled {
+			fax = append(fax, n)
+		}
+	}
+	return fax
+}
+
+// validateFromNumber checks that from is a number owned by the account, refetching the list once
+// in case the number was added recently. SIP URIs and non-Telnyx profiles are not checked. If the
+// number list cannot be fetched the check is skipped and logged.
+func (p *Profile) validateFromNumber(ctx context.Context, from string) error {
+	if isSIPURI(from) || p.client == nil {
+		return nil
+	}
+	for _, refresh := range []bool{false, true} {
+		numbers, err := p.listOwnedNumbers(ctx, refresh)
+		if err != nil {
+			log.Printf("Warning: could not verify from number ownership: %v", err)
+			return nil
+		}
+		for _, n := range numbers {
+			if n.PhoneNumber == from {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s is not a phone number on this Telnyx account", from)
+}
+
+// availableNumber is a phone number that can be purchased
+type availableNumber struct {
+	PhoneNumber string
+	Region      string
+	MonthlyCost string
+	UpfrontCost string
+	Currency    string
+}
+
+// searchAvailableNumbers searches Telnyx inventory for fax-capable numbers in the given area code
+func (a *App) searchAvailableNumbers(ctx context.Context, country, areaCode string) ([]availableNumber, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.Resilience.PageTimeout)
+	defer cancel()
+
+	res, err := a.Client.AvailablePhoneNumbers.List(ctx, telnyx.AvailablePhoneNumberListParams{
+		Filter: telnyx.AvailablePhoneNumberListParamsFilter{
+			CountryCode:             telnyx.String(country),
+			NationalDestinationCode: telnyx.String(areaCode),
+			Features:                []string{"fax"},
+			Limit:                   telnyx.Int(20),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []availableNumber
+	for _, n := range res.Data {
+		var regions []string
+		for _, ri := range n.RegionInformation {
+			if ri.RegionType == "rate_center" || ri.RegionType == "state" {
+				regions = append(regions, ri.RegionName)
+			}
+		}
+		numbers = append(numbers, availableNumber{
+			PhoneNumber: n.PhoneNumber,
+			Region:      strings.Join(regions, ", "),
+			MonthlyCost: n.CostInformation.MonthlyCost,
+			UpfrontCost: n.CostInformation.UpfrontCost,
+			Currency:    n.CostInformation.Currency,
+		})
+	}
+	return numbers, nil
+}
+
+// handleAdminNumbers shows owned numbers and lets admins search, purchase and attach numbers to a fax application
+func (a *App) handleAdminNumbers(w http.ResponseWriter, r *http.Request) {
+	if a.Sandbox {
+		http.Error(w, "Phone numbers are managed in Telnyx and are not available in sandbox mode.", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		a.handleShowAdminNumbers(w, r)
+	case http.MethodPost:
+		a.handleUpdateAdminNumbers(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleShowAdminNumbers renders owned numbers and, when an area code is given, purchasable numbers
+func (a *App) handleShowAdminNumbers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	country := strings.ToUpper(firstNonEmpty(q.Get("country"), a.DefaultCountry))
+	areaCode := strings.TrimSpace(q.Get("area_code"))
+
+	data := map[string]any{
+		"Country":     country,
+		"AreaCode":    areaCode,
+		"AppID":       firstNonEmpty(q.Get("app"), a.defaultConnection(r)),
+		"Connections": a.defaultProfile().cachedConnections(r.Context()),
+		"Success":     q.Get("success"),
+		"Error":       q.Get("error"),
+	}
+
+	owned, err := a.defaultProfile().listOwnedNumbers(r.Context(), q.Get("refresh") != "")
+	if err != nil {
+		data["Error"] = firstNonEmpty(q.Get("error"), a.failureMessage(r, err, "Failed to list phone numbers"))
+	}
+	data["Owned"] = owned
+
+	if areaCode != "" {
+		available, err := a.searchAvailableNumbers(r.Context(), country, areaCode)
+		if err != nil {
+			data["Error"] = a.failureMessage(r, err, "Number search failed")
+		}
+		data["Available"] = available
+		data["Searched"] = true
+	}
+
+	a.render(w, r, "admin_numbers.html", data)
+}
+
+// handleUpdateAdminNumbers purchases a number or attaches an owned number to the selected fax application
+func (a *App) handleUpdateAdminNumbers(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	appID := strings.TrimSpace(r.FormValue("app"))
+	redirect := func(status url.Values) {
+		status.Set("app", appID)
+		status.Set("refresh", "1")
+		http.Redirect(w, r, "/admin/numbers?"+status.Encode(), http.StatusSeeOther)
+	}
+	if appID == "" {
+		redirect(url.Values{"error": {a.T(r, "Select a fax application first")}})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.ActionTimeout)
+	defer cancel()
+
+	switch r.FormValue("action") {
+	case "purchase":
+		number := strings.TrimSpace(r.FormValue("phone_number"))
+		if number == "" {
+			redirect(url.Values{"error": {a.T(r, "phone_number is required")}})
+			return
+		}
+		// Ordering with a connection ID attaches the number to the fax application on completion
+		res, err := a.Client.NumberOrders.New(ctx, telnyx.NumberOrderNewParams{
+			ConnectionID: telnyx.String(appID),
+			PhoneNumbers: []telnyx.NumberOrderNewParamsPhoneNumber{{PhoneNumber: number}},
+		})
+		if err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "Purchase failed")}})
+			return
+		}
+		log.Printf("Ordered number %s for fax application %s (order %s, status %s)", number, appID, res.Data.ID, res.Data.Status)
+		redirect(url.Values{"success": {a.T(r, "Ordered %s (order status: %s). It will appear below once the order completes.", number, res.Data.Status)}})
+	case "attach":
+		numberID := strings.TrimSpace(r.FormValue("number_id"))
+		if numberID == "" {
+			redirect(url.Values{"error": {a.T(r, "number_id is required")}})
+			return
+		}
+		res, err := a.Client.PhoneNumbers.Update(ctx, numberID, telnyx.PhoneNumberUpdateParams{
+			ConnectionID: telnyx.String(appID),
+		})
+		if err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "Attach failed")}})
+			return
+		}
+		log.Printf("Attached number %s to fax application %s", res.Data.PhoneNumber, appID)
+		redirect(url.Values{"success": {a.T(r, "%s is now attached to the fax application.", res.Data.PhoneNumber)}})
+	default:
+		redirect(url.Values{"error": {a.T(r, "unknown action")}})
+	}
+}
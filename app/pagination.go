@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Page sizes offered by the fax lists; page_size may ask for any size up to maxPageSize, the largest Telnyx allows
+var pageSizes = []int64{10, 25, 50, 100}
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 250
+)
+
+// pager describes the page of a list being shown, with links to the other pages
+type pager struct {
+	Number  int64
+	Size    int64
+	Results int64 // total number of faxes; zero when the provider does not report it
+	Pages   int64 // total number of pages; zero when the provider does not report it
+
+	// Links to the first, previous, next and last pages; empty where there is no such page
+	First, Prev, Next, Last string
+	Sizes                   []pageSizeOption
+}
+
+// pageSizeOption is an entry of the page size selector
+type pageSizeOption struct {
+	Size     int64
+	Link     string
+	Selected bool
+}
+
+// requestPage reads the page_number and page_size parameters, falling back to the first page of defaultPageSize
+func requestPage(r *http.Request) (number, size int64) {
+	number, size = 1, defaultPageSize
+	if n, err := strconv.ParseInt(r.URL.Query().Get("page_size"), 10, 64); err == nil && n > 0 {
+		size = min(n, maxPageSize)
+	}
+	if n, err := strconv.ParseInt(r.URL.Query().Get("page_number"), 10, 64); err == nil && n > 0 {
+		number = n
+	}
+	return number, size
+}
+
+// pageLink links to page number of size of the list requested by r, keeping its filters
+func pageLink(r *http.Request, number, size int64) string {
+	query := r.URL.Query()
+	query.Set("page_number", strconv.FormatInt(number, 10))
+	query.Set("page_size", strconv.FormatInt(size, 10))
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// newPager describes page number of size, which holds count faxes. Without totals, a full page
+// is taken to mean there is a next one.
+func newPager(r *http.Request, number, size int64, count int, totals listTotals) *pager {
+	p := &pager{Number: number, Size: size, Results: totals.Results, Pages: totals.Pages}
+	if number > 1 {
+		p.First = pageLink(r, 1, size)
+		p.Prev = pageLink(r, number-1, size)
+	}
+	if totals.Pages > 0 && number < totals.Pages {
+		p.Next = pageLink(r, number+1, size)
+		p.Last = pageLink(r, totals.Pages, size)
+	} else if totals.Pages == 0 && int64(count) == size {
+		p.Next = pageLink(r, number+1, size)
+	}
+	sizes := pageSizes
+	if !slices.Contains(sizes, size) {
+		sizes = append(slices.Clone(sizes), size)
+		slices.Sort(sizes)
+	}
+	for _, s := range sizes {
+		p.Sizes = append(p.Sizes, pageSizeOption{Size: s, Link: pageLink(r, 1, s), Selected: s == size})
+	}
+	return p
+}
+
+// faxSorts maps the columns a fax list can be sorted by to the stored faxes' columns. The providers' APIs
+// only list newest first, so any other order is served from the local database.
+var faxSorts = map[string]string{"created_at": "f.created_at", "status": "f.status", "to": "f.to_number"}
+
+// listSort is the order of a fax list, with links to sort it by a column
+type listSort struct {
+	Column    string
+	Ascending bool
+	r         *http.Request
+}
+
+// requestSort reads the sort and order parameters. Columns other than created_at sort ascending by default.
+func requestSort(r *http.Request) listSort {
+	s := listSort{Column: r.URL.Query().Get("sort"), r: r}
+	if _, ok := faxSorts[s.Column]; !ok {
+		s.Column = "created_at"
+	}
+	switch r.URL.Query().Get("order") {
+	case "asc":
+		s.Ascending = true
+	case "desc":
+	default:
+		s.Ascending = s.Column != "created_at"
+	}
+	return s
+}
+
+// query returns the faxQuery.Sort for s, empty for the providers' newest first
+func (s listSort) query() string {
+	if s.Column == "created_at" && !s.Ascending {
+		return ""
+	}
+	return s.Column
+}
+
+// Link links to the list sorted by column, reversing the order if it is already sorted by it
+func (s listSort) Link(column string) string {
+	ascending := column != "created_at"
+	if column == s.Column {
+		ascending = !s.Ascending
+	}
+	query := s.r.URL.Query()
+	query.Set("sort", column)
+	query.Set("order", map[bool]string{true: "asc", false: "desc"}[ascending])
+	query.Del("page_number")
+	return s.r.URL.Path + "?" + query.Encode()
+}
+
+// Mark shows whether the list is sorted by column, and in which order
+func (s listSort) Mark(column string) string {
+	switch {
+	case column != s.Column:
+		return ""
+	case s.Ascending:
+		return "▲"
+	default:
+		return "▼"
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// faxRow is a fax with what its row in the list shows beside it. The list page and /partials/fax-row
+// render it with the same "fax_row" template, so a refreshed row matches the page.
+type faxRow struct {
+	Fax
+	Profile string
+	Tags    []string
+}
+
+// faxRows pairs a page of faxes with their tags
+func faxRows(faxes []Fax, profile string, tags map[string][]string) []faxRow {
+	rows := make([]faxRow, len(faxes))
+	for i, f := range faxes {
+		rows[i] = faxRow{Fax: f, Profile: profile, Tags: tags[f.ID]}
+	}
+	return rows
+}
+
+// Final reports whether the fax has finished, so pages can stop refreshing it
+func (f Fax) Final() bool {
+	return f.Status == "delivered" || f.Status == "received" || f.Status == "failed"
+}
+
+// faxPartialJSON is the JSON form of both partial endpoints
+type faxPartialJSON struct {
+	ID                 string    `json:"id"`
+	Profile            string    `json:"profile"`
+	Status             string    `json:"status"`
+	Final              bool      `json:"final"`
+	Direction          string    `json:"direction"`
+	From               string    `json:"from"`
+	To                 string    `json:"to"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+	Pages              int       `json:"pages,omitempty"`
+	DurationSeconds    int       `json:"duration_seconds,omitempty"`
+	FailureReason      string    `json:"failure_reason,omitempty"`
+	FailureExplanation string    `json:"failure_explanation,omitempty"`
+	Tags               []string  `json:"tags"`
+}
+
+// handleFaxRowPartial renders one row of the fax list, for refreshing it in place
+func (a *App) handleFaxRowPartial(w http.ResponseWriter, r *http.Request) {
+	a.handleFaxPartial(w, r, "fax_row")
+}
+
+// handleFaxStatusPartial renders the status of a fax as shown on its detail page
+func (a *App) handleFaxStatusPartial(w http.ResponseWriter, r *http.Request) {
+	a.handleFaxPartial(w, r, "fax_status")
+}
+
+// handleFaxStatus returns the status of a fax as JSON, for the detail page to follow it until it finishes
+func (a *App) handleFaxStatus(w http.ResponseWriter, r *http.Request) {
+	a.handleFaxPartial(w, r, "")
+}
+
+// handleFaxPartial looks up the fax named by the id and profile parameters and renders it with the named
+// template, or as JSON for format=json, an Accept: application/json request or no template
+func (a *App) handleFaxPartial(w http.ResponseWriter, r *http.Request, tmpl string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !a.requireFaxVisible(w, r, profile, id) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.PageTimeout)
+	defer cancel()
+	fax, err := profile.provider.Get(ctx, id)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	faxTags, err := a.Store.faxTags(ctx, profile.Name, []string{id})
+	if err != nil {
+		log.Printf("Warning: could not load tags for fax %s: %v", id, err)
+	}
+	row := faxRow{Fax: *fax, Profile: profile.Name, Tags: faxTags[id]}
+
+	w.Header().Set("Cache-Control", "no-store")
+	explanation := ""
+	if e, ok := explainFailure(fax.FailureReason); ok {
+		explanation = a.T(r, e.Summary)
+	}
+	if tmpl == "" || r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, faxPartialJSON{
+			ID:                 fax.ID,
+			Profile:            profile.Name,
+			Status:             fax.Status,
+			Final:              fax.Final(),
+			Direction:          fax.Direction,
+			From:               fax.From,
+			To:                 fax.To,
+			CreatedAt:          fax.CreatedAt,
+			UpdatedAt:          fax.UpdatedAt,
+			Pages:              fax.Pages,
+			DurationSeconds:    int(fax.Duration.Seconds()),
+			FailureReason:      fax.FailureReason,
+			FailureExplanation: explanation,
+			Tags:               append([]string{}, row.Tags...),
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := a.tmpl(r).ExecuteTemplate(w, tmpl, row); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
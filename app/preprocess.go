@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// preprocessDPI is the resolution scans are rendered at for cleaning up
+const preprocessDPI = 200
+
+// Deskewing tries angles up to maxSkew degrees either way, in skewStep increments
+const (
+	maxSkew  = 5.0
+	skewStep = 0.25
+)
+
+// PreprocessConfig selects the clean-up applied to uploaded PDFs before they are sent (PREPROCESS)
+type PreprocessConfig struct {
+	Grayscale  bool // grayscale with the contrast stretched
+	BlackWhite bool // pure black and white; implies Grayscale
+	Deskew     bool // straighten pages scanned at a slight angle
+}
+
+// parsePreprocess reads a comma-separated list of grayscale, bw and deskew
+func parsePreprocess(s string) (PreprocessConfig, error) {
+	var c PreprocessConfig
+	for _, step := range splitList(s) {
+		switch strings.ToLower(step) {
+		case "grayscale", "greyscale":
+			c.Grayscale = true
+		case "bw":
+			c.Grayscale, c.BlackWhite = true, true
+		case "deskew":
+			c.Deskew = true
+		default:
+			return c, fmt.Errorf("unknown preprocessing step %q (expected grayscale, bw or deskew)", step)
+		}
+	}
+	return c, nil
+}
+
+func (c PreprocessConfig) enabled() bool {
+	return c.Grayscale || c.Deskew
+}
+
+// preprocessPDF renders each page of a PDF with Ghostscript, cleans it up and returns a PDF of the cleaned page images.
+// Rendering always drops color; the configuration decides what else happens.
+func preprocessPDF(ctx context.Context, gs string, cfg PreprocessConfig, doc *document) (*document, error) {
+	dir, err := os.MkdirTemp("", "fax-ui-preprocess-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.pdf")
+	if err := os.WriteFile(in, doc.Data, 0o600); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, compatTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, gs, "-q", "-dSAFER", "-dBATCH", "-dNOPAUSE",
+		"-sDEVICE=pnggray", fmt.Sprintf("-r%d", preprocessDPI), "-sOutputFile="+filepath.Join(dir, "page-%04d.png"), in)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ghostscript: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// Glob sorts the zero-padded page numbers in order
+	files, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err != nil || len(files) == 0 {
+		return nil, fmt.Errorf("ghostscript rendered no pages")
+	}
+
+	objects := []string{"<< /Type /Catalog /Pages 2 0 R >>", ""}
+	var kids []string
+	for _, file := range files {
+		page, err := loadGrayPNG(file)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Deskew {
+			if angle := skewAngle(page); angle != 0 {
+				page = rotateGray(page, angle)
+			}
+		}
+		if cfg.Grayscale {
+			stretchContrast(page)
+		}
+		pageImage, err := pdfPageImage(page, cfg.BlackWhite)
+		if err != nil {
+			return nil, err
+		}
+		n := len(objects) + 1 // page, content stream and image objects are n, n+1 and n+2
+		b := page.Bounds()
+		width, height := float64(b.Dx())*72/preprocessDPI, float64(b.Dy())*72/preprocessDPI
+		content := fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm /Im0 Do Q\n", width, height)
+		kids = append(kids, fmt.Sprintf("%d 0 R", n))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>", width, height, n+2, n+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+			pageImage,
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+	return &document{Name: doc.Name, Type: "application/pdf", Data: writePDF(objects)}, nil
+}
+
+func loadGrayPNG(path string) (*image.Gray, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("read rendered page: %w", err)
+	}
+	if g, ok := img.(*image.Gray); ok {
+		return g, nil
+	}
+	g := image.NewGray(img.Bounds())
+	draw.Draw(g, g.Bounds(), img, img.Bounds().Min, draw.Src)
+	return g, nil
+}
+
+// stretchContrast maps the darkest and lightest percent of the page to black and white, so faded
+// scans and grey paper come out crisp
+func stretchContrast(img *image.Gray) {
+	var hist [256]int
+	for _, v := range img.Pix {
+		hist[v]++
+	}
+	clip := len(img.Pix) / 100
+	low, high := 0, 255
+	for n := 0; low < 255 && n+hist[low] <= clip; low++ {
+		n += hist[low]
+	}
+	for n := 0; high > 0 && n+hist[high] <= clip; high-- {
+		n += hist[high]
+	}
+	if high-low < 16 {
+		return // a blank or nearly uniform page
+	}
+	var table [256]uint8
+	for v := range table {
+		table[v] = uint8(min(max((v-low)*255/(high-low), 0), 255))
+	}
+	for i, v := range img.Pix {
+		img.Pix[i] = table[v]
+	}
+}
+
+// otsuThreshold picks the grey level that best separates ink from paper
+func otsuThreshold(img *image.Gray) uint8 {
+	var hist [256]float64
+	for _, v := range img.Pix {
+		hist[v]++
+	}
+	total := float64(len(img.Pix))
+	var sum float64
+	for v, n := range hist {
+		sum += float64(v) * n
+	}
+	var sumBack, weightBack, best float64
+	threshold := 128
+	for v, n := range hist {
+		weightBack += n
+		weightFore := total - weightBack
+		if weightBack == 0 {
+			continue
+		}
+		if weightFore == 0 {
+			break
+		}
+		sumBack += float64(v) * n
+		meanBack, meanFore := sumBack/weightBack, (sum-sumBack)/weightFore
+		if between := weightBack * weightFore * (meanBack - meanFore) * (meanBack - meanFore); between > best {
+			best, threshold = between, v+1
+		}
+	}
+	return uint8(min(threshold, 255))
+}
+
+// skewAngle estimates in degrees how far the text lines are turned clockwise, i.e. slope down to the
+// right. Lines of text give the sharpest row profile when projected at their own angle.
+func skewAngle(img *image.Gray) float64 {
+	threshold := otsuThreshold(img)
+	b := img.Bounds()
+	// Sample every other dark pixel; that is plenty to find the angle. Rows are binned at the same
+	// step, or the sampling itself would make the unrotated profile look sharpest.
+	const step = 2
+	var xs, ys []float64
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		row := img.Pix[(y-b.Min.Y)*img.Stride:]
+		for x := 0; x < b.Dx(); x += step {
+			if row[x] < threshold {
+				xs = append(xs, float64(x))
+				ys = append(ys, float64(y-b.Min.Y))
+			}
+		}
+	}
+	if len(xs) < 100 {
+		return 0
+	}
+	diagonal := int(math.Hypot(float64(b.Dx()), float64(b.Dy())))/step + 1
+	bins := make([]float64, 2*diagonal+1)
+	bestAngle, bestScore := 0.0, -1.0
+	for angle := -maxSkew; angle <= maxSkew+skewStep/2; angle += skewStep {
+		sin, cos := math.Sincos(angle * math.Pi / 180)
+		clear(bins)
+		for i := range xs {
+			bins[int((ys[i]*cos-xs[i]*sin)/step)+diagonal]++
+		}
+		var score float64
+		for _, n := range bins {
+			score += n * n
+		}
+		if score > bestScore {
+			bestAngle, bestScore = angle, score
+		}
+	}
+	if math.Abs(bestAngle) < skewStep/2 {
+		return 0
+	}
+	return bestAngle
+}
+
+// rotateGray rotates a page counterclockwise by degrees around its center, filling the corners with white
+func rotateGray(img *image.Gray, degrees float64) *image.Gray {
+	b := img.Bounds()
+	out := image.NewGray(b)
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	cx, cy := float64(b.Dx())/2, float64(b.Dy())/2
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			// Each output pixel takes the source pixel rotated back onto it
+			dx, dy := float64(x)-cx, float64(y)-cy
+			sx, sy := int(math.Round(dx*cos-dy*sin+cx)), int(math.Round(dx*sin+dy*cos+cy))
+			v := uint8(255)
+			if sx >= 0 && sy >= 0 && sx < b.Dx() && sy < b.Dy() {
+				v = img.Pix[sy*img.Stride+sx]
+			}
+			out.Pix[y*out.Stride+x] = v
+		}
+	}
+	return out
+}
+
+// pdfPageImage encodes a page as a PDF image object: 8-bit grey, or 1-bit black and white when bw is set
+func pdfPageImage(img *image.Gray, bw bool) (string, error) {
+	b := img.Bounds()
+	bits := 8
+	var raw []byte
+	if bw {
+		bits = 1
+		threshold := otsuThreshold(img)
+		rowBytes := (b.Dx() + 7) / 8
+		raw = make([]byte, rowBytes*b.Dy())
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				// In DeviceGray a set bit is white
+				if img.Pix[y*img.Stride+x] >= threshold {
+					raw[y*rowBytes+x/8] |= 0x80 >> (x % 8)
+				}
+			}
+		}
+	} else {
+		raw = make([]byte, 0, b.Dx()*b.Dy())
+		for y := 0; y < b.Dy(); y++ {
+			raw = append(raw, img.Pix[y*img.Stride:y*img.Stride+b.Dx()]...)
+		}
+	}
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	if _, err := zw.Write(raw); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent %d /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream",
+		b.Dx(), b.Dy(), bits, data.Len(), data.String()), nil
+}
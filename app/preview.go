@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// handleFaxPreview streams a fax's stored preview to the signed-in user. The preview is fetched
+// server-side, so the page never links to Telnyx and the API key stays on the server.
+func (a *App) handleFaxPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !a.requireFaxVisible(w, r, profile, id) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+	// Received faxes are served from the archived copy, which outlives the provider's links
+	if name, _, err := a.Store.receivedMedia(ctx, profile.Name, id); err == nil && name != "" {
+		if doc, err := a.Media.Get(ctx, name); err == nil {
+			setPreviewHeaders(w, id, doc.Type)
+			w.Header().Set("Content-Length", fmt.Sprint(len(doc.Data)))
+			w.Write(doc.Data)
+			a.recordAccess(r, accessDocument, profile.Name, id)
+			return
+		}
+	}
+	// Look the fax up again rather than trusting a URL from the client; preview links expire
+	fax, err := profile.provider.Get(ctx, id)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	if fax.PreviewURL == "" {
+		http.Error(w, "this fax has no stored preview", http.StatusNotFound)
+		return
+	}
+
+	req, err := profile.documentRequest(ctx, fax.PreviewURL)
+	if err != nil {
+		http.Error(w, "invalid preview URL", http.StatusBadGateway)
+		return
+	}
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		http.Error(w, "failed to fetch preview: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		http.Error(w, "failed to fetch preview: "+resp.Status, http.StatusBadGateway)
+		return
+	}
+
+	setPreviewHeaders(w, id, resp.Header.Get("Content-Type"))
+	if resp.ContentLength > 0 {
+		w.Header().Set("Content-Length", fmt.Sprint(resp.ContentLength))
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("Warning: preview of fax %s was cut off: %v", id, err)
+	}
+	a.recordAccess(r, accessDocument, profile.Name, id)
+}
+
+// setPreviewHeaders marks a fax document as private and shown inline
+func setPreviewHeaders(w http.ResponseWriter, id, contentType string) {
+	contentType = firstNonEmpty(contentType, "application/pdf")
+	ext := ".pdf"
+	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+		ext = exts[0]
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="fax-%s-preview%s"`, sanitizeFilename(id), ext))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// documentRequest builds the request for a preview or media link reported by the profile's provider.
+// Links served by the Telnyx API need the key; pre-signed storage links must not receive it.
+func (p *Profile) documentRequest(ctx context.Context, link string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.client != nil && isTelnyxHost(req.URL) {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+	return req, nil
+}
+
+// isTelnyxHost reports whether u points at Telnyx itself rather than a storage provider
+func isTelnyxHost(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	return u.Scheme == "https" && (host == "telnyx.com" || strings.HasSuffix(host, ".telnyx.com"))
+}
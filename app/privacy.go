@@ -0,0 +1,343 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Admins answer data subject requests (GDPR access and erasure) under Privacy. A subject is a phone number,
+// covering the faxes sent to or from it, or a user identity such as an email address, covering what that
+// user did in fax-ui. Exports bundle every local record and stored document as JSON plus files. Erasure
+// deletes the documents and drafts and replaces the number or identity with a pseudonym, keeping pages and
+// costs so billing reports still add up.
+
+// pseudonymSecret names the server secret that keys pseudonyms, so one subject always gets the same one
+const pseudonymSecret = "privacy_pseudonym_key"
+
+// dataSubject is whose records a privacy request covers: a phone number, or a user identity
+type dataSubject struct {
+	Number string // E.164
+	User   string
+}
+
+func (d dataSubject) String() string {
+	return firstNonEmpty(d.Number, d.User)
+}
+
+// parseDataSubject reads a subject from the form: anything with an @ is a user identity, anything else a number
+func (a *App) parseDataSubject(s string) (dataSubject, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return dataSubject{}, fmt.Errorf("enter a phone number or user")
+	case strings.Contains(s, "@") && !isSIPURI(s):
+		return dataSubject{User: s}, nil
+	}
+	number, err := normalizePhoneNumber(s, a.DefaultCountry)
+	if err != nil {
+		return dataSubject{}, err
+	}
+	return dataSubject{Number: number}, nil
+}
+
+// pseudonym returns the stable stand-in that replaces the subject in erased records
+func (a *App) pseudonym(ctx context.Context, d dataSubject) (string, error) {
+	key, err := a.Store.secret(ctx, pseudonymSecret, generateSessionToken)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.ToLower(d.String())))
+	return "erased-" + hex.EncodeToString(mac.Sum(nil))[:12], nil
+}
+
+// subjectBundle is the subject.json of a privacy export
+type subjectBundle struct {
+	Subject     string             `json:"subject"`
+	ExportedAt  time.Time          `json:"exported_at"`
+	Faxes       []subjectFaxJSON   `json:"faxes"`
+	Drafts      []subjectDraftJSON `json:"drafts"`
+	Audit       []subjectAuditJSON `json:"audit_log"`
+	Preferences map[string]string  `json:"preferences,omitempty"`
+	Browsers    int                `json:"push_subscriptions,omitempty"` // browsers signed up for notifications
+}
+
+type subjectFaxJSON struct {
+	ID            string        `json:"id"`
+	Profile       string        `json:"profile"`
+	Direction     string        `json:"direction"`
+	Status        string        `json:"status,omitempty"`
+	From          string        `json:"from"`
+	To            string        `json:"to"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Pages         int           `json:"pages,omitempty"`
+	Cost          float64       `json:"cost,omitempty"`
+	Currency      string        `json:"currency,omitempty"`
+	FailureReason string        `json:"failure_reason,omitempty"`
+	RemoteID      string        `json:"remote_id,omitempty"`
+	SentBy        string        `json:"sent_by,omitempty"`
+	Tags          []string      `json:"tags,omitempty"`
+	Comments      []commentJSON `json:"comments,omitempty"`
+	Document      string        `json:"document,omitempty"` // file in the bundle
+	Note          string        `json:"note,omitempty"`
+}
+
+type commentJSON struct {
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type subjectDraftJSON struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"owner"`
+	Profile    string    `json:"profile"`
+	To         string    `json:"to,omitempty"`
+	From       string    `json:"from,omitempty"`
+	CoverText  string    `json:"cover_text,omitempty"`
+	Attachment string    `json:"attachment,omitempty"` // file in the bundle
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type subjectAuditJSON struct {
+	At      time.Time `json:"at"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action"`
+	Profile string    `json:"profile,omitempty"`
+	FaxID   string    `json:"fax_id,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// handleAdminPrivacy shows the data subject form (GET), and exports or erases a subject's records (POST)
+func (a *App) handleAdminPrivacy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.render(w, r, "admin_privacy.html", map[string]any{
+			"Subject": r.URL.Query().Get("subject"),
+			"Success": r.URL.Query().Get("success"),
+			"Error":   r.URL.Query().Get("error"),
+		})
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		fail := func(msg string, args ...any) {
+			q := url.Values{"subject": {r.FormValue("subject")}, "error": {a.T(r, msg, args...)}}
+			http.Redirect(w, r, "/admin/privacy?"+q.Encode(), http.StatusSeeOther)
+		}
+		subject, err := a.parseDataSubject(r.FormValue("subject"))
+		if err != nil {
+			fail(err.Error())
+			return
+		}
+		// The audit log names the subject by pseudonym only, so it does not itself keep the erased data
+		pseudonym, err := a.pseudonym(r.Context(), subject)
+		if err != nil {
+			fail("Failed to look up records: " + err.Error())
+			return
+		}
+		switch r.FormValue("action") {
+		case "export":
+			a.exportSubject(w, r, subject, pseudonym, fail)
+		case "erase":
+			a.eraseSubject(w, r, subject, pseudonym, fail)
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// exportSubject streams a ZIP of subject.json and the subject's documents
+func (a *App) exportSubject(w http.ResponseWriter, r *http.Request, subject dataSubject, pseudonym string, fail func(string, ...any)) {
+	ctx := r.Context()
+	faxes, err := a.Store.subjectFaxes(ctx, subject)
+	if err != nil {
+		fail("Failed to look up records: " + err.Error())
+		return
+	}
+	drafts, err := a.Store.subjectDrafts(ctx, subject)
+	if err != nil {
+		fail("Failed to look up records: " + err.Error())
+		return
+	}
+	ids := make([]string, len(faxes))
+	for i, f := range faxes {
+		ids[i] = f.FaxID
+	}
+	entries, err := a.Store.subjectAudit(ctx, subject, ids)
+	if err != nil {
+		fail("Failed to look up records: " + err.Error())
+		return
+	}
+
+	bundle := subjectBundle{Subject: subject.String(), ExportedAt: time.Now().UTC(), Faxes: []subjectFaxJSON{},
+		Drafts: []subjectDraftJSON{}, Audit: []subjectAuditJSON{}}
+	name := fmt.Sprintf("privacy-export-%s.zip", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	zw := zip.NewWriter(w)
+	addFile := func(name string, data []byte, modified time.Time) {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+		if err == nil {
+			_, err = f.Write(data)
+		}
+		if err != nil {
+			log.Printf("Warning: privacy export: could not add %s: %v", name, err)
+		}
+	}
+	documents := 0
+	for _, f := range faxes {
+		row := subjectFaxJSON{ID: f.FaxID, Profile: f.Profile, Direction: f.Direction, Status: f.Status, From: f.From, To: f.To,
+			CreatedAt: f.CreatedAt, Pages: f.Pages, Cost: f.Cost, Currency: f.Currency, FailureReason: f.FailureReason,
+			RemoteID: f.RemoteID, SentBy: f.SentBy}
+		if tags, err := a.Store.faxTags(ctx, f.Profile, []string{f.FaxID}); err == nil {
+			row.Tags = tags[f.FaxID]
+		}
+		comments, err := a.Store.comments(ctx, f.Profile, f.FaxID)
+		if err != nil {
+			row.Note = "comments could not be loaded: " + err.Error()
+		}
+		for _, c := range comments {
+			row.Comments = append(row.Comments, commentJSON{Author: c.Author, Body: c.Body, CreatedAt: c.CreatedAt})
+		}
+		if media := firstNonEmpty(f.SentMedia, f.ReceivedMedia); media != "" {
+			if doc, err := a.Media.Get(ctx, media); err == nil {
+				row.Document = "documents/" + sanitizeFilename(fmt.Sprintf("%s_%s%s", f.CreatedAt.Format("2006-01-02"), f.FaxID, filepath.Ext(media)))
+				addFile(row.Document, doc.Data, f.CreatedAt)
+				a.recordAccess(r, accessExported, f.Profile, f.FaxID)
+				documents++
+			} else {
+				row.Note = "document could not be read: " + err.Error()
+			}
+		}
+		bundle.Faxes = append(bundle.Faxes, row)
+	}
+	for _, d := range drafts {
+		row := subjectDraftJSON{ID: d.ID, Owner: d.Owner, Profile: d.Profile, To: d.To, From: d.From, CoverText: d.CoverText,
+			CreatedAt: d.CreatedAt, UpdatedAt: d.UpdatedAt}
+		if full, err := a.Store.draft(ctx, d.Owner, d.ID); err == nil && full != nil && full.File != nil {
+			row.Attachment = "drafts/" + sanitizeFilename(d.ID+"_"+full.File.Name)
+			addFile(row.Attachment, full.File.Data, d.UpdatedAt)
+			documents++
+		}
+		bundle.Drafts = append(bundle.Drafts, row)
+	}
+	for _, e := range entries {
+		bundle.Audit = append(bundle.Audit, subjectAuditJSON{At: e.At, Actor: e.Actor, Action: e.Action, Profile: e.Profile,
+			FaxID: e.FaxID, Detail: e.Detail})
+	}
+	if subject.User != "" {
+		bundle.Preferences = make(map[string]string)
+		if tag, err := a.Store.userLanguage(ctx, subject.User); err == nil && tag != "" {
+			bundle.Preferences["language"] = tag
+		}
+		if zone, err := a.Store.userTimezone(ctx, subject.User); err == nil && zone != "" {
+			bundle.Preferences["timezone"] = zone
+		}
+		if subs, err := a.Store.queryPushSubscriptions(ctx, `WHERE "user" = ? COLLATE NOCASE`, subject.User); err == nil {
+			bundle.Browsers = len(subs)
+		}
+	}
+	data, _ := json.MarshalIndent(bundle, "", "  ")
+	addFile("subject.json", data, time.Now())
+	if err := zw.Close(); err != nil {
+		log.Printf("Warning: privacy export: %v", err)
+	}
+
+	user, _ := a.sessionUser(r)
+	a.writeAudit(ctx, auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "privacy.exported",
+		Detail: fmt.Sprintf("subject %s: %d faxes, %d drafts, %d audit entries, %d documents", pseudonym, len(faxes), len(drafts), len(entries), documents)})
+}
+
+// eraseSubject deletes the subject's documents and drafts and pseudonymizes the records that stay.
+// Faxes on legal hold are left as they are.
+func (a *App) eraseSubject(w http.ResponseWriter, r *http.Request, subject dataSubject, pseudonym string, fail func(string, ...any)) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+	faxes, err := a.Store.subjectFaxes(ctx, subject)
+	if err != nil {
+		fail("Failed to look up records: " + err.Error())
+		return
+	}
+	remote := r.FormValue("remote") != ""
+	erased, held := 0, 0
+	var notes []string
+	if subject.Number != "" {
+		for _, f := range faxes {
+			hold, err := a.Store.faxHold(ctx, f.Profile, f.FaxID)
+			if err != nil {
+				fail("Erasure stopped after %d faxes: %s", erased, err.Error())
+				return
+			}
+			if hold != nil {
+				held++
+				continue
+			}
+			if err := a.eraseSubjectFax(ctx, f, subject.Number, pseudonym, remote); err != nil {
+				fail("Erasure stopped after %d faxes: %s", erased, err.Error())
+				return
+			}
+			erased++
+		}
+		err = a.Store.eraseNumber(ctx, subject.Number, pseudonym)
+	} else {
+		erased = len(faxes)
+		err = a.Store.eraseUser(ctx, subject.User, pseudonym)
+	}
+	if err != nil {
+		fail("Erasure stopped after %d faxes: %s", erased, err.Error())
+		return
+	}
+	if held > 0 {
+		notes = append(notes, fmt.Sprintf("%d on legal hold kept", held))
+	}
+	if remote {
+		notes = append(notes, "deleted at the provider")
+	}
+
+	user, _ := a.sessionUser(r)
+	detail := fmt.Sprintf("subject %s: %d faxes", pseudonym, erased)
+	if len(notes) > 0 {
+		detail += "; " + strings.Join(notes, ", ")
+	}
+	a.writeAudit(ctx, auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "privacy.erased", Detail: detail})
+	msg := a.T(r, "Erased the records of %s; it now appears as %s", subject.String(), pseudonym)
+	if held > 0 {
+		msg += " " + a.T(r, "%d faxes on legal hold were left unchanged.", held)
+	}
+	http.Redirect(w, r, "/admin/privacy?success="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+// eraseSubjectFax deletes a fax's documents, at the provider too when remote is set, and pseudonymizes its records
+func (a *App) eraseSubjectFax(ctx context.Context, f subjectFax, number, pseudonym string, remote bool) error {
+	for _, media := range []string{f.SentMedia, f.ReceivedMedia} {
+		if media == "" {
+			continue
+		}
+		if err := a.Media.Delete(ctx, media); err != nil {
+			return fmt.Errorf("delete document of fax %s: %w", f.FaxID, err)
+		}
+	}
+	if p := a.profileByName(f.Profile); p != nil && remote {
+		if err := p.provider.Delete(ctx, f.FaxID); err != nil && !isNotFound(err) && !errors.Is(err, errNotSupported) {
+			return fmt.Errorf("delete fax %s from %s: %w", f.FaxID, p.provider.Name(), err)
+		}
+	}
+	return a.Store.pseudonymizeFax(ctx, f.Profile, f.FaxID, number, pseudonym)
+}
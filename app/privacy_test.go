@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestReplaceIdentity(t *testing.T) {
+	tests := []struct{ s, want string }{
+		{"bob@example.com", "subject-1"},
+		{"Bob@Example.COM", "subject-1"},
+		{"admin@example.com (as bob@example.com)", "admin@example.com (as subject-1)"},
+		{"assigned to bob@example.com.", "assigned to subject-1."},
+		{"bob@example.com, BOB@example.com", "subject-1, subject-1"},
+		{"jimbob@example.com", "jimbob@example.com"},
+		{"bob@example.com.evil.test", "bob@example.com.evil.test"},
+		{"bob@example.community", "bob@example.community"},
+		{"nothing to see", "nothing to see"},
+	}
+	for _, tt := range tests {
+		if got := replaceIdentity(tt.s, "bob@example.com", "subject-1"); got != tt.want {
+			t.Errorf("replaceIdentity(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestEraseUserAuditLog(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	ctx := context.Background()
+	for _, e := range []auditEntry{
+		{Actor: "BOB@example.com", Action: "fax.sent"},
+		{Actor: "admin@example.com (as bob@example.com)", Action: "fax.viewed"},
+		{Actor: "admin@example.com", Action: "impersonation.started", Detail: "viewing as Bob@Example.com"},
+		{Actor: "jimbob@example.com", Action: "fax.sent", Detail: "cc jimbob@example.com"},
+		{Actor: "retention", Action: "retention.media_deleted", Detail: "3 documents"},
+	} {
+		if err := app.Store.audit(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.Store.eraseUser(ctx, "bob@example.com", "subject-1"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := app.Store.queryAudit(ctx, `ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Actor+" | "+e.Detail)
+	}
+	want := []string{
+		"subject-1 | ",
+		"admin@example.com (as subject-1) | ",
+		"admin@example.com | viewing as subject-1",
+		"jimbob@example.com | cc jimbob@example.com",
+		"retention | 3 documents",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("audit log after erasure:\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+)
+
+// defaultProfileName is the profile built from TELNYX_API_KEY and the top-level defaults
+const defaultProfileName = "default"
+
+// Profile is a named fax account (e.g. per department or per customer) with its own defaults.
+// Profiles use Telnyx unless another provider is configured.
+type Profile struct {
+	Name         string     `yaml:"name"`
+	Provider     string     `yaml:"provider"` // telnyx (default), phaxio, srfax, documo or email
+	APIKey       string     `yaml:"api_key"`  // may reference the environment, e.g. ${BILLING_TELNYX_API_KEY}
+	APISecret    string     `yaml:"api_secret"`
+	AccountID    string     `yaml:"account_id"`   // SRFax access ID
+	SenderEmail  string     `yaml:"sender_email"` // SRFax delivery confirmation address
+	SMTP         SMTPConfig `yaml:"smtp"`         // email-to-fax gateway settings
+	From         string     `yaml:"from"`
+	ConnectionID string     `yaml:"connection_id"`
+	Users        []string   `yaml:"users"` // identities allowed to use this profile; empty means everyone
+
+	Failover             string `yaml:"failover"`               // profile to send through when this one is failing
+	FailoverConnectionID string `yaml:"failover_connection_id"` // secondary Telnyx connection tried before the failover profile
+
+	provider    FaxProvider
+	client      *telnyx.Client // nil for profiles using another provider
+	breaker     *circuitBreaker
+	pageTimeout time.Duration               // bounds the Telnyx lookups behind the connection and number pickers
+	connCache   listCache[connectionOption] // cached fax applications for the connection picker
+	numberCache listCache[ownedNumber]      // cached phone numbers owned by the account
+}
+
+// defaultProfile returns the profile backed by TELNYX_API_KEY
+func (a *App) defaultProfile() *Profile {
+	return a.Profiles[0]
+}
+
+// allowedProfiles returns the profiles the requesting user may use, default first
+func (a *App) allowedProfiles(r *http.Request) []*Profile {
+	user, _ := a.sessionUser(r)
+	var allowed []*Profile
+	for _, p := range a.Profiles {
+		if p.allows(user) || !a.hasAuthConfigured() {
+			allowed = append(allowed, p)
+		}
+	}
+	return allowed
+}
+
+// allows reports whether the given user identity may use the profile
+func (p *Profile) allows(user string) bool {
+	if len(p.Users) == 0 {
+		return true
+	}
+	for _, u := range p.Users {
+		if strings.EqualFold(u, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestProfile resolves the profile named by the request's "profile" parameter, defaulting to the
+// deployment's send profile. Returns an error if the profile does not exist or the user is not allowed to use it.
+func (a *App) requestProfile(r *http.Request) (*Profile, error) {
+	return a.userProfile(r, r.FormValue("profile"))
+}
+
+// userProfile resolves the named profile for the requesting user, defaulting to the deployment's send profile
+func (a *App) userProfile(r *http.Request, name string) (*Profile, error) {
+	allowed := a.allowedProfiles(r)
+	if k := a.kiosk(r); k != nil {
+		// Kiosks always send with their own profile
+		name = k.Profile
+	}
+	requested := strings.TrimSpace(name)
+	name = firstNonEmpty(requested, a.SendProfile)
+	for _, p := range allowed {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	// Users who may not use the send profile fall back to one they may use
+	if requested == "" && len(allowed) > 0 {
+		return allowed[0], nil
+	}
+	return nil, fmt.Errorf("unknown profile %q", name)
+}
+
+// profileDefaults returns the from number and connection ID to prefill for the profile.
+// The default profile follows the fax application switcher; other profiles use their own defaults.
+func (a *App) profileDefaults(r *http.Request, p *Profile) (from, connectionID string) {
+	if p == a.defaultProfile() {
+		return a.defaultFrom(r), a.defaultConnection(r)
+	}
+	return p.From, p.ConnectionID
+}
+
+// profileByName returns the profile with the given name, or nil
+func (a *App) profileByName(name string) *Profile {
+	for _, p := range a.Profiles {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// profileNames returns the names of the given profiles, for rendering selectors
+func profileNames(profiles []*Profile) []string {
+	names := make([]string, len(profiles))
+	for i, p := range profiles {
+		names[i] = p.Name
+	}
+	return names
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+	"github.com/team-telnyx/telnyx-go/v4/option"
+)
+
+// Supported fax providers
+const (
+	providerTelnyx = "telnyx"
+	providerPhaxio = "phaxio"
+	providerSRFax  = "srfax"
+	providerDocumo = "documo"
+	providerEmail  = "email" // email-to-fax gateway, e.g. HylaFAX faxmail
+)
+
+// errNotSupported is returned by providers that cannot perform an operation (e.g. listing through an email gateway)
+var errNotSupported = errors.New("not supported by this fax provider")
+
+// FaxProvider sends and looks up faxes through a carrier
+type FaxProvider interface {
+	// Name returns the provider type, e.g. "telnyx"
+	Name() string
+	Send(ctx context.Context, req SendRequest) (*Fax, error)
+	Get(ctx context.Context, id string) (*Fax, error)
+	// List returns a page of faxes, newest first. direction is "inbound", "outbound" or empty for both.
+	List(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, error)
+	// Delete removes a fax and its media from the carrier
+	Delete(ctx context.Context, id string) error
+}
+
+// listTotals is the size of a whole list of faxes; zero fields are not reported
+type listTotals struct {
+	Results int64
+	Pages   int64
+}
+
+// totalsLister is implemented by providers whose lists report their total size
+type totalsLister interface {
+	// ListWithTotals is List that also returns the size of the whole list
+	ListWithTotals(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, listTotals, error)
+}
+
+// listWithTotals lists a page of faxes from p, with the list's totals if p reports them
+func listWithTotals(ctx context.Context, p FaxProvider, direction string, pageNumber, pageSize int64) ([]Fax, listTotals, error) {
+	if t, ok := p.(totalsLister); ok {
+		return t.ListWithTotals(ctx, direction, pageNumber, pageSize)
+	}
+	faxes, err := p.List(ctx, direction, pageNumber, pageSize)
+	return faxes, listTotals{}, err
+}
+
+// SendRequest is a fax to send. Fields a provider does not support are ignored.
+type SendRequest struct {
+	ConnectionID string // Telnyx connection (fax application) ID
+	From         string
+	To           string
+	MediaURL     string
+	Document     *document // the document at MediaURL when fax-ui stored it, so providers need not fetch it back
+	WebhookURL   string
+	Quality      string
+	StorePreview bool
+	StoreMedia   bool
+	Options      faxOptions // advanced Telnyx options; other providers ignore them
+}
+
+// Fax is a fax as reported by a provider. Statuses use the Telnyx vocabulary
+// (queued, sending, delivered, failed, ...) where the carrier's can be mapped.
+type Fax struct {
+	ID             string
+	Status         string
+	Direction      string
+	From           string
+	To             string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	PreviewURL     string
+	StoredMediaURL string
+	MediaURL       string // the received document of an inbound fax
+	// Delivery details, zero when the provider does not report them
+	Pages         int
+	Duration      time.Duration // call duration
+	Cost          float64       // in Currency
+	Currency      string
+	FailureReason string
+	RemoteID      string // station ID (CSID) the receiving machine answered with
+}
+
+// newProvider builds the fax provider configured for a profile.
+// Telnyx profiles get a client (unless they already have one) used for the Telnyx-only features.
+func newProvider(p *Profile, rc ResilienceConfig) (FaxProvider, error) {
+	switch strings.ToLower(p.Provider) {
+	case "", providerTelnyx:
+		if p.client == nil {
+			p.client, p.breaker = newTelnyxClient(p.APIKey, rc)
+		}
+		p.pageTimeout = rc.PageTimeout
+		return &telnyxProvider{client: p.client}, nil
+	case providerPhaxio:
+		return &phaxioProvider{apiKey: p.APIKey, apiSecret: p.APISecret}, nil
+	case providerSRFax:
+		return &srfaxProvider{accessID: p.AccountID, password: p.APIKey, senderEmail: p.SenderEmail}, nil
+	case providerDocumo:
+		return &documoProvider{apiKey: p.APIKey}, nil
+	case providerEmail:
+		if p.SMTP.Host == "" || p.SMTP.Gateway == "" {
+			return nil, fmt.Errorf("profile %q: the email provider needs smtp.host and smtp.gateway", p.Name)
+		}
+		return &emailProvider{smtp: p.SMTP}, nil
+	default:
+		return nil, fmt.Errorf("profile %q: unknown provider %q (expected telnyx, phaxio, srfax, documo or email)", p.Name, p.Provider)
+	}
+}
+
+// providerHTTPClient is used by the providers that call REST APIs directly
+var providerHTTPClient = &http.Client{Timeout: 60 * time.Second}
+
+// fetchMedia downloads the document at mediaURL for providers that need the file itself rather than a URL.
+// Returns the content, a file name and the content type.
+func fetchMedia(ctx context.Context, client *http.Client, mediaURL string) ([]byte, string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("fetch media: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("fetch media: %s", resp.Status)
+	}
+	doc, err := readMediaResponse(req, resp)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("fetch media: %w", err)
+	}
+	return doc.Data, doc.Name, doc.Type, nil
+}
+
+// requestMedia returns the document of a send request, fetching it from MediaURL unless fax-ui has it already.
+// MediaURL comes from users, so it is only fetched from public addresses. Returns the content, a file name
+// and the content type.
+func requestMedia(ctx context.Context, req SendRequest) ([]byte, string, string, error) {
+	if req.Document != nil {
+		return req.Document.Data, req.Document.Name, req.Document.Type, nil
+	}
+	return fetchMedia(ctx, mediaFetchClient, req.MediaURL)
+}
+
+// maxDocumentSize is the largest document fax-ui downloads, the carrier's limit
+const maxDocumentSize = 25 << 20
+
+var errDocumentTooLarge = errors.New("the document is larger than 25 MB")
+
+// readDocument reads a downloaded document, failing instead of cutting off one over maxDocumentSize
+func readDocument(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDocumentSize {
+		return nil, errDocumentTooLarge
+	}
+	return data, nil
+}
+
+// readMediaResponse reads a downloaded document, named after the last part of the URL
+func readMediaResponse(req *http.Request, resp *http.Response) (*document, error) {
+	data, err := readDocument(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+	name := path.Base(req.URL.Path)
+	if name == "/" || name == "." || !strings.Contains(name, ".") {
+		name = "document.pdf"
+		if contentType == "image/tiff" {
+			name = "document.tiff"
+		}
+	}
+	return &document{Name: name, Type: contentType, Data: data}, nil
+}
+
+// telnyxMediaTTL is how long documents uploaded to Telnyx media storage are kept: long enough for Telnyx
+// to retry a busy line, but they do not linger with Telnyx once the fax is through
+const telnyxMediaTTL = 24 * time.Hour
+
+// telnyxProvider sends faxes through the Telnyx Programmable Fax API
+type telnyxProvider struct {
+	client      *telnyx.Client
+	uploadMedia bool // upload documents to Telnyx media storage instead of having Telnyx fetch them from /media/
+}
+
+func (t *telnyxProvider) Name() string { return providerTelnyx }
+
+func (t *telnyxProvider) Send(ctx context.Context, req SendRequest) (*Fax, error) {
+	params := telnyx.FaxNewParams{
+		ConnectionID: req.ConnectionID,
+		From:         req.From,
+		To:           req.To,
+		StorePreview: telnyx.Bool(req.StorePreview),
+		StoreMedia:   telnyx.Bool(req.StoreMedia),
+	}
+	if t.uploadMedia && req.Document != nil {
+		name, err := t.upload(ctx, req.Document)
+		if err != nil {
+			return nil, err
+		}
+		params.MediaName = telnyx.String(name)
+	} else {
+		params.MediaURL = telnyx.String(req.MediaURL)
+	}
+	if req.WebhookURL != "" {
+		params.WebhookURL = telnyx.String(req.WebhookURL)
+	}
+	if req.Quality != "" {
+		params.Quality = telnyx.FaxNewParamsQuality(req.Quality)
+	}
+	if req.Options.Monochrome {
+		params.Monochrome = telnyx.Bool(true)
+		if req.Options.BlackThreshold > 0 {
+			params.BlackThreshold = telnyx.Int(int64(req.Options.BlackThreshold))
+		}
+	}
+	if req.Options.DisableT38 {
+		params.T38Enabled = telnyx.Bool(false)
+	}
+	if req.Options.FromDisplayName != "" {
+		params.FromDisplayName = telnyx.String(req.Options.FromDisplayName)
+	}
+	res, err := t.client.Faxes.New(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	fax := telnyxFax(res.Data)
+	return &fax, nil
+}
+
+// upload stores a document in Telnyx media storage and returns its media name. The SDK only uploads
+// from a URL, which is what this avoids, so the file is posted as multipart/form-data.
+func (t *telnyxProvider) upload(ctx context.Context, doc *document) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("ttl_secs", strconv.Itoa(int(telnyxMediaTTL.Seconds())))
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="media"; filename=%q`, sanitizeFilename(doc.Name)))
+	h.Set("Content-Type", firstNonEmpty(doc.Type, "application/pdf"))
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return "", err
+	}
+	part.Write(doc.Data)
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+	var res telnyx.MediaUploadResponse
+	if err := t.client.Post(ctx, "media", nil, &res, option.WithRequestBody(mw.FormDataContentType(), body.Bytes())); err != nil {
+		return "", fmt.Errorf("upload media: %w", err)
+	}
+	if res.Data.MediaName == "" {
+		return "", errors.New("upload media: Telnyx returned no media name")
+	}
+	return res.Data.MediaName, nil
+}
+
+func (t *telnyxProvider) Get(ctx context.Context, id string) (*Fax, error) {
+	res, err := t.client.Faxes.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	fax := telnyxFax(res.Data)
+	return &fax, nil
+}
+
+func (t *telnyxProvider) List(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, error) {
+	faxes, _, err := t.ListWithTotals(ctx, direction, pageNumber, pageSize)
+	return faxes, err
+}
+
+// ListWithTotals reads the totals from the list's meta; total_results is not part of the SDK's type
+func (t *telnyxProvider) ListWithTotals(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, listTotals, error) {
+	params := telnyx.FaxListParams{
+		PageNumber: telnyx.Int(pageNumber),
+		PageSize:   telnyx.Int(pageSize),
+	}
+	if direction != "" {
+		params.Filter.Direction.Eq = telnyx.String(direction)
+	}
+	res, err := t.client.Faxes.List(ctx, params)
+	if err != nil {
+		return nil, listTotals{}, err
+	}
+	faxes := make([]Fax, len(res.Data))
+	for i, f := range res.Data {
+		faxes[i] = telnyxFax(f)
+	}
+	results, _ := strconv.ParseInt(extraFieldString(res.Meta.JSON.ExtraFields, "total_results"), 10, 64)
+	return faxes, listTotals{Results: results, Pages: res.Meta.TotalPages}, nil
+}
+
+func (t *telnyxProvider) Delete(ctx context.Context, id string) error {
+	return t.client.Faxes.Delete(ctx, id)
+}
+
+// telnyxFax converts a Telnyx fax resource. Page count, call duration and failure reason are
+// not part of the SDK's fax type, so they are read from the extra fields when the API includes them.
+func telnyxFax(f telnyx.Fax) Fax {
+	pages, _ := strconv.Atoi(extraFieldString(f.JSON.ExtraFields, "page_count"))
+	seconds, _ := strconv.ParseFloat(extraFieldString(f.JSON.ExtraFields, "call_duration_secs"), 64)
+	fax := Fax{
+		ID:             f.ID,
+		Status:         string(f.Status),
+		Direction:      string(f.Direction),
+		From:           f.From,
+		To:             f.To,
+		CreatedAt:      f.CreatedAt,
+		UpdatedAt:      f.UpdatedAt,
+		PreviewURL:     f.PreviewURL,
+		StoredMediaURL: f.StoredMediaURL,
+		Pages:          pages,
+		Duration:       time.Duration(seconds * float64(time.Second)),
+		FailureReason:  extraFieldString(f.JSON.ExtraFields, "failure_reason"),
+	}
+	// For outbound faxes media_url is the document we sent, served by this deployment
+	if fax.Direction == "inbound" {
+		fax.MediaURL = f.MediaURL
+	}
+	return fax
+}
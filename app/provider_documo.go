@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// documoAPIURL is the base URL of the Documo (mFax) v1 API
+const documoAPIURL = "https://api.documo.com/v1"
+
+// documoProvider sends faxes through Documo (https://docs.documo.com)
+type documoProvider struct {
+	apiKey string
+}
+
+// documoFax is a fax resource in Documo API responses
+type documoFax struct {
+	MessageID   string    `json:"messageId"`
+	Direction   string    `json:"direction"` // outbound or inbound
+	Status      string    `json:"status"`
+	FaxNumber   string    `json:"faxNumber"`
+	FaxCallerID string    `json:"faxCallerId"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	PagesCount  int       `json:"pagesCount"`
+	Duration    int       `json:"duration"` // seconds
+	ResultInfo  string    `json:"resultInfo"`
+}
+
+func (d *documoProvider) Name() string { return providerDocumo }
+
+func (d *documoProvider) Send(ctx context.Context, req SendRequest) (*Fax, error) {
+	// Documo takes the document as a multipart upload rather than by URL
+	data, name, _, err := requestMedia(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("faxNumber", req.To)
+	_ = mw.WriteField("callerId", req.From)
+	_ = mw.WriteField("coverPage", "false")
+	if req.WebhookURL != "" {
+		_ = mw.WriteField("webhookUrl", req.WebhookURL)
+	}
+	part, err := mw.CreateFormFile("attachments", name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var f documoFax
+	if err := d.do(ctx, http.MethodPost, "/faxes", &body, mw.FormDataContentType(), &f); err != nil {
+		return nil, err
+	}
+	fax := f.fax()
+	return &fax, nil
+}
+
+func (d *documoProvider) Get(ctx context.Context, id string) (*Fax, error) {
+	var f documoFax
+	if err := d.do(ctx, http.MethodGet, "/fax/"+url.PathEscape(id)+"/info", nil, "", &f); err != nil {
+		return nil, err
+	}
+	fax := f.fax()
+	return &fax, nil
+}
+
+func (d *documoProvider) List(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, error) {
+	query := url.Values{
+		"offset": {strconv.FormatInt((pageNumber-1)*pageSize, 10)},
+		"limit":  {strconv.FormatInt(pageSize, 10)},
+	}
+	if direction != "" {
+		query.Set("direction", direction)
+	}
+	var res struct {
+		Rows []documoFax `json:"rows"`
+	}
+	if err := d.do(ctx, http.MethodGet, "/fax/history?"+query.Encode(), nil, "", &res); err != nil {
+		return nil, err
+	}
+	faxes := make([]Fax, len(res.Rows))
+	for i, f := range res.Rows {
+		faxes[i] = f.fax()
+	}
+	return faxes, nil
+}
+
+func (d *documoProvider) Delete(ctx context.Context, id string) error {
+	return errNotSupported
+}
+
+// do calls the Documo API and decodes the JSON response into out
+func (d *documoProvider) do(ctx context.Context, method, path string, body io.Reader, contentType string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, documoAPIURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Basic "+d.apiKey)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("documo: %s", firstNonEmpty(apiErr.Error.Message, resp.Status))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("documo: invalid response: %w", err)
+	}
+	return nil
+}
+
+// fax converts a Documo fax resource
+func (f documoFax) fax() Fax {
+	fax := Fax{
+		ID:        f.MessageID,
+		Status:    documoStatus(f.Status),
+		Direction: firstNonEmpty(f.Direction, "outbound"),
+		From:      f.FaxCallerID,
+		To:        f.FaxNumber,
+		CreatedAt: f.CreatedAt,
+		UpdatedAt: f.UpdatedAt,
+		Pages:     f.PagesCount,
+		Duration:  time.Duration(f.Duration) * time.Second,
+	}
+	if fax.Status == "failed" {
+		fax.FailureReason = f.ResultInfo
+	}
+	if f.Direction == "inbound" {
+		fax.From, fax.To = f.FaxNumber, f.FaxCallerID
+	}
+	return fax
+}
+
+// documoStatus maps a Documo fax status to the Telnyx vocabulary
+func documoStatus(status string) string {
+	switch status {
+	case "queued", "pending":
+		return "queued"
+	case "processing", "sending":
+		return "sending"
+	case "success":
+		return "delivered"
+	case "failed", "canceled":
+		return "failed"
+	}
+	return status
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// SMTPConfig configures the email-to-fax gateway provider
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"` // defaults to 587
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`    // envelope and header sender
+	Gateway  string `yaml:"gateway"` // faxes go to <number>@gateway, e.g. fax.example.com for HylaFAX faxmail
+}
+
+// emailProvider sends faxes by emailing the document to an email-to-fax gateway such as HylaFAX.
+// Gateways do not report status back, so faxes cannot be looked up or listed.
+type emailProvider struct {
+	smtp SMTPConfig
+}
+
+func (e *emailProvider) Name() string { return providerEmail }
+
+func (e *emailProvider) Send(ctx context.Context, req SendRequest) (*Fax, error) {
+	data, name, contentType, err := requestMedia(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	id, err := generateSecureToken(16)
+	if err != nil {
+		return nil, err
+	}
+	to := strings.TrimPrefix(req.To, "+") + "@" + e.smtp.Gateway
+
+	// Build a multipart message with the document attached
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fmt.Fprintf(&body, "From: %s\r\nTo: %s\r\nSubject: Fax from %s\r\nMessage-ID: <%s@fax-ui>\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		e.smtp.From, to, req.From, id, mw.Boundary())
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", name)},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		fmt.Fprintf(part, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(part, "%s\r\n", encoded)
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var auth smtp.Auth
+	if e.smtp.Username != "" {
+		auth = smtp.PlainAuth("", e.smtp.Username, e.smtp.Password, e.smtp.Host)
+	}
+	addr := net.JoinHostPort(e.smtp.Host, firstNonEmpty(e.smtp.Port, "587"))
+	if err := smtp.SendMail(addr, auth, e.smtp.From, []string{to}, body.Bytes()); err != nil {
+		return nil, fmt.Errorf("email gateway: %w", err)
+	}
+	return &Fax{
+		ID:        id,
+		Status:    "queued",
+		Direction: "outbound",
+		From:      req.From,
+		To:        req.To,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+func (e *emailProvider) Get(ctx context.Context, id string) (*Fax, error) {
+	return nil, errNotSupported
+}
+
+func (e *emailProvider) List(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, error) {
+	return nil, errNotSupported
+}
+
+func (e *emailProvider) Delete(ctx context.Context, id string) error {
+	return errNotSupported
+}
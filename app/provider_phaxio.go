@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// phaxioAPIURL is the base URL of the Phaxio v2 API
+const phaxioAPIURL = "https://api.phaxio.com/v2"
+
+// phaxioProvider sends faxes through Phaxio (https://www.phaxio.com/docs/api/v2)
+type phaxioProvider struct {
+	apiKey    string
+	apiSecret string
+}
+
+// phaxioFax is a fax resource in Phaxio API responses
+type phaxioFax struct {
+	ID          int64     `json:"id"`
+	Direction   string    `json:"direction"` // sent or received
+	Status      string    `json:"status"`
+	CallerID    string    `json:"caller_id"`
+	FromNumber  string    `json:"from_number"`
+	ToNumber    string    `json:"to_number"`
+	CreatedAt   time.Time `json:"created_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	NumPages    int       `json:"num_pages"`
+	Cost        int       `json:"cost"` // US cents
+	Recipients  []struct {
+		PhoneNumber  string `json:"phone_number"`
+		ErrorMessage string `json:"error_message"`
+	} `json:"recipients"`
+}
+
+func (p *phaxioProvider) Name() string { return providerPhaxio }
+
+func (p *phaxioProvider) Send(ctx context.Context, req SendRequest) (*Fax, error) {
+	form := url.Values{
+		"to":          {req.To},
+		"content_url": {req.MediaURL},
+		"caller_id":   {req.From},
+	}
+	if req.WebhookURL != "" {
+		form.Set("callback_url", req.WebhookURL)
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/faxes", form, &created); err != nil {
+		return nil, err
+	}
+	return &Fax{
+		ID:        strconv.FormatInt(created.ID, 10),
+		Status:    "queued",
+		Direction: "outbound",
+		From:      req.From,
+		To:        req.To,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+func (p *phaxioProvider) Get(ctx context.Context, id string) (*Fax, error) {
+	var f phaxioFax
+	if err := p.do(ctx, http.MethodGet, "/faxes/"+url.PathEscape(id), nil, &f); err != nil {
+		return nil, err
+	}
+	fax := f.fax()
+	return &fax, nil
+}
+
+func (p *phaxioProvider) List(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, error) {
+	query := url.Values{
+		"page":     {strconv.FormatInt(pageNumber, 10)},
+		"per_page": {strconv.FormatInt(pageSize, 10)},
+	}
+	switch direction {
+	case "inbound":
+		query.Set("direction", "received")
+	case "outbound":
+		query.Set("direction", "sent")
+	}
+	var list []phaxioFax
+	if err := p.do(ctx, http.MethodGet, "/faxes?"+query.Encode(), nil, &list); err != nil {
+		return nil, err
+	}
+	faxes := make([]Fax, len(list))
+	for i, f := range list {
+		faxes[i] = f.fax()
+	}
+	return faxes, nil
+}
+
+func (p *phaxioProvider) Delete(ctx context.Context, id string) error {
+	return p.do(ctx, http.MethodDelete, "/faxes/"+url.PathEscape(id), nil, nil)
+}
+
+// do calls the Phaxio API, posting form when given, and decodes the response's data into out unless it is nil
+func (p *phaxioProvider) do(ctx context.Context, method, path string, form url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, phaxioAPIURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.apiKey, p.apiSecret)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Success bool            `json:"success"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("phaxio: %s: invalid response: %w", resp.Status, err)
+	}
+	if !envelope.Success {
+		return fmt.Errorf("phaxio: %s", firstNonEmpty(envelope.Message, resp.Status))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
+
+// fax converts a Phaxio fax resource
+func (f phaxioFax) fax() Fax {
+	fax := Fax{
+		ID:        strconv.FormatInt(f.ID, 10),
+		Status:    phaxioStatus(f.Status),
+		Direction: "outbound",
+		From:      f.CallerID,
+		CreatedAt: f.CreatedAt,
+		UpdatedAt: f.CreatedAt,
+		Pages:     f.NumPages,
+		Cost:      float64(f.Cost) / 100,
+		Currency:  "USD",
+	}
+	if f.Direction == "received" {
+		fax.Direction = "inbound"
+		fax.From = f.FromNumber
+		fax.To = f.ToNumber
+	}
+	if !f.CompletedAt.IsZero() {
+		fax.UpdatedAt = f.CompletedAt
+	}
+	if len(f.Recipients) > 0 {
+		fax.To = firstNonEmpty(fax.To, f.Recipients[0].PhoneNumber)
+		fax.FailureReason = f.Recipients[0].ErrorMessage
+	}
+	return fax
+}
+
+// phaxioStatus maps a Phaxio fax status to the Telnyx vocabulary
+func phaxioStatus(status string) string {
+	switch status {
+	case "queued", "pendingbatch":
+		return "queued"
+	case "inprogress":
+		return "sending"
+	case "success", "partialsuccess":
+		return "delivered"
+	case "failure":
+		return "failed"
+	}
+	return status
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// providerSandbox is the in-process mock carrier used in sandbox mode
+const providerSandbox = "sandbox"
+
+// sandboxFailureReasons are the Telnyx failure reasons a sandbox fax fails with at random
+var sandboxFailureReasons = []string{"user_busy", "no_answer", "receiver_call_dropped", "fax_signaling_error"}
+
+// SandboxConfig configures sandbox mode, where every profile sends through the mock carrier
+type SandboxConfig struct {
+	Enabled     bool
+	FailRate    float64       // share of sends that fail, 0 to 1
+	FailNumbers []string      // destinations that always fail, e.g. to rehearse a failure in training
+	Delay       time.Duration // how long a fax stays queued, then sending, before it finishes
+}
+
+// sandboxProvider simulates a carrier in memory: sent faxes move from queued to sending to delivered
+// (or failed) as time passes, without calling Telnyx or spending money. Faxes are lost on restart.
+type sandboxProvider struct {
+	cfg SandboxConfig
+
+	mu    sync.Mutex
+	faxes map[string]*sandboxFax
+}
+
+// sandboxFax is a simulated fax and the outcome decided when it was sent
+type sandboxFax struct {
+	fax           Fax
+	pages         int
+	failureReason string // empty when the fax will be delivered
+}
+
+func newSandboxProvider(cfg SandboxConfig) *sandboxProvider {
+	return &sandboxProvider{cfg: cfg, faxes: make(map[string]*sandboxFax)}
+}
+
+func (s *sandboxProvider) Name() string { return providerSandbox }
+
+func (s *sandboxProvider) Send(ctx context.Context, req SendRequest) (*Fax, error) {
+	if req.MediaURL == "" {
+		return nil, errors.New("media_url is required")
+	}
+	id, err := generateSecureToken(16)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	f := &sandboxFax{
+		fax: Fax{
+			ID:             "sandbox-" + id,
+			Direction:      "outbound",
+			From:           req.From,
+			To:             req.To,
+			CreatedAt:      now,
+			StoredMediaURL: req.MediaURL,
+		},
+		pages: 1 + rand.IntN(3),
+	}
+	switch {
+	case slices.Contains(s.cfg.FailNumbers, req.To):
+		f.failureReason = "user_busy"
+	case rand.Float64() < s.cfg.FailRate:
+		f.failureReason = sandboxFailureReasons[rand.IntN(len(sandboxFailureReasons))]
+	}
+
+	s.mu.Lock()
+	s.faxes[f.fax.ID] = f
+	s.mu.Unlock()
+	fax := f.at(now, s.cfg.Delay)
+	return &fax, nil
+}
+
+func (s *sandboxProvider) Get(ctx context.Context, id string) (*Fax, error) {
+	s.mu.Lock()
+	f, ok := s.faxes[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errors.New("fax not found")
+	}
+	fax := f.at(time.Now(), s.cfg.Delay)
+	return &fax, nil
+}
+
+func (s *sandboxProvider) List(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, error) {
+	faxes, _, err := s.ListWithTotals(ctx, direction, pageNumber, pageSize)
+	return faxes, err
+}
+
+func (s *sandboxProvider) ListWithTotals(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, listTotals, error) {
+	now := time.Now()
+	s.mu.Lock()
+	var faxes []Fax
+	for _, f := range s.faxes {
+		if direction == "" || strings.EqualFold(direction, f.fax.Direction) {
+			faxes = append(faxes, f.at(now, s.cfg.Delay))
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(faxes, func(i, j int) bool { return faxes[i].CreatedAt.After(faxes[j].CreatedAt) })
+
+	total := int64(len(faxes))
+	totals := listTotals{Results: total, Pages: (total + pageSize - 1) / pageSize}
+	start := (pageNumber - 1) * pageSize
+	if start < 0 || start >= total {
+		return nil, totals, nil
+	}
+	return faxes[start:min(start+pageSize, total)], totals, nil
+}
+
+func (s *sandboxProvider) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.faxes[id]; !ok {
+		return errors.New("fax not found")
+	}
+	delete(s.faxes, id)
+	return nil
+}
+
+// at returns the fax as it stands at now: queued for the first delay, sending for the second, then finished
+func (f *sandboxFax) at(now time.Time, delay time.Duration) Fax {
+	fax := f.fax
+	elapsed := now.Sub(fax.CreatedAt)
+	switch {
+	case elapsed < delay:
+		fax.Status = "queued"
+		fax.UpdatedAt = fax.CreatedAt
+	case elapsed < 2*delay:
+		fax.Status = "sending"
+		fax.UpdatedAt = fax.CreatedAt.Add(delay)
+	default:
+		fax.UpdatedAt = fax.CreatedAt.Add(2 * delay)
+		fax.Duration = delay
+		if f.failureReason != "" {
+			fax.Status = "failed"
+			fax.FailureReason = f.failureReason
+			break
+		}
+		fax.Status = "delivered"
+		fax.Pages = f.pages
+		// Fax machines usually answer with their own number
+		fax.RemoteID = fax.To
+		// Rough Telnyx pricing, so the cost views have something to show
+		fax.Cost = 0.007 * float64(f.pages)
+		fax.Currency = "USD"
+	}
+	return fax
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// srfaxAPIURL is the SRFax secure web service endpoint
+const srfaxAPIURL = "https://www.srfax.com/SRF_SecWebSvc.php"
+
+// srfaxTimeLayout is the layout of SRFax timestamps, e.g. "Jan 02/06 03:04 PM"
+const srfaxTimeLayout = "Jan 02/06 03:04 PM"
+
+// srfaxProvider sends faxes through SRFax (https://www.srfax.com/api-page/)
+type srfaxProvider struct {
+	accessID    string // SRFax account number
+	password    string
+	senderEmail string // required by SRFax for delivery confirmations
+}
+
+// srfaxFax is an outbox or inbox entry or fax status in SRFax responses
+type srfaxFax struct {
+	FileName    string `json:"FileName"` // "<name>|<FaxDetailsID>"
+	SentStatus  string `json:"SentStatus"`
+	DateQueued  string `json:"DateQueued"`
+	DateSent    string `json:"DateSent"`
+	ToFaxNumber string `json:"ToFaxNumber"`
+	RemoteID    string `json:"RemoteID"`
+
+	// Inbox entries only
+	ReceiveStatus string `json:"ReceiveStatus"`
+	Date          string `json:"Date"`
+	CallerID      string `json:"CallerID"`
+}
+
+func (s *srfaxProvider) Name() string { return providerSRFax }
+
+func (s *srfaxProvider) Send(ctx context.Context, req SendRequest) (*Fax, error) {
+	// SRFax takes the document inline rather than by URL
+	data, name, _, err := requestMedia(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var id json.Number
+	err = s.call(ctx, "Queue_Fax", url.Values{
+		"sCallerID":      {srfaxNumber(req.From, false)},
+		"sSenderEmail":   {s.senderEmail},
+		"sFaxType":       {"SINGLE"},
+		"sToFaxNumber":   {srfaxNumber(req.To, true)},
+		"sFileName_1":    {name},
+		"sFileContent_1": {base64.StdEncoding.EncodeToString(data)},
+	}, &id)
+	if err != nil {
+		return nil, err
+	}
+	return &Fax{
+		ID:        id.String(),
+		Status:    "queued",
+		Direction: "outbound",
+		From:      req.From,
+		To:        req.To,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+func (s *srfaxProvider) Get(ctx context.Context, id string) (*Fax, error) {
+	var f srfaxFax
+	if err := s.call(ctx, "Get_FaxStatus", url.Values{"sFaxDetailsID": {id}}, &f); err != nil {
+		return nil, err
+	}
+	fax := f.fax()
+	fax.ID = id
+	return &fax, nil
+}
+
+// List pages through the outbox (or the inbox for inbound faxes) locally; SRFax returns it in one response
+func (s *srfaxProvider) List(ctx context.Context, direction string, pageNumber, pageSize int64) ([]Fax, error) {
+	action := "Get_Fax_Outbox"
+	if direction == "inbound" {
+		action = "Get_Fax_Inbox"
+	}
+	var box []srfaxFax
+	if err := s.call(ctx, action, url.Values{}, &box); err != nil {
+		return nil, err
+	}
+	start := (pageNumber - 1) * pageSize
+	if start >= int64(len(box)) {
+		return nil, nil
+	}
+	end := min(start+pageSize, int64(len(box)))
+	faxes := make([]Fax, 0, end-start)
+	for _, f := range box[start:end] {
+		faxes = append(faxes, f.fax())
+	}
+	return faxes, nil
+}
+
+// Delete is not supported: SRFax deletes by direction, which fax IDs alone do not tell
+func (s *srfaxProvider) Delete(ctx context.Context, id string) error {
+	return errNotSupported
+}
+
+// call invokes an SRFax action and decodes its Result into out
+func (s *srfaxProvider) call(ctx context.Context, action string, form url.Values, out any) error {
+	form.Set("action", action)
+	form.Set("access_id", s.accessID)
+	form.Set("access_pwd", s.password)
+	form.Set("sResponseFormat", "JSON")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, srfaxAPIURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Status string          `json:"Status"`
+		Result json.RawMessage `json:"Result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("srfax: %s: invalid response: %w", resp.Status, err)
+	}
+	if envelope.Status != "Success" {
+		var msg string
+		_ = json.Unmarshal(envelope.Result, &msg)
+		return fmt.Errorf("srfax: %s", firstNonEmpty(msg, envelope.Status, resp.Status))
+	}
+	return json.Unmarshal(envelope.Result, out)
+}
+
+// fax converts an SRFax outbox or inbox entry
+func (f srfaxFax) fax() Fax {
+	fax := Fax{
+		Status:    srfaxStatus(f.SentStatus),
+		Direction: "outbound",
+		To:        f.ToFaxNumber,
+		RemoteID:  strings.TrimSpace(f.RemoteID),
+	}
+	if _, id, ok := strings.Cut(f.FileName, "|"); ok {
+		fax.ID = id
+	}
+	if f.ReceiveStatus != "" {
+		fax.Direction = "inbound"
+		fax.Status = srfaxStatus(f.ReceiveStatus)
+		if strings.EqualFold(f.ReceiveStatus, "ok") {
+			fax.Status = "received"
+		}
+		fax.From = f.CallerID
+		fax.CreatedAt, _ = time.Parse(srfaxTimeLayout, f.Date)
+		fax.UpdatedAt = fax.CreatedAt
+		return fax
+	}
+	fax.CreatedAt, _ = time.Parse(srfaxTimeLayout, f.DateQueued)
+	fax.UpdatedAt = fax.CreatedAt
+	if sent, err := time.Parse(srfaxTimeLayout, f.DateSent); err == nil {
+		fax.UpdatedAt = sent
+	}
+	return fax
+}
+
+// srfaxStatus maps an SRFax sent status to the Telnyx vocabulary
+func srfaxStatus(status string) string {
+	switch strings.ToLower(status) {
+	case "in progress":
+		return "sending"
+	case "sent":
+		return "delivered"
+	case "failed":
+		return "failed"
+	}
+	return strings.ToLower(status)
+}
+
+// srfaxNumber converts an E.164 number to the digits SRFax expects: 10 digits for caller IDs,
+// 11 digits (with the leading 1) for North American destinations
+func srfaxNumber(e164 string, destination bool) string {
+	digits := strings.TrimPrefix(e164, "+")
+	if !destination && len(digits) == 11 && strings.HasPrefix(digits, "1") {
+		return digits[1:]
+	}
+	return digits
+}
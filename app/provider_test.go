@@ -0,0 +1,17 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReadDocument(t *testing.T) {
+	data, err := readDocument(bytes.NewReader(make([]byte, maxDocumentSize)))
+	if err != nil || len(data) != maxDocumentSize {
+		t.Fatalf("document of the largest size: %d bytes, %v", len(data), err)
+	}
+	if data, err := readDocument(bytes.NewReader(make([]byte, maxDocumentSize+1))); !errors.Is(err, errDocumentTooLarge) {
+		t.Fatalf("document one byte too large: %d bytes, %v", len(data), err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Users hand fax-ui URLs: webhook URLs and media URLs on the send form, the CLI and the gRPC API. fax-ui
+// posts to or fetches some of them itself, so they are kept from pointing into the network it runs in,
+// where they would reach services the user cannot.
+
+// errHostNotPublic refuses connections into the deployment's own network
+var errHostNotPublic = errors.New("host is not a public address")
+
+// reservedNets are the ranges that are not public but that net.IP has no method for
+var reservedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // "this network"
+		"100.64.0.0/10", // carrier-grade NAT, also Tailscale
+		"192.0.0.0/24",  // IETF protocol assignments
+		"198.18.0.0/15", // benchmarking
+		"240.0.0.0/4",   // reserved for future use, and the broadcast address
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// isPublicIP reports whether an address is reachable on the internet rather than this host or its network
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newPublicClient returns a client that only connects to public addresses. The address is checked as
+// it is dialed, after the name is resolved and for every redirect, so a name resolving into the
+// deployment's network cannot be used to reach it. It connects directly, without HTTPS_PROXY.
+func newPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return errHostNotPublic
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+	}
+}
+
+// parseHostPatterns parses an allowlist of hosts: host names, or *.domain for its subdomains
+func parseHostPatterns(s, what string) ([]string, error) {
+	var hosts []string
+	for _, h := range splitList(s) {
+		h = strings.ToLower(strings.TrimSuffix(h, "."))
+		name := strings.TrimPrefix(h, "*.")
+		if name == "" || strings.ContainsAny(name, "/:*@") {
+			return nil, fmt.Errorf("invalid %s host %q (expected e.g. files.example.com or *.example.com)", what, h)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// hostMatches reports whether a host is on an allowlist
+func hostMatches(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range patterns {
+		if name, ok := strings.CutPrefix(h, "*."); ok {
+			if strings.HasSuffix(host, "."+name) {
+				return true
+			}
+		} else if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUserURL validates a URL a user typed: http or https, without credentials, and on the allowlist when there is one
+func checkUserURL(raw string, hosts []string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return nil, errors.New("enter an http or https URL")
+	}
+	if u.User != nil {
+		return nil, errors.New("the URL may not contain a user name or password")
+	}
+	if len(hosts) > 0 && !hostMatches(hosts, u.Hostname()) {
+		return nil, fmt.Errorf("%s is not an allowed host", u.Hostname())
+	}
+	return u, nil
+}
+
+// checkPublicHost refuses localhost and addresses in a private network. With resolve, a host name is
+// looked up and refused when any of its addresses is not public.
+func checkPublicHost(ctx context.Context, host string, resolve bool) error {
+	if h := strings.ToLower(strings.TrimSuffix(host, ".")); h == "localhost" || strings.HasSuffix(h, ".localhost") {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("%s is not a public address", host)
+		}
+		return nil
+	}
+	if !resolve {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("%s could not be found", host)
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return fmt.Errorf("%s is not a public address", host)
+		}
+	}
+	return nil
+}
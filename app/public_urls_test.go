@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"100.63.255.255", true},
+		{"100.128.0.0", true},
+		{"198.17.255.255", true},
+		{"198.20.0.0", true},
+		{"223.255.255.255", true},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.127.255.255", false},
+		{"192.0.0.8", false},
+		{"198.18.0.1", false},
+		{"198.19.255.255", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"224.0.0.251", false},
+		{"::", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:100.64.0.1", false},
+		{"::ffff:198.18.0.1", false},
+		{"::ffff:240.0.0.1", false},
+		{"::ffff:0.0.0.0", false},
+		{"::ffff:8.8.8.8", true},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCheckUserURL(t *testing.T) {
+	tests := []struct {
+		raw    string
+		hosts  []string
+		ok     bool // accepted by checkUserURL
+		public bool // and its host by checkPublicHost
+	}{
+		{"https://docs.example.com/fax.pdf", nil, true, true},
+		{"http://8.8.8.8/fax.pdf", nil, true, true},
+		{"https://docs.example.com/fax.pdf", []string{"*.example.com"}, true, true},
+		{"https://docs.example.org/fax.pdf", []string{"*.example.com"}, false, false},
+		{"ftp://docs.example.com/fax.pdf", nil, false, false},
+		{"https://user:pw@docs.example.com/fax.pdf", nil, false, false},
+		{"/fax.pdf", nil, false, false},
+		{"http://localhost/fax.pdf", nil, true, false},
+		{"http://printer.localhost./fax.pdf", nil, true, false},
+		{"http://0.0.0.0/fax.pdf", nil, true, false},
+		{"http://100.64.1.2/fax.pdf", nil, true, false},
+		{"http://198.18.0.1/fax.pdf", nil, true, false},
+		{"http://240.1.2.3/fax.pdf", nil, true, false},
+		{"http://[::ffff:10.0.0.1]/fax.pdf", nil, true, false},
+		{"http://[::ffff:169.254.169.254]/latest/meta-data", nil, true, false},
+		{"http://[::1]:8080/fax.pdf", nil, true, false},
+	}
+	for _, tt := range tests {
+		u, err := checkUserURL(tt.raw, tt.hosts)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkUserURL(%s, %v): %v, want accepted %v", tt.raw, tt.hosts, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		// Without resolving, so host names pass; only the addresses are judged
+		if err := checkPublicHost(context.Background(), u.Hostname(), false); (err == nil) != tt.public {
+			t.Errorf("checkPublicHost(%s): %v, want public %v", u.Hostname(), err, tt.public)
+		}
+	}
+}
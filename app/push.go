@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Web Push (RFC 8030) lets browsers show desktop notifications for fax events while fax-ui is closed.
+// Messages are encrypted for the browser (RFC 8291) and signed with the server's VAPID key (RFC 8292).
+
+// pushTTL is how long the push service keeps a notification for a browser that is offline
+const pushTTL = 24 * time.Hour
+
+// pushHTTPClient delivers to push services, which are public; the endpoint comes from the browser
+var pushHTTPClient = newPublicClient(15 * time.Second)
+
+// PushConfig configures Web Push notifications
+type PushConfig struct {
+	PrivateKey string // VAPID_PRIVATE_KEY; generated and kept in the database when empty
+	Subject    string // contact for push services, a mailto: or https: URL
+}
+
+// webPush sends notifications signed with the server's VAPID key
+type webPush struct {
+	key     *ecdsa.PrivateKey
+	public  []byte // uncompressed public key
+	subject string
+}
+
+// pushSubscription is a browser that opted into notifications
+type pushSubscription struct {
+	Endpoint string
+	User     string
+	P256dh   string // browser public key, base64url
+	Auth     string // authentication secret, base64url
+	Sent     bool   // notify about faxes the user sent being delivered or failing
+	Received bool   // notify about new received faxes
+}
+
+// pushMessage is what the service worker turns into a notification
+type pushMessage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+	Tag   string `json:"tag"` // a later notification for the same fax replaces the earlier one
+}
+
+// newWebPush loads the VAPID key from cfg, or from the database, generating it on first start
+func newWebPush(ctx context.Context, cfg PushConfig, store *Store) (*webPush, error) {
+	encoded := strings.TrimSpace(cfg.PrivateKey)
+	if encoded == "" {
+		var err error
+		encoded, err = store.secret(ctx, "vapid_private_key", func() (string, error) {
+			key, err := ecdh.P256().GenerateKey(rand.Reader)
+			if err != nil {
+				return "", err
+			}
+			return base64.RawURLEncoding.EncodeToString(key.Bytes()), nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("web push key: %w", err)
+		}
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("VAPID_PRIVATE_KEY must be a base64url-encoded 32-byte P-256 private key")
+	}
+	private, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID_PRIVATE_KEY: %w", err)
+	}
+	public := private.PublicKey().Bytes()
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(public[1:33]), Y: new(big.Int).SetBytes(public[33:])},
+		D:         new(big.Int).SetBytes(raw),
+	}
+	return &webPush{key: key, public: public, subject: cfg.Subject}, nil
+}
+
+// PublicKey is the applicationServerKey browsers subscribe with, base64url
+func (p *webPush) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(p.public)
+}
+
+// send delivers one notification. gone reports a subscription the push service no longer knows,
+// e.g. because the user revoked the permission.
+func (p *webPush) send(ctx context.Context, sub pushSubscription, msg pushMessage) (gone bool, err error) {
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil || endpoint.Scheme != "https" {
+		return true, errors.New("push endpoint is not an https URL")
+	}
+	payload, _ := json.Marshal(msg)
+	body, err := encryptPush(sub, payload)
+	if err != nil {
+		return true, err
+	}
+	token, err := p.vapidToken(endpoint.Scheme+"://"+endpoint.Host, time.Now())
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	req.Header.Set("TTL", fmt.Sprint(int(pushTTL.Seconds())))
+	req.Header.Set("Urgency", "normal")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Authorization", "vapid t="+token+", k="+p.PublicKey())
+	resp, err := pushHTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return true, fmt.Errorf("push service: %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("push service: %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return false, nil
+}
+
+// vapidToken is the ES256 JWT identifying this server to the push service at audience
+func (p *webPush) vapidToken(audience string, now time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, _ := json.Marshal(map[string]any{"aud": audience, "exp": now.Add(12 * time.Hour).Unix(), "sub": p.subject})
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, p.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants the two 32-byte integers side by side rather than ASN.1
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// encryptPush encrypts payload for the subscription's browser as a single aes128gcm record (RFC 8291)
+func encryptPush(sub pushSubscription, payload []byte) ([]byte, error) {
+	uaRaw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.P256dh, "="))
+	if err != nil {
+		return nil, errors.New("invalid subscription key")
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.Auth, "="))
+	if err != nil || len(authSecret) == 0 {
+		return nil, errors.New("invalid subscription secret")
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaRaw)
+	if err != nil {
+		return nil, errors.New("invalid subscription key")
+	}
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	shared, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	ikm, err := hkdf.Key(sha256.New, shared, authSecret, "WebPush: info\x00"+string(uaRaw)+string(asPublic), 32)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// Header: salt, record size, and the server's public key as the key ID; 0x02 marks the last record
+	body := append(salt, binary.BigEndian.AppendUint32(nil, 4096)...)
+	body = append(body, byte(len(asPublic)))
+	body = append(body, asPublic...)
+	return gcm.Seal(body, nonce, append(payload, 0x02), nil), nil
+}
+
+// notifyPush sends the notifications a fax event calls for: new received faxes to users who may see
+// them, and delivered or failed faxes to the user who sent them
+func (a *App) notifyPush(ctx context.Context, eventType string, fax Fax, profiles []string) {
+	for _, name := range profiles {
+		profile := a.profileByName(name)
+		if profile == nil {
+			continue
+		}
+		link := a.PublicBaseURL() + "/fax?id=" + url.QueryEscape(fax.ID) + "&profile=" + url.QueryEscape(name)
+		var subs []pushSubscription
+		var msg pushMessage
+		var err error
+		switch {
+		case eventType == "fax.received":
+			subs, err = a.Store.receivedPushSubscriptions(ctx)
+			// Only users who may use the profile hear about its faxes
+			kept := subs[:0]
+			for _, s := range subs {
+				if (profile.allows(s.User) && a.userSeesFax(ctx, name, s.User, fax.ID)) || !a.hasAuthConfigured() {
+					kept = append(kept, s)
+				}
+			}
+			subs = kept
+			msg = pushMessage{Title: "New fax received", Body: "From " + fax.From}
+		case fax.Status == "delivered" || fax.Status == "failed":
+			sent, lookupErr := a.Store.sentFax(ctx, name, fax.ID)
+			// Without sign-in every browser shares the empty identity, so all of them hear about every fax
+			if lookupErr != nil || sent == nil || (sent.SentBy == "" && a.hasAuthConfigured()) {
+				err = lookupErr
+				break
+			}
+			subs, err = a.Store.sentPushSubscriptions(ctx, sent.SentBy)
+			if fax.Status == "delivered" {
+				msg = pushMessage{Title: "Fax delivered", Body: "To " + sent.To}
+			} else {
+				msg = pushMessage{Title: "Fax failed", Body: "To " + sent.To}
+				if e, ok := explainFailure(fax.FailureReason); ok {
+					msg.Body = "To " + sent.To + ": " + e.Summary + ". " + e.Remedy
+				} else if fax.FailureReason != "" {
+					msg.Body = "To " + sent.To + ": " + fax.FailureReason
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Warning: could not look up push subscriptions for fax %s: %v", fax.ID, err)
+			continue
+		}
+		// Lock screens show notifications to anyone nearby, so HIPAA mode leaves the numbers out
+		if a.Hipaa && msg.Body != "" {
+			msg.Body = "Open fax-ui for details."
+		}
+		msg.URL, msg.Tag = link, "fax-"+fax.ID
+		for _, sub := range subs {
+			a.sendPush(ctx, sub, msg)
+		}
+	}
+}
+
+// sendPush delivers one notification, forgetting subscriptions the push service has dropped
+func (a *App) sendPush(ctx context.Context, sub pushSubscription, msg pushMessage) error {
+	gone, err := a.Push.send(ctx, sub, msg)
+	if gone {
+		if err := a.Store.deletePushSubscription(ctx, sub.Endpoint); err != nil {
+			log.Printf("Warning: could not remove push subscription: %v", err)
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: could not send push notification to %s: %v", firstNonEmpty(sub.User, "a browser"), err)
+	}
+	return err
+}
+
+// pushSubscriptionJSON is the browser's PushSubscription.toJSON() with the user's choices
+type pushSubscriptionJSON struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+	Sent     bool `json:"sent"`
+	Received bool `json:"received"`
+}
+
+// handleNotifications shows the notification settings (GET), and reports (POST status), saves (POST subscribe),
+// removes (POST unsubscribe) or tests (POST test) the browser's subscription
+func (a *App) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	user, _ := a.sessionUser(r)
+	switch r.Method {
+	case http.MethodGet:
+		a.render(w, r, "notifications.html", map[string]any{
+			"PublicKey": a.Push.PublicKey(),
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req pushSubscriptionJSON
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil || req.Endpoint == "" {
+		http.Error(w, "invalid subscription", http.StatusBadRequest)
+		return
+	}
+	if u, err := checkUserURL(req.Endpoint, nil); err != nil || u.Scheme != "https" || checkPublicHost(r.Context(), u.Hostname(), true) != nil {
+		http.Error(w, "push endpoint must be a public https URL", http.StatusBadRequest)
+		return
+	}
+	sub := pushSubscription{Endpoint: req.Endpoint, User: user, P256dh: req.Keys.P256dh, Auth: req.Keys.Auth, Sent: req.Sent, Received: req.Received}
+	switch r.URL.Query().Get("action") {
+	case "status":
+		saved, err := a.Store.pushSubscription(r.Context(), req.Endpoint)
+		if err != nil {
+			http.Error(w, "failed to load subscription: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status := map[string]bool{"subscribed": saved != nil && saved.User == user}
+		if status["subscribed"] {
+			status["sent"], status["received"] = saved.Sent, saved.Received
+		}
+		writeJSON(w, http.StatusOK, status)
+		return
+	case "subscribe":
+		if _, err := encryptPush(sub, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.Store.savePushSubscription(r.Context(), sub); err != nil {
+			http.Error(w, "failed to save subscription: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "unsubscribe":
+		// The endpoint is an unguessable URL only the subscribed browser knows
+		if err := a.Store.deletePushSubscription(r.Context(), req.Endpoint); err != nil {
+			http.Error(w, "failed to remove subscription: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "test":
+		if err := a.sendPush(r.Context(), sub, pushMessage{Title: "fax-ui", Body: "Notifications are working.", URL: a.PublicBaseURL() + "/", Tag: "test"}); err != nil {
+			// sendPush logged the push service's answer; the browser does not get it, so the endpoint
+			// cannot be used to probe other hosts
+			http.Error(w, "failed to send notification", http.StatusBadGateway)
+			return
+		}
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleServiceWorker serves the script that shows push notifications. It lives at the root so it can
+// control every page, and needs no session since browsers fetch it in the background.
+func (a *App) handleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	io.WriteString(w, serviceWorkerJS)
+}
+
+const serviceWorkerJS = `self.addEventListener('push', (event) => {
+  const msg = event.data ? event.data.json() : { title: 'fax-ui', body: '' };
+  event.waitUntil(self.registration.showNotification(msg.title, { body: msg.body, tag: msg.tag, data: { url: msg.url } }));
+});
+
+self.addEventListener('notificationclick', (event) => {
+  event.notification.close();
+  const url = event.notification.data && event.notification.data.url;
+  if (url) event.waitUntil(clients.openWindow(url));
+});
+`
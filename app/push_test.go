@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNotificationsRefusePrivateEndpoints(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	for _, endpoint := range []string{
+		"http://push.example.com/send/1",
+		"https://127.0.0.1/send/1",
+		"https://10.0.0.8/send/1",
+		"https://localhost/send/1",
+		"https://user:pw@push.example.com/send/1",
+	} {
+		body := `{"endpoint":"` + endpoint + `","keys":{"p256dh":"x","auth":"y"}}`
+		rec := httptest.NewRecorder()
+		app.handleNotifications(rec, httptest.NewRequest(http.MethodPost, "/notifications?action=test", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", endpoint, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Most faxes are sent with the quality left at the default, which blurs photos. When the sender does
+// not choose one, fax-ui looks at the images in the document and picks it: Very High for photos and
+// shaded images, or pages scanned finer than High's 196 dpi, and High for text and line art.
+
+// qualityAdvice is the quality recommended for a document, with the reason shown to the sender
+type qualityAdvice struct {
+	Quality string
+	Reason  string // message for T, with DPI for its %d
+	DPI     int
+}
+
+// Reasons for a recommended quality
+const (
+	reasonShaded  = "It has photos or shaded images."
+	reasonFine    = "It was scanned at %d dpi, finer than High."
+	reasonLineArt = "It is text and line art."
+)
+
+// qualityReasons name the reasons in the link to a sent fax, whose page explains the quality again
+var qualityReasons = map[string]string{"shaded": reasonShaded, "fine": reasonFine, "line_art": reasonLineArt}
+
+// minAdviceImagePixels leaves logos, signatures and other small images out of the advice
+const minAdviceImagePixels = 200 * 200
+
+// fineScanDPI is the scan resolution from which Very High, at 391 lines per inch, shows more than High's 196
+const fineScanDPI = 300
+
+// qualityLabels are the names the send form gives the qualities
+var qualityLabels = map[string]string{"normal": "Normal", "high": "High", "very_high": "Very High", "ultra_light": "Ultra Light", "ultra_dark": "Ultra Dark"}
+
+// imageFacts describes the images of a document
+type imageFacts struct {
+	Shaded bool        // photos or shaded images, beyond small ones
+	Color  bool        // some of those images are in color
+	DPI    int         // finest resolution of a black and white scan, 0 without one
+	Pages  []types.Dim // page sizes in points (1/72 in); nil for a TIFF
+}
+
+// documentImages looks at the images of a PDF or TIFF document; ok is false when it cannot be read
+func documentImages(doc *document) (facts imageFacts, ok bool) {
+	// pdfcpu panics on some malformed PDFs, which are then sent as they are
+	defer func() {
+		if recover() != nil {
+			facts, ok = imageFacts{}, false
+		}
+	}()
+	switch {
+	case doc == nil:
+		return facts, false
+	case doc.isPDF():
+		pages, err := api.Images(bytes.NewReader(doc.Data), nil, nil)
+		if err != nil {
+			return facts, false
+		}
+		dims, err := api.PageDims(bytes.NewReader(doc.Data), nil)
+		if err != nil {
+			return facts, false
+		}
+		facts.Pages = dims
+		for _, images := range pages {
+			for _, img := range images {
+				if img.IsImgMask || img.Thumb || img.Width*img.Height < minAdviceImagePixels {
+					continue
+				}
+				if img.Bpc > 1 || strings.Contains(img.Filter, "DCT") || strings.Contains(img.Filter, "JPX") {
+					facts.Shaded = true
+					facts.Color = facts.Color || img.Comp >= 3
+				} else if img.PageNr >= 1 && img.PageNr <= len(dims) && dims[img.PageNr-1].Width > 0 {
+					// A scanned page fills the page, so its resolution is its width over the page's
+					facts.DPI = max(facts.DPI, int(float64(img.Width)*72/dims[img.PageNr-1].Width))
+				}
+			}
+		}
+	case uploadType(doc.Type) == "image/tiff":
+		bits, xres, ok := tiffImageInfo(doc.Data)
+		if !ok {
+			return facts, false
+		}
+		facts.Shaded, facts.Color, facts.DPI = bits > 1, bits >= 24, xres
+	default:
+		return facts, false
+	}
+	return facts, true
+}
+
+// adviseQuality recommends a quality for a PDF or TIFF document; ok is false when it cannot be read
+func adviseQuality(doc *document) (qualityAdvice, bool) {
+	facts, ok := documentImages(doc)
+	switch {
+	case !ok:
+		return qualityAdvice{}, false
+	case facts.Shaded:
+		return qualityAdvice{Quality: "very_high", Reason: reasonShaded}, true
+	case facts.DPI >= fineScanDPI:
+		return qualityAdvice{Quality: "very_high", Reason: reasonFine, DPI: facts.DPI}, true
+	}
+	return qualityAdvice{Quality: "high", Reason: reasonLineArt}, true
+}
+
+// qualityNote explains the quality of a sent fax: the one picked when the sender left the default, or the
+// one recommended over a lower one the sender chose. It is empty when the sender's choice needs no comment.
+func (a *App) qualityNote(r *http.Request, chosen string, advice qualityAdvice) string {
+	reason := a.T(r, advice.Reason)
+	if advice.DPI > 0 {
+		reason = a.T(r, advice.Reason, advice.DPI)
+	}
+	label := a.T(r, qualityLabels[advice.Quality])
+	switch {
+	case chosen == "":
+		return a.T(r, "Sent at %s quality.", label) + " " + reason
+	case chosen != advice.Quality && advice.Quality == "very_high":
+		return a.T(r, "%s quality is recommended for this document.", label) + " " + reason
+	}
+	return ""
+}
+
+// adviceQuery records the quality chosen and the advice in q, the query of the link to the sent fax
+func adviceQuery(q url.Values, chosen string, advice qualityAdvice) {
+	for name, reason := range qualityReasons {
+		if reason == advice.Reason {
+			q.Set("reason", name)
+		}
+	}
+	q.Set("advice", advice.Quality)
+	if chosen != "" {
+		q.Set("quality", chosen)
+	}
+	if advice.DPI > 0 {
+		q.Set("dpi", strconv.Itoa(advice.DPI))
+	}
+}
+
+// adviceFromQuery reads back what adviceQuery recorded; ok is false when q holds no valid advice
+func adviceFromQuery(q url.Values) (chosen string, advice qualityAdvice, ok bool) {
+	advice = qualityAdvice{Quality: q.Get("advice"), Reason: qualityReasons[q.Get("reason")]}
+	advice.DPI, _ = strconv.Atoi(q.Get("dpi"))
+	chosen = q.Get("quality")
+	_, known := qualityLabels[advice.Quality]
+	_, knownChoice := qualityLabels[chosen]
+	switch {
+	case !known || advice.Reason == "" || chosen != "" && !knownChoice:
+		return "", qualityAdvice{}, false
+	case advice.Reason == reasonFine && advice.DPI <= 0:
+		return "", qualityAdvice{}, false
+	}
+	return chosen, advice, true
+}
+
+// tiffImageInfo reads the bits per pixel and horizontal resolution (dpi) of a TIFF's first image
+func tiffImageInfo(data []byte) (bits, dpi int, ok bool) {
+	if len(data) < 8 {
+		return 0, 0, false
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, 0, false
+	}
+	ifd := int(order.Uint32(data[4:8]))
+	if ifd+2 > len(data) {
+		return 0, 0, false
+	}
+	bitsPerSample, samples, inches := 1, 1, true
+	var xres float64
+	for i, n := 0, int(order.Uint16(data[ifd:])); i < n; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(data) {
+			return 0, 0, false
+		}
+		tag, typ, value := order.Uint16(data[e:]), order.Uint16(data[e+2:]), data[e+8:e+12]
+		short := int(order.Uint16(value))
+		if typ == 4 { // LONG
+			short = int(order.Uint32(value))
+		}
+		switch tag {
+		case 258: // BitsPerSample, the first sample's when there are several
+			bitsPerSample = short
+			if count := order.Uint32(data[e+4:]); count > 2 {
+				if off := int(order.Uint32(value)); off+2 <= len(data) {
+					bitsPerSample = int(order.Uint16(data[off:]))
+				}
+			}
+		case 277: // SamplesPerPixel
+			samples = short
+		case 282: // XResolution, a RATIONAL stored at an offset
+			if off := int(order.Uint32(value)); off+8 <= len(data) {
+				if den := order.Uint32(data[off+4:]); den != 0 {
+					xres = float64(order.Uint32(data[off:])) / float64(den)
+				}
+			}
+		case 296: // ResolutionUnit: 3 is centimeters
+			inches = short != 3
+		}
+	}
+	if !inches {
+		xres *= 2.54
+	}
+	return bitsPerSample * samples, int(xres), true
+}
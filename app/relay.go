@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Home and office deployments often have no public address for Telnyx to fetch uploads from. The built-in
+// relay connects out to an SSH server and has it forward a port back to fax-ui (a reverse tunnel, like
+// ssh -R), so the SSH server's public address reaches fax-ui without opening the firewall. The SSH server
+// can be a small VPS of the practice's own behind nginx, or a service such as localhost.run that assigns
+// an HTTPS URL to each tunnel.
+
+// RelayConfig configures the built-in relay
+type RelayConfig struct {
+	Address    string // ssh://user@host[:port] of the SSH server; empty disables the relay
+	Key        string // PEM private key file; none tries the server's "none" authentication, which localhost.run accepts
+	KnownHosts string // known_hosts file the server's host key is checked against (default ~/.ssh/known_hosts)
+	Listen     string // address the SSH server listens on for fax-ui (default localhost:80)
+	PublicURL  string // URL the SSH server's address is reached at; empty takes the first https:// URL the server prints
+}
+
+// defaultRelayListen is what ssh -R 80:localhost:8080 asks for, and what tunnel services expect
+const defaultRelayListen = "localhost:80"
+
+// relayURLWait is how long the relay waits for the SSH server to print the URL it assigned
+const relayURLWait = 30 * time.Second
+
+// relayURLPattern finds the URL a tunnel service prints when the tunnel opens
+var relayURLPattern = regexp.MustCompile(`https://[A-Za-z0-9.-]+[A-Za-z0-9]`)
+
+// relay keeps a reverse tunnel open to the SSH server
+type relay struct {
+	cfg    RelayConfig
+	addr   string // host:port of the SSH server
+	client *ssh.ClientConfig
+}
+
+// newRelay parses the SSH server address and loads the key and known hosts the relay connects with
+func newRelay(cfg RelayConfig) (*relay, error) {
+	u, err := url.Parse(cfg.Address)
+	if err != nil || u.Scheme != "ssh" || u.Host == "" || u.User == nil {
+		return nil, fmt.Errorf("invalid relay %q (expected ssh://user@host[:port])", cfg.Address)
+	}
+	var auth []ssh.AuthMethod
+	if cfg.Key != "" {
+		data, err := os.ReadFile(cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("relay key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("relay key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	hostKeys, err := knownHostsCallback(cfg.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("relay known hosts: %w", err)
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = defaultRelayListen
+	}
+	if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		return nil, fmt.Errorf("invalid relay listen address %q (expected host:port)", cfg.Listen)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	return &relay{
+		cfg:  cfg,
+		addr: addr,
+		client: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+// serveRelay serves the routes Telnyx needs from handler through the relay, reconnecting with backoff
+// whenever the connection drops. Each connection's public URL becomes fax-ui's public base URL.
+func (a *App) serveRelay(sc ServerConfig, handler http.Handler) {
+	srv := newHTTPServer(sc, relayRoutes(handler))
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := a.relayOnce(srv)
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("Warning: relay %s: %v; reconnecting in %s", a.relay.addr, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// relayOnce opens the reverse tunnel and serves through it until the connection drops
+func (a *App) relayOnce(srv *http.Server) error {
+	client, err := ssh.Dial("tcp", a.relay.addr, a.relay.client)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ln, err := client.Listen("tcp", a.relay.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", a.relay.cfg.Listen, err)
+	}
+	defer ln.Close()
+
+	public := a.relay.cfg.PublicURL
+	if public == "" {
+		if public, err = relayAssignedURL(client); err != nil {
+			return err
+		}
+	}
+	if a.setPublicBaseURL(public) {
+		log.Printf("Public URL changed to %s (relay %s)", public, a.relay.addr)
+		if a.provisionHooks {
+			go a.provisionWebhooks()
+		}
+	}
+	log.Printf("Relay %s connected, serving %s", a.relay.addr, public)
+
+	// Serve returns once the listener fails, i.e. when the SSH connection drops
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	go func() { errc <- client.Wait() }()
+	err = <-errc
+	if err == nil {
+		err = io.EOF
+	}
+	return err
+}
+
+// relayRemoteAddr replaces the address of requests through the relay. The SSH client hands them over
+// from a loopback address, which would pass for a trusted certificate proxy or an exempted network.
+const relayRemoteAddr = "relay:0"
+
+// relayRoutes serves only what Telnyx reaches through the relay: the documents it fetches and the fax
+// events it posts. The pages stay on fax-ui's own listener.
+func relayRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean(r.URL.Path)
+		if !strings.HasPrefix(p, "/media/") && p != telnyxWebhookPath && !strings.HasPrefix(p, telnyxWebhookPath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		r.RemoteAddr = relayRemoteAddr
+		next.ServeHTTP(w, r)
+	})
+}
+
+// relayAssignedURL opens a shell session, as ssh -R does, and returns the first https:// URL the SSH
+// server prints, which tunnel services use to say where the tunnel is reachable
+func relayAssignedURL(client *ssh.Client) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	out, err := session.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := session.Shell(); err != nil {
+		return "", err
+	}
+	found := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			if u := relayURLPattern.FindString(scanner.Text()); u != "" {
+				found <- u
+				break
+			}
+		}
+		// Keep reading, so the server is never blocked writing to the session
+		io.Copy(io.Discard, out)
+	}()
+	select {
+	case u := <-found:
+		return u, nil
+	case <-time.After(relayURLWait):
+		return "", fmt.Errorf("the relay did not print the URL it serves fax-ui at; set RELAY_PUBLIC_URL")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRelayRoutes(t *testing.T) {
+	var remote string
+	h := relayRoutes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { remote = r.RemoteAddr }))
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/media/abc.pdf", http.StatusOK},
+		{telnyxWebhookPath, http.StatusOK},
+		{telnyxWebhookPath + "/token", http.StatusOK},
+		{"/", http.StatusNotFound},
+		{"/admin/config", http.StatusNotFound},
+		{"/media/../admin/config", http.StatusNotFound},
+		{"/webhooks/telnyxx", http.StatusNotFound},
+		{"/api/faxes", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		remote = ""
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://relay.example"+tt.path, nil)
+		req.RemoteAddr = "127.0.0.1:40000"
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.path, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && remote != relayRemoteAddr {
+			t.Errorf("%s: served with remote address %q", tt.path, remote)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/team-telnyx/telnyx-go/v4/option"
+)
+
+// requestIDHeader carries the request ID back to the browser and out to Telnyx. A reverse proxy
+// in front of fax-ui may set it on the way in so its logs and ours share the ID.
+const requestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// withRequestID gives every request an ID that the request log, error pages and Telnyx calls carry,
+// so the reference a user reports with an error can be found in the logs
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id, _ = generateSecureToken(6)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID of the request ctx belongs to, or "" outside a request
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts IDs a proxy may assign (UUIDs, hex, base64url) but nothing that could
+// break a log line or a header
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware sends the ID of the request calling Telnyx along, so a call can be traced in Telnyx support tickets
+func requestIDMiddleware() option.Middleware {
+	return func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		if id := requestID(req.Context()); id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		return next(req)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+	"github.com/team-telnyx/telnyx-go/v4/option"
+)
+
+// errTelnyxUnavailable is returned without calling Telnyx while the circuit breaker is open
+var errTelnyxUnavailable = errors.New("Telnyx is temporarily unavailable, please try again in a few minutes")
+
+// ResilienceConfig controls timeouts, retries and the circuit breaker for Telnyx API calls
+type ResilienceConfig struct {
+	BaseURL          string        // Telnyx API base URL; empty uses the SDK default, e.g. set to a faketelnyx server in tests
+	Timeout          time.Duration // per API call, including retries
+	PageTimeout      time.Duration // Telnyx lookups a page waits on before it renders
+	ActionTimeout    time.Duration // sends, changes and the list pages fetched for exports
+	MaxRetries       int           // retries on 429, 5xx and network errors; POSTs only on 429 and failed connections
+	BreakerThreshold int           // consecutive failures that open the breaker; 0 disables it
+	BreakerCooldown  time.Duration // how long the breaker stays open before letting a probe through
+	Proxy            *url.URL      // proxy Telnyx API calls go through; nil uses HTTPS_PROXY / HTTP_PROXY / NO_PROXY
+}
+
+// circuitBreaker stops calling Telnyx after repeated failures so every page does not wait on
+// timeouts during an outage. After the cooldown a single probe request decides whether to close it.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero while closed
+	probing  bool      // a probe request is in flight after the cooldown
+}
+
+// allow reports whether a request may be sent
+func (b *circuitBreaker) allow() bool {
+	if b == nil || b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if time.Since(b.openedAt) < b.cooldown || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record updates the breaker with the outcome of a request
+func (b *circuitBreaker) record(ok bool) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		if !b.openedAt.IsZero() {
+			log.Println("Telnyx API calls are succeeding again, closing circuit breaker")
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.openedAt.IsZero() {
+			log.Printf("Warning: %d consecutive Telnyx API failures, opening circuit breaker for %s", b.failures, b.cooldown)
+		}
+		b.openedAt = time.Now()
+	}
+}
+
+// abandon releases a probe whose request ended without an outcome
+func (b *circuitBreaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// isOpen reports whether calls are currently being refused
+func (b *circuitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openedAt.IsZero()
+}
+
+// newTelnyxClient creates a Telnyx client whose calls go through the resilience layer.
+// The SDK's own retries are replaced so retries and the breaker see the same attempts.
+func newTelnyxClient(apiKey string, rc ResilienceConfig) (*telnyx.Client, *circuitBreaker) {
+	breaker := &circuitBreaker{threshold: rc.BreakerThreshold, cooldown: rc.BreakerCooldown}
+	// The default transport already honors the proxy environment variables; an explicit proxy overrides them
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if rc.Proxy != nil {
+		transport.Proxy = http.ProxyURL(rc.Proxy)
+	}
+	opts := []option.RequestOption{
+		option.WithHTTPClient(&http.Client{Transport: transport}),
+		option.WithAPIKey(apiKey),
+		option.WithMaxRetries(0),
+		option.WithRequestTimeout(rc.Timeout),
+		option.WithMiddleware(requestIDMiddleware(), resilienceMiddleware(rc, breaker)),
+	}
+	if rc.BaseURL != "" {
+		opts = append(opts, option.WithBaseURL(rc.BaseURL))
+	}
+	client := telnyx.NewClient(opts...)
+	return &client, breaker
+}
+
+// resilienceMiddleware refuses calls while the breaker is open and retries rate limits,
+// server errors and network errors with jittered exponential backoff. A POST may have been acted on
+// when its answer is lost or an error, e.g. a fax queued, so it is only retried when Telnyx cannot have
+// seen it: on a rate limit, or when the connection was never opened.
+func resilienceMiddleware(rc ResilienceConfig, breaker *circuitBreaker) option.Middleware {
+	return func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		if !breaker.allow() {
+			return nil, errTelnyxUnavailable
+		}
+		for attempt := 0; ; attempt++ {
+			res, err := next(req)
+			if req.Context().Err() != nil {
+				// Cancelled or timed out by the caller; says nothing about Telnyx
+				breaker.abandon()
+				return res, err
+			}
+			retryable := err != nil || res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+			if retryable && !idempotentMethod(req.Method) {
+				retryable = err == nil && res.StatusCode == http.StatusTooManyRequests || err != nil && requestNotSent(err)
+			}
+			// Rate limits mean Telnyx is up; only outages count against the breaker
+			breaker.record(err == nil && res.StatusCode < 500)
+			if !retryable || attempt >= rc.MaxRetries {
+				return res, err
+			}
+			// Requests whose body cannot be replayed are not retried
+			if req.Body != nil && req.GetBody == nil {
+				return res, err
+			}
+			delay := retryBackoff(res, attempt)
+			if res != nil {
+				res.Body.Close()
+			}
+			if req.GetBody != nil {
+				if req.Body, err = req.GetBody(); err != nil {
+					return nil, err
+				}
+			}
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+			if !breaker.allow() {
+				return nil, errTelnyxUnavailable
+			}
+		}
+	}
+}
+
+// idempotentMethod reports whether repeating a request of the method has the effect of sending it once
+func idempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requestNotSent reports whether err shows a request never reached the server: it failed while
+// connecting, to the server or the proxy, before anything was written
+func requestNotSent(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && (op.Op == "dial" || op.Op == "proxyconnect")
+}
+
+// retryBackoff returns how long to wait before retrying: the server's Retry-After when it is
+// reasonable, otherwise exponential backoff from 500ms (capped at 8s) with full jitter
+func retryBackoff(res *http.Response, attempt int) time.Duration {
+	if res != nil {
+		if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && secs >= 0 && secs < 60 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	backoff := min(500*time.Millisecond<<attempt, 8*time.Second)
+	return time.Duration(rand.Int64N(int64(backoff))) + 100*time.Millisecond
+}
+
+// upstreamStatus returns the HTTP status for a failed provider call
+func upstreamStatus(err error) int {
+	if errors.Is(err, errTelnyxUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// writeUpstreamError reports a failed provider call: a friendly 503 while Telnyx is unavailable,
+// otherwise the provider's error as a 502
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	msg := err.Error()
+	if errors.Is(err, errTelnyxUnavailable) {
+		msg = errTelnyxUnavailable.Error()
+	}
+	http.Error(w, msg, upstreamStatus(err))
+}
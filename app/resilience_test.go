@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// callThroughMiddleware sends a request through resilienceMiddleware allowing two retries
+func callThroughMiddleware(t *testing.T, method, target string) {
+	t.Helper()
+	req, err := http.NewRequest(method, target, strings.NewReader(`{"to":"+13125550199"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := resilienceMiddleware(ResilienceConfig{MaxRetries: 2}, &circuitBreaker{})
+	res, err := mw(req, http.DefaultClient.Do)
+	if err == nil {
+		res.Body.Close()
+	}
+}
+
+func TestResilienceRetries(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status int  // answered by the server
+		drop   bool // the connection is closed after the request was read
+		want   int32
+	}{
+		{"GET on 500", http.MethodGet, http.StatusInternalServerError, false, 3},
+		{"GET on a dropped connection", http.MethodGet, 0, true, 3},
+		{"POST on 429", http.MethodPost, http.StatusTooManyRequests, false, 3},
+		{"POST on 500", http.MethodPost, http.StatusInternalServerError, false, 1},
+		{"POST on 503", http.MethodPost, http.StatusServiceUnavailable, false, 1},
+		{"POST on a dropped connection", http.MethodPost, 0, true, 1},
+		{"POST on 400", http.MethodPost, http.StatusBadRequest, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				if tt.drop {
+					conn, _, _ := w.(http.Hijacker).Hijack()
+					conn.Close()
+					return
+				}
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			callThroughMiddleware(t, tt.method, srv.URL)
+			if got := attempts.Load(); got != tt.want {
+				t.Fatalf("%d attempts, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestNotSent(t *testing.T) {
+	// A port nothing listens on refuses the connection before the request is written
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	_, err = http.Post("http://"+addr+"/v2/faxes", "application/json", strings.NewReader("{}"))
+	if err == nil || !requestNotSent(err) {
+		t.Fatalf("refused connection: %v is not recognized as unsent", err)
+	}
+	if requestNotSent(errTelnyxUnavailable) {
+		t.Fatal("an open breaker is not a failed connection")
+	}
+}
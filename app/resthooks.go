@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// No-code tools such as Zapier and Make follow fax events through REST hooks: they subscribe a callback
+// URL to an event with POST /api/hooks, receive every event as a flat JSON object, and unsubscribe with
+// DELETE /api/hooks/{id}. GET /api/hooks/sample returns recent faxes in the same shape, for setting up
+// a zap. The API is authenticated by personal API tokens, created on the Preferences page and sent as
+// "Authorization: Bearer <token>"; a hook hears about the faxes its owner sees in the web UI.
+
+// hookEvents are the fax events hooks can subscribe to
+var hookEvents = []string{"fax.received", "fax.delivered", "fax.failed"}
+
+// hookAttempts and hookTimeout bound the delivery of an event to a callback URL
+const (
+	hookAttempts = 3
+	hookTimeout  = 10 * time.Second
+)
+
+// hookClient delivers events to callback URLs, which users typed, so only to public addresses
+var hookClient = newPublicClient(hookTimeout)
+
+// apiTokenPrefix marks fax-ui API tokens, so leaked ones are easy to recognize
+const apiTokenPrefix = "fxu_"
+
+// apiToken is a personal API token; only its hash is stored
+type apiToken struct {
+	Hash      string
+	User      string
+	Name      string
+	CreatedAt time.Time
+	LastUsed  time.Time // zero until the token is first used
+}
+
+// apiTokenKey carries the user of a request authenticated by an API token in its context
+type apiTokenKey struct{}
+
+// restHook is a callback URL subscribed to a fax event
+type restHook struct {
+	ID        string    `json:"id"`
+	User      string    `json:"-"`
+	Event     string    `json:"event"`
+	TargetURL string    `json:"target_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// hookPayload is a fax event as hooks receive it: one flat object, which no-code tools map field by field
+type hookPayload struct {
+	ID                 string    `json:"id"` // unique per fax and event, for deduplication
+	Event              string    `json:"event"`
+	FaxID              string    `json:"fax_id"`
+	Profile            string    `json:"profile"`
+	Status             string    `json:"status"`
+	Direction          string    `json:"direction"`
+	From               string    `json:"from"`
+	To                 string    `json:"to"`
+	Pages              int       `json:"pages"`
+	DurationSeconds    int       `json:"duration_seconds"`
+	FailureReason      string    `json:"failure_reason"`
+	FailureExplanation string    `json:"failure_explanation"`
+	OccurredAt         time.Time `json:"occurred_at"`
+	URL                string    `json:"url"`          // the fax's page in fax-ui
+	DocumentURL        string    `json:"document_url"` // where the received document can be fetched for a while; empty in HIPAA mode
+}
+
+// withAPIToken authenticates an API request by its bearer token, carrying the token's user in the context
+func (a *App) withAPIToken(r *http.Request) *http.Request {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		return r
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(token, apiTokenPrefix) {
+		return r
+	}
+	t, found, err := a.Store.useAPIToken(r.Context(), hashSessionToken(strings.TrimSpace(token)))
+	if err != nil {
+		log.Printf("Warning: could not look up API token: %v", err)
+		return r
+	}
+	if !found {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, t.User))
+}
+
+// apiTokenUser returns the user of a request authenticated by an API token
+func apiTokenUser(r *http.Request) (string, bool) {
+	user, ok := r.Context().Value(apiTokenKey{}).(string)
+	return user, ok
+}
+
+// tokenOwner returns the user who may manage API tokens and hooks on this request: one signed in as
+// themselves, not an admin viewing as them or a network let in without signing in
+func (a *App) tokenOwner(r *http.Request) (string, bool) {
+	if _, exempted := exemptUser(r); exempted {
+		return "", false
+	}
+	if _, _, viewingAs := a.impersonation(r); viewingAs {
+		return "", false
+	}
+	return a.signedInUser(r)
+}
+
+// handleAPITokens creates (POST action=create) or revokes (POST action=revoke) the user's API tokens.
+// A new token is shown once, on the Preferences page.
+func (a *App) handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	user, ok := a.tokenOwner(r)
+	if !ok {
+		http.Error(w, "sign in to manage API tokens", http.StatusForbidden)
+		return
+	}
+	switch r.FormValue("action") {
+	case "create":
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			http.Error(w, "missing name", http.StatusBadRequest)
+			return
+		}
+		secret, err := generateSecureToken(24)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		token := apiTokenPrefix + secret
+		t := apiToken{Hash: hashSessionToken(token), User: user, Name: name, CreatedAt: time.Now().UTC()}
+		if err := a.Store.createAPIToken(r.Context(), t); err != nil {
+			http.Error(w, "failed to save token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.writeAudit(r.Context(), auditEntry{Actor: user, Action: "api_token.created", Detail: name})
+		data := a.preferencesData(r)
+		data["NewToken"] = token
+		data["NewTokenName"] = name
+		a.render(w, r, "preferences.html", data)
+	case "revoke":
+		if err := a.Store.deleteAPIToken(r.Context(), user, r.FormValue("hash")); err != nil {
+			http.Error(w, "failed to revoke token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.writeAudit(r.Context(), auditEntry{Actor: user, Action: "api_token.revoked"})
+		http.Redirect(w, r, "/preferences?success="+url.QueryEscape(a.T(r, "API token revoked")), http.StatusSeeOther)
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+	}
+}
+
+// handleHooks lists the user's hooks (GET) or subscribes a callback URL to an event (POST, JSON or form
+// with target_url and event)
+func (a *App) handleHooks(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.tokenOwner(r)
+	if !ok {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "sign in to manage hooks"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := a.Store.restHooks(r.Context(), user, "")
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, append([]restHook{}, hooks...))
+	case http.MethodPost:
+		var req struct {
+			TargetURL string `json:"target_url"`
+			Event     string `json:"event"`
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+				return
+			}
+		} else {
+			req.TargetURL, req.Event = r.FormValue("target_url"), r.FormValue("event")
+		}
+		if !containsFold(hookEvents, req.Event) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "event must be one of " + strings.Join(hookEvents, ", ")})
+			return
+		}
+		u, err := checkUserURL(req.TargetURL, nil)
+		if err == nil {
+			err = checkPublicHost(r.Context(), u.Hostname(), true)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "target_url: " + err.Error()})
+			return
+		}
+		id, err := generateSecureToken(12)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		hook := restHook{ID: id, User: user, Event: strings.ToLower(req.Event), TargetURL: req.TargetURL, CreatedAt: time.Now().UTC()}
+		if err := a.Store.createRestHook(r.Context(), hook); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		a.writeAudit(r.Context(), auditEntry{Actor: user, Action: "hook.subscribed", Detail: hook.Event + " " + hook.TargetURL})
+		writeJSON(w, http.StatusCreated, hook)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleHook unsubscribes one of the user's hooks (DELETE /api/hooks/{id}), or returns sample events
+// (GET /api/hooks/sample?event=...)
+func (a *App) handleHook(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/hooks/")
+	switch {
+	case r.Method == http.MethodGet && id == "sample":
+		a.handleHookSample(w, r)
+	case r.Method == http.MethodDelete:
+		user, ok := a.tokenOwner(r)
+		if !ok {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "sign in to manage hooks"})
+			return
+		}
+		deleted, err := a.Store.deleteRestHook(r.Context(), user, id)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if !deleted {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "hook not found"})
+			return
+		}
+		a.writeAudit(r.Context(), auditEntry{Actor: user, Action: "hook.unsubscribed", Detail: id})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleHookSample returns recent faxes of the event as hooks would receive them, newest first
+func (a *App) handleHookSample(w http.ResponseWriter, r *http.Request) {
+	event := strings.ToLower(r.URL.Query().Get("event"))
+	if !containsFold(hookEvents, event) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "event must be one of " + strings.Join(hookEvents, ", ")})
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		return
+	}
+	direction, status := "outbound", strings.TrimPrefix(event, "fax.")
+	if event == "fax.received" {
+		direction = "inbound"
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.PageTimeout)
+	defer cancel()
+	faxes, err := profile.provider.List(ctx, direction, 1, 25)
+	if err != nil {
+		writeJSON(w, upstreamStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	user, _ := a.sessionUser(r)
+	samples := []hookPayload{}
+	for _, f := range faxes {
+		if len(samples) == 3 {
+			break
+		}
+		if (direction == "outbound" && f.Status != status) || !a.userSeesFax(ctx, profile.Name, user, f.ID) {
+			continue
+		}
+		samples = append(samples, a.hookPayload(event, profile.Name, f))
+	}
+	writeJSON(w, http.StatusOK, samples)
+}
+
+// hookPayload builds the event hooks receive for a fax
+func (a *App) hookPayload(event, profile string, fax Fax) hookPayload {
+	p := hookPayload{
+		ID:              fax.ID + ":" + event,
+		Event:           event,
+		FaxID:           fax.ID,
+		Profile:         profile,
+		Status:          fax.Status,
+		Direction:       fax.Direction,
+		From:            fax.From,
+		To:              fax.To,
+		Pages:           fax.Pages,
+		DurationSeconds: int(fax.Duration.Seconds()),
+		FailureReason:   fax.FailureReason,
+		OccurredAt:      firstTime(fax.UpdatedAt, fax.CreatedAt),
+		URL:             a.PublicBaseURL() + "/fax?id=" + url.QueryEscape(fax.ID) + "&profile=" + url.QueryEscape(profile),
+	}
+	if e, ok := explainFailure(fax.FailureReason); ok {
+		p.FailureExplanation = e.Summary
+	}
+	if event == "fax.received" && !a.Hipaa {
+		p.DocumentURL = fax.MediaURL
+	}
+	return p
+}
+
+// deliverHooks posts a fax event to the callback URLs subscribed to it whose owners see the fax
+func (a *App) deliverHooks(ctx context.Context, eventType string, fax Fax, profiles []string) {
+	if !containsFold(hookEvents, eventType) {
+		return
+	}
+	hooks, err := a.Store.restHooks(ctx, "", eventType)
+	if err != nil {
+		log.Printf("Warning: could not look up hooks for fax %s: %v", fax.ID, err)
+		return
+	}
+	for _, h := range hooks {
+		for _, name := range profiles {
+			profile := a.profileByName(name)
+			if profile == nil {
+				continue
+			}
+			if a.hasAuthConfigured() && !(profile.allows(h.User) && a.userSeesFax(ctx, name, h.User, fax.ID)) {
+				continue
+			}
+			a.postHook(ctx, h, a.hookPayload(eventType, name, fax))
+			break
+		}
+	}
+}
+
+// postHook delivers an event to a hook, retrying with backoff. A callback answering 410 Gone has been
+// deleted by the tool that subscribed it, so the hook is removed.
+func (a *App) postHook(ctx context.Context, h restHook, payload hookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Warning: could not encode hook event: %v", err)
+		return
+	}
+	var lastErr error
+	for attempt := 0; attempt < hookAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt*attempt) * 5 * time.Second)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.TargetURL, bytes.NewReader(body))
+		if err != nil {
+			lastErr = err
+			break
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "fax-ui/"+Version)
+		resp, err := hookClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusGone:
+			if _, err := a.Store.deleteRestHook(ctx, h.User, h.ID); err != nil {
+				log.Printf("Warning: could not remove hook %s: %v", h.ID, err)
+			}
+			return
+		case resp.StatusCode < 300:
+			return
+		case resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+			// The callback rejected the event; sending it again would not help
+			log.Printf("Warning: hook %s rejected %s for fax %s: %s", h.ID, payload.Event, payload.FaxID, resp.Status)
+			return
+		}
+		lastErr = fmt.Errorf("%s", resp.Status)
+	}
+	log.Printf("Warning: could not deliver %s for fax %s to hook %s: %v", payload.Event, payload.FaxID, h.ID, lastErr)
+}
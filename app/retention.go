@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// retentionInterval is how often the retention job looks for expired fax data
+const retentionInterval = time.Hour
+
+// retentionActor is the audit log actor for deletions made by the retention job
+const retentionActor = "retention"
+
+// retentionPeriod is how long data is kept after a fax was created; 0 keeps it forever
+type retentionPeriod time.Duration
+
+// parseRetentionPeriod parses a period such as "30d", "2w", "7y" or a Go duration ("720h").
+// Empty, "0" and "forever" keep data forever.
+func parseRetentionPeriod(s string) (retentionPeriod, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "0" || s == "forever" {
+		return 0, nil
+	}
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour, 'y': 365 * 24 * time.Hour}
+	if unit, ok := units[s[len(s)-1]]; ok {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid retention period %q", s)
+		}
+		return retentionPeriod(time.Duration(n) * unit), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention period %q (use e.g. 30d, 2w, 7y)", s)
+	}
+	return retentionPeriod(d), nil
+}
+
+func (p *retentionPeriod) UnmarshalYAML(n *yaml.Node) error {
+	parsed, err := parseRetentionPeriod(n.Value)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// String formats the period in years or days where it is a whole number of them
+func (p retentionPeriod) String() string {
+	d := time.Duration(p)
+	switch {
+	case d == 0:
+		return "forever"
+	case d%(365*24*time.Hour) == 0:
+		return fmt.Sprintf("%dy", d/(365*24*time.Hour))
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// RetentionPolicy is how long fax data is kept. A nil period keeps data forever, or in a tag override defers to the default.
+type RetentionPolicy struct {
+	Media    *retentionPeriod `yaml:"media"`    // documents kept in the upload directory
+	Metadata *retentionPeriod `yaml:"metadata"` // fax records, send records, comments, tags and triage
+}
+
+// RetentionConfig is the retention section of the config file
+type RetentionConfig struct {
+	RetentionPolicy `yaml:",inline"`
+	Tags            map[string]RetentionPolicy `yaml:"tags"`          // per-tag overrides; the longest override of a fax's tags applies
+	DeleteRemote    bool                       `yaml:"delete_remote"` // also delete faxes from the provider when their metadata expires
+}
+
+// enabled reports whether any data is ever deleted
+func (c RetentionConfig) enabled() bool {
+	policies := []RetentionPolicy{c.RetentionPolicy}
+	for _, p := range c.Tags {
+		policies = append(policies, p)
+	}
+	for _, p := range policies {
+		if (p.Media != nil && *p.Media > 0) || (p.Metadata != nil && *p.Metadata > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// overrideRetention replaces the default media and metadata periods with those given, where one is
+func overrideRetention(retention *RetentionConfig, media, metadata string) error {
+	for _, setting := range []struct {
+		value  string
+		target **retentionPeriod
+	}{
+		{media, &retention.Media},
+		{metadata, &retention.Metadata},
+	} {
+		if setting.value == "" {
+			continue
+		}
+		period, err := parseRetentionPeriod(setting.value)
+		if err != nil {
+			return err
+		}
+		*setting.target = &period
+	}
+	return nil
+}
+
+// mediaPeriod and metadataPeriod select a period from a policy
+func mediaPeriod(p RetentionPolicy) *retentionPeriod    { return p.Media }
+func metadataPeriod(p RetentionPolicy) *retentionPeriod { return p.Metadata }
+
+// period returns how long a fax with the given tags keeps the data kind selects
+func (c RetentionConfig) period(tags []string, kind func(RetentionPolicy) *retentionPeriod) retentionPeriod {
+	var longest *retentionPeriod
+	for name, policy := range c.Tags {
+		p := kind(policy)
+		if p == nil || !containsFold(tags, name) {
+			continue
+		}
+		// Keeping forever beats any finite period
+		if longest == nil || *p == 0 || (*longest != 0 && *p > *longest) {
+			longest = p
+		}
+	}
+	if longest == nil {
+		longest = kind(c.RetentionPolicy)
+	}
+	if longest == nil {
+		return 0
+	}
+	return *longest
+}
+
+// expired reports whether data created at created has outlived period
+func (p retentionPeriod) expired(created, now time.Time) bool {
+	return p > 0 && !created.IsZero() && now.Sub(created) > time.Duration(p)
+}
+
+// startRetention runs the retention job now and then periodically
+func (a *App) startRetention(interval time.Duration) {
+	backgroundTasks.register("retention", interval)
+	run := func() {
+		backgroundTasks.beat("retention")
+		// Retention may be turned on at /admin/config after fax-ui started
+		if a.runtime().Retention.enabled() && a.leads("retention", interval) {
+			a.applyRetention(context.Background())
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run()
+		}
+	}()
+}
+
+// applyRetention deletes stored media and fax records that have outlived their retention period,
+// recording each deletion in the audit log
+func (a *App) applyRetention(ctx context.Context) {
+	faxes, err := a.Store.retainedFaxes(ctx)
+	if err != nil {
+		log.Printf("Warning: retention: could not list faxes: %v", err)
+		return
+	}
+	retention := a.runtime().Retention
+	now := time.Now()
+	tracked := make(map[string]bool)
+	purged := 0
+	for _, f := range faxes {
+		if f.MediaFile != "" {
+			tracked[f.MediaFile] = true
+		}
+		if f.Held {
+			continue
+		}
+		media := retention.period(f.Tags, mediaPeriod)
+		if f.MediaFile != "" && media.expired(f.CreatedAt, now) {
+			a.deleteRetainedMedia(ctx, f, media)
+		}
+		metadata := retention.period(f.Tags, metadataPeriod)
+		if metadata.expired(f.CreatedAt, now) && a.deleteRetainedFax(ctx, f, metadata) && !f.Local && !retention.DeleteRemote {
+			purged++
+		}
+	}
+	// Faxes only known from provider lookups are logged together; they reappear if the provider still lists them
+	if purged > 0 {
+		a.auditRetention(ctx, auditEntry{Action: "retention.cache_purged", Detail: fmt.Sprintf("%d cached fax records", purged)})
+	}
+	a.pruneUploads(ctx, retention, tracked, now)
+}
+
+// deleteRetainedMedia deletes a sent fax's stored document
+func (a *App) deleteRetainedMedia(ctx context.Context, f *retainedFax, kept retentionPeriod) {
+	if err := a.Media.Delete(ctx, f.MediaFile); err != nil {
+		log.Printf("Warning: retention: could not delete media for fax %s: %v", f.FaxID, err)
+		return
+	}
+	if err := a.Store.clearMediaFile(ctx, f.Profile, f.FaxID); err != nil {
+		log.Printf("Warning: retention: could not update fax %s: %v", f.FaxID, err)
+		return
+	}
+	f.MediaFile = ""
+	a.auditRetention(ctx, auditEntry{Action: "retention.media_deleted", Profile: f.Profile, FaxID: f.FaxID, Detail: "kept " + kept.String()})
+}
+
+// deleteRetainedFax deletes everything kept about a fax, and the fax itself at the provider when configured.
+// It returns false if the fax was kept, e.g. because the provider could not be reached.
+func (a *App) deleteRetainedFax(ctx context.Context, f *retainedFax, kept retentionPeriod) bool {
+	remote := a.runtime().Retention.DeleteRemote
+	note, err := a.deleteFaxData(ctx, f.Profile, f.FaxID, f.MediaFile, remote)
+	if err != nil {
+		log.Printf("Warning: retention: could not delete fax %s, will retry: %v", f.FaxID, err)
+		return false
+	}
+	// Deleting only a cached lookup is summarized by applyRetention
+	if f.Local || remote {
+		detail := "kept " + kept.String()
+		if note != "" {
+			detail += "; " + note
+		}
+		a.auditRetention(ctx, auditEntry{Action: "retention.fax_deleted", Profile: f.Profile, FaxID: f.FaxID, Detail: detail})
+	}
+	return true
+}
+
+// deleteFaxData deletes a fax's stored document and local records, and the fax itself at the provider when remote is set.
+// The returned note says what happened at the provider.
+func (a *App) deleteFaxData(ctx context.Context, profile, faxID, mediaFile string, remote bool) (string, error) {
+	note := ""
+	if p := a.profileByName(profile); p != nil && remote {
+		switch err := p.provider.Delete(ctx, faxID); {
+		case err == nil:
+			note = "deleted from " + p.provider.Name()
+		case errors.Is(err, errNotSupported):
+			note = p.provider.Name() + " does not support deletion"
+		case isNotFound(err):
+			note = "already gone from " + p.provider.Name()
+		default:
+			return "", fmt.Errorf("delete from %s: %w", p.provider.Name(), err)
+		}
+	}
+	if err := a.Media.Delete(ctx, mediaFile); err != nil {
+		return "", err
+	}
+	if err := a.Store.deleteFaxRecords(ctx, profile, faxID); err != nil {
+		return "", err
+	}
+	return note, nil
+}
+
+// pruneUploads deletes stored documents that no sent fax refers to (e.g. from failed sends)
+// once they outlive the default media retention
+func (a *App) pruneUploads(ctx context.Context, retention RetentionConfig, tracked map[string]bool, now time.Time) {
+	period := retention.period(nil, mediaPeriod)
+	if period == 0 {
+		return
+	}
+	deleted, err := a.Media.ExpireBefore(ctx, now.Add(-time.Duration(period)), tracked)
+	if err != nil {
+		log.Printf("Warning: retention: could not delete uploads: %v", err)
+	}
+	for _, name := range deleted {
+		a.auditRetention(ctx, auditEntry{Action: "retention.upload_deleted", Detail: name + " kept " + period.String()})
+	}
+}
+
+// isNotFound reports whether a Telnyx call failed because the resource does not exist
+func isNotFound(err error) bool {
+	var apiErr *telnyx.Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// auditRetention records a deletion made by the retention job
+func (a *App) auditRetention(ctx context.Context, e auditEntry) {
+	e.Actor = retentionActor
+	if err := a.Store.audit(ctx, e); err != nil {
+		log.Printf("Warning: could not write audit entry %s: %v", e.Action, err)
+	}
+}
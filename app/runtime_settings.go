@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Some settings are changed more often than fax-ui is redeployed: the default from number, the number
+// lookup mode, where fax events are posted, how long faxes are kept. Admins change them at /admin/config,
+// which also shows the configuration fax-ui started with. Changes are kept in the settings table, which
+// every instance polls, so they take effect everywhere within settingsPollInterval and survive restarts.
+
+// settingsPollInterval is how often each instance looks for settings changed on another
+const settingsPollInterval = 15 * time.Second
+
+// runtimeSettings are the values of the settings that can be changed while fax-ui runs: at /admin/config,
+// or in the config file, which is reloaded on SIGHUP
+type runtimeSettings struct {
+	DefaultFrom         string
+	DefaultConnectionID string
+	NumberLookup        string
+	DefaultWebhook      string
+	Retention           RetentionConfig
+	Departments         []Department
+	Exemptions          []AuthExemption
+	Kiosks              []Kiosk
+}
+
+// runtimeSetting describes a setting of /admin/config, named after the environment variable it overrides
+type runtimeSetting struct {
+	Name  string
+	Label string
+	Help  string
+}
+
+// runtimeSettingList are the settings /admin/config edits, in the order shown
+var runtimeSettingList = []runtimeSetting{
+	{"FAX_FROM_DEFAULT", "Default From Number", "Number faxes are sent from when the form or API names none."},
+	{"FAX_CONNECTION_ID", "Default Connection", "Telnyx connection faxes are sent through when none is chosen."},
+	{"NUMBER_LOOKUP", "Number Lookup", "off, warn or block when the destination looks unable to receive faxes."},
+	{"WEBHOOK_URL", "Default Webhook URL", "Where the events of faxes sent without a webhook URL are posted; may use {fax_id}, {profile}, {user}, {from} and {to}."},
+	{"RETENTION_MEDIA", "Keep Documents", "How long sent documents are kept, e.g. 30d, 2w or 7y; empty keeps them forever. Tag overrides in the config file still apply."},
+	{"RETENTION_METADATA", "Keep Fax Records", "How long fax records are kept, e.g. 30d, 2w or 7y; empty keeps them forever. Tag overrides in the config file still apply."},
+}
+
+// get returns a setting's value as it is typed on the page
+func (s runtimeSettings) get(name string) string {
+	switch name {
+	case "FAX_FROM_DEFAULT":
+		return s.DefaultFrom
+	case "FAX_CONNECTION_ID":
+		return s.DefaultConnectionID
+	case "NUMBER_LOOKUP":
+		return s.NumberLookup
+	case "WEBHOOK_URL":
+		return s.DefaultWebhook
+	case "RETENTION_MEDIA":
+		if s.Retention.Media != nil && *s.Retention.Media > 0 {
+			return s.Retention.Media.String()
+		}
+	case "RETENTION_METADATA":
+		if s.Retention.Metadata != nil && *s.Retention.Metadata > 0 {
+			return s.Retention.Metadata.String()
+		}
+	}
+	return ""
+}
+
+// set validates and changes a setting; numbers without a country code are taken to be in country
+func (s *runtimeSettings) set(name, value, country string) error {
+	value = strings.TrimSpace(value)
+	switch name {
+	case "FAX_FROM_DEFAULT":
+		number, err := normalizePhoneNumber(value, country)
+		if err != nil {
+			return err
+		}
+		s.DefaultFrom = number
+	case "FAX_CONNECTION_ID":
+		s.DefaultConnectionID = value
+	case "NUMBER_LOOKUP":
+		mode, err := parseLookupMode(value)
+		if err != nil {
+			return err
+		}
+		s.NumberLookup = mode
+	case "WEBHOOK_URL":
+		if err := validateWebhookTemplate(value); err != nil {
+			return err
+		}
+		s.DefaultWebhook = value
+	case "RETENTION_MEDIA", "RETENTION_METADATA":
+		period, err := parseRetentionPeriod(value)
+		if err != nil {
+			return err
+		}
+		// The config is replaced rather than changed, as the retention job may be reading it
+		retention := s.Retention
+		if name == "RETENTION_MEDIA" {
+			retention.Media = &period
+		} else {
+			retention.Metadata = &period
+		}
+		s.Retention = retention
+	default:
+		return fmt.Errorf("%s cannot be changed while fax-ui runs", name)
+	}
+	return nil
+}
+
+// storedSetting is a setting changed at /admin/config
+type storedSetting struct {
+	Name      string
+	Value     string
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
+// runtimeState guards the App fields runtimeSettings mirrors, and remembers how they were set
+type runtimeState struct {
+	mu      sync.RWMutex
+	startup runtimeSettings          // from flags, environment and the config file
+	changed map[string]storedSetting // applied from the settings table, by name
+}
+
+// runtime returns the settings in effect
+func (a *App) runtime() runtimeSettings {
+	a.settings.mu.RLock()
+	defer a.settings.mu.RUnlock()
+	return a.runtimeLocked()
+}
+
+// runtimeLocked returns the settings in effect while a.settings.mu is held
+func (a *App) runtimeLocked() runtimeSettings {
+	return runtimeSettings{
+		DefaultFrom:         a.DefaultFrom,
+		DefaultConnectionID: a.DefaultConnectionID,
+		NumberLookup:        a.NumberLookup,
+		DefaultWebhook:      a.DefaultWebhook,
+		Retention:           a.Retention,
+		Departments:         a.AuthConfig.Departments,
+		Exemptions:          a.AuthConfig.Exemptions,
+		Kiosks:              a.AuthConfig.Kiosks,
+	}
+}
+
+// applySettings puts the startup settings with the stored changes into effect, returning what changed.
+// Stored values that are no longer valid are skipped.
+func (a *App) applySettings(stored []storedSetting) []string {
+	a.settings.mu.Lock()
+	defer a.settings.mu.Unlock()
+	before := a.runtimeLocked()
+	s := a.settings.startup
+	changed := make(map[string]storedSetting, len(stored))
+	for _, st := range stored {
+		if err := s.set(st.Name, st.Value, a.DefaultCountry); err != nil {
+			log.Printf("Warning: ignoring the stored setting %s: %v", st.Name, err)
+			continue
+		}
+		changed[st.Name] = st
+	}
+	a.settings.changed = changed
+	a.DefaultFrom, a.DefaultConnectionID, a.NumberLookup, a.DefaultWebhook, a.Retention =
+		s.DefaultFrom, s.DefaultConnectionID, s.NumberLookup, s.DefaultWebhook, s.Retention
+	a.AuthConfig.Departments, a.AuthConfig.Exemptions, a.AuthConfig.Kiosks = s.Departments, s.Exemptions, s.Kiosks
+	return diffSettings(before, s)
+}
+
+// diffSettings describes how two sets of settings differ, e.g. `NUMBER_LOOKUP: "warn" → "block"`; the
+// sections of the config file are named after their key
+func diffSettings(before, after runtimeSettings) []string {
+	var diff []string
+	for _, setting := range runtimeSettingList {
+		if old, v := before.get(setting.Name), after.get(setting.Name); old != v {
+			diff = append(diff, fmt.Sprintf("%s: %q → %q", setting.Name, old, v))
+		}
+	}
+	if before.Retention.DeleteRemote != after.Retention.DeleteRemote {
+		diff = append(diff, fmt.Sprintf("retention.delete_remote: %t → %t", before.Retention.DeleteRemote, after.Retention.DeleteRemote))
+	}
+	for _, section := range []struct {
+		name          string
+		before, after any
+	}{
+		{"retention.tags", before.Retention.Tags, after.Retention.Tags},
+		{"departments", before.Departments, after.Departments},
+		{"auth_exemptions", before.Exemptions, after.Exemptions},
+		{"kiosks", before.Kiosks, after.Kiosks},
+	} {
+		if !reflect.DeepEqual(section.before, section.after) {
+			diff = append(diff, section.name+" changed")
+		}
+	}
+	return diff
+}
+
+// reloadSettings applies the settings table, logging what changed
+func (a *App) reloadSettings(ctx context.Context) error {
+	stored, err := a.Store.settings(ctx)
+	if err != nil {
+		return err
+	}
+	if diff := a.applySettings(stored); len(diff) > 0 {
+		log.Printf("Settings changed at /admin/config: %s", strings.Join(diff, "; "))
+	}
+	return nil
+}
+
+// startSettingsWatch applies the settings changed at /admin/config now, then those changed on other
+// instances as they appear
+func (a *App) startSettingsWatch() {
+	a.settings.startup = a.runtime()
+	if err := a.reloadSettings(context.Background()); err != nil {
+		log.Printf("Warning: could not load settings: %v", err)
+	}
+	backgroundTasks.register("settings watch", settingsPollInterval)
+	go func() {
+		ticker := time.NewTicker(settingsPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			backgroundTasks.beat("settings watch")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := a.reloadSettings(ctx); err != nil {
+				log.Printf("Warning: could not load settings: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// settings returns the settings changed at /admin/config
+func (s *Store) settings(ctx context.Context) ([]storedSetting, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, value, updated_by, updated_at FROM settings ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var settings []storedSetting
+	for rows.Next() {
+		var st storedSetting
+		if err := rows.Scan(&st.Name, &st.Value, &st.UpdatedBy, &st.UpdatedAt); err != nil {
+			return nil, err
+		}
+		settings = append(settings, st)
+	}
+	return settings, rows.Err()
+}
+
+// saveSetting stores a setting's new value
+func (s *Store) saveSetting(ctx context.Context, st storedSetting) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO settings (name, value, updated_by, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		st.Name, st.Value, st.UpdatedBy, time.Now().UTC())
+	return err
+}
+
+// deleteSetting returns a setting to the value fax-ui started with
+func (s *Store) deleteSetting(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM settings WHERE name = ?`, name)
+	return err
+}
+
+// configRow is a line of /admin/config
+type configRow struct {
+	runtimeSetting
+	Value   string         // in effect
+	Startup string         // from flags, environment and the config file
+	Changed *storedSetting // nil when the startup value is in effect
+}
+
+// handleAdminConfig shows the configuration (GET) and changes or resets a setting (POST)
+func (a *App) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		current := a.runtime()
+		a.settings.mu.RLock()
+		var rows []configRow
+		for _, setting := range runtimeSettingList {
+			row := configRow{runtimeSetting: setting, Value: current.get(setting.Name), Startup: a.settings.startup.get(setting.Name)}
+			if st, ok := a.settings.changed[setting.Name]; ok {
+				row.Changed = &st
+			}
+			rows = append(rows, row)
+		}
+		a.settings.mu.RUnlock()
+		snap := configSnapshot()
+		q := r.URL.Query()
+		a.render(w, r, "admin_config.html", map[string]any{
+			"Settings":    rows,
+			"Flags":       sortedPairs(snap.Flags),
+			"Environment": sortedPairs(snap.Environment),
+			"Version":     Version,
+			"Success":     q.Get("success"),
+			"Error":       q.Get("error"),
+		})
+	case http.MethodPost:
+		a.handleUpdateAdminConfig(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// sortedPairs returns a map's entries sorted by key, for templates
+func sortedPairs(m map[string]string) [][2]string {
+	pairs := make([][2]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, [2]string{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	return pairs
+}
+
+// handleUpdateAdminConfig changes a setting, or resets it to its startup value, on every instance
+func (a *App) handleUpdateAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	redirect := func(status url.Values) {
+		http.Redirect(w, r, "/admin/config?"+status.Encode(), http.StatusSeeOther)
+	}
+	name := r.FormValue("name")
+	if !slices.ContainsFunc(runtimeSettingList, func(s runtimeSetting) bool { return s.Name == name }) {
+		redirect(url.Values{"error": {a.T(r, "unknown setting")}})
+		return
+	}
+	user, _ := a.sessionUser(r)
+	actor := firstNonEmpty(user, "anonymous")
+	old := a.runtime().get(name)
+
+	var success string
+	switch r.FormValue("action") {
+	case "save":
+		// Validate before storing, and store the value as it is shown
+		s := a.runtime()
+		if err := s.set(name, r.FormValue("value"), a.DefaultCountry); err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "%s: the value is not valid", name)}})
+			return
+		}
+		value := s.get(name)
+		if err := a.Store.saveSetting(r.Context(), storedSetting{Name: name, Value: value, UpdatedBy: actor}); err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "Failed to save the setting")}})
+			return
+		}
+		a.writeAudit(r.Context(), auditEntry{Actor: actor, Action: "config.changed", Detail: fmt.Sprintf("%s: %q → %q", name, old, value)})
+		success = a.T(r, "Updated %s", name)
+	case "reset":
+		if err := a.Store.deleteSetting(r.Context(), name); err != nil {
+			redirect(url.Values{"error": {a.failureMessage(r, err, "Failed to save the setting")}})
+			return
+		}
+		a.writeAudit(r.Context(), auditEntry{Actor: actor, Action: "config.reset", Detail: fmt.Sprintf("%s: %q", name, old)})
+		success = a.T(r, "Reset %s to its startup value", name)
+	default:
+		redirect(url.Values{"error": {a.T(r, "unknown action")}})
+		return
+	}
+	if err := a.reloadSettings(r.Context()); err != nil {
+		redirect(url.Values{"error": {a.failureMessage(r, err, "The setting was saved but could not be applied yet")}})
+		return
+	}
+	redirect(url.Values{"success": {success}})
+}
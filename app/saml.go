@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+// fax-ui can sign users in through a SAML 2.0 identity provider (IdP), for organizations whose IdP does
+// not speak OIDC. fax-ui is the service provider: it publishes its metadata at /auth/saml/metadata, sends
+// users to the IdP from /auth/saml/login and receives the IdP's signed assertion at /auth/saml/acs. Users
+// are identified by their email address, like OAuth logins, so ADMIN_USERS, profile users and departments
+// list SAML users the same way.
+
+// SAMLConfig configures sign-in through a SAML identity provider
+type SAMLConfig struct {
+	IDPMetadata    string // URL or file of the IdP's metadata; empty disables SAML
+	Certificate    string // PEM file of fax-ui's certificate; generated and kept in the database when empty
+	Key            string // PEM file of the certificate's private key
+	EmailAttribute string // assertion attribute holding the user's email; the NameID when the assertion has none
+}
+
+// samlEmailAttributes are the attributes IdPs commonly put the email address in, tried when
+// --saml_email_attribute is not set
+var samlEmailAttributes = []string{
+	"email",
+	"mail",
+	"emailaddress",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	"urn:oid:0.9.2342.19200300.100.1.3",
+}
+
+// samlLoginMaxAge is how long a user has to sign in at the IdP
+const samlLoginMaxAge = 10 * time.Minute
+
+var samlHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// samlSP signs users in through the IdP
+type samlSP struct {
+	sp             saml.ServiceProvider
+	emailAttribute string
+
+	// Sign-ins sent to the IdP are kept in the database by relay state. The IdP posts its answer
+	// cross-site, which browsers send without Lax cookies, and it may reach another instance.
+	store *Store
+}
+
+// samlLogin is a sign-in waiting for the IdP's answer
+type samlLogin struct {
+	RequestID string
+	Redirect  string
+	Remember  bool
+	Expires   time.Time
+}
+
+// newSAML loads the IdP's metadata and fax-ui's certificate. Without a configured certificate, one is
+// generated on first start and kept in the database, so the metadata given to the IdP stays valid.
+func newSAML(ctx context.Context, cfg SAMLConfig, store *Store, publicBaseURL string) (*samlSP, error) {
+	metadata, err := readSAMLMetadata(ctx, cfg.IDPMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("SAML IdP metadata: %w", err)
+	}
+	idp, err := parseSAMLMetadata(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("SAML IdP metadata: %w", err)
+	}
+
+	var pair tls.Certificate
+	if cfg.Certificate != "" || cfg.Key != "" {
+		if pair, err = tls.LoadX509KeyPair(cfg.Certificate, cfg.Key); err != nil {
+			return nil, fmt.Errorf("SAML certificate: %w", err)
+		}
+	} else {
+		encoded, err := store.secret(ctx, "saml_sp_key_pair", func() (string, error) { return generateSAMLKeyPair(publicBaseURL) })
+		if err != nil {
+			return nil, fmt.Errorf("SAML certificate: %w", err)
+		}
+		if pair, err = tls.X509KeyPair([]byte(encoded), []byte(encoded)); err != nil {
+			return nil, fmt.Errorf("SAML certificate: %w", err)
+		}
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("SAML certificate: the private key must be an RSA key")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("SAML certificate: %w", err)
+	}
+
+	base, err := url.Parse(strings.TrimRight(publicBaseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("public base URL: %w", err)
+	}
+	return &samlSP{
+		sp: saml.ServiceProvider{
+			EntityID:          base.String() + "/auth/saml/metadata",
+			Key:               key,
+			Certificate:       cert,
+			HTTPClient:        samlHTTPClient,
+			MetadataURL:       *base.JoinPath("/auth/saml/metadata"),
+			AcsURL:            *base.JoinPath("/auth/saml/acs"),
+			IDPMetadata:       idp,
+			AuthnNameIDFormat: saml.EmailAddressNameIDFormat,
+		},
+		emailAttribute: cfg.EmailAttribute,
+		store:          store,
+	}, nil
+}
+
+// readSAMLMetadata fetches the IdP's metadata from a URL, or reads it from a file
+func readSAMLMetadata(ctx context.Context, location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "https://") && !strings.HasPrefix(location, "http://") {
+		return os.ReadFile(location)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := samlHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", location, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+}
+
+// parseSAMLMetadata parses an IdP's EntityDescriptor, or picks the first IdP of an EntitiesDescriptor
+func parseSAMLMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	var entity saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err == nil {
+		if len(entity.IDPSSODescriptors) == 0 {
+			return nil, errors.New("no IDPSSODescriptor")
+		}
+		return &entity, nil
+	}
+	var entities saml.EntitiesDescriptor
+	if err := xml.Unmarshal(data, &entities); err != nil {
+		return nil, err
+	}
+	for i := range entities.EntityDescriptors {
+		if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[i], nil
+		}
+	}
+	return nil, errors.New("no IDPSSODescriptor")
+}
+
+// generateSAMLKeyPair creates an RSA key and a self-signed certificate for it, both PEM-encoded
+func generateSAMLKeyPair(publicBaseURL string) (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", err
+	}
+	name := "fax-ui"
+	if u, err := url.Parse(publicBaseURL); err == nil && u.Hostname() != "" {
+		name = u.Hostname()
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(20, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// handleSAMLMetadata serves fax-ui's service provider metadata, to be uploaded to the IdP
+func (a *App) handleSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	if a.SAML == nil {
+		http.NotFound(w, r)
+		return
+	}
+	out, err := xml.MarshalIndent(a.SAML.sp.Metadata(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
+
+// handleSAMLLogin sends the user to the IdP to sign in
+func (a *App) handleSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	if a.SAML == nil {
+		http.NotFound(w, r)
+		return
+	}
+	redirect := r.URL.Query().Get("redirect")
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/"
+	}
+	sp := &a.SAML.sp
+	req, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		log.Printf("Warning: could not create SAML request: %v", err)
+		http.Error(w, "failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+	target, err := req.Redirect(state, sp)
+	if err != nil {
+		log.Printf("Warning: could not create SAML request: %v", err)
+		http.Error(w, "failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	login := samlLogin{RequestID: req.ID, Redirect: redirect, Remember: r.URL.Query().Get("remember") == "1"}
+	if err := a.SAML.remember(r.Context(), state, login); err != nil {
+		log.Printf("Warning: could not keep SAML sign-in: %v", err)
+		http.Error(w, "failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+// handleSAMLACS receives the IdP's assertion and signs the user in
+func (a *App) handleSAMLACS(w http.ResponseWriter, r *http.Request) {
+	if a.SAML == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	// Sign-ins started at the IdP are refused: only answers to fax-ui's own requests are accepted
+	login, ok, err := a.SAML.take(r.Context(), r.PostForm.Get("RelayState"))
+	if err != nil {
+		log.Printf("Warning: could not look up SAML sign-in: %v", err)
+		http.Error(w, "sign-in failed", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "sign-in expired, please sign in again", http.StatusBadRequest)
+		return
+	}
+	assertion, err := a.SAML.sp.ParseResponse(r, []string{login.RequestID})
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		log.Printf("Warning: rejected SAML response: %v", err)
+		http.Error(w, "sign-in failed", http.StatusForbidden)
+		return
+	}
+	user := a.SAML.user(assertion)
+	if user == "" {
+		log.Printf("Warning: SAML assertion for %s names no email address", assertion.Issuer.Value)
+		http.Error(w, "sign-in failed: the identity provider sent no email address", http.StatusForbidden)
+		return
+	}
+	if err := a.startSession(w, r, user, login.Remember); err != nil {
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, login.Redirect, http.StatusSeeOther)
+}
+
+// user returns the email address an assertion identifies the user by
+func (s *samlSP) user(assertion *saml.Assertion) string {
+	names := samlEmailAttributes
+	if s.emailAttribute != "" {
+		names = []string{s.emailAttribute}
+	}
+	for _, name := range names {
+		for _, statement := range assertion.AttributeStatements {
+			for _, attr := range statement.Attributes {
+				if !strings.EqualFold(attr.Name, name) && !strings.EqualFold(attr.FriendlyName, name) {
+					continue
+				}
+				for _, v := range attr.Values {
+					if value := strings.TrimSpace(v.Value); value != "" {
+						return value
+					}
+				}
+			}
+		}
+	}
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		return strings.TrimSpace(assertion.Subject.NameID.Value)
+	}
+	return ""
+}
+
+// remember keeps a sign-in sent to the IdP until it expires
+func (s *samlSP) remember(ctx context.Context, state string, login samlLogin) error {
+	login.Expires = time.Now().Add(samlLoginMaxAge)
+	return s.store.saveSAMLLogin(ctx, state, login)
+}
+
+// take returns and forgets the sign-in of a relay state, so each answer is used once
+func (s *samlSP) take(ctx context.Context, state string) (samlLogin, bool, error) {
+	login, ok, err := s.store.takeSAMLLogin(ctx, state)
+	return login, ok && time.Now().Before(login.Expires), err
+}
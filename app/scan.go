@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scanSettle is how long a scan must go unmodified before it is picked up, so files the scanner is
+// still writing are left alone
+const scanSettle = 5 * time.Second
+
+// scanSentDir is the subdirectory sent scans are moved into
+const scanSentDir = "sent"
+
+// scanAutoSendPattern matches scans named for their destination, e.g. +15551234567_referral.pdf.
+// The leading + keeps date-numbered scanner names from being dialed.
+var scanAutoSendPattern = regexp.MustCompile(`^(\+[0-9]{7,15})(?:[_ -].*)?$`)
+
+// scanFolder is the directory the office scanner drops documents into (SCAN_DIR)
+type scanFolder struct {
+	Dir string
+
+	mu     sync.Mutex
+	failed map[string]string // auto-send scans that failed since startup, with the reason
+}
+
+// scanFile is a document waiting in the scan folder
+type scanFile struct {
+	Name     string
+	Size     int64
+	ModTime  time.Time
+	To       string // destination from the file name, if it follows the convention
+	Failure  string // why sending it automatically failed
+	autoSend bool
+}
+
+// Kilobytes is the file size for display, rounded up
+func (f scanFile) Kilobytes() int64 {
+	return (f.Size + 1023) / 1024
+}
+
+func newScanFolder(dir string) (*scanFolder, error) {
+	if err := os.MkdirAll(filepath.Join(dir, scanSentDir), 0o755); err != nil {
+		return nil, fmt.Errorf("scan folder: %w", err)
+	}
+	return &scanFolder{Dir: dir, failed: make(map[string]string)}, nil
+}
+
+// validScanName reports whether name is a document directly inside the scan folder
+func validScanName(name string) bool {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pdf", ".tif", ".tiff":
+		return true
+	}
+	return false
+}
+
+// scanDestination returns the number a scan's name asks it to be sent to, or ""
+func scanDestination(name string) string {
+	if m := scanAutoSendPattern.FindStringSubmatch(strings.TrimSuffix(name, filepath.Ext(name))); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// list returns the settled scans in the folder, oldest first
+func (s *scanFolder) list() ([]scanFile, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []scanFile
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !validScanName(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < scanSettle {
+			continue
+		}
+		f := scanFile{Name: e.Name(), Size: info.Size(), ModTime: info.ModTime(), To: scanDestination(e.Name()), Failure: s.failed[e.Name()]}
+		f.autoSend = f.To != "" && f.Failure == ""
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime.Before(files[j].ModTime) })
+	return files, nil
+}
+
+// read loads a scan for sending
+func (s *scanFolder) read(name string) (*document, error) {
+	if !validScanName(name) {
+		return nil, errors.New("invalid scan name")
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("the scan %s is no longer in the scan folder", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	typ := "application/pdf"
+	if !strings.EqualFold(filepath.Ext(name), ".pdf") {
+		typ = "image/tiff"
+	}
+	return &document{Name: name, Type: typ, Data: data}, nil
+}
+
+// done takes a sent scan out of the folder: into sent/, or deleted in HIPAA mode
+func (s *scanFolder) done(name string, remove bool) error {
+	s.mu.Lock()
+	delete(s.failed, name)
+	s.mu.Unlock()
+	src := filepath.Join(s.Dir, name)
+	if remove {
+		return os.Remove(src)
+	}
+	return os.Rename(src, filepath.Join(s.Dir, scanSentDir, time.Now().Format("20060102-150405")+"_"+name))
+}
+
+// remove deletes a scan nobody is going to send
+func (s *scanFolder) remove(name string) error {
+	if !validScanName(name) {
+		return errors.New("invalid scan name")
+	}
+	s.mu.Lock()
+	delete(s.failed, name)
+	s.mu.Unlock()
+	return os.Remove(filepath.Join(s.Dir, name))
+}
+
+func (s *scanFolder) fail(name string, err error) {
+	s.mu.Lock()
+	s.failed[name] = err.Error()
+	s.mu.Unlock()
+}
+
+// startScanWatcher polls the scan folder and sends the scans named for their destination. Instances
+// sharing the folder take turns through a lease, so a scan is not sent twice.
+func (a *App) startScanWatcher(interval time.Duration) {
+	backgroundTasks.register("scan folder", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			backgroundTasks.beat("scan folder")
+			if !a.leads("scan folder", interval) {
+				continue
+			}
+			files, err := a.Scans.list()
+			if err != nil {
+				log.Printf("Warning: could not read scan folder: %v", err)
+				continue
+			}
+			for _, f := range files {
+				if !f.autoSend {
+					continue
+				}
+				if err := a.autoSendScan(context.Background(), f); err != nil {
+					log.Printf("Warning: could not send scan %s: %v", f.Name, err)
+					a.Scans.fail(f.Name, err)
+				}
+			}
+		}
+	}()
+}
+
+// autoSendScan faxes a scan to the number in its name from the default profile, as if sent from the form
+// with its defaults. Unattended sends stop at anything the form would ask a person to confirm.
+func (a *App) autoSendScan(ctx context.Context, f scanFile) error {
+	profile := a.profileByName(a.SendProfile)
+	// A scan has no browser session, so it gets the defaults of the first fax application
+	from, connectionID := a.profileDefaults(&http.Request{}, profile)
+	if from == "" || (connectionID == "" && profile.client != nil) {
+		return errors.New("no default from number or connection is configured")
+	}
+	to, err := normalizePhoneNumber(f.To, a.DefaultCountry)
+	if err != nil {
+		return err
+	}
+	if msg := a.checkBlocked(ctx, to); msg != "" {
+		return errors.New(msg)
+	}
+	if msg := a.checkDestination(ctx, profile, to); msg != "" {
+		return errors.New(msg)
+	}
+	doc, err := a.Scans.read(f.Name)
+	if err != nil {
+		return err
+	}
+	if doc.isPDF() && a.Preprocess.enabled() && a.Ghostscript != "" {
+		if cleaned, err := preprocessPDF(ctx, a.Ghostscript, a.Preprocess, doc); err != nil {
+			log.Printf("Warning: could not preprocess %s, sending it unchanged: %v", doc.Name, err)
+		} else {
+			doc = cleaned
+		}
+	}
+	if doc.isPDF() && a.Stamp.enabled() {
+		stamped, err := stampPDF(doc.Data, a.Stamp, from, to, time.Now().In(a.Location))
+		if err != nil {
+			return err
+		}
+		doc = &document{Name: doc.Name, Type: "application/pdf", Data: stamped}
+	}
+	if doc.isPDF() && a.CompatMode && a.Ghostscript != "" {
+		if doc, err = convertToFaxTIFF(ctx, a.Ghostscript, doc); err != nil {
+			return fmt.Errorf("compatibility mode conversion failed: %w", err)
+		}
+	}
+	mediaURL, err := a.storeDocument(ctx, doc)
+	if err != nil {
+		return err
+	}
+	carrierHook, forwardHook := a.faxWebhook("", "scanner", profile.Name, from, to)
+	fax, record, err := a.sendFax(ctx, profile, SendRequest{ConnectionID: connectionID, From: from, To: to, MediaURL: mediaURL, Document: doc, WebhookURL: carrierHook})
+	if err != nil {
+		return err
+	}
+	record.SentBy = "scanner"
+	a.recordFaxWebhook(ctx, record.Profile, fax.ID, forwardHook)
+	record.MediaFile = path.Base(mediaURL)
+	if err := a.Store.recordSentFax(ctx, record); err != nil {
+		log.Printf("Warning: could not record sent fax %s: %v", fax.ID, err)
+	}
+	if err := a.Store.saveFaxes(ctx, record.Profile, []Fax{*fax}); err != nil {
+		log.Printf("Warning: could not save sent fax %s: %v", fax.ID, err)
+	}
+	log.Printf("Sent scan %s to %s as fax %s", f.Name, to, fax.ID)
+	if err := a.Scans.done(f.Name, a.Hipaa); err != nil {
+		// Keep it from being sent again on the next pass
+		log.Printf("Warning: could not move sent scan %s out of the scan folder: %v", f.Name, err)
+		a.Scans.fail(f.Name, fmt.Errorf("sent as fax %s, but could not be moved out of the scan folder", fax.ID))
+	}
+	return nil
+}
+
+// handleScans lists the scans waiting to be sent (GET) and deletes scans (POST)
+func (a *App) handleScans(w http.ResponseWriter, r *http.Request) {
+	if a.Scans == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		files, err := a.Scans.list()
+		if err != nil {
+			http.Error(w, "failed to read scan folder: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Scans that are about to be sent automatically are not offered for sending by hand
+		var ready []scanFile
+		for _, f := range files {
+			if !f.autoSend {
+				ready = append(ready, f)
+			}
+		}
+		data := map[string]any{
+			"Scans":   ready,
+			"Success": r.URL.Query().Get("success"),
+		}
+		a.render(w, r, "scans.html", data)
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		if err := a.Scans.remove(r.FormValue("scan")); err != nil {
+			http.Error(w, "failed to delete scan: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/scans?success="+url.QueryEscape(a.T(r, "Scan deleted")), http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
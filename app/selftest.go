@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+)
+
+// The self-test at /admin/selftest checks what fax-ui needs from outside itself, for admins setting it up or
+// finding out why faxes stopped: that Telnyx accepts the API key, that the public URL reaches this
+// deployment for /media/ and webhooks, that the fax applications send their events here, that the database
+// and document storage take writes, and that email-to-fax gateways accept a connection.
+
+// selfTestTimeout bounds each check of the self-test
+const selfTestTimeout = 15 * time.Second
+
+// selfTestEvent is the event posted to the webhook URL; handleTelnyxWebhook ignores it once it is accepted
+const selfTestEvent = `{"data":{"event_type":"fax_ui.self_test","payload":{}}}`
+
+// Outcomes of a self-test check
+const (
+	selfTestPass = "pass"
+	selfTestWarn = "warn"
+	selfTestFail = "fail"
+	selfTestSkip = "skip"
+)
+
+// selfTestResult is the outcome of one check, with what to do about a failure
+type selfTestResult struct {
+	Name   string
+	Status string
+	Detail string
+	Fix    string
+}
+
+// handleAdminSelfTest shows the self-test (GET) and runs it (POST)
+func (a *App) handleAdminSelfTest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.render(w, r, "admin_selftest.html", map[string]any{})
+	case http.MethodPost:
+		started := time.Now()
+		results := a.runSelfTest(r)
+		failed := 0
+		for _, res := range results {
+			if res.Status == selfTestFail {
+				failed++
+			}
+		}
+		a.render(w, r, "admin_selftest.html", map[string]any{
+			"Results":  results,
+			"Failed":   failed,
+			"Total":    len(results),
+			"Duration": time.Since(started).Round(100 * time.Millisecond),
+		})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// runSelfTest runs every check in turn, in the language of r
+func (a *App) runSelfTest(r *http.Request) []selfTestResult {
+	var results []selfTestResult
+	results = append(results, a.selfTestTelnyx(r)...)
+	results = append(results, a.selfTestPublicURL(r), a.selfTestWebhookDelivery(r))
+	results = append(results, a.selfTestWebhookURLs(r)...)
+	results = append(results, a.selfTestDatabase(r), a.selfTestMedia(r))
+	results = append(results, a.selfTestSMTP(r)...)
+	return results
+}
+
+// selfTestTelnyx checks that each Telnyx profile's API key is accepted, by listing its fax applications,
+// and that its connection is one of them
+func (a *App) selfTestTelnyx(r *http.Request) []selfTestResult {
+	if a.Sandbox {
+		return []selfTestResult{{Name: a.T(r, "Telnyx API"), Status: selfTestSkip, Detail: a.T(r, "Sandbox mode: faxes never reach Telnyx")}}
+	}
+	var results []selfTestResult
+	for _, p := range a.Profiles {
+		if p.client == nil {
+			continue
+		}
+		name := a.T(r, "Telnyx API")
+		if len(a.Profiles) > 1 {
+			name = a.T(r, "Telnyx API (profile %s)", p.Name)
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), selfTestTimeout)
+		conns, err := p.listConnections(ctx, true)
+		cancel()
+		var apiErr *telnyx.Error
+		switch {
+		case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+			results = append(results, selfTestResult{Name: name, Status: selfTestFail,
+				Detail: a.T(r, "Telnyx did not accept the API key: %s", err.Error()),
+				Fix:    a.T(r, "Check TELNYX_API_KEY, or the api_key of the profile in the config file. Create a new key in the Telnyx portal under Account Settings → Keys & Credentials if it was revoked.")})
+			continue
+		case err != nil:
+			results = append(results, selfTestResult{Name: name, Status: selfTestFail,
+				Detail: a.T(r, "Could not reach Telnyx: %s", err.Error()),
+				Fix:    a.T(r, "Check that this server can make HTTPS requests to api.telnyx.com (DNS, firewall), and TELNYX_HTTP_PROXY or HTTPS_PROXY where outbound traffic needs a proxy.")})
+			continue
+		}
+		conn := p.ConnectionID
+		if p == a.defaultProfile() {
+			conn = a.runtime().DefaultConnectionID
+		}
+		if conn != "" && !slices.ContainsFunc(conns, func(c connectionOption) bool { return c.ID == conn }) {
+			results = append(results, selfTestResult{Name: name, Status: selfTestWarn,
+				Detail: a.T(r, "The API key works, but connection %s is not one of the account's %d fax applications", conn, len(conns)),
+				Fix:    a.T(r, "Set FAX_CONNECTION_ID (or the profile's connection_id) to the ID of a fax application, listed in the Telnyx portal under Programmable Fax.")})
+			continue
+		}
+		results = append(results, selfTestResult{Name: name, Status: selfTestPass,
+			Detail: a.T(r, "The API key works; the account has %d fax applications", len(conns))})
+	}
+	if results == nil {
+		results = append(results, selfTestResult{Name: a.T(r, "Telnyx API"), Status: selfTestSkip, Detail: a.T(r, "No profile sends through Telnyx")})
+	}
+	return results
+}
+
+// selfTestPublicURL stores a document and fetches it through the public URL, the way Telnyx fetches the
+// documents of faxes. The request leaves from this server, which may reach the URL where Telnyx cannot.
+func (a *App) selfTestPublicURL(r *http.Request) selfTestResult {
+	name := a.T(r, "Public URL")
+	base := trimTrailingSlash(a.PublicBaseURL())
+	if isLocalURL(base) {
+		return selfTestResult{Name: name, Status: selfTestFail,
+			Detail: a.T(r, "%s points at this machine, which Telnyx cannot reach", base),
+			Fix:    a.T(r, "Set PUBLIC_BASE_URL to the https URL fax-ui is reached at from the internet, or run a tunnel or the relay.")}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), selfTestTimeout)
+	defer cancel()
+	token, err := generateSecureToken(16)
+	if err != nil {
+		return selfTestResult{Name: name, Status: selfTestFail, Detail: err.Error()}
+	}
+	media, err := a.Media.Put(ctx, &document{Name: "self-test.txt", Type: "text/plain", Data: []byte(token)})
+	if err != nil {
+		return selfTestResult{Name: name, Status: selfTestSkip, Detail: a.T(r, "Document storage does not take writes; see below")}
+	}
+	defer a.Media.Delete(context.WithoutCancel(ctx), media)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/media/"+media, nil)
+	if err != nil {
+		return selfTestResult{Name: name, Status: selfTestFail, Detail: err.Error(), Fix: a.T(r, "Set PUBLIC_BASE_URL to a valid URL.")}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return selfTestResult{Name: name, Status: selfTestFail,
+			Detail: a.T(r, "Could not reach %s: %s", base, err.Error()),
+			Fix:    a.T(r, "Check the DNS name, the firewall, and the reverse proxy or tunnel in front of fax-ui.")}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, []byte(token)) {
+		return selfTestResult{Name: name, Status: selfTestFail,
+			Detail: a.T(r, "%s answered %s, not with the test document", base, resp.Status),
+			Fix:    a.T(r, "Check that PUBLIC_BASE_URL points at this deployment, and that the reverse proxy passes /media/ through unchanged.")}
+	}
+	return selfTestResult{Name: name, Status: selfTestPass,
+		Detail: a.T(r, "Fetched a test document through %s/media/, as Telnyx does, from this server", base)}
+}
+
+// selfTestWebhookDelivery posts a test event to the webhook URL Telnyx is given. Where webhooks are
+// verified the unsigned event is refused, which shows the URL reaches fax-ui just the same.
+func (a *App) selfTestWebhookDelivery(r *http.Request) selfTestResult {
+	name := a.T(r, "Webhook delivery")
+	target := a.webhookURL()
+	if isLocalURL(target) {
+		return selfTestResult{Name: name, Status: selfTestSkip, Detail: a.T(r, "Webhooks need a public URL; see above")}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), selfTestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(selfTestEvent))
+	if err != nil {
+		return selfTestResult{Name: name, Status: selfTestFail, Detail: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return selfTestResult{Name: name, Status: selfTestFail,
+			Detail: a.T(r, "Could not post a test event to %s: %s", a.PublicBaseURL()+telnyxWebhookPath, err.Error()),
+			Fix:    a.T(r, "Check that the reverse proxy or tunnel in front of fax-ui passes POST requests to %s through.", telnyxWebhookPath)}
+	}
+	resp.Body.Close()
+	verified := a.webhookKey(a.webhookSigning()) != nil
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return selfTestResult{Name: name, Status: selfTestPass, Detail: a.T(r, "Delivered a test event to %s", a.PublicBaseURL()+telnyxWebhookPath)}
+	case resp.StatusCode == http.StatusUnauthorized && verified:
+		return selfTestResult{Name: name, Status: selfTestPass,
+			Detail: a.T(r, "The test event reached %s and was refused for its missing signature, as webhooks are verified", a.PublicBaseURL()+telnyxWebhookPath)}
+	case resp.StatusCode == http.StatusUnauthorized:
+		return selfTestResult{Name: name, Status: selfTestFail,
+			Detail: a.T(r, "The test event reached %s, but webhooks are refused while they can be neither verified nor posted to a secret URL", a.PublicBaseURL()+telnyxWebhookPath),
+			Fix:    a.T(r, "Set TELNYX_PUBLIC_KEY to the public key from the Telnyx portal, or use Rotate Webhook Signing on the settings page.")}
+	}
+	return selfTestResult{Name: name, Status: selfTestFail,
+		Detail: a.T(r, "The test event was answered %s", resp.Status),
+		Fix:    a.T(r, "Check that PUBLIC_BASE_URL points at this deployment, and that the reverse proxy passes %s through unchanged.", telnyxWebhookPath)}
+}
+
+// selfTestWebhookURLs checks that the fax applications send their events to this deployment
+func (a *App) selfTestWebhookURLs(r *http.Request) []selfTestResult {
+	p := a.defaultProfile()
+	if a.Sandbox || p.client == nil {
+		return nil
+	}
+	var apps []string
+	for _, app := range a.FaxApps {
+		apps = append(apps, app.ID)
+	}
+	if len(apps) == 0 {
+		if conn := a.runtime().DefaultConnectionID; conn != "" {
+			apps = append(apps, conn)
+		}
+	}
+	target := a.webhookURL()
+	var results []selfTestResult
+	for _, id := range apps {
+		name := a.T(r, "Webhook URL of %s", id)
+		ctx, cancel := context.WithTimeout(r.Context(), selfTestTimeout)
+		res, err := p.client.FaxApplications.Get(ctx, id)
+		cancel()
+		switch {
+		case err != nil:
+			results = append(results, selfTestResult{Name: name, Status: selfTestFail,
+				Detail: a.T(r, "Could not look up the fax application: %s", err.Error()),
+				Fix:    a.T(r, "Check that %s is a fax application of this account.", id)})
+		case res.Data.WebhookEventURL == "":
+			results = append(results, selfTestResult{Name: name, Status: selfTestFail,
+				Detail: a.T(r, "The fax application has no webhook URL, so fax-ui hears of status changes only when it asks"),
+				Fix:    a.T(r, "Set PROVISION_WEBHOOK=true, use Use This Server on the settings page, or set the webhook URL in the Telnyx portal to %s.", target)})
+		case res.Data.WebhookEventURL != target:
+			results = append(results, selfTestResult{Name: name, Status: selfTestFail,
+				Detail: a.T(r, "Telnyx sends its events to %s", res.Data.WebhookEventURL),
+				Fix:    a.T(r, "Set PROVISION_WEBHOOK=true, use Use This Server on the settings page, or set the webhook URL in the Telnyx portal to %s.", target)})
+		default:
+			results = append(results, selfTestResult{Name: name, Status: selfTestPass, Detail: a.T(r, "Telnyx sends its events to this deployment")})
+		}
+	}
+	return results
+}
+
+// selfTestDatabase checks that the database takes writes
+func (a *App) selfTestDatabase(r *http.Request) selfTestResult {
+	name := a.T(r, "Database")
+	ctx, cancel := context.WithTimeout(r.Context(), selfTestTimeout)
+	defer cancel()
+	if err := a.Store.checkWritable(ctx); err != nil {
+		return selfTestResult{Name: name, Status: selfTestFail,
+			Detail: a.T(r, "Could not write to the database: %s", err.Error()),
+			Fix:    a.T(r, "Check that fax-ui may write DATABASE_PATH and its directory, that the disk is not full, or that the DATABASE_URL role may write.")}
+	}
+	return selfTestResult{Name: name, Status: selfTestPass, Detail: a.T(r, "The database takes writes")}
+}
+
+// checkWritable writes to the database without keeping what it wrote
+func (s *Store) checkWritable(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `INSERT INTO settings (name, value, updated_by, updated_at) VALUES (?, ?, ?, ?)`,
+		"fax-ui self-test", "", "self-test", time.Now().UTC())
+	return err
+}
+
+// selfTestMedia stores, reads back and deletes a document where uploads are kept
+func (a *App) selfTestMedia(r *http.Request) selfTestResult {
+	name := a.T(r, "Document storage")
+	fix := a.T(r, "Check that fax-ui may write UPLOAD_DIR, or the S3 bucket and its credentials.")
+	ctx, cancel := context.WithTimeout(r.Context(), selfTestTimeout)
+	defer cancel()
+	data := []byte("fax-ui self-test")
+	media, err := a.Media.Put(ctx, &document{Name: "self-test.txt", Type: "text/plain", Data: data})
+	if err != nil {
+		return selfTestResult{Name: name, Status: selfTestFail, Detail: a.T(r, "Could not store a document: %s", err.Error()), Fix: fix}
+	}
+	file, err := a.Media.Get(ctx, media)
+	if err == nil && !bytes.Equal(file.Data, data) {
+		err = errors.New("the document read back differs")
+	}
+	if derr := a.Media.Delete(ctx, media); err == nil && derr != nil {
+		err = derr
+	}
+	if err != nil {
+		return selfTestResult{Name: name, Status: selfTestFail, Detail: a.T(r, "Could not read back and delete a document: %s", err.Error()), Fix: fix}
+	}
+	if a.memMedia != nil {
+		return selfTestResult{Name: name, Status: selfTestPass, Detail: a.T(r, "Documents are kept in memory, and lost on restart")}
+	}
+	return selfTestResult{Name: name, Status: selfTestPass, Detail: a.T(r, "Stored, read back and deleted a document")}
+}
+
+// selfTestSMTP connects to the email-to-fax gateway of each profile that sends through one and signs in,
+// without sending mail
+func (a *App) selfTestSMTP(r *http.Request) []selfTestResult {
+	var results []selfTestResult
+	for _, p := range a.Profiles {
+		if p.Provider != providerEmail {
+			continue
+		}
+		name := a.T(r, "SMTP (profile %s)", p.Name)
+		addr := net.JoinHostPort(p.SMTP.Host, firstNonEmpty(p.SMTP.Port, "587"))
+		ctx, cancel := context.WithTimeout(r.Context(), selfTestTimeout)
+		err := checkSMTP(ctx, addr, p.SMTP)
+		cancel()
+		if err != nil {
+			results = append(results, selfTestResult{Name: name, Status: selfTestFail,
+				Detail: fmt.Sprintf("%s: %v", addr, err),
+				Fix:    a.T(r, "Check the smtp host, port, username and password of the profile, and that this server may connect to the mail server.")})
+			continue
+		}
+		results = append(results, selfTestResult{Name: name, Status: selfTestPass, Detail: a.T(r, "%s accepted the connection and sign-in", addr)})
+	}
+	if results == nil {
+		results = append(results, selfTestResult{Name: "SMTP", Status: selfTestSkip, Detail: a.T(r, "No profile sends through an email-to-fax gateway")})
+	}
+	return results
+}
+
+// checkSMTP connects to a mail server as the email provider does, with STARTTLS where offered, and signs
+// in when a username is configured
+func checkSMTP(ctx context.Context, addr string, cfg SMTPConfig) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if err := c.Hello("localhost"); err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: cfg.Host}); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return fmt.Errorf("sign-in: %w", err)
+		}
+	}
+	return c.Quit()
+}
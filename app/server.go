@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// ServerConfig holds the HTTP server's limits and protocol options
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration // time to read request headers; guards against slowloris
+	ReadTimeout       time.Duration // time to read a whole request including the body; 0 means no limit
+	WriteTimeout      time.Duration // time to write a response; 0 means no limit
+	IdleTimeout       time.Duration // how long keep-alive connections wait for the next request
+	MaxHeaderBytes    int
+	HTTP2             bool // offer HTTP/2 over TLS
+	H2C               bool // accept HTTP/2 without TLS, e.g. from a proxy on the same host
+	Compression       bool // gzip HTML, JSON and other text responses
+}
+
+// Defaults keep slow clients from holding connections open with trickled headers, while leaving the
+// body and response unlimited: documents of up to 50 MB are uploaded and exports streamed over slow lines.
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultMaxHeaderBytes    = 64 << 10
+)
+
+// newHTTPServer creates the HTTP server for handler with the configured limits
+func newHTTPServer(cfg ServerConfig, handler http.Handler) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(cfg.HTTP2)
+	protocols.SetUnencryptedHTTP2(cfg.H2C)
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		Protocols:         protocols,
+	}
+}
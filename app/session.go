@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Sessions are kept in the local database, so signing in survives restarts and deploys, and signing out
+// ends the session on the server as well. The cookie holds a random token; the database holds its hash.
+// A session ends --session_max_age after sign-in, or after that long without a request when sliding
+// expiration is on, and --session_idle_timeout after its last request.
+//
+// "Keep me signed in" also issues a remember-me credential, a second cookie kept in the database the same
+// way. When the session ends, the credential starts a new one without signing in again, until it is
+// rememberMaxAge old. The idle timeout applies to it as well. Each use replaces its token, so a copied
+// cookie stops working once either copy is used.
+
+// rememberMaxAge is how long "Keep me signed in" keeps a user signed in
+const rememberMaxAge = 30 * 24 * time.Hour
+
+// hipaaIdleTimeout is the default idle timeout in HIPAA mode
+const hipaaIdleTimeout = 15 * time.Minute
+
+// sessionTouchInterval is how stale a session's last request may get before it is written back, so
+// busy pages do not write to the database on every request
+const sessionTouchInterval = time.Minute
+
+// session is a signed-in user's session, or a remember-me credential
+type session struct {
+	Hash      string // SHA-256 of the cookie's token
+	User      string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	ExpiresAt time.Time
+	Refresh   string // hash of the remember-me credential that started the session, if any
+}
+
+// sessionKey carries the session of an authenticated request in its context, so it is looked up once
+type sessionKey struct{}
+
+// hashSessionToken returns the key a session token is stored under
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSession signs user in: it stores a new session and sets its cookie. With remember, it
+// issues a remember-me credential too.
+func (a *App) startSession(w http.ResponseWriter, r *http.Request, user string, remember bool) error {
+	refresh := ""
+	if remember {
+		token, err := generateSessionToken()
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		c := session{Hash: hashSessionToken(token), User: user, CreatedAt: now, LastSeen: now, ExpiresAt: now.Add(rememberMaxAge)}
+		if err := a.Store.createRememberedLogin(r.Context(), c); err != nil {
+			return err
+		}
+		a.writeAuthCookie(w, a.rememberCookieName(), token, rememberMaxAge)
+		refresh = c.Hash
+	}
+	_, err := a.newSession(w, r, user, refresh)
+	return err
+}
+
+// newSession stores a session for user and sets its cookie
+func (a *App) newSession(w http.ResponseWriter, r *http.Request, user, refresh string) (session, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return session{}, err
+	}
+	now := time.Now().UTC()
+	s := session{Hash: hashSessionToken(token), User: user, CreatedAt: now, LastSeen: now,
+		ExpiresAt: now.Add(a.AuthConfig.SessionMaxAge), Refresh: refresh}
+	if err := a.Store.createSession(r.Context(), s); err != nil {
+		return session{}, err
+	}
+	a.writeAuthCookie(w, a.AuthConfig.CookieName, token, a.AuthConfig.SessionMaxAge)
+	return s, nil
+}
+
+// rememberCookieName is the cookie holding the remember-me credential, named after the session cookie
+func (a *App) rememberCookieName() string {
+	return a.AuthConfig.CookieName + "_remember"
+}
+
+// currentSession returns the unexpired session of the request's cookie
+func (a *App) currentSession(r *http.Request) (session, bool) {
+	if s, ok := r.Context().Value(sessionKey{}).(session); ok {
+		return s, true
+	}
+	cookie, err := r.Cookie(a.AuthConfig.CookieName)
+	if err != nil || cookie.Value == "" {
+		return session{}, false
+	}
+	s, found, err := a.Store.session(r.Context(), hashSessionToken(cookie.Value))
+	if err != nil {
+		log.Printf("Warning: could not look up session: %v", err)
+		return session{}, false
+	}
+	return s, found && a.sessionActive(s)
+}
+
+// sessionActive checks that a session or remember-me credential has neither expired nor been idle too long
+func (a *App) sessionActive(s session) bool {
+	now := time.Now()
+	if now.After(s.ExpiresAt) {
+		return false
+	}
+	idle := a.AuthConfig.SessionIdleTimeout
+	return idle <= 0 || now.Sub(s.LastSeen) <= idle
+}
+
+// resumeSession starts a new session from the request's remember-me credential, replacing its token
+func (a *App) resumeSession(w http.ResponseWriter, r *http.Request) (session, bool) {
+	cookie, err := r.Cookie(a.rememberCookieName())
+	if err != nil || cookie.Value == "" {
+		return session{}, false
+	}
+	hash := hashSessionToken(cookie.Value)
+	c, found, err := a.Store.rememberedLogin(r.Context(), hash)
+	if err != nil {
+		log.Printf("Warning: could not look up remembered sign-in: %v", err)
+		return session{}, false
+	}
+	if !found || !a.sessionActive(c) {
+		return session{}, false
+	}
+	token, err := generateSessionToken()
+	if err != nil {
+		return session{}, false
+	}
+	c.Hash = hashSessionToken(token)
+	c.LastSeen = time.Now().UTC()
+	// Only one of concurrent requests carrying the same credential gets to replace it
+	if renewed, err := a.Store.renewRememberedLogin(r.Context(), hash, c); err != nil || !renewed {
+		if err != nil {
+			log.Printf("Warning: could not renew remembered sign-in: %v", err)
+		}
+		return session{}, false
+	}
+	a.writeAuthCookie(w, a.rememberCookieName(), token, time.Until(c.ExpiresAt))
+	s, err := a.newSession(w, r, c.User, c.Hash)
+	if err != nil {
+		log.Printf("Warning: could not resume session: %v", err)
+		return session{}, false
+	}
+	return s, true
+}
+
+// withSession records the request in its session and carries the session in the request's context,
+// starting a new session from a remember-me credential when the last one ended. With sliding expiration
+// the session, and its cookie, are extended by --session_max_age.
+func (a *App) withSession(w http.ResponseWriter, r *http.Request) *http.Request {
+	s, ok := a.currentSession(r)
+	if !ok {
+		if s, ok = a.resumeSession(w, r); ok {
+			return r.WithContext(context.WithValue(r.Context(), sessionKey{}, s))
+		}
+		return r
+	}
+	now := time.Now().UTC()
+	if now.Sub(s.LastSeen) >= sessionTouchInterval {
+		s.LastSeen = now
+		if a.AuthConfig.SessionSliding {
+			s.ExpiresAt = now.Add(a.AuthConfig.SessionMaxAge)
+		}
+		if err := a.Store.touchSession(r.Context(), s); err != nil {
+			log.Printf("Warning: could not update session: %v", err)
+		} else if cookie, err := r.Cookie(a.AuthConfig.CookieName); err == nil && a.AuthConfig.SessionSliding {
+			a.writeAuthCookie(w, a.AuthConfig.CookieName, cookie.Value, a.AuthConfig.SessionMaxAge)
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), sessionKey{}, s))
+}
+
+// endSession deletes the request's session and remember-me credential, and their cookies
+func (a *App) endSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(a.AuthConfig.CookieName); err == nil && cookie.Value != "" {
+		if err := a.Store.deleteSession(r.Context(), hashSessionToken(cookie.Value)); err != nil {
+			log.Printf("Warning: could not delete session: %v", err)
+		}
+	}
+	if cookie, err := r.Cookie(a.rememberCookieName()); err == nil && cookie.Value != "" {
+		if err := a.Store.deleteRememberedLogin(r.Context(), hashSessionToken(cookie.Value)); err != nil {
+			log.Printf("Warning: could not delete remembered sign-in: %v", err)
+		}
+	}
+	a.writeAuthCookie(w, a.AuthConfig.CookieName, "", -1)
+	a.writeAuthCookie(w, a.rememberCookieName(), "", -1)
+}
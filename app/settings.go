@@ -2,18 +2,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/team-telnyx/telnyx-go/v4"
+	"github.com/team-telnyx/telnyx-go/v4/option"
+	"github.com/team-telnyx/telnyx-go/v4/packages/respjson"
 )
 
 // handleSettings displays and updates fax application settings
 func (a *App) handleSettings(w http.ResponseWriter, r *http.Request) {
 	// Only show settings if a fax application ID is configured
-	if a.FaxApplicationID == "" {
+	if len(a.FaxApps) == 0 {
 		http.Error(w, "Settings are only available when a fax application ID is configured. Use --fax_app_id or FAX_APPLICATION_ID environment variable.", http.StatusNotFound)
 		return
 	}
@@ -28,29 +32,63 @@ func (a *App) handleSettings(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// settingsAppID returns the fax application selected in the picker, defaulting to the one selected in the
+// switcher. It is false when the picker names an application that is not configured.
+func (a *App) settingsAppID(r *http.Request) (string, bool) {
+	id := strings.TrimSpace(r.FormValue("app"))
+	if id == "" {
+		app := a.currentFaxApp(r)
+		if app == nil {
+			return "", false
+		}
+		return app.ID, true
+	}
+	return id, a.faxApp(id) != nil
+}
+
+// settingsRedirect redirects back to the settings page for appID with the given status query parameters
+func settingsRedirect(w http.ResponseWriter, r *http.Request, appID string, status url.Values) {
+	status.Set("app", appID)
+	http.Redirect(w, r, "/settings?"+status.Encode(), http.StatusSeeOther)
+}
+
 // handleShowSettings fetches and displays current fax application settings
 func (a *App) handleShowSettings(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.PageTimeout)
 	defer cancel()
 
 	// Fetch fax application details by fax application ID
-	res, err := a.Client.FaxApplications.Get(ctx, a.FaxApplicationID)
+	appID, ok := a.settingsAppID(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	res, err := a.Client.FaxApplications.Get(ctx, appID)
 	if err != nil {
-		http.Error(w, "Failed to fetch fax application settings: "+err.Error(), http.StatusBadGateway)
+		writeUpstreamError(w, fmt.Errorf("Failed to fetch fax application settings: %w", err))
 		return
 	}
 
 	data := map[string]any{
-		"Application":  res.Data,
-		"FaxAppID":     a.FaxApplicationID,
-		"ConnectionID": a.DefaultConnectionID,
-		"Success":      r.URL.Query().Get("success") == "true",
-		"Error":        r.URL.Query().Get("error"),
+		"Application":        res.Data,
+		"FaxAppID":           appID,
+		"Connections":        a.defaultProfile().cachedConnections(r.Context()),
+		"ConnectionID":       a.runtime().DefaultConnectionID,
+		"EmailRecipient":     extraFieldString(res.Data.JSON.ExtraFields, "fax_email_recipient"),
+		"WebhookAPIVersion":  extraFieldString(res.Data.JSON.ExtraFields, "webhook_api_version"),
+		"Tags":               strings.Join(res.Data.Tags, ", "),
+		"Anchorsites":        anchorsiteOptions,
+		"VoiceProfiles":      a.outboundVoiceProfiles(ctx),
+		"WebhookAPIVersions": []string{"1", "2"},
+		"WebhookURL":         a.webhookURL(),
+		"WebhookSigning":     a.webhookSigning(),
+		"RotationGrace":      strings.TrimSuffix(strings.TrimSuffix(a.signing.grace.String(), "0s"), "0m"), // 1h0m0s as 1h
+		"Rotated":            r.URL.Query().Get("rotated"),
+		"Success":            r.URL.Query().Get("success") == "true",
+		"Error":              r.URL.Query().Get("error"),
 	}
 
-	if err := a.Tmpl.ExecuteTemplate(w, "settings.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	a.render(w, r, "settings.html", data)
 }
 
 // handleUpdateSettings processes form submission to update fax application settings
@@ -60,13 +98,27 @@ func (a *App) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.ActionTimeout)
 	defer cancel()
 
+	appID, ok := a.settingsAppID(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.FormValue("action") == "provision_webhook" {
+		if _, err := a.provisionWebhook(ctx, appID); err != nil {
+			settingsRedirect(w, r, appID, url.Values{"error": {a.T(r, err.Error())}})
+			return
+		}
+		settingsRedirect(w, r, appID, url.Values{"success": {"true"}})
+		return
+	}
+
 	// First, fetch the current settings to get all required fields
-	current, err := a.Client.FaxApplications.Get(ctx, a.FaxApplicationID)
+	current, err := a.Client.FaxApplications.Get(ctx, appID)
 	if err != nil {
-		http.Error(w, "Failed to fetch current settings: "+err.Error(), http.StatusBadGateway)
+		writeUpstreamError(w, fmt.Errorf("Failed to fetch current settings: %w", err))
 		return
 	}
 
@@ -74,6 +126,22 @@ func (a *App) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
 	params := telnyx.FaxApplicationUpdateParams{
 		ApplicationName: current.Data.ApplicationName,
 		WebhookEventURL: current.Data.WebhookEventURL,
+		Active:          telnyx.Bool(r.FormValue("active") == "on"),
+	}
+	var opts []option.RequestOption
+
+	// General settings
+	if name := strings.TrimSpace(r.FormValue("application_name")); name != "" {
+		params.ApplicationName = name
+	}
+	if anchorsite := r.FormValue("anchorsite_override"); anchorsite != "" {
+		params.AnchorsiteOverride = telnyx.AnchorsiteOverride(anchorsite)
+	}
+	// Tags are always sent so clearing the field removes them
+	if tags := splitList(r.FormValue("tags")); len(tags) > 0 {
+		params.Tags = tags
+	} else {
+		opts = append(opts, option.WithJSONSet("tags", []string{}))
 	}
 
 	// Update email recipient if provided
@@ -102,6 +170,12 @@ func (a *App) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Webhook API version is not part of the SDK params, so it is set on the request body directly
+	switch version := r.FormValue("webhook_api_version"); version {
+	case "1", "2":
+		opts = append(opts, option.WithJSONSet("webhook_api_version", version))
+	}
+
 	// Update inbound settings
 	inbound := telnyx.FaxApplicationUpdateParamsInbound{}
 	hasInboundUpdates := false
@@ -130,12 +204,82 @@ func (a *App) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
 		params.Inbound = inbound
 	}
 
+	// Update outbound settings
+	outbound := telnyx.FaxApplicationUpdateParamsOutbound{}
+	hasOutboundUpdates := false
+
+	outboundLimit := r.FormValue("outbound_channel_limit")
+	if outboundLimit != "" {
+		if limit, err := strconv.ParseInt(outboundLimit, 10, 64); err == nil {
+			outbound.ChannelLimit = telnyx.Int(limit)
+			hasOutboundUpdates = true
+		}
+	}
+
+	voiceProfile := strings.TrimSpace(r.FormValue("outbound_voice_profile_id"))
+	if voiceProfile != "" {
+		outbound.OutboundVoiceProfileID = telnyx.String(voiceProfile)
+		hasOutboundUpdates = true
+	}
+
+	if hasOutboundUpdates {
+		params.Outbound = outbound
+	}
+
 	// Update the fax application
-	_, err = a.Client.FaxApplications.Update(ctx, a.FaxApplicationID, params)
+	_, err = a.Client.FaxApplications.Update(ctx, appID, params, opts...)
 	if err != nil {
-		http.Redirect(w, r, "/settings?error="+err.Error(), http.StatusSeeOther)
+		settingsRedirect(w, r, appID, url.Values{"error": {a.T(r, err.Error())}})
 		return
 	}
 
-	http.Redirect(w, r, "/settings?success=true", http.StatusSeeOther)
+	settingsRedirect(w, r, appID, url.Values{"success": {"true"}})
+}
+
+// anchorsiteOptions lists the media anchorsites a fax application can be pinned to
+var anchorsiteOptions = []telnyx.AnchorsiteOverride{
+	telnyx.AnchorsiteOverrideLatency,
+	telnyx.AnchorsiteOverrideChicagoIl,
+	telnyx.AnchorsiteOverrideAshburnVa,
+	telnyx.AnchorsiteOverrideSanJoseCa,
+	telnyx.AnchorsiteOverrideSydneyAustralia,
+	telnyx.AnchorsiteOverrideAmsterdamNetherlands,
+	telnyx.AnchorsiteOverrideLondonUk,
+	telnyx.AnchorsiteOverrideTorontoCanada,
+	telnyx.AnchorsiteOverrideVancouverCanada,
+	telnyx.AnchorsiteOverrideFrankfurtGermany,
+}
+
+// voiceProfileOption is an outbound voice profile that can be selected for a fax application
+type voiceProfileOption struct {
+	ID   string
+	Name string
+}
+
+// outboundVoiceProfiles lists the account's outbound voice profiles, or nil if they cannot be fetched.
+// The settings form falls back to a free-text profile ID field in that case.
+func (a *App) outboundVoiceProfiles(ctx context.Context) []voiceProfileOption {
+	var profiles []voiceProfileOption
+	iter := a.Client.OutboundVoiceProfiles.ListAutoPaging(ctx, telnyx.OutboundVoiceProfileListParams{})
+	for iter.Next() {
+		p := iter.Current()
+		profiles = append(profiles, voiceProfileOption{ID: p.ID, Name: firstNonEmpty(p.Name, p.ID)})
+	}
+	if err := iter.Err(); err != nil {
+		return nil
+	}
+	return profiles
+}
+
+// extraFieldString returns a response field the SDK does not model as a plain string, or "" if absent
+func extraFieldString(fields map[string]respjson.Field, key string) string {
+	f, ok := fields[key]
+	if !ok || !f.Valid() {
+		return ""
+	}
+	var v any
+	if err := json.Unmarshal([]byte(f.Raw()), &v); err != nil || v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSettingsOnlyForConfiguredApps(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	app.FaxApps = []FaxApp{{ID: "app-1", Name: "Main"}}
+
+	rec := httptest.NewRecorder()
+	app.handleSettings(rec, httptest.NewRequest(http.MethodGet, "/settings?app=app-1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("configured application: status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	app.handleSettings(rec, httptest.NewRequest(http.MethodGet, "/settings?app=app-2", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("other application: status %d, want 404", rec.Code)
+	}
+
+	form := url.Values{"app": {"app-2"}, "action": {"provision_webhook"}}
+	req := httptest.NewRequest(http.MethodPost, "/settings", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	app.handleSettings(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("updating another application: status %d, want 404", rec.Code)
+	}
+}
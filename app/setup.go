@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A new install knows neither a Telnyx API key nor how users sign in. Rather than have the operator assemble
+// environment variables before the first start, fax-ui then leads every page to /setup, which asks for the
+// API key, the fax application and number to send from, and an admin password, and offers a test fax. What
+// it saves is used at every start where flags and environment configure neither. Until then /setup opens
+// only with the link in the startup log, so whoever reaches a new install first cannot take it over.
+
+// minSetupPassword is the shortest admin password /setup accepts
+const minSetupPassword = 10
+
+// setupKeyFile is the file next to DATABASE_PATH the setup keeps the API key in. Like TELNYX_API_KEY it
+// stays out of the database, which goes into backups and may be shared.
+const setupKeyFile = "telnyx-api-key"
+
+// setupCookieAge is how long the link from the startup log keeps /setup open in the browser that followed it
+const setupCookieAge = 24 * time.Hour
+
+// setupTestCover is the cover page of the test fax sent at the end of the setup
+const setupTestCover = "This is a test fax from fax-ui. If you can read it, faxes are sent."
+
+// setupState is the first-run setup in progress
+type setupState struct {
+	pending atomic.Bool // no API key and no sign-in are configured yet
+	token   string      // opens /setup while pending; printed in the startup log
+	keyPath string      // the setupKeyFile of this deployment
+	mu      sync.Mutex  // guards draft
+	draft   setupDraft
+}
+
+// setupDraft is what the setup steps so far have entered
+type setupDraft struct {
+	profile *Profile           // the account of the API key entered
+	apps    []connectionOption // its fax applications
+	appID   string
+	from    string
+}
+
+// step names the setup step the draft is at
+func (d setupDraft) step() string {
+	switch {
+	case d.profile == nil:
+		return "key"
+	case d.appID == "":
+		return "application"
+	}
+	return "password"
+}
+
+// setupPending reports whether fax-ui waits for the first-run setup
+func (a *App) setupPending() bool {
+	return a.setup.pending.Load()
+}
+
+// setupValues returns what the first-run setup saved, by name; nothing before it ran
+func (s *Store) setupValues(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, value FROM setup`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return values, rows.Err()
+}
+
+// saveSetup stores what the first-run setup entered, all or nothing
+func (s *Store) saveSetup(ctx context.Context, values map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	for name, value := range values {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO setup (name, value, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+			name, value, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// readSetupKey returns the API key the setup saved in path, or nothing before it ran
+func readSetupKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
+
+// writeSetupKey saves the API key in path, readable by fax-ui's user only
+func writeSetupKey(path, key string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), setupKeyFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(key + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// setupCookie names the cookie that keeps /setup open after the link from the startup log
+func (a *App) setupCookie() string {
+	return a.AuthConfig.CookieName + "_setup"
+}
+
+// setupOpened reports whether the request may use the pending setup: it comes from the browser that
+// followed the link in the startup log. Otherwise it has been answered, the link itself with a redirect
+// that takes the token out of the address bar.
+func (a *App) setupOpened(w http.ResponseWriter, r *http.Request) bool {
+	matches := func(token string) bool {
+		return a.setup.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.setup.token)) == 1
+	}
+	if token := r.URL.Query().Get("token"); token != "" && matches(token) {
+		a.writeAuthCookie(w, a.setupCookie(), token, setupCookieAge)
+		http.Redirect(w, r, "/setup", http.StatusSeeOther)
+		return false
+	}
+	if c, err := r.Cookie(a.setupCookie()); err == nil && matches(c.Value) {
+		return true
+	}
+	http.Error(w, "fax-ui is not set up yet. Open the setup link printed in its log at startup.", http.StatusForbidden)
+	return false
+}
+
+// useSetupAccount sends through the Telnyx account the setup saved, from its fax application and number,
+// where flags and environment give no API key
+func (a *App) useSetupAccount(setup map[string]string) {
+	key := setup["api_key"]
+	if key == "" {
+		return
+	}
+	client, breaker := newTelnyxClient(key, a.Resilience)
+	var provider FaxProvider = &telnyxProvider{client: client, uploadMedia: a.MediaUpload}
+	p := a.defaultProfile()
+	if c, ok := p.provider.(*cachingProvider); ok {
+		provider = newCachingProvider(provider, c.store, c.profile, c.ttl)
+	}
+
+	a.settings.mu.Lock()
+	defer a.settings.mu.Unlock()
+	a.Client, p.APIKey, p.client, p.breaker, p.provider = client, key, client, breaker, provider
+	a.FaxApplicationID = firstNonEmpty(a.FaxApplicationID, setup["fax_application_id"])
+	a.DefaultConnectionID = firstNonEmpty(a.DefaultConnectionID, setup["fax_application_id"])
+	a.DefaultFrom = firstNonEmpty(a.DefaultFrom, setup["from"])
+	p.ConnectionID, p.From = a.DefaultConnectionID, a.DefaultFrom
+	a.settings.startup.DefaultConnectionID, a.settings.startup.DefaultFrom = a.DefaultConnectionID, a.DefaultFrom
+}
+
+// useSetupPassword lets users sign in with the admin password the setup saved, where no sign-in is configured
+func (a *App) useSetupPassword(setup map[string]string) {
+	if setup["password_hash"] == "" {
+		return
+	}
+	a.AuthConfig.PasswordHash = setup["password_hash"]
+	a.AuthConfig.SessionSecret = firstNonEmpty(a.AuthConfig.SessionSecret, setup["session_secret"])
+}
+
+// handleSetup serves the first-run setup while it is pending, and its test fax to the admin it signed in
+func (a *App) handleSetup(w http.ResponseWriter, r *http.Request) {
+	if !a.setupPending() {
+		if r.URL.Query().Get("step") == "test" || r.Method == http.MethodPost {
+			a.requireAdmin(a.handleSetupTest)(w, r)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !a.setupOpened(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		a.setup.mu.Lock()
+		draft := a.setup.draft
+		a.setup.mu.Unlock()
+		q := r.URL.Query()
+		data := map[string]any{
+			"Step":          draft.step(),
+			"Apps":          draft.apps,
+			"Application":   draft.appID,
+			"MinPassword":   minSetupPassword,
+			"PublicBaseURL": a.PublicBaseURL(),
+			"Success":       q.Get("success"),
+			"Error":         q.Get("error"),
+		}
+		if draft.step() == "application" {
+			// Numbers are suggested where they can be listed; the form takes any the account owns
+			if numbers, err := draft.profile.listOwnedNumbers(r.Context(), false); err == nil {
+				data["Numbers"] = numbers
+			}
+		}
+		a.renderSetup(w, r, data)
+	case http.MethodPost:
+		a.handleUpdateSetup(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// renderSetup renders the setup page, which has no navigation while no other page opens
+func (a *App) renderSetup(w http.ResponseWriter, r *http.Request, data map[string]any) {
+	if err := a.tmpl(r).ExecuteTemplate(w, "setup.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleUpdateSetup applies a setup step: it checks the API key, the fax application and number, and
+// finally saves the setup with the admin password and signs the admin in
+func (a *App) handleUpdateSetup(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	redirect := func(status url.Values) {
+		http.Redirect(w, r, "/setup?"+status.Encode(), http.StatusSeeOther)
+	}
+	a.setup.mu.Lock()
+	defer a.setup.mu.Unlock()
+	if !a.setupPending() {
+		// Finished in another window
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	draft := &a.setup.draft
+
+	switch r.FormValue("action") {
+	case "key":
+		key := strings.TrimSpace(r.FormValue("api_key"))
+		if key == "" {
+			redirect(url.Values{"error": {a.T(r, "Enter your Telnyx API key")}})
+			return
+		}
+		client, breaker := newTelnyxClient(key, a.Resilience)
+		p := &Profile{Name: defaultProfileName, APIKey: key, client: client, breaker: breaker, pageTimeout: a.Resilience.PageTimeout}
+		apps, err := p.listConnections(r.Context(), true)
+		if err != nil {
+			redirect(url.Values{"error": {a.T(r, "Telnyx did not accept the API key: %s", err.Error())}})
+			return
+		}
+		*draft = setupDraft{profile: p, apps: apps}
+		redirect(nil)
+	case "application":
+		if draft.step() == "key" {
+			redirect(nil)
+			return
+		}
+		appID := r.FormValue("application")
+		if !slices.ContainsFunc(draft.apps, func(c connectionOption) bool { return c.ID == appID }) {
+			redirect(url.Values{"error": {a.T(r, "Choose a fax application")}})
+			return
+		}
+		from, err := normalizePhoneNumber(r.FormValue("from"), a.DefaultCountry)
+		if err != nil || from == "" {
+			redirect(url.Values{"error": {a.T(r, "Enter a valid fax number")}})
+			return
+		}
+		if err := draft.profile.validateFromNumber(r.Context(), from); err != nil {
+			redirect(url.Values{"error": {err.Error()}})
+			return
+		}
+		draft.appID, draft.from = appID, from
+		redirect(nil)
+	case "password":
+		if draft.step() != "password" {
+			redirect(nil)
+			return
+		}
+		password := r.FormValue("password")
+		if len(password) < minSetupPassword {
+			redirect(url.Values{"error": {a.T(r, "The password needs at least %d characters", minSetupPassword)}})
+			return
+		}
+		if password != r.FormValue("confirm") {
+			redirect(url.Values{"error": {a.T(r, "The passwords do not match")}})
+			return
+		}
+		a.finishSetup(w, r, password)
+	case "back":
+		if draft.appID != "" {
+			draft.appID, draft.from = "", ""
+		} else {
+			*draft = setupDraft{}
+		}
+		redirect(nil)
+	default:
+		redirect(url.Values{"error": {a.T(r, "unknown action")}})
+	}
+}
+
+// finishSetup saves the setup drafted so far with the admin password, the API key in its file and the
+// rest in the database, puts it into effect and signs the admin in, under a.setup.mu
+func (a *App) finishSetup(w http.ResponseWriter, r *http.Request, password string) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "failed to hash the password", http.StatusInternalServerError)
+		return
+	}
+	secret, err := generateSessionToken()
+	if err != nil {
+		http.Error(w, "failed to generate a session secret", http.StatusInternalServerError)
+		return
+	}
+	draft := a.setup.draft
+	setup := map[string]string{
+		"fax_application_id": draft.appID,
+		"from":               draft.from,
+		"password_hash":      string(hash),
+		"session_secret":     secret,
+	}
+	// The key first, so the saved setup is never without it
+	err = writeSetupKey(a.setup.keyPath, draft.profile.APIKey)
+	if err == nil {
+		err = a.Store.saveSetup(r.Context(), setup)
+	}
+	if err != nil {
+		http.Redirect(w, r, "/setup?"+url.Values{"error": {a.T(r, "Failed to save the setup: %s", err.Error())}}.Encode(), http.StatusSeeOther)
+		return
+	}
+	setup["api_key"] = draft.profile.APIKey
+	// While the setup was pending every other page led here, so nothing else reads what this changes
+	a.useSetupAccount(setup)
+	a.useSetupPassword(setup)
+	a.setup.draft = setupDraft{}
+	a.setup.pending.Store(false)
+	log.Printf("Setup finished: sending through fax application %s from %s", draft.appID, draft.from)
+	a.writeAudit(r.Context(), auditEntry{Actor: "password", Action: "setup.finished",
+		Detail: fmt.Sprintf("fax application %s, from %s", draft.appID, draft.from)})
+
+	a.writeAuthCookie(w, a.setupCookie(), "", -1)
+	if err := a.startSession(w, r, "password", false); err != nil {
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/setup?step=test", http.StatusSeeOther)
+}
+
+// handleSetupTest offers a test fax after the setup (GET) and sends it (POST)
+func (a *App) handleSetupTest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		a.renderSetup(w, r, map[string]any{
+			"Step":          "test",
+			"From":          a.runtime().DefaultFrom,
+			"PublicBaseURL": a.PublicBaseURL(),
+			"Success":       q.Get("success"),
+			"Error":         q.Get("error"),
+		})
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		user, _ := a.sessionUser(r)
+		profile := a.defaultProfile()
+		fax, err := a.sendDocument(r.Context(), profile, headlessSend{
+			To:        r.FormValue("to"),
+			CoverText: setupTestCover,
+			SentBy:    firstNonEmpty(user, "anonymous"),
+		})
+		if err != nil {
+			http.Redirect(w, r, "/setup?"+url.Values{"step": {"test"}, "error": {a.T(r, "The test fax was not sent: %s", err.Error())}}.Encode(), http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/fax?"+url.Values{"id": {fax.ID}, "profile": {profile.Name}}.Encode(), http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
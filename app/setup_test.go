@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newSetupApp starts an app with neither an API key nor sign-in configured, on the database at path
+func newSetupApp(t *testing.T, path string) *App {
+	t.Helper()
+	app, err := NewApp(&Config{
+		DefaultCountry: "US",
+		Language:       "en",
+		SendProfile:    defaultProfileName,
+		DatabasePath:   path,
+		PublicBaseURL:  "http://fax-ui.test",
+		Port:           "8080",
+		Ghostscript:    "gs-not-installed",
+		Tesseract:      "tesseract-not-installed",
+		NoBackground:   true,
+	})
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	t.Cleanup(func() { app.Store.Close() })
+	return app
+}
+
+func TestSetupWithoutKeyFileRunsAgain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fax-ui.db")
+	app := newSetupApp(t, path)
+	if !app.setupPending() {
+		t.Fatal("a new install does not wait for the setup")
+	}
+	err := app.Store.saveSetup(context.Background(), map[string]string{
+		"fax_application_id": "app-1", "from": "+13125550100", "password_hash": "$2a$10$hash", "session_secret": "secret"})
+	if err == nil {
+		err = writeSetupKey(app.setup.keyPath, "KEY_SETUP")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Store.Close()
+
+	app = newSetupApp(t, path)
+	if app.setupPending() || app.defaultProfile().APIKey != "KEY_SETUP" || !app.hasAuthConfigured() {
+		t.Fatalf("after the setup: pending %v, key %q", app.setupPending(), app.defaultProfile().APIKey)
+	}
+	app.Store.Close()
+
+	if err := os.Remove(app.setup.keyPath); err != nil {
+		t.Fatal(err)
+	}
+	app = newSetupApp(t, path)
+	if !app.setupPending() || app.hasAuthConfigured() {
+		t.Fatalf("without the key file: pending %v, sign-in configured %v", app.setupPending(), app.hasAuthConfigured())
+	}
+}
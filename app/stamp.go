@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// StampConfig is the transmission header and footer printed on every page of uploaded PDFs.
+// Header and Footer may use {org}, {from}, {to}, {date}, {page} and {pages}.
+type StampConfig struct {
+	Header string
+	Footer string
+	Org    string // sender organization for {org}
+}
+
+func (c StampConfig) enabled() bool {
+	return c.Header != "" || c.Footer != ""
+}
+
+// Stamps are small black Helvetica lines just inside the top and bottom edge of the page
+const (
+	stampHeaderDesc = "font:Helvetica, points:8, pos:tc, off:0 -10, scale:1 abs, rot:0, color:0 0 0"
+	stampFooterDesc = "font:Helvetica, points:8, pos:bc, off:0 10, scale:1 abs, rot:0, color:0 0 0"
+)
+
+// stampPDF prints the configured header and footer on every page of a PDF
+func stampPDF(pdf []byte, c StampConfig, from, to string, now time.Time) ([]byte, error) {
+	// pdfcpu fills in %p and %P per page, so literal percent signs are doubled
+	fill := strings.NewReplacer(
+		"%", "%%",
+		"{org}", strings.ReplaceAll(c.Org, "%", "%%"),
+		"{from}", from,
+		"{to}", to,
+		"{date}", now.Format("2006-01-02 15:04 MST"),
+		"{page}", "%p",
+		"{pages}", "%P",
+	)
+	for _, stamp := range []struct{ text, desc string }{{c.Header, stampHeaderDesc}, {c.Footer, stampFooterDesc}} {
+		if stamp.text == "" {
+			continue
+		}
+		wm, err := api.TextWatermark(fill.Replace(stamp.text), stamp.desc, true, false, types.POINTS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page stamp: %w", err)
+		}
+		var out bytes.Buffer
+		if err := api.AddWatermarks(bytes.NewReader(pdf), &out, nil, wm, nil); err != nil {
+			return nil, fmt.Errorf("failed to stamp pages: %w", err)
+		}
+		pdf = out.Bytes()
+	}
+	return pdf, nil
+}
@@ -0,0 +1,2392 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/oauth2"
+)
+
+// Store is the database holding what fax-ui knows beyond the providers' APIs: a local SQLite file, or a
+// Postgres database shared by several instances
+type Store struct {
+	db *database
+}
+
+// storeSchema creates the tables of the first migration; statements must be safe to run on a database
+// created before migrations. Later changes to the schema are new migrations.
+var storeSchema = []string{
+	`CREATE TABLE IF NOT EXISTS sent_faxes (
+		fax_id        TEXT NOT NULL,
+		profile       TEXT NOT NULL,
+		provider      TEXT NOT NULL,
+		connection_id TEXT NOT NULL DEFAULT '',
+		from_number   TEXT NOT NULL,
+		to_number     TEXT NOT NULL,
+		primary_path  TEXT NOT NULL,
+		failover      INTEGER NOT NULL DEFAULT 0,
+		failure       TEXT NOT NULL DEFAULT '',
+		created_at    TIMESTAMP NOT NULL,
+		PRIMARY KEY (profile, fax_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS faxes (
+		profile          TEXT NOT NULL,
+		fax_id           TEXT NOT NULL,
+		status           TEXT NOT NULL,
+		direction        TEXT NOT NULL,
+		from_number      TEXT NOT NULL,
+		to_number        TEXT NOT NULL,
+		preview_url      TEXT NOT NULL DEFAULT '',
+		stored_media_url TEXT NOT NULL DEFAULT '',
+		created_at       TIMESTAMP NOT NULL,
+		updated_at       TIMESTAMP NOT NULL,
+		fetched_at       TIMESTAMP NOT NULL,
+		PRIMARY KEY (profile, fax_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS drafts (
+		id            TEXT PRIMARY KEY,
+		owner         TEXT NOT NULL,
+		profile       TEXT NOT NULL,
+		to_number     TEXT NOT NULL DEFAULT '',
+		from_number   TEXT NOT NULL DEFAULT '',
+		connection_id TEXT NOT NULL DEFAULT '',
+		media_url     TEXT NOT NULL DEFAULT '',
+		cover_text    TEXT NOT NULL DEFAULT '',
+		file_name     TEXT NOT NULL DEFAULT '',
+		file_type     TEXT NOT NULL DEFAULT '',
+		file_data     BLOB,
+		created_at    TIMESTAMP NOT NULL,
+		updated_at    TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS tags (
+		name       TEXT PRIMARY KEY COLLATE NOCASE,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS fax_tags (
+		profile TEXT NOT NULL,
+		fax_id  TEXT NOT NULL,
+		tag     TEXT NOT NULL COLLATE NOCASE,
+		PRIMARY KEY (profile, fax_id, tag)
+	)`,
+	`CREATE TABLE IF NOT EXISTS inbox_triage (
+		profile    TEXT NOT NULL,
+		fax_id     TEXT NOT NULL,
+		state      TEXT NOT NULL DEFAULT 'new',
+		assignee   TEXT NOT NULL DEFAULT '',
+		updated_by TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (profile, fax_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS fax_comments (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		profile    TEXT NOT NULL,
+		fax_id     TEXT NOT NULL,
+		author     TEXT NOT NULL,
+		body       TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS fax_comments_fax ON fax_comments (profile, fax_id)`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id      INTEGER PRIMARY KEY AUTOINCREMENT,
+		at      TIMESTAMP NOT NULL,
+		actor   TEXT NOT NULL,
+		action  TEXT NOT NULL,
+		profile TEXT NOT NULL DEFAULT '',
+		fax_id  TEXT NOT NULL DEFAULT '',
+		detail  TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS legal_holds (
+		profile   TEXT NOT NULL,
+		fax_id    TEXT NOT NULL,
+		reason    TEXT NOT NULL DEFAULT '',
+		placed_by TEXT NOT NULL DEFAULT '',
+		placed_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (profile, fax_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS fax_costs (
+		profile    TEXT NOT NULL,
+		fax_id     TEXT NOT NULL,
+		direction  TEXT NOT NULL DEFAULT '',
+		cost       REAL NOT NULL,
+		currency   TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (profile, fax_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS received_media (
+		profile     TEXT NOT NULL,
+		fax_id      TEXT NOT NULL,
+		media_file  TEXT NOT NULL, -- empty once retention deleted the document
+		archived_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (profile, fax_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS push_subscriptions (
+		endpoint        TEXT PRIMARY KEY,
+		"user"          TEXT NOT NULL,
+		p256dh          TEXT NOT NULL,
+		auth            TEXT NOT NULL,
+		notify_sent     INTEGER NOT NULL DEFAULT 1,
+		notify_received INTEGER NOT NULL DEFAULT 1,
+		created_at      TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_preferences (
+		"user"   TEXT PRIMARY KEY,
+		language TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS secrets (
+		name  TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS saved_views (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		owner      TEXT NOT NULL,
+		page       TEXT NOT NULL, -- "faxes" or "inbox"
+		name       TEXT NOT NULL,
+		query      TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		UNIQUE (owner, page, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS sessions (
+		hash       TEXT PRIMARY KEY, -- SHA-256 of the cookie's token
+		"user"     TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		last_seen  TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS remembered_logins (
+		hash       TEXT PRIMARY KEY, -- SHA-256 of the remember-me cookie's token
+		"user"     TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		last_seen  TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key        TEXT PRIMARY KEY,
+		profile    TEXT NOT NULL DEFAULT '',
+		fax_id     TEXT NOT NULL DEFAULT '', -- empty while the send is in progress
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS fhir_exports (
+		profile     TEXT NOT NULL,
+		fax_id      TEXT NOT NULL,
+		resource    TEXT NOT NULL, -- location of the DocumentReference, e.g. DocumentReference/123
+		exported_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (profile, fax_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS received_deliveries (
+		profile      TEXT NOT NULL,
+		fax_id       TEXT NOT NULL,
+		path         TEXT NOT NULL, -- where the document was written
+		delivered_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (profile, fax_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS intake_jobs (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		pipeline     TEXT NOT NULL,
+		profile      TEXT NOT NULL,
+		fax_id       TEXT NOT NULL,
+		from_number  TEXT NOT NULL,
+		to_number    TEXT NOT NULL,
+		media_url    TEXT NOT NULL DEFAULT '',
+		received_at  TIMESTAMP NOT NULL,
+		status       TEXT NOT NULL, -- pending, delivered or dead
+		attempts     INTEGER NOT NULL DEFAULT 0,
+		last_error   TEXT NOT NULL DEFAULT '',
+		next_attempt TIMESTAMP NOT NULL,
+		updated_at   TIMESTAMP NOT NULL,
+		UNIQUE (pipeline, profile, fax_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS cloud_accounts (
+		provider     TEXT PRIMARY KEY, -- google or onedrive
+		account      TEXT NOT NULL,    -- email address of the account
+		token        TEXT NOT NULL,    -- OAuth token as JSON
+		connected_by TEXT NOT NULL,
+		connected_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS cloud_uploads (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		profile      TEXT NOT NULL,
+		fax_id       TEXT NOT NULL,
+		direction    TEXT NOT NULL,
+		media_url    TEXT NOT NULL DEFAULT '', -- where the provider keeps a received document
+		status       TEXT NOT NULL, -- pending, uploaded or failed
+		attempts     INTEGER NOT NULL DEFAULT 0,
+		last_error   TEXT NOT NULL DEFAULT '',
+		next_attempt TIMESTAMP NOT NULL,
+		updated_at   TIMESTAMP NOT NULL,
+		UNIQUE (profile, fax_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_tokens (
+		hash       TEXT PRIMARY KEY, -- SHA-256 of the token
+		"user"     TEXT NOT NULL,
+		name       TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		last_used  TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS rest_hooks (
+		id         TEXT PRIMARY KEY,
+		"user"     TEXT NOT NULL,
+		event      TEXT NOT NULL,
+		target_url TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`,
+}
+
+// storeColumns are columns added to tables after they were first released and before migrations, as
+// {table, column, definition}
+var storeColumns = [][3]string{
+	{"sent_faxes", "media_file", "TEXT NOT NULL DEFAULT ''"},
+	{"tags", "hold", "INTEGER NOT NULL DEFAULT 0"},
+	{"sent_faxes", "sent_by", "TEXT NOT NULL DEFAULT ''"},
+	{"faxes", "pages", "INTEGER NOT NULL DEFAULT 0"},
+	{"faxes", "duration_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"faxes", "cost", "REAL NOT NULL DEFAULT 0"},
+	{"faxes", "currency", "TEXT NOT NULL DEFAULT ''"},
+	{"faxes", "failure_reason", "TEXT NOT NULL DEFAULT ''"},
+	{"faxes", "remote_id", "TEXT NOT NULL DEFAULT ''"},
+	{"user_preferences", "timezone", "TEXT NOT NULL DEFAULT ''"},
+	{"sessions", "refresh", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// openStore opens the database at a postgres:// URL or a SQLite path. Its schema is brought up to date
+// by migrate.
+func openStore(dsn string) (*Store, error) {
+	db, err := openDatabase(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// sentFax records how a fax sent from the UI was delivered to a provider
+type sentFax struct {
+	FaxID        string
+	Profile      string // profile that accepted the fax
+	Provider     string
+	ConnectionID string
+	From         string
+	To           string
+	PrimaryPath  string // the path tried first, e.g. "default (telnyx)"
+	Failover     bool   // accepted by the failover path after the primary failed
+	Failure      string // primary path error that triggered failover
+	MediaFile    string // document kept in the upload directory, if any
+	SentBy       string // user identity that sent the fax, empty without login
+	CreatedAt    time.Time
+}
+
+// DeliveryPath describes the profile, provider and connection that accepted the fax
+func (s *sentFax) DeliveryPath() string {
+	return deliveryPath(s.Profile, s.Provider, s.ConnectionID)
+}
+
+// recordSentFax stores how a fax was sent
+func (s *Store) recordSentFax(ctx context.Context, f *sentFax) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sent_faxes
+		(fax_id, profile, provider, connection_id, from_number, to_number, primary_path, failover, failure, media_file, sent_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (profile, fax_id) DO UPDATE SET provider = excluded.provider, connection_id = excluded.connection_id,
+			from_number = excluded.from_number, to_number = excluded.to_number, primary_path = excluded.primary_path,
+			failover = excluded.failover, failure = excluded.failure, media_file = excluded.media_file, sent_by = excluded.sent_by,
+			created_at = excluded.created_at`,
+		f.FaxID, f.Profile, f.Provider, f.ConnectionID, f.From, f.To, f.PrimaryPath, f.Failover, f.Failure, f.MediaFile, f.SentBy, f.CreatedAt)
+	return err
+}
+
+// sentFax returns the send record for a fax, or nil if it was not sent from this UI
+func (s *Store) sentFax(ctx context.Context, profile, faxID string) (*sentFax, error) {
+	f := &sentFax{}
+	err := s.db.QueryRowContext(ctx, `SELECT fax_id, profile, provider, connection_id, from_number, to_number,
+		primary_path, failover, failure, media_file, sent_by, created_at FROM sent_faxes WHERE profile = ? AND fax_id = ?`, profile, faxID).
+		Scan(&f.FaxID, &f.Profile, &f.Provider, &f.ConnectionID, &f.From, &f.To, &f.PrimaryPath, &f.Failover, &f.Failure, &f.MediaFile,
+			&f.SentBy, &f.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// senders returns who sent each of the given faxes from this UI, keyed by fax ID
+func (s *Store) senders(ctx context.Context, profile string, faxIDs []string) (map[string]string, error) {
+	senders := make(map[string]string)
+	if len(faxIDs) == 0 {
+		return senders, nil
+	}
+	args := []any{profile}
+	for _, id := range faxIDs {
+		args = append(args, id)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT fax_id, sent_by FROM sent_faxes WHERE profile = ? AND sent_by != '' AND fax_id IN (?`+
+		strings.Repeat(", ?", len(faxIDs)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, user string
+		if err := rows.Scan(&id, &user); err != nil {
+			return nil, err
+		}
+		senders[id] = user
+	}
+	return senders, rows.Err()
+}
+
+// saveFaxes stores the latest known state of faxes fetched from a profile's provider
+func (s *Store) saveFaxes(ctx context.Context, profile string, faxes []Fax) error {
+	if len(faxes) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	for _, f := range faxes {
+		_, err := tx.ExecContext(ctx, `INSERT INTO faxes
+			(profile, fax_id, status, direction, from_number, to_number, preview_url, stored_media_url, created_at, updated_at, fetched_at,
+			pages, duration_ms, cost, currency, failure_reason, remote_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (profile, fax_id) DO UPDATE SET status = excluded.status, direction = excluded.direction,
+				from_number = excluded.from_number, to_number = excluded.to_number, preview_url = excluded.preview_url,
+				stored_media_url = excluded.stored_media_url, created_at = excluded.created_at, updated_at = excluded.updated_at,
+				fetched_at = excluded.fetched_at, pages = excluded.pages, duration_ms = excluded.duration_ms, cost = excluded.cost,
+				currency = excluded.currency, failure_reason = excluded.failure_reason, remote_id = excluded.remote_id`,
+			profile, f.ID, f.Status, f.Direction, f.From, f.To, f.PreviewURL, f.StoredMediaURL, f.CreatedAt.UTC(), f.UpdatedAt.UTC(), now,
+			f.Pages, f.Duration.Milliseconds(), f.Cost, f.Currency, f.FailureReason, f.RemoteID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// updateFaxStatus applies a status callback to every stored copy of a fax, returning how many were updated.
+// Delivery details are kept when the callback leaves them out.
+func (s *Store) updateFaxStatus(ctx context.Context, f Fax) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE faxes SET status = COALESCE(NULLIF(?, ''), status), updated_at = ?,
+		pages = CASE WHEN ? > 0 THEN ? ELSE pages END, duration_ms = CASE WHEN ? > 0 THEN ? ELSE duration_ms END,
+		failure_reason = COALESCE(NULLIF(?, ''), failure_reason) WHERE fax_id = ?`,
+		f.Status, f.UpdatedAt.UTC(), f.Pages, f.Pages, f.Duration.Milliseconds(), f.Duration.Milliseconds(), f.FailureReason, f.ID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// faxProfiles returns the profiles a fax is stored under
+func (s *Store) faxProfiles(ctx context.Context, faxID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT profile FROM faxes WHERE fax_id = ?`, faxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var profiles []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// cachedFax returns the stored state of a fax and when it was fetched, or nil if it is not stored
+func (s *Store) cachedFax(ctx context.Context, profile, faxID string) (*Fax, time.Time, error) {
+	f := &Fax{}
+	var fetchedAt time.Time
+	var durationMS int64
+	err := s.db.QueryRowContext(ctx, `SELECT fax_id, status, direction, from_number, to_number, preview_url,
+		stored_media_url, created_at, updated_at, fetched_at, pages, duration_ms, cost, currency, failure_reason, remote_id
+		FROM faxes WHERE profile = ? AND fax_id = ?`, profile, faxID).
+		Scan(&f.ID, &f.Status, &f.Direction, &f.From, &f.To, &f.PreviewURL, &f.StoredMediaURL, &f.CreatedAt, &f.UpdatedAt, &fetchedAt,
+			&f.Pages, &durationMS, &f.Cost, &f.Currency, &f.FailureReason, &f.RemoteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	f.Duration = time.Duration(durationMS) * time.Millisecond
+	return f, fetchedAt, nil
+}
+
+// idempotencyKeyTTL is how long a send form's key keeps deduplicating submissions
+const idempotencyKeyTTL = 24 * time.Hour
+
+// claimIdempotencyKey reserves a user's key for a send. It returns false if the user already used the key.
+func (s *Store) claimIdempotencyKey(ctx context.Context, user, key string) (bool, error) {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE created_at < ?`, time.Now().UTC().Add(-idempotencyKeyTTL)); err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, `INSERT INTO idempotency_keys ("user", key, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+		user, key, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// idempotentSend is what became of the send that claimed an idempotency key
+type idempotentSend struct {
+	Profile string
+	FaxID   string // empty while the send is in progress, or if its outcome is unknown
+	Unknown bool   // the send failed in a way that may still have created a fax
+}
+
+// idempotentFax returns what the send that claimed a user's key produced, or nil once a send that
+// certainly failed released the key
+func (s *Store) idempotentFax(ctx context.Context, user, key string) (*idempotentSend, error) {
+	var send idempotentSend
+	var outcome string
+	err := s.db.QueryRowContext(ctx, `SELECT profile, fax_id, outcome FROM idempotency_keys WHERE "user" = ? AND key = ?`,
+		user, key).Scan(&send.Profile, &send.FaxID, &outcome)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	send.Unknown = outcome == "unknown"
+	return &send, nil
+}
+
+// completeIdempotencyKey records the fax a claimed key produced
+func (s *Store) completeIdempotencyKey(ctx context.Context, user, key, profile, faxID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE idempotency_keys SET profile = ?, fax_id = ?, outcome = '' WHERE "user" = ? AND key = ?`,
+		profile, faxID, user, key)
+	return err
+}
+
+// releaseIdempotencyKey frees a claimed key after a send that certainly failed, so the form can be resubmitted
+func (s *Store) releaseIdempotencyKey(ctx context.Context, user, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE "user" = ? AND key = ? AND fax_id = ''`, user, key)
+	return err
+}
+
+// keepIdempotencyKeyUnknown keeps a claimed key after a send that may have created a fax despite failing,
+// so a resubmission is refused instead of sending the fax twice
+func (s *Store) keepIdempotencyKeyUnknown(ctx context.Context, user, key string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE idempotency_keys SET outcome = 'unknown' WHERE "user" = ? AND key = ? AND fax_id = ''`,
+		user, key)
+	return err
+}
+
+// draft is a send form saved to finish later
+type draft struct {
+	ID           string
+	Owner        string // user identity that saved the draft
+	Profile      string
+	To           string
+	From         string
+	ConnectionID string
+	MediaURL     string
+	CoverText    string
+	FileName     string
+	File         *document // attached file; only loaded by Store.draft
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// saveDraft creates or updates a draft owned by d.Owner. A draft without a File keeps its previous attachment.
+func (s *Store) saveDraft(ctx context.Context, d *draft) error {
+	now := time.Now().UTC()
+	var fileName, fileType string
+	var fileData []byte
+	if d.File != nil {
+		fileName, fileType, fileData = d.File.Name, d.File.Type, d.File.Data
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO drafts
+		(id, owner, profile, to_number, from_number, connection_id, media_url, cover_text, file_name, file_type, file_data, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			profile = excluded.profile, to_number = excluded.to_number, from_number = excluded.from_number,
+			connection_id = excluded.connection_id, media_url = excluded.media_url, cover_text = excluded.cover_text,
+			file_name = CASE WHEN excluded.file_name != '' THEN excluded.file_name ELSE drafts.file_name END,
+			file_type = CASE WHEN excluded.file_name != '' THEN excluded.file_type ELSE drafts.file_type END,
+			file_data = CASE WHEN excluded.file_name != '' THEN excluded.file_data ELSE drafts.file_data END,
+			updated_at = excluded.updated_at
+		WHERE drafts.owner = excluded.owner`,
+		d.ID, d.Owner, d.Profile, d.To, d.From, d.ConnectionID, d.MediaURL, d.CoverText, fileName, fileType, fileData, now, now)
+	return err
+}
+
+// draft returns a draft with its attachment, or nil if the owner has no draft with that ID
+func (s *Store) draft(ctx context.Context, owner, id string) (*draft, error) {
+	d := &draft{}
+	var fileType string
+	var fileData []byte
+	err := s.db.QueryRowContext(ctx, `SELECT id, owner, profile, to_number, from_number, connection_id, media_url, cover_text,
+		file_name, file_type, file_data, created_at, updated_at FROM drafts WHERE owner = ? AND id = ?`, owner, id).
+		Scan(&d.ID, &d.Owner, &d.Profile, &d.To, &d.From, &d.ConnectionID, &d.MediaURL, &d.CoverText,
+			&d.FileName, &fileType, &fileData, &d.CreatedAt, &d.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.FileName != "" {
+		d.File = &document{Name: d.FileName, Type: fileType, Data: fileData}
+	}
+	return d, nil
+}
+
+// drafts lists the owner's drafts, most recently updated first, without attachments
+func (s *Store) drafts(ctx context.Context, owner string) ([]draft, error) {
+	return s.queryDrafts(ctx, `WHERE owner = ? ORDER BY updated_at DESC`, owner)
+}
+
+func (s *Store) queryDrafts(ctx context.Context, where string, args ...any) ([]draft, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, owner, profile, to_number, from_number, connection_id, media_url, cover_text,
+		file_name, created_at, updated_at FROM drafts `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var drafts []draft
+	for rows.Next() {
+		var d draft
+		if err := rows.Scan(&d.ID, &d.Owner, &d.Profile, &d.To, &d.From, &d.ConnectionID, &d.MediaURL, &d.CoverText,
+			&d.FileName, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts, rows.Err()
+}
+
+// deleteDraft removes one of the owner's drafts
+func (s *Store) deleteDraft(ctx context.Context, owner, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM drafts WHERE owner = ? AND id = ?`, owner, id)
+	return err
+}
+
+// tag is a label admins define for filing faxes
+type tag struct {
+	Name  string
+	Faxes int  // number of faxes carrying the tag
+	Hold  bool // faxes carrying the tag are on legal hold
+}
+
+// tags lists the defined tags by name with their usage counts
+func (s *Store) tags(ctx context.Context) ([]tag, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT t.name, COUNT(ft.fax_id), t.hold FROM tags t
+		LEFT JOIN fax_tags ft ON ft.tag = t.name GROUP BY t.name ORDER BY t.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tags []tag
+	for rows.Next() {
+		var t tag
+		if err := rows.Scan(&t.Name, &t.Faxes, &t.Hold); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// createTag defines a tag; names are case-insensitive and creating an existing tag does nothing
+func (s *Store) createTag(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO tags (name, created_at) VALUES (?, ?) ON CONFLICT DO NOTHING`, name, time.Now().UTC())
+	return err
+}
+
+// setTagHold places or lifts a legal hold on every fax carrying a tag
+func (s *Store) setTagHold(ctx context.Context, name string, hold bool) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE tags SET hold = ? WHERE name = ?`, hold, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("unknown tag %q", name)
+	}
+	return nil
+}
+
+// deleteTag removes a tag and takes it off every fax
+func (s *Store) deleteTag(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM fax_tags WHERE tag = ?`, name); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE name = ?`, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// faxTags returns the tags of the given faxes by fax ID
+func (s *Store) faxTags(ctx context.Context, profile string, faxIDs []string) (map[string][]string, error) {
+	tags := make(map[string][]string)
+	if len(faxIDs) == 0 {
+		return tags, nil
+	}
+	args := []any{profile}
+	for _, id := range faxIDs {
+		args = append(args, id)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT fax_id, tag FROM fax_tags WHERE profile = ? AND fax_id IN (?`+
+		strings.Repeat(", ?", len(faxIDs)-1)+`) ORDER BY tag`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	return tags, rows.Err()
+}
+
+// setFaxTags replaces a fax's tags; names that are not defined tags are ignored
+func (s *Store) setFaxTags(ctx context.Context, profile, faxID string, tags []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM fax_tags WHERE profile = ? AND fax_id = ?`, profile, faxID); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO fax_tags (profile, fax_id, tag)
+			SELECT ?, ?, name FROM tags WHERE name = ? ON CONFLICT DO NOTHING`, profile, faxID, t); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// faxQuery filters the faxes stored for a profile; empty fields match everything
+type faxQuery struct {
+	Direction  string // "inbound" or "outbound"
+	Tag        string
+	State      string // triage state
+	Assignee   string
+	Unassigned bool      // only open faxes nobody is assigned to
+	Scope      *faxScope // only the faxes a user may see; nil for everything
+	Status     string
+	Since      time.Time // only faxes created at or after
+	Sort       string    // column of faxSorts to sort by; empty for newest first
+	Ascending  bool
+	PageNumber int64
+	PageSize   int64
+}
+
+// local reports whether q filters on something only the local database knows about
+func (q faxQuery) local() bool {
+	return q.Tag != "" || q.State != "" || q.Assignee != "" || q.Unassigned || q.Scope != nil || q.Status != "" ||
+		!q.Since.IsZero() || q.Sort != ""
+}
+
+// orderBy returns the SQL ordering of q's faxes; ties go newest first
+func (q faxQuery) orderBy() string {
+	column, ok := faxSorts[q.Sort]
+	if !ok {
+		return "f.created_at DESC"
+	}
+	direction := "DESC"
+	if q.Ascending {
+		direction = "ASC"
+	}
+	if q.Sort == "created_at" {
+		return column + " " + direction
+	}
+	return column + " " + direction + ", f.created_at DESC"
+}
+
+// where returns the SQL condition selecting q's faxes of profile from faxes f joined with inbox_triage t
+func (q faxQuery) where(profile string) (string, []any) {
+	where := []string{"f.profile = ?"}
+	args := []any{profile}
+	if q.Direction != "" {
+		where = append(where, "f.direction = ?")
+		args = append(args, q.Direction)
+	}
+	if q.Tag != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM fax_tags ft WHERE ft.profile = f.profile AND ft.fax_id = f.fax_id AND ft.tag = ?)")
+		args = append(args, q.Tag)
+	}
+	if q.State != "" {
+		where = append(where, "COALESCE(t.state, ?) = ?")
+		args = append(args, triageNew, q.State)
+	}
+	if q.Assignee != "" {
+		where = append(where, "t.assignee = ?")
+		args = append(args, q.Assignee)
+	}
+	if q.Unassigned {
+		where = append(where, "COALESCE(t.assignee, '') = ''", "COALESCE(t.state, ?) IN (?, ?)")
+		args = append(args, triageNew, triageNew, triageInReview)
+	}
+	if q.Status != "" {
+		where = append(where, "f.status = ?")
+		args = append(args, q.Status)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "f.created_at >= ?")
+		args = append(args, q.Since.UTC())
+	}
+	if q.Scope != nil {
+		cond, scopeArgs := q.Scope.where()
+		where = append(where, cond)
+		args = append(args, scopeArgs...)
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// queryFaxes returns a page of the stored faxes matching q, in q's order
+func (s *Store) queryFaxes(ctx context.Context, profile string, q faxQuery) ([]Fax, error) {
+	where, args := q.where(profile)
+	args = append(args, q.PageSize, (q.PageNumber-1)*q.PageSize)
+	rows, err := s.db.QueryContext(ctx, `SELECT f.fax_id, f.status, f.direction, f.from_number, f.to_number, f.preview_url,
+		f.stored_media_url, f.created_at, f.updated_at, f.pages, f.duration_ms, f.cost, f.currency, f.failure_reason, f.remote_id FROM faxes f
+		LEFT JOIN inbox_triage t ON t.profile = f.profile AND t.fax_id = f.fax_id
+		WHERE `+where+` ORDER BY `+q.orderBy()+` LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var faxes []Fax
+	for rows.Next() {
+		var f Fax
+		var durationMS int64
+		if err := rows.Scan(&f.ID, &f.Status, &f.Direction, &f.From, &f.To, &f.PreviewURL, &f.StoredMediaURL,
+			&f.CreatedAt, &f.UpdatedAt, &f.Pages, &durationMS, &f.Cost, &f.Currency, &f.FailureReason, &f.RemoteID); err != nil {
+			return nil, err
+		}
+		f.Duration = time.Duration(durationMS) * time.Millisecond
+		faxes = append(faxes, f)
+	}
+	return faxes, rows.Err()
+}
+
+// countFaxes returns how many stored faxes match q
+func (s *Store) countFaxes(ctx context.Context, profile string, q faxQuery) (int64, error) {
+	where, args := q.where(profile)
+	var n int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM faxes f
+		LEFT JOIN inbox_triage t ON t.profile = f.profile AND t.fax_id = f.fax_id WHERE `+where, args...).Scan(&n)
+	return n, err
+}
+
+// Triage states of received faxes; faxes without a triage record are new
+const (
+	triageNew       = "new"
+	triageInReview  = "in_review"
+	triageProcessed = "processed"
+	triageArchived  = "archived"
+)
+
+// triageStates lists the triage states in workflow order
+var triageStates = []string{triageNew, triageInReview, triageProcessed, triageArchived}
+
+// triage is where a received fax stands in the office's inbox workflow
+type triage struct {
+	State     string
+	Assignee  string // user identity working the fax
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
+// faxTriage returns the triage records of the given faxes by fax ID; faxes without one are omitted
+func (s *Store) faxTriage(ctx context.Context, profile string, faxIDs []string) (map[string]triage, error) {
+	triages := make(map[string]triage)
+	if len(faxIDs) == 0 {
+		return triages, nil
+	}
+	args := []any{profile}
+	for _, id := range faxIDs {
+		args = append(args, id)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT fax_id, state, assignee, updated_by, updated_at FROM inbox_triage
+		WHERE profile = ? AND fax_id IN (?`+strings.Repeat(", ?", len(faxIDs)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var t triage
+		if err := rows.Scan(&id, &t.State, &t.Assignee, &t.UpdatedBy, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		triages[id] = t
+	}
+	return triages, rows.Err()
+}
+
+// setTriageState moves faxes to a triage state
+func (s *Store) setTriageState(ctx context.Context, profile string, faxIDs []string, state, user string) error {
+	return s.updateTriage(ctx, profile, faxIDs, user, "state", state)
+}
+
+// setTriageAssignee assigns faxes to a user, or unassigns them when assignee is empty
+func (s *Store) setTriageAssignee(ctx context.Context, profile string, faxIDs []string, assignee, user string) error {
+	return s.updateTriage(ctx, profile, faxIDs, user, "assignee", assignee)
+}
+
+// updateTriage sets one triage column on each fax, creating records as needed
+func (s *Store) updateTriage(ctx context.Context, profile string, faxIDs []string, user, column, value string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	for _, id := range faxIDs {
+		_, err := tx.ExecContext(ctx, `INSERT INTO inbox_triage (profile, fax_id, `+column+`, updated_by, updated_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(profile, fax_id) DO UPDATE SET `+column+` = excluded.`+column+`,
+				updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+			profile, id, value, user, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// assignees lists the users faxes are currently assigned to
+func (s *Store) assignees(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT assignee FROM inbox_triage WHERE assignee != '' ORDER BY assignee`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// comment is a staff note on a fax
+type comment struct {
+	Author    string
+	Body      string
+	CreatedAt time.Time
+}
+
+// addComment adds a note to a fax's comment thread
+func (s *Store) addComment(ctx context.Context, profile, faxID, author, body string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO fax_comments (profile, fax_id, author, body, created_at) VALUES (?, ?, ?, ?, ?)`,
+		profile, faxID, author, body, time.Now().UTC())
+	return err
+}
+
+// comments returns a fax's comment thread, oldest first
+func (s *Store) comments(ctx context.Context, profile, faxID string) ([]comment, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT author, body, created_at FROM fax_comments
+		WHERE profile = ? AND fax_id = ? ORDER BY id`, profile, faxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var comments []comment
+	for rows.Next() {
+		var c comment
+		if err := rows.Scan(&c.Author, &c.Body, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// auditEntry records a sensitive action such as deleting fax data
+type auditEntry struct {
+	At      time.Time
+	Actor   string // user identity, or "retention" for the background job
+	Action  string // e.g. "retention.media_deleted"
+	Profile string
+	FaxID   string
+	Detail  string
+}
+
+// audit appends an entry to the audit log
+func (s *Store) audit(ctx context.Context, e auditEntry) error {
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO audit_log (at, actor, action, profile, fax_id, detail) VALUES (?, ?, ?, ?, ?, ?)`,
+		e.At, e.Actor, e.Action, e.Profile, e.FaxID, e.Detail)
+	return err
+}
+
+// auditLog returns the most recent audit entries, newest first
+func (s *Store) auditLog(ctx context.Context, limit int) ([]auditEntry, error) {
+	return s.queryAudit(ctx, `ORDER BY id DESC LIMIT ?`, limit)
+}
+
+func (s *Store) queryAudit(ctx context.Context, where string, args ...any) ([]auditEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT at, actor, action, profile, fax_id, detail FROM audit_log `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []auditEntry
+	for rows.Next() {
+		var e auditEntry
+		if err := rows.Scan(&e.At, &e.Actor, &e.Action, &e.Profile, &e.FaxID, &e.Detail); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// retainedFax is a fax the local database holds data about, as seen by the retention job
+type retainedFax struct {
+	Profile   string
+	FaxID     string
+	CreatedAt time.Time
+	MediaFile string // document in the upload directory, if any
+	Tags      []string
+	Local     bool // has data beyond the lookup cache: a send record, tags, comments or triage
+	Held      bool // on legal hold, directly or through a tag
+}
+
+// retainedFaxes lists every fax with data in the local database
+func (s *Store) retainedFaxes(ctx context.Context) ([]*retainedFax, error) {
+	faxes := make(map[[2]string]*retainedFax)
+	get := func(profile, faxID string, createdAt time.Time) *retainedFax {
+		key := [2]string{profile, faxID}
+		f := faxes[key]
+		if f == nil {
+			f = &retainedFax{Profile: profile, FaxID: faxID, CreatedAt: createdAt}
+			faxes[key] = f
+		}
+		if f.CreatedAt.IsZero() || (!createdAt.IsZero() && createdAt.Before(f.CreatedAt)) {
+			f.CreatedAt = createdAt
+		}
+		return f
+	}
+	scan := func(query string, each func(rows *sql.Rows) error) error {
+		rows, err := s.db.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err := each(rows); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+
+	err := scan(`SELECT profile, fax_id, created_at FROM faxes`, func(rows *sql.Rows) error {
+		var profile, id string
+		var createdAt time.Time
+		if err := rows.Scan(&profile, &id, &createdAt); err != nil {
+			return err
+		}
+		get(profile, id, createdAt)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = scan(`SELECT profile, fax_id, created_at, media_file FROM sent_faxes`, func(rows *sql.Rows) error {
+		var profile, id, media string
+		var createdAt time.Time
+		if err := rows.Scan(&profile, &id, &createdAt, &media); err != nil {
+			return err
+		}
+		f := get(profile, id, createdAt)
+		f.MediaFile = media
+		f.Local = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = scan(`SELECT profile, fax_id, archived_at, media_file FROM received_media`, func(rows *sql.Rows) error {
+		var profile, id, media string
+		var archivedAt time.Time
+		if err := rows.Scan(&profile, &id, &archivedAt, &media); err != nil {
+			return err
+		}
+		f := get(profile, id, archivedAt)
+		f.MediaFile = media
+		f.Local = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Comments and triage are dated by when they were written, not by the fax, so they only mark it as local
+	err = scan(`SELECT profile, fax_id, tag FROM fax_tags
+		UNION ALL SELECT profile, fax_id, NULL FROM fax_comments UNION ALL SELECT profile, fax_id, NULL FROM inbox_triage`,
+		func(rows *sql.Rows) error {
+			var profile, id string
+			var tag sql.NullString
+			if err := rows.Scan(&profile, &id, &tag); err != nil {
+				return err
+			}
+			f := faxes[[2]string{profile, id}]
+			if f == nil {
+				return nil
+			}
+			f.Local = true
+			if tag.Valid {
+				f.Tags = append(f.Tags, tag.String)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scan(`SELECT profile, fax_id FROM legal_holds UNION
+		SELECT ft.profile, ft.fax_id FROM fax_tags ft JOIN tags t ON t.name = ft.tag WHERE t.hold != 0`, func(rows *sql.Rows) error {
+		var profile, id string
+		if err := rows.Scan(&profile, &id); err != nil {
+			return err
+		}
+		if f := faxes[[2]string{profile, id}]; f != nil {
+			f.Held = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*retainedFax, 0, len(faxes))
+	for _, f := range faxes {
+		list = append(list, f)
+	}
+	return list, nil
+}
+
+// clearMediaFile forgets a fax's sent or archived document after it was deleted from the media store
+func (s *Store) clearMediaFile(ctx context.Context, profile, faxID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE sent_faxes SET media_file = '' WHERE profile = ? AND fax_id = ?`, profile, faxID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE received_media SET media_file = '' WHERE profile = ? AND fax_id = ?`, profile, faxID)
+	return err
+}
+
+// saveReceivedMedia records the media store name a received fax's document was archived under
+func (s *Store) saveReceivedMedia(ctx context.Context, profile, faxID, mediaFile string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO received_media (profile, fax_id, media_file, archived_at)
+		VALUES (?, ?, ?, ?) ON CONFLICT (profile, fax_id) DO UPDATE SET media_file = excluded.media_file, archived_at = excluded.archived_at`, profile, faxID, mediaFile, time.Now().UTC())
+	return err
+}
+
+// receivedMedia returns the archived document of a received fax. archived is true once the fax has been
+// archived, even when retention has since deleted the document and name is empty.
+func (s *Store) receivedMedia(ctx context.Context, profile, faxID string) (name string, archived bool, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT media_file FROM received_media WHERE profile = ? AND fax_id = ?`, profile, faxID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return name, err == nil, err
+}
+
+// archiveAttempts returns how often archiving a received fax failed, and when it may be tried again
+func (s *Store) archiveAttempts(ctx context.Context, profile, faxID string) (attempts int, next time.Time, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT attempts, next_attempt FROM archive_attempts WHERE profile = ? AND fax_id = ?`,
+		profile, faxID).Scan(&attempts, &next)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, nil
+	}
+	return attempts, next, err
+}
+
+// recordArchiveFailure records a failed attempt at archiving a received fax
+func (s *Store) recordArchiveFailure(ctx context.Context, profile, faxID string, attempts int, lastError string, next time.Time) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO archive_attempts (profile, fax_id, attempts, last_error, next_attempt)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT (profile, fax_id) DO UPDATE SET attempts = excluded.attempts,
+		last_error = excluded.last_error, next_attempt = excluded.next_attempt`, profile, faxID, attempts, lastError, next.UTC())
+	return err
+}
+
+// clearArchiveFailures forgets the failed attempts at archiving a fax once it is archived
+func (s *Store) clearArchiveFailures(ctx context.Context, profile, faxID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM archive_attempts WHERE profile = ? AND fax_id = ?`, profile, faxID)
+	return err
+}
+
+// deleteFaxRecords removes everything the local database holds about a fax
+func (s *Store) deleteFaxRecords(ctx context.Context, profile, faxID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"faxes", "sent_faxes", "received_media", "fax_tags", "fax_comments", "inbox_triage", "fhir_exports", "intake_jobs", "received_deliveries", "cloud_uploads", "fax_webhooks", "archive_attempts"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE profile = ? AND fax_id = ?`, profile, faxID); err != nil {
+			return err
+		}
+	}
+	// Events are not kept per profile; they go with the fax's last copy
+	if _, err := tx.ExecContext(ctx, `DELETE FROM fax_events WHERE fax_id = ? AND fax_id NOT IN (SELECT fax_id FROM faxes)`, faxID); err != nil {
+		return err
+	}
+	if err := deleteOrphanJobErrors(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// legalHold keeps a fax from being deleted, by the retention job or by hand
+type legalHold struct {
+	Tag      string // set when the hold comes from a tag rather than the fax itself
+	Reason   string
+	PlacedBy string
+	PlacedAt time.Time
+}
+
+// faxHold returns the hold on a fax, placed directly or through one of its tags, or nil if it is not held
+func (s *Store) faxHold(ctx context.Context, profile, faxID string) (*legalHold, error) {
+	h := &legalHold{}
+	err := s.db.QueryRowContext(ctx, `SELECT reason, placed_by, placed_at FROM legal_holds WHERE profile = ? AND fax_id = ?`,
+		profile, faxID).Scan(&h.Reason, &h.PlacedBy, &h.PlacedAt)
+	if err == nil {
+		return h, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	err = s.db.QueryRowContext(ctx, `SELECT t.name FROM fax_tags ft JOIN tags t ON t.name = ft.tag
+		WHERE ft.profile = ? AND ft.fax_id = ? AND t.hold != 0 ORDER BY t.name LIMIT 1`, profile, faxID).Scan(&h.Tag)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// placeHold puts a fax on legal hold
+func (s *Store) placeHold(ctx context.Context, profile, faxID, reason, user string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO legal_holds (profile, fax_id, reason, placed_by, placed_at)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT (profile, fax_id) DO UPDATE SET reason = excluded.reason, placed_by = excluded.placed_by,
+			placed_at = excluded.placed_at`, profile, faxID, reason, user, time.Now().UTC())
+	return err
+}
+
+// liftHold removes a fax's own legal hold; holds through tags stay
+func (s *Store) liftHold(ctx context.Context, profile, faxID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM legal_holds WHERE profile = ? AND fax_id = ?`, profile, faxID)
+	return err
+}
+
+// faxCost is what the carrier charged for a fax, from its billing records
+type faxCost struct {
+	FaxID     string
+	Direction string
+	Cost      float64
+	Currency  string
+	CreatedAt time.Time
+}
+
+// saveCosts stores the billed cost of faxes, replacing earlier values
+func (s *Store) saveCosts(ctx context.Context, profile string, costs []faxCost) error {
+	if len(costs) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, c := range costs {
+		_, err := tx.ExecContext(ctx, `INSERT INTO fax_costs (profile, fax_id, direction, cost, currency, created_at)
+			VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (profile, fax_id) DO UPDATE SET direction = excluded.direction, cost = excluded.cost,
+				currency = excluded.currency, created_at = excluded.created_at`, profile, c.FaxID, c.Direction, c.Cost, c.Currency, c.CreatedAt.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// hasCosts reports whether any costs are stored for a profile
+func (s *Store) hasCosts(ctx context.Context, profile string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM (SELECT 1 FROM fax_costs WHERE profile = ? LIMIT 1) c`, profile).Scan(&n)
+	return n > 0, err
+}
+
+// faxCosts returns the stored costs of the given faxes, keyed by fax ID
+func (s *Store) faxCosts(ctx context.Context, profile string, faxIDs []string) (map[string]faxCost, error) {
+	costs := make(map[string]faxCost)
+	if len(faxIDs) == 0 {
+		return costs, nil
+	}
+	args := []any{profile}
+	for _, id := range faxIDs {
+		args = append(args, id)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT fax_id, direction, cost, currency, created_at FROM fax_costs
+		WHERE profile = ? AND fax_id IN (?`+strings.Repeat(", ?", len(faxIDs)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c faxCost
+		if err := rows.Scan(&c.FaxID, &c.Direction, &c.Cost, &c.Currency, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		costs[c.FaxID] = c
+	}
+	return costs, rows.Err()
+}
+
+// costGroup is the total cost of a group of faxes in one currency
+type costGroup struct {
+	Key      string // month (YYYY-MM), profile, user or tag; empty for faxes without one
+	Faxes    int
+	Cost     float64
+	Currency string
+}
+
+// costGroupColumns are the expressions costsBy groups on
+var costGroupColumns = map[string]string{
+	"month":   "", // the database's month of c.created_at
+	"profile": "c.profile",
+	"user":    "COALESCE(s.sent_by, '')",
+	"tag":     "COALESCE(ft.tag, '')",
+}
+
+// costsBy totals the costs of faxes created in the months from through to (YYYY-MM, UTC) by "month",
+// "profile", "user" or "tag".
+// Faxes with several tags count toward each of them.
+func (s *Store) costsBy(ctx context.Context, group, from, to string) ([]costGroup, error) {
+	column, ok := costGroupColumns[group]
+	if !ok {
+		return nil, fmt.Errorf("unknown cost grouping %q", group)
+	}
+	month := s.db.month("c.created_at")
+	if group == "month" {
+		column = month
+	}
+	joins := `LEFT JOIN sent_faxes s ON s.profile = c.profile AND s.fax_id = c.fax_id`
+	if group == "tag" {
+		joins += ` LEFT JOIN fax_tags ft ON ft.profile = c.profile AND ft.fax_id = c.fax_id`
+	}
+	// Months read newest first, everything else most expensive first
+	order := "SUM(c.cost) DESC, k"
+	if group == "month" {
+		order = "k DESC"
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+column+` AS k, COUNT(*), SUM(c.cost), c.currency FROM fax_costs c `+joins+`
+		WHERE `+month+` BETWEEN ? AND ?
+		GROUP BY k, c.currency ORDER BY `+order+`, c.currency`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var groups []costGroup
+	for rows.Next() {
+		var g costGroup
+		if err := rows.Scan(&g.Key, &g.Faxes, &g.Cost, &g.Currency); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// costItem is a billed fax with what it is charged back to
+type costItem struct {
+	faxCost
+	Profile string
+	SentBy  string
+	Tags    string // comma separated
+}
+
+// costItems returns the billed faxes created in a month (YYYY-MM, UTC), oldest first
+func (s *Store) costItems(ctx context.Context, month string) ([]costItem, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT c.profile, c.fax_id, c.direction, c.cost, c.currency, c.created_at,
+		COALESCE(s.sent_by, ''), COALESCE((SELECT `+s.db.joined("tag")+` FROM fax_tags ft
+			WHERE ft.profile = c.profile AND ft.fax_id = c.fax_id), '')
+		FROM fax_costs c LEFT JOIN sent_faxes s ON s.profile = c.profile AND s.fax_id = c.fax_id
+		WHERE `+s.db.month("c.created_at")+` = ? ORDER BY c.created_at`, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []costItem
+	for rows.Next() {
+		var i costItem
+		if err := rows.Scan(&i.Profile, &i.FaxID, &i.Direction, &i.Cost, &i.Currency, &i.CreatedAt, &i.SentBy, &i.Tags); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+// snapshot writes a consistent copy of the database to path, which must not exist yet
+func (s *Store) snapshot(ctx context.Context, path string) error {
+	_, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, path)
+	return err
+}
+
+// mediaFiles returns the names of the stored documents the database refers to: those of sent and
+// received faxes, and those attached to drafts
+func (s *Store) mediaFiles(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT media_file FROM sent_faxes WHERE media_file != ''
+		UNION SELECT media_file FROM received_media WHERE media_file != ''
+		UNION SELECT media_url FROM drafts WHERE media_url LIKE '%/media/%'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		// Drafts keep the document's URL
+		if _, after, ok := strings.Cut(name, "/media/"); ok {
+			name = after
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// postgres reports whether the database is Postgres rather than SQLite
+func (s *Store) postgres() bool {
+	return s.db.postgres
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// secret returns the named server secret, storing the result of generate the first time it is asked for
+func (s *Store) secret(ctx context.Context, name string, generate func() (string, error)) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM secrets WHERE name = ?`, name).Scan(&value)
+	if err == nil {
+		return value, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	if value, err = generate(); err != nil {
+		return "", err
+	}
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO secrets (name, value) VALUES (?, ?) ON CONFLICT DO NOTHING`, name, value); err != nil {
+		return "", err
+	}
+	// Another instance sharing the database may have stored one first
+	err = s.db.QueryRowContext(ctx, `SELECT value FROM secrets WHERE name = ?`, name).Scan(&value)
+	return value, err
+}
+
+// setSecret replaces the named server secret
+func (s *Store) setSecret(ctx context.Context, name, value string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO secrets (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = excluded.value`, name, value)
+	return err
+}
+
+// savePushSubscription stores a browser's push subscription, or updates its owner and choices
+func (s *Store) savePushSubscription(ctx context.Context, sub pushSubscription) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO push_subscriptions (endpoint, "user", p256dh, auth, notify_sent, notify_received, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (endpoint) DO UPDATE SET "user" = excluded."user", p256dh = excluded.p256dh,
+		auth = excluded.auth, notify_sent = excluded.notify_sent, notify_received = excluded.notify_received`,
+		sub.Endpoint, sub.User, sub.P256dh, sub.Auth, sub.Sent, sub.Received, time.Now().UTC())
+	return err
+}
+
+// pushSubscription returns the stored subscription for a push endpoint, or nil
+func (s *Store) pushSubscription(ctx context.Context, endpoint string) (*pushSubscription, error) {
+	subs, err := s.queryPushSubscriptions(ctx, `WHERE endpoint = ?`, endpoint)
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+	return &subs[0], nil
+}
+
+// deletePushSubscription forgets a browser's push subscription
+func (s *Store) deletePushSubscription(ctx context.Context, endpoint string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM push_subscriptions WHERE endpoint = ?`, endpoint)
+	return err
+}
+
+// receivedPushSubscriptions returns the subscriptions that asked to hear about received faxes
+func (s *Store) receivedPushSubscriptions(ctx context.Context) ([]pushSubscription, error) {
+	return s.queryPushSubscriptions(ctx, `WHERE notify_received = 1`)
+}
+
+// sentPushSubscriptions returns the user's subscriptions that asked to hear how their faxes went
+func (s *Store) sentPushSubscriptions(ctx context.Context, user string) ([]pushSubscription, error) {
+	return s.queryPushSubscriptions(ctx, `WHERE notify_sent = 1 AND "user" = ?`, user)
+}
+
+func (s *Store) queryPushSubscriptions(ctx context.Context, where string, args ...any) ([]pushSubscription, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT endpoint, "user", p256dh, auth, notify_sent, notify_received FROM push_subscriptions `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []pushSubscription
+	for rows.Next() {
+		var sub pushSubscription
+		if err := rows.Scan(&sub.Endpoint, &sub.User, &sub.P256dh, &sub.Auth, &sub.Sent, &sub.Received); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// userLanguage returns the language the user picked, or "" if they have not
+func (s *Store) userLanguage(ctx context.Context, user string) (string, error) {
+	var tag string
+	err := s.db.QueryRowContext(ctx, `SELECT language FROM user_preferences WHERE "user" = ?`, user).Scan(&tag)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return tag, err
+}
+
+// setUserLanguage saves the language the user picked
+func (s *Store) setUserLanguage(ctx context.Context, user, tag string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO user_preferences ("user", language) VALUES (?, ?)
+		ON CONFLICT ("user") DO UPDATE SET language = excluded.language`, user, tag)
+	return err
+}
+
+// userTimezone returns the time zone the user picked, or "" for the default
+func (s *Store) userTimezone(ctx context.Context, user string) (string, error) {
+	var name string
+	err := s.db.QueryRowContext(ctx, `SELECT timezone FROM user_preferences WHERE "user" = ?`, user).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return name, err
+}
+
+// setUserTimezone saves the time zone the user picked
+func (s *Store) setUserTimezone(ctx context.Context, user, name string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO user_preferences ("user", timezone) VALUES (?, ?)
+		ON CONFLICT ("user") DO UPDATE SET timezone = excluded.timezone`, user, name)
+	return err
+}
+
+// visibleFaxes returns the given faxes that scope lets a user see, in the order given
+func (s *Store) visibleFaxes(ctx context.Context, profile string, faxIDs []string, scope *faxScope) ([]string, error) {
+	if len(faxIDs) == 0 {
+		return nil, nil
+	}
+	args := []any{profile}
+	for _, id := range faxIDs {
+		args = append(args, id)
+	}
+	cond, scopeArgs := scope.where()
+	args = append(args, scopeArgs...)
+	// The IDs are matched on their own rather than through the faxes table, which may not have them yet
+	rows, err := s.db.QueryContext(ctx, `SELECT f.fax_id FROM (SELECT ? AS profile, column1 AS fax_id FROM (VALUES (?)`+
+		strings.Repeat(", (?)", len(faxIDs)-1)+`) v) f WHERE `+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		seen[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var visible []string
+	for _, id := range faxIDs {
+		if seen[id] {
+			visible = append(visible, id)
+		}
+	}
+	return visible, nil
+}
+
+// users returns the identities that have sent faxes from fax-ui or saved preferences
+func (s *Store) users(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT sent_by FROM sent_faxes WHERE sent_by NOT IN ('', 'scanner')
+		UNION SELECT "user" FROM user_preferences ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var user string
+		if err := rows.Scan(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// subjectFax is a fax involving a data subject, with what the local database holds about it
+type subjectFax struct {
+	Profile       string
+	FaxID         string
+	Direction     string
+	Status        string
+	From          string
+	To            string
+	CreatedAt     time.Time
+	Pages         int
+	Cost          float64
+	Currency      string
+	FailureReason string
+	RemoteID      string
+	SentBy        string
+	SentMedia     string // media store name of the sent document, if kept
+	ReceivedMedia string // media store name of the archived received document, if kept
+}
+
+// subjectFaxes returns the faxes sent to or from the subject's number, or sent by the subject user, oldest first
+func (s *Store) subjectFaxes(ctx context.Context, d dataSubject) ([]subjectFax, error) {
+	faxCond, sentCond := "from_number = ? OR to_number = ?", "from_number = ? OR to_number = ?"
+	args := []any{d.Number, d.Number, d.Number, d.Number}
+	if d.User != "" {
+		faxCond, sentCond = "1 = 0", "sent_by = ? COLLATE NOCASE"
+		args = []any{d.User}
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT k.profile, k.fax_id, f.direction, f.status, COALESCE(f.from_number, s.from_number),
+		COALESCE(f.to_number, s.to_number), f.created_at, s.created_at, COALESCE(f.pages, 0), COALESCE(f.cost, 0),
+		COALESCE(f.currency, ''), COALESCE(NULLIF(f.failure_reason, ''), s.failure, ''), COALESCE(f.remote_id, ''),
+		COALESCE(s.sent_by, ''), COALESCE(s.media_file, ''), COALESCE(m.media_file, '')
+		FROM (SELECT profile, fax_id FROM faxes WHERE `+faxCond+` UNION SELECT profile, fax_id FROM sent_faxes WHERE `+sentCond+`) k
+		LEFT JOIN faxes f ON f.profile = k.profile AND f.fax_id = k.fax_id
+		LEFT JOIN sent_faxes s ON s.profile = k.profile AND s.fax_id = k.fax_id
+		LEFT JOIN received_media m ON m.profile = k.profile AND m.fax_id = k.fax_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var faxes []subjectFax
+	for rows.Next() {
+		var f subjectFax
+		var direction, status sql.NullString
+		var created, sent sql.NullTime
+		if err := rows.Scan(&f.Profile, &f.FaxID, &direction, &status, &f.From, &f.To, &created, &sent, &f.Pages, &f.Cost,
+			&f.Currency, &f.FailureReason, &f.RemoteID, &f.SentBy, &f.SentMedia, &f.ReceivedMedia); err != nil {
+			return nil, err
+		}
+		// Faxes sent from fax-ui may not have been looked up since
+		f.Direction, f.Status = firstNonEmpty(direction.String, "outbound"), status.String
+		f.CreatedAt = created.Time
+		if !created.Valid {
+			f.CreatedAt = sent.Time
+		}
+		faxes = append(faxes, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(faxes, func(x, y subjectFax) int { return x.CreatedAt.Compare(y.CreatedAt) })
+	return faxes, nil
+}
+
+// subjectDrafts returns the drafts addressed to or from the subject's number, or saved by the subject user
+func (s *Store) subjectDrafts(ctx context.Context, d dataSubject) ([]draft, error) {
+	if d.User != "" {
+		return s.queryDrafts(ctx, `WHERE owner = ? COLLATE NOCASE ORDER BY created_at`, d.User)
+	}
+	return s.queryDrafts(ctx, `WHERE to_number = ? OR from_number = ? ORDER BY created_at`, d.Number, d.Number)
+}
+
+// subjectAudit returns the audit entries about the given faxes or mentioning the subject, oldest first
+func (s *Store) subjectAudit(ctx context.Context, d dataSubject, faxIDs []string) ([]auditEntry, error) {
+	like := s.db.like()
+	where := `WHERE detail ` + like + ` '%' || ? || '%' OR actor ` + like + ` '%' || ? || '%'`
+	args := []any{d.String(), d.String()}
+	if len(faxIDs) > 0 {
+		where += ` OR fax_id IN (?` + strings.Repeat(", ?", len(faxIDs)-1) + `)`
+		for _, id := range faxIDs {
+			args = append(args, id)
+		}
+	}
+	return s.queryAudit(ctx, where+` ORDER BY id`, args...)
+}
+
+// pseudonymizeFax replaces the subject's number in a fax's local records with a pseudonym and forgets its
+// documents, which the caller has deleted from the media store. Costs and page counts are kept for billing.
+func (s *Store) pseudonymizeFax(ctx context.Context, profile, faxID, number, pseudonym string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`UPDATE faxes SET from_number = CASE WHEN from_number = ?1 THEN ?2 ELSE from_number END,
+			to_number = CASE WHEN to_number = ?1 THEN ?2 ELSE to_number END, preview_url = '', stored_media_url = '', remote_id = ''
+			WHERE profile = ?3 AND fax_id = ?4`,
+		`UPDATE sent_faxes SET from_number = CASE WHEN from_number = ?1 THEN ?2 ELSE from_number END,
+			to_number = CASE WHEN to_number = ?1 THEN ?2 ELSE to_number END, media_file = ''
+			WHERE profile = ?3 AND fax_id = ?4`,
+		`UPDATE fax_comments SET body = REPLACE(body, ?1, ?2) WHERE profile = ?3 AND fax_id = ?4`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, number, pseudonym, profile, faxID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE received_media SET media_file = '' WHERE profile = ? AND fax_id = ?`, profile, faxID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// eraseNumber deletes the drafts and intake jobs addressed to or from a number and its contact, and replaces it
+// with a pseudonym in the audit log. A blocked number stays on the blocklist, so faxes to it stay refused.
+func (s *Store) eraseNumber(ctx context.Context, number, pseudonym string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"drafts", "intake_jobs"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE to_number = ? OR from_number = ?`, number, number); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM contacts WHERE number = ?`, number); err != nil {
+		return err
+	}
+	if err := deleteOrphanJobErrors(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE audit_log SET detail = REPLACE(detail, ?, ?)`, number, pseudonym); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// eraseUser replaces a user identity with a pseudonym wherever it is recorded, and deletes the user's
+// drafts, preferences, push subscriptions, API tokens and hooks. Faxes the user sent keep counting toward cost reports.
+func (s *Store) eraseUser(ctx context.Context, user, pseudonym string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`UPDATE sent_faxes SET sent_by = ?2 WHERE sent_by = ?1 COLLATE NOCASE`,
+		`UPDATE fax_comments SET author = ?2 WHERE author = ?1 COLLATE NOCASE`,
+		`UPDATE inbox_triage SET assignee = CASE WHEN assignee = ?1 COLLATE NOCASE THEN ?2 ELSE assignee END,
+			updated_by = CASE WHEN updated_by = ?1 COLLATE NOCASE THEN ?2 ELSE updated_by END`,
+		`UPDATE legal_holds SET placed_by = ?2 WHERE placed_by = ?1 COLLATE NOCASE`,
+		`UPDATE audit_log SET actor = ?2 WHERE actor = ?1 COLLATE NOCASE`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, user, pseudonym); err != nil {
+			return err
+		}
+	}
+	if err := eraseAuditMentions(ctx, tx, user, pseudonym); err != nil {
+		return err
+	}
+	for _, table := range []string{"drafts WHERE owner", `push_subscriptions WHERE "user"`, `user_preferences WHERE "user"`, "saved_views WHERE owner", `sessions WHERE "user"`, `remembered_logins WHERE "user"`, `api_tokens WHERE "user"`, `rest_hooks WHERE "user"`} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` = ? COLLATE NOCASE`, user); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// eraseAuditMentions replaces a user identity where audit entries mention it: as the user an admin
+// viewed as, "admin (as user)", and in the detail. Only the whole identity is replaced, in any case, so
+// erasing bob@example.com leaves jimbob@example.com alone.
+func eraseAuditMentions(ctx context.Context, tx *dbTx, user, pseudonym string) error {
+	// LIKE narrows the entries down; any wildcards in the identity only let more of them through
+	rows, err := tx.QueryContext(ctx, `SELECT id, actor, detail FROM audit_log WHERE actor `+tx.db.like()+` ?1 OR detail `+tx.db.like()+` ?1`,
+		"%"+user+"%")
+	if err != nil {
+		return err
+	}
+	type mention struct {
+		id            int64
+		actor, detail string
+	}
+	var changed []mention
+	for rows.Next() {
+		var m mention
+		if err := rows.Scan(&m.id, &m.actor, &m.detail); err != nil {
+			rows.Close()
+			return err
+		}
+		actor, detail := replaceIdentity(m.actor, user, pseudonym), replaceIdentity(m.detail, user, pseudonym)
+		if actor != m.actor || detail != m.detail {
+			changed = append(changed, mention{m.id, actor, detail})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range changed {
+		if _, err := tx.ExecContext(ctx, `UPDATE audit_log SET actor = ?, detail = ? WHERE id = ?`, m.actor, m.detail, m.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceIdentity replaces the occurrences of identity in s, in any case, that are not part of a longer
+// identity or address
+func replaceIdentity(s, identity, replacement string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range regexp.MustCompile(`(?i)`+regexp.QuoteMeta(identity)).FindAllStringIndex(s, -1) {
+		start, end := m[0], m[1]
+		// A dot ending a sentence does not continue the identity
+		continues := end < len(s) && identityByte(s[end]) && !(s[end] == '.' && (end+1 == len(s) || !identityByte(s[end+1])))
+		if start == end || (start > 0 && identityByte(s[start-1])) || continues {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(replacement)
+		last = end
+	}
+	if last == 0 {
+		return s
+	}
+	return b.String() + s[last:]
+}
+
+// identityByte reports whether c may be part of a user identity, such as an email address
+func identityByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("._%+-@", c) >= 0
+}
+
+// accessLog returns the access entries an access report covers, oldest first
+func (s *Store) accessLog(ctx context.Context, q accessQuery, limit int) ([]auditEntry, error) {
+	where := `WHERE action IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(accessActions)), ", ") + `) AND at >= ? AND at < ?`
+	var args []any
+	for _, action := range accessActions {
+		args = append(args, action)
+	}
+	args = append(args, q.From.UTC(), q.To.UTC())
+	if len(q.FaxIDs) > 0 {
+		where += ` AND fax_id IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(q.FaxIDs)), ", ") + `)`
+		for _, id := range q.FaxIDs {
+			args = append(args, id)
+		}
+	}
+	if q.User != "" {
+		// Accesses by an admin viewing as another user are recorded as "admin (as user)", and match either
+		prefix, suffix := q.User+" (as ", " (as "+q.User+")"
+		where += ` AND (actor = ? COLLATE NOCASE OR lower(substr(actor, 1, ?)) = lower(?) OR lower(substr(actor, length(actor) - ? + 1)) = lower(?))`
+		args = append(args, q.User, utf8.RuneCountInString(prefix), prefix, utf8.RuneCountInString(suffix), suffix)
+	}
+	return s.queryAudit(ctx, where+` ORDER BY id LIMIT ?`, append(args, limit)...)
+}
+
+// savedView is a named filter and sort order of a fax list, saved by a user
+type savedView struct {
+	ID    int64
+	Page  string // "faxes" or "inbox"
+	Name  string
+	Query string // the list's query string
+}
+
+// savedViews returns the views the user saved for page, by name
+func (s *Store) savedViews(ctx context.Context, owner, page string) ([]savedView, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, page, name, query FROM saved_views WHERE owner = ? AND page = ?
+		ORDER BY name COLLATE NOCASE`, owner, page)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var views []savedView
+	for rows.Next() {
+		var v savedView
+		if err := rows.Scan(&v.ID, &v.Page, &v.Name, &v.Query); err != nil {
+			return nil, err
+		}
+		views = append(views, v)
+	}
+	return views, rows.Err()
+}
+
+// saveView saves a view for the user, replacing their view of the same name
+func (s *Store) saveView(ctx context.Context, owner string, v savedView) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO saved_views (owner, page, name, query, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (owner, page, name) DO UPDATE SET query = excluded.query`, owner, v.Page, v.Name, v.Query, time.Now().UTC())
+	return err
+}
+
+// deleteSavedView deletes one of the user's views
+func (s *Store) deleteSavedView(ctx context.Context, owner string, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM saved_views WHERE id = ? AND owner = ?`, id, owner)
+	return err
+}
+
+// createSession stores a new session, dropping expired ones
+func (s *Store) createSession(ctx context.Context, sess session) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < ?`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sessions (hash, "user", created_at, last_seen, expires_at, refresh) VALUES (?, ?, ?, ?, ?, ?)`,
+		sess.Hash, sess.User, sess.CreatedAt, sess.LastSeen, sess.ExpiresAt, sess.Refresh)
+	return err
+}
+
+// session returns the session stored under hash, expired or not
+func (s *Store) session(ctx context.Context, hash string) (session, bool, error) {
+	sess := session{Hash: hash}
+	err := s.db.QueryRowContext(ctx, `SELECT "user", created_at, last_seen, expires_at, refresh FROM sessions WHERE hash = ?`, hash).
+		Scan(&sess.User, &sess.CreatedAt, &sess.LastSeen, &sess.ExpiresAt, &sess.Refresh)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session{}, false, nil
+	}
+	return sess, err == nil, err
+}
+
+// touchSession records a session's last request and expiry, and the last request of its remember-me credential
+func (s *Store) touchSession(ctx context.Context, sess session) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE sessions SET last_seen = ?, expires_at = ? WHERE hash = ?`, sess.LastSeen, sess.ExpiresAt, sess.Hash); err != nil {
+		return err
+	}
+	if sess.Refresh == "" {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE remembered_logins SET last_seen = ? WHERE hash = ?`, sess.LastSeen, sess.Refresh)
+	return err
+}
+
+// deleteSession ends a session
+func (s *Store) deleteSession(ctx context.Context, hash string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE hash = ?`, hash)
+	return err
+}
+
+// createRememberedLogin stores a new remember-me credential, dropping expired ones
+func (s *Store) createRememberedLogin(ctx context.Context, c session) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM remembered_logins WHERE expires_at < ?`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO remembered_logins (hash, "user", created_at, last_seen, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		c.Hash, c.User, c.CreatedAt, c.LastSeen, c.ExpiresAt)
+	return err
+}
+
+// rememberedLogin returns the remember-me credential stored under hash, expired or not
+func (s *Store) rememberedLogin(ctx context.Context, hash string) (session, bool, error) {
+	c := session{Hash: hash}
+	err := s.db.QueryRowContext(ctx, `SELECT "user", created_at, last_seen, expires_at FROM remembered_logins WHERE hash = ?`, hash).
+		Scan(&c.User, &c.CreatedAt, &c.LastSeen, &c.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session{}, false, nil
+	}
+	return c, err == nil, err
+}
+
+// renewRememberedLogin moves the credential stored under old to c's hash and records its use. It returns
+// false if the credential was renewed or deleted meanwhile.
+func (s *Store) renewRememberedLogin(ctx context.Context, old string, c session) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE remembered_logins SET hash = ?, last_seen = ? WHERE hash = ?`, c.Hash, c.LastSeen, old)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// deleteRememberedLogin forgets a remember-me credential
+func (s *Store) deleteRememberedLogin(ctx context.Context, hash string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM remembered_logins WHERE hash = ?`, hash)
+	return err
+}
+
+// saveSAMLLogin keeps a sign-in sent to the IdP under its relay state, dropping expired ones
+func (s *Store) saveSAMLLogin(ctx context.Context, state string, l samlLogin) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM saml_logins WHERE expires_at < ?`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO saml_logins (state, request_id, redirect, remember, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		state, l.RequestID, l.Redirect, l.Remember, l.Expires.UTC())
+	return err
+}
+
+// takeSAMLLogin returns and deletes the sign-in kept under a relay state, expired or not
+func (s *Store) takeSAMLLogin(ctx context.Context, state string) (samlLogin, bool, error) {
+	var l samlLogin
+	err := s.db.QueryRowContext(ctx, `DELETE FROM saml_logins WHERE state = ? RETURNING request_id, redirect, remember, expires_at`, state).
+		Scan(&l.RequestID, &l.Redirect, &l.Remember, &l.Expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return samlLogin{}, false, nil
+	}
+	return l, err == nil, err
+}
+
+// createAPIToken stores a new API token
+func (s *Store) createAPIToken(ctx context.Context, t apiToken) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO api_tokens (hash, "user", name, created_at) VALUES (?, ?, ?, ?)`,
+		t.Hash, t.User, t.Name, t.CreatedAt)
+	return err
+}
+
+// useAPIToken returns the API token stored under hash and records its use
+func (s *Store) useAPIToken(ctx context.Context, hash string) (apiToken, bool, error) {
+	t := apiToken{Hash: hash}
+	err := s.db.QueryRowContext(ctx, `SELECT "user", name, created_at FROM api_tokens WHERE hash = ?`, hash).
+		Scan(&t.User, &t.Name, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apiToken{}, false, nil
+	}
+	if err != nil {
+		return apiToken{}, false, err
+	}
+	t.LastUsed = time.Now().UTC()
+	_, err = s.db.ExecContext(ctx, `UPDATE api_tokens SET last_used = ? WHERE hash = ?`, t.LastUsed, hash)
+	return t, err == nil, err
+}
+
+// apiTokens returns a user's API tokens, newest first
+func (s *Store) apiTokens(ctx context.Context, user string) ([]apiToken, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT hash, "user", name, created_at, last_used FROM api_tokens WHERE "user" = ? COLLATE NOCASE ORDER BY created_at DESC`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []apiToken
+	for rows.Next() {
+		var t apiToken
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&t.Hash, &t.User, &t.Name, &t.CreatedAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		t.LastUsed = lastUsed.Time
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// deleteAPIToken revokes one of a user's API tokens
+func (s *Store) deleteAPIToken(ctx context.Context, user, hash string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE hash = ? AND "user" = ? COLLATE NOCASE`, hash, user)
+	return err
+}
+
+// createRestHook subscribes a callback URL to an event
+func (s *Store) createRestHook(ctx context.Context, h restHook) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO rest_hooks (id, "user", event, target_url, created_at) VALUES (?, ?, ?, ?, ?)`,
+		h.ID, h.User, h.Event, h.TargetURL, h.CreatedAt)
+	return err
+}
+
+// restHooks returns the hooks of a user, or subscribed to an event; empty arguments match every hook
+func (s *Store) restHooks(ctx context.Context, user, event string) ([]restHook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, "user", event, target_url, created_at FROM rest_hooks
+		WHERE (?1 = '' OR "user" = ?1 COLLATE NOCASE) AND (?2 = '' OR event = ?2) ORDER BY created_at`, user, event)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hooks []restHook
+	for rows.Next() {
+		var h restHook
+		if err := rows.Scan(&h.ID, &h.User, &h.Event, &h.TargetURL, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// deleteRestHook unsubscribes one of a user's hooks, reporting whether it existed
+func (s *Store) deleteRestHook(ctx context.Context, user, id string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM rest_hooks WHERE id = ? AND "user" = ? COLLATE NOCASE`, id, user)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// fhirExported returns the FHIR resource a received fax was exported as, or "" if it was not
+func (s *Store) fhirExported(ctx context.Context, profile, faxID string) (string, error) {
+	var resource string
+	err := s.db.QueryRowContext(ctx, `SELECT resource FROM fhir_exports WHERE profile = ? AND fax_id = ?`, profile, faxID).Scan(&resource)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return resource, err
+}
+
+// saveFHIRExport records the FHIR resource a received fax was exported as
+func (s *Store) saveFHIRExport(ctx context.Context, profile, faxID, resource string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO fhir_exports (profile, fax_id, resource, exported_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (profile, fax_id) DO UPDATE SET resource = excluded.resource, exported_at = excluded.exported_at`,
+		profile, faxID, resource, time.Now().UTC())
+	return err
+}
+
+// queueIntakeJob queues a received fax for delivery to a pipeline, unless it was queued before
+func (s *Store) queueIntakeJob(ctx context.Context, j intakeJob) error {
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO intake_jobs
+		(pipeline, profile, fax_id, from_number, to_number, media_url, received_at, status, next_attempt, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+		j.Pipeline, j.Profile, j.FaxID, j.From, j.To, j.MediaURL, j.ReceivedAt.UTC(), intakePending, now, now)
+	return err
+}
+
+// dueIntakeJobs returns up to limit pending intake jobs whose next attempt is due, oldest first
+func (s *Store) dueIntakeJobs(ctx context.Context, now time.Time, limit int) ([]intakeJob, error) {
+	return s.queryIntakeJobs(ctx, `WHERE status = ? AND next_attempt <= ? ORDER BY next_attempt, id LIMIT ?`, intakePending, now, limit)
+}
+
+// claimIntakeJob takes a due intake job for an attempt, pushing its next attempt back until the attempt
+// times out, and reports whether it got it: instances sharing the database each see the job as due
+func (s *Store) claimIntakeJob(ctx context.Context, id int64, now time.Time, timeout time.Duration) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE intake_jobs SET next_attempt = ? WHERE id = ? AND status = ? AND next_attempt <= ?`,
+		now.Add(timeout), id, intakePending, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// intakeJobs returns up to limit intake jobs in the states, most recently updated first
+func (s *Store) intakeJobs(ctx context.Context, states []string, limit int) ([]intakeJob, error) {
+	where := `WHERE status IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(states)), ", ") + `) ORDER BY updated_at DESC, id DESC LIMIT ?`
+	var args []any
+	for _, state := range states {
+		args = append(args, state)
+	}
+	return s.queryIntakeJobs(ctx, where, append(args, limit)...)
+}
+
+func (s *Store) queryIntakeJobs(ctx context.Context, where string, args ...any) ([]intakeJob, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, pipeline, profile, fax_id, from_number, to_number, media_url, received_at,
+		status, attempts, last_error, next_attempt, updated_at FROM intake_jobs `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []intakeJob
+	for rows.Next() {
+		var j intakeJob
+		if err := rows.Scan(&j.ID, &j.Pipeline, &j.Profile, &j.FaxID, &j.From, &j.To, &j.MediaURL, &j.ReceivedAt,
+			&j.Status, &j.Attempts, &j.LastError, &j.NextAttempt, &j.UpdatedAt); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// updateIntakeJob records the outcome of a delivery attempt
+func (s *Store) updateIntakeJob(ctx context.Context, j intakeJob) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE intake_jobs SET status = ?, attempts = ?, last_error = ?, next_attempt = ?, updated_at = ? WHERE id = ?`,
+		j.Status, j.Attempts, j.LastError, j.NextAttempt.UTC(), time.Now().UTC(), j.ID)
+	return err
+}
+
+// retryIntakeJob queues a dead intake job again with fresh attempts
+func (s *Store) retryIntakeJob(ctx context.Context, id int64) error {
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, `UPDATE intake_jobs SET status = ?, attempts = 0, next_attempt = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		intakePending, now, now, id, intakeDead)
+	return err
+}
+
+// deleteIntakeJob discards a dead intake job
+func (s *Store) deleteIntakeJob(ctx context.Context, id int64) error {
+	_, err := s.discardJob(ctx, jobQueueIntake, id, false)
+	return err
+}
+
+// receivedDelivery returns the path a received fax was delivered to, or "" if it was not
+func (s *Store) receivedDelivery(ctx context.Context, profile, faxID string) (string, error) {
+	var path string
+	err := s.db.QueryRowContext(ctx, `SELECT path FROM received_deliveries WHERE profile = ? AND fax_id = ?`, profile, faxID).Scan(&path)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return path, err
+}
+
+// saveReceivedDelivery records the path a received fax was delivered to
+func (s *Store) saveReceivedDelivery(ctx context.Context, profile, faxID, path string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO received_deliveries (profile, fax_id, path, delivered_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (profile, fax_id) DO UPDATE SET path = excluded.path, delivered_at = excluded.delivered_at`,
+		profile, faxID, path, time.Now().UTC())
+	return err
+}
+
+// cloudAccount returns the connected account of a cloud archive provider, or nil if none is connected
+func (s *Store) cloudAccount(ctx context.Context, provider string) (*cloudAccount, error) {
+	var a cloudAccount
+	var token string
+	err := s.db.QueryRowContext(ctx, `SELECT provider, account, token, connected_by, connected_at FROM cloud_accounts WHERE provider = ?`,
+		provider).Scan(&a.Provider, &a.Account, &token, &a.ConnectedBy, &a.ConnectedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(token), &a.Token); err != nil {
+		return nil, fmt.Errorf("stored %s token: %w", provider, err)
+	}
+	return &a, nil
+}
+
+// saveCloudAccount connects a cloud archive account, replacing the one connected before
+func (s *Store) saveCloudAccount(ctx context.Context, a cloudAccount) error {
+	token, err := json.Marshal(a.Token)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO cloud_accounts (provider, account, token, connected_by, connected_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (provider) DO UPDATE SET account = excluded.account, token = excluded.token, connected_by = excluded.connected_by,
+			connected_at = excluded.connected_at`,
+		a.Provider, a.Account, string(token), a.ConnectedBy, a.ConnectedAt.UTC())
+	return err
+}
+
+// saveCloudToken keeps a refreshed token of a cloud archive account
+func (s *Store) saveCloudToken(ctx context.Context, provider string, token *oauth2.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE cloud_accounts SET token = ? WHERE provider = ?`, string(data), provider)
+	return err
+}
+
+// deleteCloudAccount disconnects a cloud archive account
+func (s *Store) deleteCloudAccount(ctx context.Context, provider string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM cloud_accounts WHERE provider = ?`, provider)
+	return err
+}
+
+// queueCloudUpload queues a fax for the cloud archive, unless it was queued before
+func (s *Store) queueCloudUpload(ctx context.Context, u cloudUpload) error {
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO cloud_uploads (profile, fax_id, direction, media_url, status, next_attempt, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`, u.Profile, u.FaxID, u.Direction, u.MediaURL, cloudPending, now, now)
+	return err
+}
+
+// dueCloudUploads returns up to limit pending cloud uploads whose next attempt is due, oldest first
+func (s *Store) dueCloudUploads(ctx context.Context, now time.Time, limit int) ([]cloudUpload, error) {
+	return s.queryCloudUploads(ctx, `WHERE status = ? AND next_attempt <= ? ORDER BY next_attempt, id LIMIT ?`, cloudPending, now, limit)
+}
+
+// claimCloudUpload takes a due cloud upload for an attempt, like claimIntakeJob
+func (s *Store) claimCloudUpload(ctx context.Context, id int64, now time.Time, timeout time.Duration) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE cloud_uploads SET next_attempt = ? WHERE id = ? AND status = ? AND next_attempt <= ?`,
+		now.Add(timeout), id, cloudPending, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// cloudUploads returns up to limit cloud uploads in the states, most recently updated first
+func (s *Store) cloudUploads(ctx context.Context, states []string, limit int) ([]cloudUpload, error) {
+	where := `WHERE status IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(states)), ", ") + `) ORDER BY updated_at DESC, id DESC LIMIT ?`
+	var args []any
+	for _, state := range states {
+		args = append(args, state)
+	}
+	return s.queryCloudUploads(ctx, where, append(args, limit)...)
+}
+
+func (s *Store) queryCloudUploads(ctx context.Context, where string, args ...any) ([]cloudUpload, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, profile, fax_id, direction, media_url, status, attempts, last_error, next_attempt, updated_at
+		FROM cloud_uploads `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var uploads []cloudUpload
+	for rows.Next() {
+		var u cloudUpload
+		if err := rows.Scan(&u.ID, &u.Profile, &u.FaxID, &u.Direction, &u.MediaURL, &u.Status, &u.Attempts, &u.LastError, &u.NextAttempt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
+
+// cloudUploadCounts returns the number of cloud uploads in each state
+func (s *Store) cloudUploadCounts(ctx context.Context) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM cloud_uploads GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// pendingJobs counts the intake deliveries and cloud archive uploads still waiting to go out
+func (s *Store) pendingJobs(ctx context.Context) (intake, cloud int, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT
+		(SELECT COUNT(*) FROM intake_jobs WHERE status = ?),
+		(SELECT COUNT(*) FROM cloud_uploads WHERE status = ?)`, intakePending, cloudPending).Scan(&intake, &cloud)
+	return intake, cloud, err
+}
+
+// updateCloudUpload records the outcome of an upload attempt
+func (s *Store) updateCloudUpload(ctx context.Context, u cloudUpload) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE cloud_uploads SET status = ?, attempts = ?, last_error = ?, next_attempt = ?, updated_at = ? WHERE id = ?`,
+		u.Status, u.Attempts, u.LastError, u.NextAttempt.UTC(), time.Now().UTC(), u.ID)
+	return err
+}
+
+// retryCloudUpload queues a failed cloud upload again with fresh attempts
+func (s *Store) retryCloudUpload(ctx context.Context, id int64) error {
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, `UPDATE cloud_uploads SET status = ?, attempts = 0, next_attempt = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		cloudPending, now, now, id, cloudFailed)
+	return err
+}
+
+// recordJobError keeps the error of a failed attempt at a queued job
+func (s *Store) recordJobError(ctx context.Context, queue string, id int64, attempt int, msg string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO job_errors (queue, job_id, attempt, error, created_at) VALUES (?, ?, ?, ?, ?)`,
+		queue, id, attempt, msg, time.Now().UTC())
+	return err
+}
+
+// jobErrors returns the errors of the jobs of a queue by job, oldest first
+func (s *Store) jobErrors(ctx context.Context, queue string, ids []int64) (map[int64][]jobError, error) {
+	errs := map[int64][]jobError{}
+	if len(ids) == 0 {
+		return errs, nil
+	}
+	args := []any{queue}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT job_id, attempt, error, created_at FROM job_errors
+		WHERE queue = ? AND job_id IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")+`) ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var e jobError
+		if err := rows.Scan(&id, &e.Attempt, &e.Error, &e.At); err != nil {
+			return nil, err
+		}
+		errs[id] = append(errs[id], e)
+	}
+	return errs, rows.Err()
+}
+
+// requeueJob queues a dead or waiting job of a queue for an attempt now, with fresh attempts. It
+// returns false when there is no such job, e.g. as it was delivered meanwhile.
+func (s *Store) requeueJob(ctx context.Context, queue string, id int64) (bool, error) {
+	q := jobQueues[queue]
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `UPDATE `+q.table+` SET status = ?, attempts = 0, next_attempt = ?, updated_at = ? WHERE id = ? AND status IN (?, ?)`,
+		q.pending, now, now, id, q.pending, q.dead)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// requeueDeadJobs queues every dead job of a queue again with fresh attempts, returning how many
+func (s *Store) requeueDeadJobs(ctx context.Context, queue string) (int64, error) {
+	q := jobQueues[queue]
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `UPDATE `+q.table+` SET status = ?, attempts = 0, next_attempt = ?, updated_at = ? WHERE status = ?`,
+		q.pending, now, now, q.dead)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// discardJob deletes a dead job of a queue, or a waiting one too with waiting set, and its errors
+func (s *Store) discardJob(ctx context.Context, queue string, id int64, waiting bool) (bool, error) {
+	q := jobQueues[queue]
+	states := []any{q.dead, q.dead}
+	if waiting {
+		states[1] = q.pending
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `DELETE FROM `+q.table+` WHERE id = ? AND status IN (?, ?)`, id, states[0], states[1])
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM job_errors WHERE queue = ? AND job_id = ?`, queue, id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// deleteOrphanJobErrors deletes the errors of jobs that were deleted
+func deleteOrphanJobErrors(ctx context.Context, tx *dbTx) error {
+	for queue, q := range jobQueues {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM job_errors WHERE queue = ? AND job_id NOT IN (SELECT id FROM `+q.table+`)`, queue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
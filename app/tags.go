@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// handleAdminTags lists the defined tags (GET) and creates or deletes tags (POST)
+func (a *App) handleAdminTags(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tags, err := a.Store.tags(r.Context())
+		if err != nil {
+			http.Error(w, "failed to load tags: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		q := r.URL.Query()
+		data := map[string]any{
+			"Tags":    tags,
+			"Success": q.Get("success"),
+			"Error":   q.Get("error"),
+		}
+		a.render(w, r, "admin_tags.html", data)
+	case http.MethodPost:
+		a.handleUpdateAdminTags(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUpdateAdminTags creates or deletes a tag, or places or releases a legal hold on it
+func (a *App) handleUpdateAdminTags(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	redirect := func(status url.Values) {
+		http.Redirect(w, r, "/admin/tags?"+status.Encode(), http.StatusSeeOther)
+	}
+
+	name := strings.Join(strings.Fields(r.FormValue("name")), " ")
+	if name == "" {
+		redirect(url.Values{"error": {a.T(r, "Tag name is required")}})
+		return
+	}
+	switch r.FormValue("action") {
+	case "create":
+		if len(name) > 40 {
+			redirect(url.Values{"error": {a.T(r, "Tag names are limited to 40 characters")}})
+			return
+		}
+		if err := a.Store.createTag(r.Context(), name); err != nil {
+			redirect(url.Values{"error": {a.T(r, "Failed to create tag: "+err.Error())}})
+			return
+		}
+		redirect(url.Values{"success": {a.T(r, "Created tag %s", name)}})
+	case "delete":
+		tags, err := a.Store.tags(r.Context())
+		if err != nil {
+			redirect(url.Values{"error": {a.T(r, "Failed to delete tag: "+err.Error())}})
+			return
+		}
+		for _, t := range tags {
+			if t.Hold && strings.EqualFold(t.Name, name) {
+				redirect(url.Values{"error": {a.T(r, "Tag %s is on legal hold; release the hold before deleting it", t.Name)}})
+				return
+			}
+		}
+		if err := a.Store.deleteTag(r.Context(), name); err != nil {
+			redirect(url.Values{"error": {a.T(r, "Failed to delete tag: "+err.Error())}})
+			return
+		}
+		redirect(url.Values{"success": {a.T(r, "Deleted tag %s", name)}})
+	case "hold", "release":
+		hold := r.FormValue("action") == "hold"
+		if err := a.Store.setTagHold(r.Context(), name, hold); err != nil {
+			redirect(url.Values{"error": {a.T(r, "Failed to update legal hold: "+err.Error())}})
+			return
+		}
+		user, _ := a.sessionUser(r)
+		entry := auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "hold.tag_placed", Detail: "tag " + name}
+		msg := a.T(r, "Faxes tagged %s are on legal hold", name)
+		if !hold {
+			entry.Action = "hold.tag_lifted"
+			msg = a.T(r, "Released the legal hold on tag %s", name)
+		}
+		a.writeAudit(r.Context(), entry)
+		redirect(url.Values{"success": {msg}})
+	default:
+		redirect(url.Values{"error": {a.T(r, "unknown action")}})
+	}
+}
+
+// handleFaxTags replaces the tags on a fax from the fax details page
+func (a *App) handleFaxTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	profile, err := a.requestProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !a.requireFaxVisible(w, r, profile, id) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.PageTimeout)
+	defer cancel()
+	// Keep the fax in the local database so tag filters can list it without the provider
+	fax, err := profile.provider.Get(ctx, id)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	if err := a.Store.saveFaxes(ctx, profile.Name, []Fax{*fax}); err != nil {
+		http.Error(w, "failed to save fax: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tags, err := a.Store.tags(ctx)
+	if err != nil {
+		http.Error(w, "failed to load tags: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	current, err := a.Store.faxTags(ctx, profile.Name, []string{id})
+	if err != nil {
+		http.Error(w, "failed to load tags: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if held := heldTagRemoved(tags, current[id], r.Form["tag"]); held != "" {
+		http.Error(w, "tag "+held+" is on legal hold and cannot be removed from this fax", http.StatusConflict)
+		return
+	}
+	if err := a.Store.setFaxTags(ctx, profile.Name, id, r.Form["tag"]); err != nil {
+		http.Error(w, "failed to save tags: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	q := url.Values{"id": {id}, "profile": {profile.Name}, "success": {"tags_saved"}}
+	http.Redirect(w, r, "/fax?"+q.Encode(), http.StatusSeeOther)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// templateFiles lists the page templates matching pattern, each replaced by the file of the same name in
+// overrideDir when it has one, so a deployment can customize single pages without forking. Files in
+// overrideDir with no counterpart are added, e.g. a partial an overridden page includes. It reports the
+// overrides in the log.
+func templateFiles(pattern, overrideDir string) ([]string, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if overrideDir == "" {
+		return files, nil
+	}
+	if info, err := os.Stat(overrideDir); err != nil {
+		return nil, fmt.Errorf("template override directory: %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("template override directory %s is not a directory", overrideDir)
+	}
+	overrides, err := filepath.Glob(filepath.Join(overrideDir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]string, len(files))
+	for _, f := range files {
+		byName[filepath.Base(f)] = f
+	}
+	for _, f := range overrides {
+		name := filepath.Base(f)
+		if _, ok := byName[name]; ok {
+			log.Printf("Template override: %s from %s", name, f)
+		} else {
+			log.Printf("Template override: %s added from %s", name, f)
+		}
+		byName[name] = f
+	}
+	if len(overrides) == 0 {
+		log.Printf("Warning: template override directory %s has no .html files", overrideDir)
+	}
+	files = files[:0]
+	for _, f := range byName {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	_ "time/tzdata" // the distroless image has no zoneinfo
+)
+
+// Timestamps are stored in UTC and shown in a display time zone: the signed-in user's preference, then the
+// zone picked in this browser, then --display_timezone. Templates format them with {{ datetime .T }}, and
+// the lists add {{ ago .T }} ("3 minutes ago").
+
+// timezoneCookieName remembers the time zone picked on the preferences page, for users without an account
+const timezoneCookieName = "fax_ui_tz"
+
+// dateTimeLayout is how pages show a timestamp
+const dateTimeLayout = "2006-01-02 15:04"
+
+// location picks the request's display time zone
+func (a *App) location(r *http.Request) *time.Location {
+	if name := a.pickedTimezone(r); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return a.Location
+}
+
+// pickedTimezone returns the time zone picked by the signed-in user or in this browser, or "" for the default
+func (a *App) pickedTimezone(r *http.Request) string {
+	if user, ok := a.sessionUser(r); ok {
+		name, err := a.Store.userTimezone(r.Context(), user)
+		if err != nil {
+			log.Printf("Warning: could not load the time zone of %s: %v", user, err)
+		} else if name != "" {
+			return name
+		}
+	}
+	if c, err := r.Cookie(timezoneCookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// zoneName names loc for people; the server's local time zone has no IANA name to show
+func zoneName(loc *time.Location) string {
+	if loc == time.Local {
+		abbr, _ := time.Now().Zone()
+		return abbr
+	}
+	return loc.String()
+}
+
+// timeFuncs are the template functions that show timestamps in loc, in language l
+func timeFuncs(l *language, loc *time.Location) template.FuncMap {
+	return template.FuncMap{
+		// datetime formats t in the display time zone; an optional layout replaces dateTimeLayout
+		"datetime": func(t time.Time, layout ...string) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.In(loc).Format(firstNonEmpty(append(layout, dateTimeLayout)...))
+		},
+		"ago": func(t time.Time) string {
+			return relativeTime(l, t, time.Now())
+		},
+	}
+}
+
+// relativeTime describes how long before now t was, e.g. "3 minutes ago"
+func relativeTime(l *language, t, now time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	d := now.Sub(t)
+	plural := func(n int, one, many string) string {
+		if n == 1 {
+			return l.T(one)
+		}
+		return l.T(many, n)
+	}
+	switch {
+	case d < time.Minute:
+		return l.T("just now")
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "1 minute ago", "%d minutes ago")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "1 hour ago", "%d hours ago")
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "1 day ago", "%d days ago")
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "1 month ago", "%d months ago")
+	default:
+		return plural(int(d/(365*24*time.Hour)), "1 year ago", "%d years ago")
+	}
+}
+
+// handlePreferences shows and saves the display time zone: for the signed-in user, and in this browser
+func (a *App) handlePreferences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		data := a.preferencesData(r)
+		data["Success"] = r.URL.Query().Get("success")
+		a.render(w, r, "preferences.html", data)
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		// An empty zone goes back to the default
+		name := strings.TrimSpace(r.FormValue("timezone"))
+		if _, err := time.LoadLocation(name); err != nil {
+			data := a.preferencesData(r)
+			data["Timezone"] = name
+			data["Error"] = a.T(r, "Unknown time zone %s", name)
+			a.render(w, r, "preferences.html", data)
+			return
+		}
+		if user, ok := a.sessionUser(r); ok {
+			if err := a.Store.setUserTimezone(r.Context(), user, name); err != nil {
+				http.Error(w, "failed to save time zone: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		cookie := &http.Cookie{
+			Name:     timezoneCookieName,
+			Value:    name,
+			Path:     "/",
+			MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		}
+		if name == "" {
+			cookie.MaxAge = -1
+		}
+		http.SetCookie(w, cookie)
+		http.Redirect(w, r, "/preferences?success="+url.QueryEscape(a.T(r, "Preferences saved")), http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// preferencesData returns the data of the preferences page: the time zone, and the user's API tokens
+func (a *App) preferencesData(r *http.Request) map[string]any {
+	data := map[string]any{
+		"Timezone": a.pickedTimezone(r),
+		"Default":  zoneName(a.Location),
+	}
+	if user, ok := a.tokenOwner(r); ok {
+		tokens, err := a.Store.apiTokens(r.Context(), user)
+		if err != nil {
+			log.Printf("Warning: could not load API tokens: %v", err)
+		}
+		data["Tokens"] = tokens
+		data["ManageTokens"] = true
+	}
+	return data
+}
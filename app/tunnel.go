@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTunnelCheckInterval is how often a detected tunnel's URL is checked again. Quick tunnels
+// get a new URL whenever they restart, and documents uploaded meanwhile must be fetched from it.
+const defaultTunnelCheckInterval = time.Minute
+
+// defaultTailscaleSocket is where tailscaled serves its local API on Linux
+const defaultTailscaleSocket = "/var/run/tailscale/tailscaled.sock"
+
+// TunnelConfig names the local APIs of tunnels that give fax-ui its public URL when it has none of its own
+type TunnelConfig struct {
+	NgrokAPI           string // ngrok agent API, e.g. http://localhost:4040
+	CloudflaredMetrics string // cloudflared metrics server of a quick tunnel, e.g. http://localhost:20241
+	TailscaleSocket    string // tailscaled's local API socket, for a Tailscale Funnel
+	Interval           time.Duration
+}
+
+// enabled reports whether any tunnel is to be detected
+func (c TunnelConfig) enabled() bool {
+	return c.NgrokAPI != "" || c.CloudflaredMetrics != "" || c.TailscaleSocket != ""
+}
+
+// publicURL is the base URL Telnyx and links in notifications reach fax-ui at. It changes while
+// fax-ui runs when a tunnel restarts with a new URL.
+type publicURL struct {
+	mu       sync.RWMutex
+	url      string
+	previous []string // URLs fax-ui had before, whose /media/ links are rewritten to the current one
+}
+
+// PublicBaseURL returns the base URL fax-ui is reachable at from the internet
+func (a *App) PublicBaseURL() string {
+	a.public.mu.RLock()
+	defer a.public.mu.RUnlock()
+	return a.public.url
+}
+
+// setPublicBaseURL changes the public base URL, reporting whether it was different
+func (a *App) setPublicBaseURL(u string) bool {
+	a.public.mu.Lock()
+	defer a.public.mu.Unlock()
+	if a.public.url == u {
+		return false
+	}
+	if !slices.Contains(a.public.previous, a.public.url) {
+		a.public.previous = append(a.public.previous, a.public.url)
+	}
+	a.public.url = u
+	return true
+}
+
+// currentMediaURL points a /media/ link made under an earlier public base URL at the current one, so
+// a document uploaded before a tunnel restarted can still be fetched. Other URLs are returned as they are.
+func (a *App) currentMediaURL(u string) string {
+	a.public.mu.RLock()
+	defer a.public.mu.RUnlock()
+	for _, prev := range a.public.previous {
+		if name, ok := strings.CutPrefix(u, trimTrailingSlash(prev)+"/media/"); ok {
+			return trimTrailingSlash(a.public.url) + "/media/" + name
+		}
+	}
+	return u
+}
+
+// detectTunnelURL asks each configured tunnel for its public URL, returning the first found and the
+// tunnel's name, or "" when none is running
+func detectTunnelURL(cfg TunnelConfig) (string, string) {
+	if cfg.NgrokAPI != "" {
+		if u := detectNgrokPublicURL(cfg.NgrokAPI); u != "" {
+			return u, "ngrok"
+		}
+	}
+	if cfg.CloudflaredMetrics != "" {
+		if u := detectCloudflaredURL(cfg.CloudflaredMetrics); u != "" {
+			return u, "Cloudflare Tunnel"
+		}
+	}
+	if cfg.TailscaleSocket != "" {
+		if u := detectTailscaleFunnelURL(cfg.TailscaleSocket); u != "" {
+			return u, "Tailscale Funnel"
+		}
+	}
+	return "", ""
+}
+
+// detectCloudflaredURL asks cloudflared's metrics server for the hostname of its quick tunnel
+// (trycloudflare.com). Named tunnels have a fixed hostname, which belongs in PUBLIC_BASE_URL.
+func detectCloudflaredURL(metricsBase string) string {
+	var resp struct {
+		Hostname string `json:"hostname"`
+	}
+	httpClient := &http.Client{Timeout: 3 * time.Second}
+	res, err := httpClient.Get(strings.TrimRight(metricsBase, "/") + "/quicktunnel")
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ""
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil || resp.Hostname == "" {
+		return ""
+	}
+	return "https://" + resp.Hostname
+}
+
+// detectTailscaleFunnelURL asks tailscaled which of this machine's names and ports Tailscale Funnel
+// exposes to the internet, preferring port 443
+func detectTailscaleFunnelURL(socket string) string {
+	httpClient := &http.Client{
+		Timeout: 3 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://local-tailscaled.sock/localapi/v0/serve-config", nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Sec-Tailscale", "localapi")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ""
+	}
+	var serve struct {
+		AllowFunnel map[string]bool `json:"AllowFunnel"` // "host.tailnet.ts.net:443" -> true
+	}
+	if err := json.NewDecoder(res.Body).Decode(&serve); err != nil {
+		return ""
+	}
+	var hosts []string
+	for hostPort, on := range serve.AllowFunnel {
+		if on {
+			hosts = append(hosts, hostPort)
+		}
+	}
+	slices.Sort(hosts)
+	for _, h := range hosts {
+		if host, ok := strings.CutSuffix(h, ":443"); ok {
+			return "https://" + host
+		}
+	}
+	if len(hosts) == 0 {
+		return ""
+	}
+	return "https://" + hosts[0]
+}
+
+// checkTunnel asks the tunnels for their URL and switches the public base URL when it changed,
+// pointing the fax applications' webhooks at the new URL when provisioning is on. It does nothing
+// when no tunnel is configured.
+func (a *App) checkTunnel() {
+	if !a.Tunnels.enabled() {
+		return
+	}
+	u, tunnel := detectTunnelURL(a.Tunnels)
+	if u == "" {
+		return
+	}
+	prev := a.PublicBaseURL()
+	if !a.setPublicBaseURL(u) {
+		return
+	}
+	log.Printf("Public URL changed from %s to %s (%s)", prev, u, tunnel)
+	if a.provisionHooks {
+		go a.provisionWebhooks()
+	}
+}
+
+// startTunnelCheck checks the tunnels' URL on an interval. Sends check it too, just before Telnyx
+// is given a /media/ link.
+func (a *App) startTunnelCheck() {
+	backgroundTasks.register("tunnel check", a.Tunnels.Interval)
+	go func() {
+		ticker := time.NewTicker(a.Tunnels.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			backgroundTasks.beat("tunnel check")
+			a.checkTunnel()
+		}
+	}()
+}
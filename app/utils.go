@@ -2,10 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/nyaruka/phonenumbers"
 )
 
 // firstNonEmpty returns the first non-empty string from the provided values
@@ -18,53 +21,88 @@ func firstNonEmpty(values ...string) string {
 	return ""
 }
 
-// normalizePhoneNumber converts a phone number to E.164 format
-// Assumes US/Canada (country code 1) if no country code is provided
-func normalizePhoneNumber(phone string) string {
-	phone = strings.TrimSpace(phone)
-	if phone == "" {
-		return ""
+// firstDuration returns the flag value when set, else the environment value when it parses, else def
+func firstDuration(flagValue time.Duration, env string, def time.Duration) time.Duration {
+	if flagValue > 0 {
+		return flagValue
 	}
-
-	// If it looks like a SIP URI, return as-is
-	if strings.HasPrefix(strings.ToLower(phone), "sip:") {
-		return phone
+	if d, err := time.ParseDuration(strings.TrimSpace(env)); err == nil && d > 0 {
+		return d
 	}
+	return def
+}
 
-	// Remove all non-digit characters except leading +
-	hasPlus := strings.HasPrefix(phone, "+")
-	digits := regexp.MustCompile(`\D`).ReplaceAllString(phone, "")
+// firstInt returns the flag value when set (non-negative), else the environment value when it parses, else def
+func firstInt(flagValue int, env string, def int) int {
+	if flagValue >= 0 {
+		return flagValue
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(env)); err == nil && n >= 0 {
+		return n
+	}
+	return def
+}
 
-	// If already has + and digits, validate and return
-	if hasPlus {
-		if len(digits) >= 10 {
-			return "+" + digits
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
 		}
-		// Invalid format, continue to normalize
 	}
+	return false
+}
 
-	// Handle different digit lengths
-	switch len(digits) {
-	case 10:
-		// US/Canada number without country code: 5551234567 -> +15551234567
-		return "+1" + digits
-	case 11:
-		// Likely has country code 1: 15551234567 -> +15551234567
-		if strings.HasPrefix(digits, "1") {
-			return "+" + digits
+// splitList splits a comma-separated list, trimming whitespace and dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
 		}
-		// Unknown 11-digit format, assume it needs +1
-		return "+1" + digits
-	case 12, 13, 14, 15:
-		// International number, just add +
-		return "+" + digits
-	default:
-		// Less than 10 digits or very long, return with + if digits exist
-		if len(digits) > 0 {
-			return "+" + digits
+	}
+	return out
+}
+
+// normalizePhoneNumber parses a phone number and converts it to E.164 format.
+// Numbers without a country code are interpreted using the given default region
+// (ISO 3166-1 alpha-2, e.g. "US"). SIP URIs are returned as-is.
+// Returns an error if the number cannot be parsed or is not valid for its region.
+func normalizePhoneNumber(phone, defaultRegion string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	if phone == "" {
+		return "", nil
+	}
+
+	// If it looks like a SIP URI, return as-is
+	if isSIPURI(phone) {
+		return phone, nil
+	}
+
+	num, err := phonenumbers.Parse(phone, strings.ToUpper(defaultRegion))
+	if err != nil {
+		return "", fmt.Errorf("%q is not a recognizable phone number", phone)
+	}
+	if !phonenumbers.IsValidNumber(num) {
+		region := phonenumbers.GetRegionCodeForNumber(num)
+		if region == "" || region == "ZZ" {
+			return "", fmt.Errorf("%q is not a valid phone number", phone)
 		}
-		return phone
+		return "", fmt.Errorf("%q is not a valid phone number for region %s", phone, region)
 	}
+	return phonenumbers.Format(num, phonenumbers.E164), nil
+}
+
+// isSIPURI reports whether a destination is a SIP URI rather than a phone number
+func isSIPURI(s string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(s)), "sip:")
+}
+
+// writeJSON encodes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
 }
 
 // sanitizeFilename removes potentially dangerous characters from filenames
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Users save the filters and sort order of /faxes or /inbox as named views (e.g. "Failed this week",
+// "Inbound unassigned"), shown as tabs above the list. A view is the list's query string, kept per user.
+
+// savedViewPages are the lists views can be saved for
+var savedViewPages = []string{"faxes", "inbox"}
+
+// maxViewName caps the length of a view's name, in characters
+const maxViewName = 60
+
+// viewQuery returns the filters and sort order in a list's query, as saved in a view. Empty
+// parameters, as submitted by the filter forms, are left out so the same filters give the same view.
+func viewQuery(query url.Values) string {
+	query = maps.Clone(query)
+	for _, key := range []string{"page_number", "format", "success", "error"} {
+		query.Del(key)
+	}
+	for key, values := range query {
+		if !slices.ContainsFunc(values, func(v string) bool { return v != "" }) {
+			query.Del(key)
+		}
+	}
+	return query.Encode()
+}
+
+// Link links to the list the view shows
+func (v savedView) Link() string {
+	return "/" + v.Page + "?" + v.Query
+}
+
+// addSavedViews adds the user's views of the list requested by r to its template data
+func (a *App) addSavedViews(r *http.Request, data map[string]any) {
+	user, _ := a.sessionUser(r)
+	page := strings.TrimPrefix(r.URL.Path, "/")
+	views, err := a.Store.savedViews(r.Context(), user, page)
+	if err != nil {
+		log.Printf("Warning: could not load saved views: %v", err)
+	}
+	current := viewQuery(r.URL.Query())
+	data["Views"] = views
+	data["ViewPage"] = page
+	data["ViewQuery"] = current
+	data["CurrentView"] = int64(0)
+	for _, v := range views {
+		if v.Query == current {
+			data["CurrentView"] = v.ID
+		}
+	}
+}
+
+// handleSavedViews saves the list's current filters as a view (POST name), or deletes one (POST action=delete)
+func (a *App) handleSavedViews(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	page := r.FormValue("page")
+	if !slices.Contains(savedViewPages, page) {
+		http.Error(w, "invalid page", http.StatusBadRequest)
+		return
+	}
+	query, err := url.ParseQuery(r.FormValue("query"))
+	if err != nil {
+		http.Error(w, "invalid query", http.StatusBadRequest)
+		return
+	}
+	user, _ := a.sessionUser(r)
+	redirectBack := func(key, text string) {
+		target := url.Values{}
+		target.Set(key, text)
+		encoded := viewQuery(query)
+		if encoded != "" {
+			encoded += "&"
+		}
+		http.Redirect(w, r, "/"+page+"?"+encoded+target.Encode(), http.StatusSeeOther)
+	}
+	back := func(key, msg string, args ...any) { redirectBack(key, a.T(r, msg, args...)) }
+
+	if r.FormValue("action") == "delete" {
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid view", http.StatusBadRequest)
+			return
+		}
+		if err := a.Store.deleteSavedView(r.Context(), user, id); err != nil {
+			redirectBack("error", a.failureMessage(r, err, "Failed to delete the view"))
+			return
+		}
+		back("success", "View deleted")
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	switch {
+	case name == "":
+		back("error", "Enter a name for the view")
+		return
+	case utf8.RuneCountInString(name) > maxViewName:
+		back("error", "View names can be at most %d characters", maxViewName)
+		return
+	}
+	if err := a.Store.saveView(r.Context(), user, savedView{Page: page, Name: name, Query: viewQuery(query)}); err != nil {
+		redirectBack("error", a.failureMessage(r, err, "Failed to save the view"))
+		return
+	}
+	back("success", "Saved view %s", name)
+}
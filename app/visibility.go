@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Fax visibility decides which faxes a signed-in user may see. With --fax_visibility=all (the default)
+// everyone who may use a profile sees all of its faxes. With "own", users who are not admins see only
+// the faxes they sent from fax-ui and the faxes tagged with one of their departments; admins see everything.
+const (
+	visibilityAll = "all"
+	visibilityOwn = "own"
+)
+
+// Department is a group of users from the config file. Faxes tagged with the department's name are
+// visible to all of its users.
+type Department struct {
+	Name  string   `yaml:"name"`  // the tag that files faxes under the department
+	Users []string `yaml:"users"` // identities in the department
+}
+
+// faxScope limits fax lists and lookups to what one user may see
+type faxScope struct {
+	User string   // sees the faxes this identity sent
+	Tags []string // and the faxes carrying any of these tags
+}
+
+// where returns the SQL condition matching the visible faxes of the table aliased f, with its arguments
+func (s *faxScope) where() (string, []any) {
+	cond := "EXISTS (SELECT 1 FROM sent_faxes s WHERE s.profile = f.profile AND s.fax_id = f.fax_id AND s.sent_by = ? COLLATE NOCASE)"
+	args := []any{s.User}
+	if len(s.Tags) > 0 {
+		cond += " OR EXISTS (SELECT 1 FROM fax_tags ft WHERE ft.profile = f.profile AND ft.fax_id = f.fax_id AND ft.tag IN (?" +
+			strings.Repeat(", ?", len(s.Tags)-1) + "))"
+		for _, t := range s.Tags {
+			args = append(args, t)
+		}
+	}
+	return "(" + cond + ")", args
+}
+
+// parseVisibility checks the visibility mode
+func parseVisibility(mode string) (string, error) {
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case "":
+		return visibilityAll, nil
+	case visibilityAll, visibilityOwn:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid fax visibility %q (expected all or own)", mode)
+}
+
+// faxScope returns what the requesting user may see, or nil when they may see every fax of the profile
+func (a *App) faxScope(r *http.Request) *faxScope {
+	if a.isAdmin(r) {
+		return nil
+	}
+	user, _ := a.sessionUser(r)
+	return a.userScope(user)
+}
+
+// userScope returns what a user who is not an admin may see, or nil when visibility is not restricted
+func (a *App) userScope(user string) *faxScope {
+	if a.AuthConfig.Visibility != visibilityOwn {
+		return nil
+	}
+	scope := &faxScope{User: user}
+	for _, d := range a.runtime().Departments {
+		if containsFold(d.Users, user) {
+			scope.Tags = append(scope.Tags, d.Name)
+		}
+	}
+	return scope
+}
+
+// userSeesFax reports whether a user sees a fax, for events sent without a request such as push notifications
+func (a *App) userSeesFax(ctx context.Context, profile, user, faxID string) bool {
+	if !a.hasAuthConfigured() || a.isAdminUser(user) {
+		return true
+	}
+	scope := a.userScope(user)
+	if scope == nil {
+		return true
+	}
+	visible, err := a.Store.visibleFaxes(ctx, profile, []string{faxID}, scope)
+	if err != nil {
+		log.Printf("Warning: could not check whether %s may see fax %s: %v", user, faxID, err)
+		return false
+	}
+	return len(visible) == 1
+}
+
+// requireFaxVisible writes a not found response unless the requesting user may see the fax. Faxes the user
+// may not see are reported as missing so their IDs reveal nothing.
+func (a *App) requireFaxVisible(w http.ResponseWriter, r *http.Request, profile *Profile, id string) bool {
+	scope := a.faxScope(r)
+	if scope == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	visible, err := a.Store.visibleFaxes(ctx, profile.Name, []string{id}, scope)
+	if err != nil {
+		http.Error(w, "failed to check fax access: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if len(visible) == 0 {
+		http.Error(w, "fax not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
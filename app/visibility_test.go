@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// signIn returns a request carrying a new session of user
+func signIn(t *testing.T, app *App, user string) *http.Request {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	if err := app.startSession(rec, httptest.NewRequest(http.MethodGet, "/", nil), user, false); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestFaxVisibility(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	app.AuthConfig.Password = "pw"
+	app.AuthConfig.CookieName, app.AuthConfig.SessionSecret = defaultSessionCookieName, "secret"
+	app.AuthConfig.SessionMaxAge = time.Hour
+	app.AuthConfig.AdminUsers = []string{"admin@example.com"}
+	app.AuthConfig.Visibility = visibilityOwn
+	app.AuthConfig.Departments = []Department{{Name: "billing", Users: []string{"Bob@example.com"}}}
+	ctx := context.Background()
+	profile := app.Profiles[0].Name
+
+	// f-alice is Alice's, f-billing is tagged for Bob's department, f-other is nobody's
+	for id, by := range map[string]string{"f-alice": "alice@example.com", "f-billing": "carol@example.com", "f-other": ""} {
+		if err := app.Store.recordSentFax(ctx, &sentFax{FaxID: id, Profile: profile, SentBy: by, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.Store.createTag(ctx, "billing"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Store.setFaxTags(ctx, profile, "f-billing", []string{"billing"}); err != nil {
+		t.Fatal(err)
+	}
+	all := []string{"f-alice", "f-billing", "f-other", "f-unknown"}
+
+	admin := signIn(t, app, "admin@example.com")
+	viewingAsAlice := signIn(t, app, "admin@example.com")
+	rec := httptest.NewRecorder()
+	if err := app.setImpersonationCookie(rec, viewingAsAlice, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		viewingAsAlice.AddCookie(c)
+	}
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		want []string // nil when the scope lets the user see everything
+	}{
+		{"own faxes", signIn(t, app, "ALICE@example.com"), []string{"f-alice"}},
+		{"department faxes", signIn(t, app, "bob@example.com"), []string{"f-billing"}},
+		{"nothing", signIn(t, app, "dave@example.com"), []string{}},
+		{"admin", admin, nil},
+		{"admin viewing as a user", viewingAsAlice, []string{"f-alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope := app.faxScope(tt.r)
+			if tt.want == nil {
+				if scope != nil {
+					t.Fatalf("scope %+v, want all faxes", scope)
+				}
+				return
+			}
+			if scope == nil {
+				t.Fatal("sees all faxes")
+			}
+			visible, err := app.Store.visibleFaxes(ctx, profile, all, scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(visible, tt.want) && !(len(visible) == 0 && len(tt.want) == 0) {
+				t.Fatalf("sees %v, want %v", visible, tt.want)
+			}
+		})
+	}
+
+	// With visibility for all, users who are not admins see every fax too
+	app.AuthConfig.Visibility = visibilityAll
+	if scope := app.faxScope(signIn(t, app, "dave@example.com")); scope != nil {
+		t.Fatalf("scope %+v with visibility for all", scope)
+	}
+	// Faxes of another profile are not visible through a scope
+	if visible, _ := app.Store.visibleFaxes(ctx, "other", all, &faxScope{User: "alice@example.com"}); len(visible) != 0 {
+		t.Fatalf("sees %v of another profile", visible)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Telnyx signs webhooks with the account's public key, which says an event came from Telnyx but not that
+// it was meant for this deployment. Rotating the webhook signing gives the fax applications a new secret
+// webhook URL (/webhooks/telnyx/{token}) and optionally a new Telnyx public key. Events Telnyx already
+// queued for the old URL, or signed with the old key, are still accepted for a grace period.
+
+// webhookSigningSecret names the secrets row the signing configuration is kept in, shared by every
+// instance on the database
+const webhookSigningSecret = "webhook_signing"
+
+// defaultWebhookRotationGrace is how long the previous webhook URL and key stay valid after a rotation;
+// Telnyx retries a failed webhook for a few minutes
+const defaultWebhookRotationGrace = time.Hour
+
+// webhookSigning is the webhook signing configuration as rotated by admins
+type webhookSigning struct {
+	Token         string    `json:"token"`          // secret path segment of the webhook URL; empty before the first rotation
+	PublicKey     string    `json:"public_key"`     // base64 key set at a rotation, replacing TELNYX_PUBLIC_KEY; empty keeps it
+	PreviousToken string    `json:"previous_token"` // token in use before the last rotation
+	PreviousKey   string    `json:"previous_key"`   // key in use before the last rotation, when it was changed
+	PreviousUntil time.Time `json:"previous_until"` // the previous token and key are accepted until then
+	RotatedAt     time.Time `json:"rotated_at"`
+}
+
+// webhookSigningState holds the signing configuration in memory
+type webhookSigningState struct {
+	mu    sync.RWMutex
+	cur   webhookSigning
+	grace time.Duration
+}
+
+// webhookSigning returns the current signing configuration
+func (a *App) webhookSigning() webhookSigning {
+	a.signing.mu.RLock()
+	defer a.signing.mu.RUnlock()
+	return a.signing.cur
+}
+
+// loadWebhookSigning reads the signing configuration from the database, e.g. after another instance rotated it
+func (a *App) loadWebhookSigning(ctx context.Context) error {
+	value, err := a.Store.secret(ctx, webhookSigningSecret, func() (string, error) { return "{}", nil })
+	if err != nil {
+		return err
+	}
+	var s webhookSigning
+	if err := json.Unmarshal([]byte(value), &s); err != nil {
+		return fmt.Errorf("invalid webhook signing configuration: %w", err)
+	}
+	a.signing.mu.Lock()
+	a.signing.cur = s
+	a.signing.mu.Unlock()
+	return nil
+}
+
+// webhookKey returns the public key webhooks are verified with: the one set at the last rotation, or
+// TELNYX_PUBLIC_KEY. Nil accepts them unsigned at a secret webhook URL only.
+func (a *App) webhookKey(s webhookSigning) ed25519.PublicKey {
+	if key, err := parseWebhookPublicKey(s.PublicKey); err == nil && key != nil {
+		return key
+	}
+	return a.WebhookPublicKey
+}
+
+// verifyWebhook checks the token the webhook was posted to and its signature against the current
+// configuration, and during the grace period against the previous one
+func (a *App) verifyWebhook(s webhookSigning, token string, h http.Header, body []byte, now time.Time) error {
+	inGrace := now.Before(s.PreviousUntil)
+	if !sameToken(token, s.Token) && !(inGrace && sameToken(token, s.PreviousToken)) {
+		return errors.New("unknown webhook URL")
+	}
+	key := a.webhookKey(s)
+	if key == nil {
+		// Without a public key only the secret token in the path says the event came from Telnyx
+		if token == "" {
+			return errors.New("unsigned event; set TELNYX_PUBLIC_KEY or rotate the webhook signing")
+		}
+		return nil
+	}
+	err := verifyTelnyxSignature(key, h, body, now)
+	if err != nil && inGrace && s.PreviousKey != "" {
+		if previous, perr := parseWebhookPublicKey(s.PreviousKey); perr == nil && previous != nil {
+			if verifyTelnyxSignature(previous, h, body, now) == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// sameToken compares webhook tokens in constant time, so response times do not reveal the secret URL
+func sameToken(token, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// webhookToken returns the token a webhook request was posted to, the path after /webhooks/telnyx
+func webhookToken(r *http.Request) string {
+	return strings.Trim(strings.TrimPrefix(r.URL.Path, telnyxWebhookPath), "/")
+}
+
+// authenticateWebhook verifies a webhook request, reloading the configuration once when it fails in case
+// another instance rotated it since
+func (a *App) authenticateWebhook(r *http.Request, body []byte) error {
+	token := webhookToken(r)
+	err := a.verifyWebhook(a.webhookSigning(), token, r.Header, body, time.Now())
+	if err == nil {
+		return nil
+	}
+	if lerr := a.loadWebhookSigning(r.Context()); lerr != nil {
+		log.Printf("Warning: could not reload the webhook signing configuration: %v", lerr)
+		return err
+	}
+	return a.verifyWebhook(a.webhookSigning(), token, r.Header, body, time.Now())
+}
+
+// rotateWebhookSigning gives the webhook URL a new token and, when newKey is set, verifies webhooks with
+// that key from now on. The new configuration is stored before the fax applications are pointed at the
+// new URL, so every instance accepts it by the time Telnyx uses it; the previous one stays valid for the
+// grace period. It returns the fax applications that could not be updated.
+func (a *App) rotateWebhookSigning(ctx context.Context, newKey string) ([]string, error) {
+	if isLocalURL(a.PublicBaseURL()) {
+		return nil, fmt.Errorf("%s is not reachable by Telnyx; set PUBLIC_BASE_URL", a.PublicBaseURL())
+	}
+	if len(a.FaxApps) == 0 {
+		return nil, errors.New("no fax application is configured")
+	}
+	newKey = strings.TrimSpace(newKey)
+	if _, err := parseWebhookPublicKey(newKey); err != nil {
+		return nil, errors.New("the public key must be the base64 ed25519 public key from the Telnyx portal")
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	// Reload first, so a rotation on another instance is not undone
+	if err := a.loadWebhookSigning(ctx); err != nil {
+		return nil, err
+	}
+	old := a.webhookSigning()
+	now := time.Now().UTC()
+	next := webhookSigning{
+		Token:         hex.EncodeToString(b),
+		PublicKey:     old.PublicKey,
+		PreviousToken: old.Token,
+		PreviousUntil: now.Add(a.signing.grace),
+		RotatedAt:     now,
+	}
+	if newKey != "" {
+		next.PublicKey = newKey
+		if key := a.webhookKey(old); key != nil {
+			next.PreviousKey = base64.StdEncoding.EncodeToString(key)
+		}
+	}
+	value, err := json.Marshal(next)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Store.setSecret(ctx, webhookSigningSecret, string(value)); err != nil {
+		return nil, err
+	}
+	a.signing.mu.Lock()
+	a.signing.cur = next
+	a.signing.mu.Unlock()
+
+	var failed []string
+	for _, app := range a.FaxApps {
+		if _, err := a.provisionWebhook(ctx, app.ID); err != nil {
+			log.Printf("Warning: could not set webhook URL of fax application %s after rotation: %v", app.ID, err)
+			failed = append(failed, app.ID)
+		}
+	}
+	return failed, nil
+}
+
+// handleAdminRotateWebhook rotates the webhook signing from the settings page
+func (a *App) handleAdminRotateWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), a.Resilience.ActionTimeout)
+	defer cancel()
+
+	appID, ok := a.settingsAppID(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	failed, err := a.rotateWebhookSigning(ctx, r.FormValue("public_key"))
+	if err != nil {
+		settingsRedirect(w, r, appID, url.Values{"error": {a.T(r, "Could not rotate the webhook signing: %v", err)}})
+		return
+	}
+	user, _ := a.sessionUser(r)
+	detail := "new webhook URL"
+	if strings.TrimSpace(r.FormValue("public_key")) != "" {
+		detail += " and public key"
+	}
+	a.writeAudit(r.Context(), auditEntry{Actor: firstNonEmpty(user, "anonymous"), Action: "webhook.rotated", Detail: detail})
+	until := a.webhookSigning().PreviousUntil
+	if len(failed) > 0 {
+		settingsRedirect(w, r, appID, url.Values{"error": {a.T(r, "The webhook signing was rotated, but these fax applications could not be updated and still use the old URL until %s: %s",
+			until.In(a.location(r)).Format("2006-01-02 15:04"), strings.Join(failed, ", "))}})
+		return
+	}
+	settingsRedirect(w, r, appID, url.Values{"rotated": {until.In(a.location(r)).Format("2006-01-02 15:04")}})
+}
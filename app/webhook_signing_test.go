@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhookToken(t *testing.T) {
+	app, _ := newFakeTelnyxApp(t)
+	app.WebhookPublicKey = nil
+	now := time.Now()
+	s := webhookSigning{Token: "current-token", PreviousToken: "previous-token", PreviousUntil: now.Add(time.Hour)}
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		ok    bool
+	}{
+		{"current", "current-token", now, true},
+		{"previous in the grace period", "previous-token", now, true},
+		{"previous after the grace period", "previous-token", now.Add(2 * time.Hour), false},
+		{"prefix of the current", "current-toke", now, false},
+		{"longer than the current", "current-token2", now, false},
+		{"none", "", now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := app.verifyWebhook(s, tt.token, http.Header{}, nil, tt.now); (err == nil) != tt.ok {
+				t.Fatalf("verifyWebhook: %v, want accepted %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/team-telnyx/telnyx-go/v4"
+)
+
+// telnyxWebhookPath receives Telnyx fax events; fax applications are pointed at it under the public base URL
+const telnyxWebhookPath = "/webhooks/telnyx"
+
+// webhookTolerance is how old a signed webhook may be before it is rejected as a replay
+const webhookTolerance = 5 * time.Minute
+
+// telnyxEvent is a Telnyx webhook (API v2) for a fax
+type telnyxEvent struct {
+	Data struct {
+		EventType string `json:"event_type"` // e.g. fax.delivered, fax.failed, fax.received
+		Payload   struct {
+			FaxID            string    `json:"fax_id"`
+			Status           string    `json:"status"`
+			Direction        string    `json:"direction"`
+			From             string    `json:"from"`
+			To               string    `json:"to"`
+			PageCount        int       `json:"page_count"`
+			CallDurationSecs float64   `json:"call_duration_secs"`
+			FailureReason    string    `json:"failure_reason"`
+			MediaURL         string    `json:"media_url"` // received document, for fax.received
+			OccurredAt       time.Time `json:"occurred_at"`
+		} `json:"payload"`
+	} `json:"data"`
+}
+
+// webhookURL is where Telnyx should send fax events for this deployment, with the secret token once
+// the webhook signing has been rotated
+func (a *App) webhookURL() string {
+	u := trimTrailingSlash(a.PublicBaseURL()) + telnyxWebhookPath
+	if token := a.webhookSigning().Token; token != "" {
+		u += "/" + token
+	}
+	return u
+}
+
+// handleTelnyxWebhook records fax status callbacks so lists and details reflect them without waiting for the cache.
+// Events are verified against TELNYX_PUBLIC_KEY, or the key of the last rotation, when one is set; without
+// a key only events posted to the secret URL of a rotation are accepted.
+func (a *App) handleTelnyxWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if err := a.authenticateWebhook(r, body); err != nil {
+		log.Printf("Warning: rejected Telnyx webhook: %v", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var event telnyxEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+	p := event.Data.Payload
+	if !strings.HasPrefix(event.Data.EventType, "fax.") || p.FaxID == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	fax := Fax{
+		ID:            p.FaxID,
+		Status:        p.Status,
+		Direction:     p.Direction,
+		From:          p.From,
+		To:            p.To,
+		UpdatedAt:     firstTime(p.OccurredAt, time.Now()),
+		Pages:         p.PageCount,
+		Duration:      time.Duration(p.CallDurationSecs * float64(time.Second)),
+		FailureReason: p.FailureReason,
+		MediaURL:      p.MediaURL,
+	}
+	updated, err := a.Store.updateFaxStatus(r.Context(), fax)
+	if err != nil {
+		http.Error(w, "failed to record event: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := a.Store.recordFaxEvent(r.Context(), event.Data.EventType, fax); err != nil {
+		log.Printf("Warning: could not record %s event of fax %s: %v", event.Data.EventType, fax.ID, err)
+	}
+	// A newly received fax is not stored yet; it belongs to the account the webhook was provisioned for
+	if updated == 0 && event.Data.EventType == "fax.received" {
+		fax.CreatedAt = fax.UpdatedAt
+		if err := a.Store.saveFaxes(r.Context(), a.defaultProfile().Name, []Fax{fax}); err != nil {
+			http.Error(w, "failed to record event: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	// Telnyx keeps received documents only for a while, so they are copied as soon as they arrive
+	if event.Data.EventType == "fax.received" {
+		a.archiveReceived(a.defaultProfile(), fax.ID, p.MediaURL)
+		a.exportFHIR(a.defaultProfile(), fax)
+		a.deliverReceived(a.defaultProfile(), fax)
+		a.queueIntake(r.Context(), a.defaultProfile().Name, fax)
+	}
+	a.queueCloudArchive(r.Context(), event.Data.EventType, fax)
+	for _, profile := range a.Profiles {
+		if c, ok := profile.provider.(*cachingProvider); ok {
+			c.forgetLists()
+		}
+	}
+	a.publishFaxEvent(r.Context(), event.Data.EventType, fax)
+	a.forwardFaxEvent(fax.ID, r.Header, body)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyTelnyxSignature checks the ed25519 signature Telnyx puts on webhooks, which covers "<timestamp>|<body>"
+func verifyTelnyxSignature(key ed25519.PublicKey, h http.Header, body []byte, now time.Time) error {
+	timestamp := h.Get("telnyx-timestamp")
+	sig, err := base64.StdEncoding.DecodeString(h.Get("telnyx-signature-ed25519"))
+	if err != nil || len(sig) == 0 || timestamp == "" {
+		return errors.New("missing signature")
+	}
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if age := now.Sub(time.Unix(secs, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return fmt.Errorf("timestamp %s outside tolerance", timestamp)
+	}
+	if !ed25519.Verify(key, append([]byte(timestamp+"|"), body...), sig) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// parseWebhookPublicKey decodes the base64 public key shown in the Telnyx portal; empty disables verification
+func parseWebhookPublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("TELNYX_PUBLIC_KEY must be the base64 ed25519 public key from the Telnyx portal")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// provisionWebhook points a fax application's webhook_event_url at this deployment.
+// It reports whether the URL had to be changed.
+func (a *App) provisionWebhook(ctx context.Context, appID string) (bool, error) {
+	target := a.webhookURL()
+	if u, err := url.Parse(target); err != nil || u.Scheme != "https" && u.Scheme != "http" {
+		return false, fmt.Errorf("invalid public base URL %q", a.PublicBaseURL())
+	}
+	current, err := a.Client.FaxApplications.Get(ctx, appID)
+	if err != nil {
+		return false, err
+	}
+	if current.Data.WebhookEventURL == target {
+		return false, nil
+	}
+	_, err = a.Client.FaxApplications.Update(ctx, appID, telnyx.FaxApplicationUpdateParams{
+		ApplicationName: current.Data.ApplicationName,
+		WebhookEventURL: target,
+	})
+	return err == nil, err
+}
+
+// provisionWebhooks points every configured fax application at this deployment, logging the outcome
+func (a *App) provisionWebhooks() {
+	if isLocalURL(a.PublicBaseURL()) {
+		log.Printf("Warning: not provisioning webhooks: %s is not reachable by Telnyx; set PUBLIC_BASE_URL", a.PublicBaseURL())
+		return
+	}
+	for _, app := range a.FaxApps {
+		ctx, cancel := context.WithTimeout(context.Background(), a.Resilience.ActionTimeout)
+		changed, err := a.provisionWebhook(ctx, app.ID)
+		cancel()
+		switch {
+		case err != nil:
+			log.Printf("Warning: could not set webhook URL of fax application %s: %v", app.ID, err)
+		case changed:
+			log.Printf("Set webhook URL of fax application %s to %s", app.ID, a.webhookURL())
+		}
+	}
+}
+
+// isLocalURL reports whether u points at this machine, which Telnyx cannot reach
+func isLocalURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return true
+	}
+	host := parsed.Hostname()
+	return host == "localhost" || host == "127.0.0.1" || host == "::1" || host == ""
+}
+
+// firstTime returns the first non-zero time
+func firstTime(times ...time.Time) time.Time {
+	for _, t := range times {
+		if !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
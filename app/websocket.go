@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// /ws is a WebSocket (RFC 6455) that pushes fax events to the inbox page as webhooks report them, so
+// pages kept open all day show new faxes without polling. The server only sends; browsers only answer
+// pings and close.
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsPingInterval = 30 * time.Second
+	wsMaxFrame     = 4096 // browsers send nothing but control frames here
+	wsOpText       = 0x1
+	wsOpClose      = 0x8
+	wsOpPing       = 0x9
+	wsOpPong       = 0xA
+)
+
+// liveEvent is the JSON message sent for each fax event
+type liveEvent struct {
+	Type      string    `json:"type"` // fax.received or fax.status
+	ID        string    `json:"id"`
+	Profile   string    `json:"profile"`
+	Status    string    `json:"status"`
+	Final     bool      `json:"final"`
+	Direction string    `json:"direction"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// liveUpdates fans fax events out to the connected browsers
+type liveUpdates struct {
+	mu          sync.Mutex
+	subscribers map[chan liveEvent]bool
+}
+
+func (l *liveUpdates) subscribe() chan liveEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.subscribers == nil {
+		l.subscribers = make(map[chan liveEvent]bool)
+	}
+	ch := make(chan liveEvent, 32)
+	l.subscribers[ch] = true
+	return ch
+}
+
+func (l *liveUpdates) unsubscribe(ch chan liveEvent) {
+	l.mu.Lock()
+	delete(l.subscribers, ch)
+	l.mu.Unlock()
+}
+
+// publish never blocks; a browser too far behind misses events and catches up on its next reload
+func (l *liveUpdates) publish(e liveEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ch := range l.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// publishFaxEvent tells the connected browsers about a fax event, under each profile that has the fax,
+// and sends the push notifications and hook deliveries it calls for
+func (a *App) publishFaxEvent(ctx context.Context, eventType string, fax Fax) {
+	profiles, err := a.Store.faxProfiles(ctx, fax.ID)
+	if err != nil {
+		log.Printf("Warning: could not look up fax %s for live updates: %v", fax.ID, err)
+		return
+	}
+	typ := "fax.status"
+	if eventType == "fax.received" {
+		typ = "fax.received"
+	}
+	for _, profile := range profiles {
+		a.live.publish(liveEvent{
+			Type:      typ,
+			ID:        fax.ID,
+			Profile:   profile,
+			Status:    fax.Status,
+			Final:     fax.Final(),
+			Direction: fax.Direction,
+			From:      fax.From,
+			To:        fax.To,
+			UpdatedAt: fax.UpdatedAt,
+		})
+	}
+	// The webhook is answered without waiting on push services
+	go a.notifyPush(context.Background(), eventType, fax, profiles)
+	go a.deliverHooks(context.Background(), eventType, fax, profiles)
+}
+
+// handleWebSocket upgrades the request and streams fax events for the user's profiles until the browser goes away
+func (a *App) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+	// Browsers send the session cookie with WebSockets opened by any site, so only this site's pages may connect
+	if !a.sameOrigin(r) {
+		http.Error(w, "cross-origin WebSocket rejected", http.StatusForbidden)
+		return
+	}
+	allowed := make(map[string]bool)
+	for _, p := range a.allowedProfiles(r) {
+		allowed[p.Name] = true
+	}
+	scope := a.faxScope(r)
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSockets are not supported here", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Time{})
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	ws := &wsConn{conn: conn, br: rw.Reader}
+
+	events := a.live.subscribe()
+	defer a.live.unsubscribe(events)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			op, payload, err := ws.read()
+			if err != nil {
+				return
+			}
+			switch op {
+			case wsOpClose:
+				ws.write(wsOpClose, payload[:min(len(payload), 2)])
+				return
+			case wsOpPing:
+				ws.write(wsOpPong, payload)
+			}
+		}
+	}()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case e := <-events:
+			if !allowed[e.Profile] {
+				continue
+			}
+			if scope != nil {
+				if visible, err := a.Store.visibleFaxes(r.Context(), e.Profile, []string{e.ID}, scope); err != nil || len(visible) == 0 {
+					continue
+				}
+			}
+			msg, _ := json.Marshal(e)
+			if err := ws.write(wsOpText, msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			// A connection outlives the page load, so it ends with the session
+			if !a.isAuthenticated(r) {
+				ws.write(wsOpClose, binary.BigEndian.AppendUint16(nil, 1008))
+				return
+			}
+			if err := ws.write(wsOpPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sameOrigin reports whether a request has no Origin or comes from this server's own pages, by the host
+// it was reached at or by the public base URL
+func (a *App) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	public, err := url.Parse(a.PublicBaseURL())
+	return err == nil && strings.EqualFold(u.Host, public.Host)
+}
+
+// headerHasToken reports whether a comma-separated header such as Connection lists token
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn frames messages on an upgraded connection
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex // events and answers to pings are written from different goroutines
+}
+
+// write sends one unmasked frame, as servers do
+func (c *wsConn) write(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(append(frame, payload...))
+	return err
+}
+
+// read returns the next frame from the browser, unmasked
+func (c *wsConn) read() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return 0, nil, err
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if head[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	if n > wsMaxFrame {
+		return 0, nil, errors.New("client frame too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return head[0] & 0x0F, payload, nil
+}